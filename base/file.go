@@ -16,6 +16,10 @@ import (
 
 var Timestamp = time.Now
 
+// ErrNotSeekable is returned when seeking within file content that doesn't
+// support seeking
+var ErrNotSeekable = errors.New("file content does not support seeking")
+
 type FileInfo interface {
 	fs.FileInfo
 	Cid() cid.Cid
@@ -154,6 +158,22 @@ func (m memfile) Close() error {
 	return nil
 }
 
+// ReadAt reads len(p) bytes from r starting at off. Like io.ReaderAt, ReadAt
+// returns io.EOF when fewer than len(p) bytes are read
+func ReadAt(r io.ReadSeeker, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
 type CBORFiler interface {
 	CBORFile() (fs.File, error)
 }
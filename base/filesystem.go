@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"sort"
 
@@ -44,9 +45,9 @@ const (
 	NTFile NodeType = iota
 	NTLDFile
 	NTDir
-	NTSymlink    // reserved for future use
-	NTUnixFSFile // reserved for future use
-	NTUnixFSDir  // reserved for future use
+	NTSymlink
+	NTUnixFSFile // file linked from an existing UnixFS DAG
+	NTUnixFSDir  // read-only directory linked from an existing UnixFS DAG
 )
 
 func (nt NodeType) String() string {
@@ -86,6 +87,16 @@ type File interface {
 	Node
 }
 
+// WritableFile is an open file that can be edited in place
+type WritableFile interface {
+	fs.File
+	io.Writer
+	io.WriterAt
+	io.ReaderAt
+	io.Seeker
+	Truncate(size int64) error
+}
+
 type LDFile interface {
 	fs.File
 	fs.ReadDirFile
@@ -100,6 +111,10 @@ type Tree interface {
 	Copy(path Path, srcPath string, src fs.FS) (PutResult, error)
 	Rm(path Path) (PutResult, error)
 	Mkdir(path Path) (PutResult, error)
+	Mv(from, to Path) (PutResult, error)
+	Symlink(target string, path Path) (PutResult, error)
+	Readlink(path Path) (string, error)
+	OpenFile(path Path, flag int) (WritableFile, error)
 }
 
 type PutResult interface {
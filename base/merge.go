@@ -35,6 +35,13 @@ type MergeResult struct {
 	HamtRoot    *cid.Cid // TODO(b5): refactor this away. unused on public nodes, required for private
 	Key         string
 	PrivateName string
+
+	// Conflicts lists how each conflicting entry was resolved, in the order
+	// conflicts were encountered
+	Conflicts []ConflictResolution
+	// Decisions lists every path the merge took a version of or merged, in
+	// the order paths were merged
+	Decisions []MergeDecision
 }
 
 var _ PutResult = (*MergeResult)(nil)
@@ -91,3 +98,306 @@ func AllKeys(ctx context.Context, bs blockstore.Blockstore) ([]cid.Cid, error) {
 
 	return ids, nil
 }
+
+// ChangeType classifies a directory entry in a three-way merge by comparing
+// local & remote versions of the entry to the common ancestor
+type ChangeType string
+
+const (
+	// CTNone is an entry both sides agree on
+	CTNone ChangeType = "none"
+	// CTAdd is an entry one side created
+	CTAdd ChangeType = "add"
+	// CTModify is an entry one side changed
+	CTModify ChangeType = "modify"
+	// CTDelete is an entry one side removed
+	CTDelete ChangeType = "delete"
+	// CTConflict is an entry both sides changed in different ways
+	CTConflict ChangeType = "conflict"
+)
+
+// EntryChange is the classification of a single directory entry
+type EntryChange struct {
+	Type ChangeType
+	// Remote is true when only the remote side changed the entry, false when
+	// only local did. unused for CTNone & CTConflict
+	Remote bool
+}
+
+// ClassifyEntry compares the CIDs of a directory entry in the common ancestor
+// (o), local (a) and remote (b) versions of a directory. a nil CID is an entry
+// that doesn't exist in that version. o must be nil when the ancestor is
+// unknown, making every entry that isn't equal on both sides an add or a
+// conflict
+func ClassifyEntry(o, a, b *cid.Cid) EntryChange {
+	switch {
+	case equalCIDs(a, b):
+		return EntryChange{Type: CTNone}
+	case equalCIDs(o, a):
+		return EntryChange{Type: changeType(o, b), Remote: true}
+	case equalCIDs(o, b):
+		return EntryChange{Type: changeType(o, a)}
+	default:
+		return EntryChange{Type: CTConflict}
+	}
+}
+
+func changeType(from, to *cid.Cid) ChangeType {
+	if from == nil {
+		return CTAdd
+	} else if to == nil {
+		return CTDelete
+	}
+	return CTModify
+}
+
+func equalCIDs(a, b *cid.Cid) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equals(*b)
+}
+
+// Resolution is the outcome of a conflict
+type Resolution string
+
+const (
+	// RLocal keeps the local version of the entry, which may be a delete
+	RLocal Resolution = "local"
+	// RRemote takes the remote version of the entry, which may be a delete
+	RRemote Resolution = "remote"
+	// RKeepBoth keeps the local version under the entry's name & the remote
+	// version as a renamed copy. Only one version is kept when the other side
+	// deleted the entry
+	RKeepBoth Resolution = "keep-both"
+	// RMarkers keeps the lines both sides of a text merge changed from each
+	// version, between conflict markers. Only text conflicts can resolve with
+	// markers
+	RMarkers Resolution = "markers"
+)
+
+// ConflictVersion describes one side of a conflict
+type ConflictVersion struct {
+	Cid    cid.Cid
+	Size   int64
+	Mtime  int64
+	IsFile bool
+}
+
+// Conflict is an entry both sides of a merge changed in different ways since
+// the common ancestor. Directories both sides changed merge recursively
+// instead of conflicting
+type Conflict struct {
+	// Path to the entry from the merged node
+	Path string
+	// Local & Remote versions of the entry. nil for a side that deleted it
+	Local  *ConflictVersion
+	Remote *ConflictVersion
+	// Fields lists the JSON pointers of values both sides changed when a
+	// structured merge finds conflicts within data. Non-conflicting changes
+	// from both sides are kept when the conflict resolves to either side
+	Fields []string
+	// Hunks counts the overlapping line ranges both sides changed when a text
+	// merge finds conflicts within a text file. Non-conflicting lines from both
+	// sides are kept when the conflict resolves to either side or RMarkers
+	Hunks int
+}
+
+// ConflictResolver decides the outcome of merge conflicts
+type ConflictResolver interface {
+	Resolve(c Conflict) (Resolution, error)
+}
+
+// ResolverFunc adapts a function to a ConflictResolver, for resolving
+// conflicts interactively
+type ResolverFunc func(c Conflict) (Resolution, error)
+
+// Resolve calls f(c)
+func (f ResolverFunc) Resolve(c Conflict) (Resolution, error) { return f(c) }
+
+var (
+	// PreferLocal resolves all conflicts with the local version
+	PreferLocal ConflictResolver = ResolverFunc(func(Conflict) (Resolution, error) { return RLocal, nil })
+	// PreferRemote resolves all conflicts with the remote version
+	PreferRemote ConflictResolver = ResolverFunc(func(Conflict) (Resolution, error) { return RRemote, nil })
+	// KeepBoth keeps both versions of all conflicts
+	KeepBoth ConflictResolver = ResolverFunc(func(Conflict) (Resolution, error) { return RKeepBoth, nil })
+	// LastWriterWins resolves conflicts with the most recently modified
+	// version. Edits win over deletes, which have no modification time. Equal
+	// modification times break by CID
+	LastWriterWins ConflictResolver = ResolverFunc(lastWriterWins)
+)
+
+func lastWriterWins(c Conflict) (Resolution, error) {
+	switch {
+	case c.Remote == nil:
+		return RLocal, nil
+	case c.Local == nil:
+		return RRemote, nil
+	case c.Local.Mtime > c.Remote.Mtime:
+		return RLocal, nil
+	case c.Remote.Mtime > c.Local.Mtime:
+		return RRemote, nil
+	case LessCID(c.Remote.Cid, c.Local.Cid):
+		return RRemote, nil
+	default:
+		return RLocal, nil
+	}
+}
+
+// ConflictResolution records the outcome of a conflict
+type ConflictResolution struct {
+	Path       string     `json:"path"`
+	Resolution Resolution `json:"resolution"`
+	Local      *cid.Cid   `json:"local,omitempty"`
+	Remote     *cid.Cid   `json:"remote,omitempty"`
+	// Copy is the name of the remote version's copy when both versions are kept
+	Copy string `json:"copy,omitempty"`
+	// Fields lists conflicting values within structured data, see Conflict
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Map encodes a ConflictResolution as a map of strings, for storing in
+// metadata
+func (cr ConflictResolution) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"path":       cr.Path,
+		"resolution": string(cr.Resolution),
+	}
+	if cr.Local != nil {
+		m["local"] = cr.Local.String()
+	}
+	if cr.Remote != nil {
+		m["remote"] = cr.Remote.String()
+	}
+	if cr.Copy != "" {
+		m["copy"] = cr.Copy
+	}
+	if len(cr.Fields) > 0 {
+		fields := make([]interface{}, len(cr.Fields))
+		for i, f := range cr.Fields {
+			fields[i] = f
+		}
+		m["fields"] = fields
+	}
+	return m
+}
+
+// Decision reports the resolution as a MergeDecision
+func (cr ConflictResolution) Decision() MergeDecision {
+	return MergeDecision{
+		Path:       cr.Path,
+		Action:     MAConflict,
+		Local:      cr.Local,
+		Remote:     cr.Remote,
+		Resolution: cr.Resolution,
+		Copy:       cr.Copy,
+		Fields:     cr.Fields,
+	}
+}
+
+// MergeAction is what a merge did with a path
+type MergeAction string
+
+const (
+	// MAFastForward takes the remote version of a path local hasn't changed
+	MAFastForward MergeAction = "fast-forward"
+	// MAAddRemote adds a path only remote created
+	MAAddRemote MergeAction = "add-remote"
+	// MARemoveRemote removes a path only remote deleted
+	MARemoveRemote MergeAction = "remove-remote"
+	// MAKeepLocal keeps a path only local added, modified or deleted
+	MAKeepLocal MergeAction = "keep-local"
+	// MAMerge merges the entries of a directory, or the fields of structured
+	// data, both sides changed
+	MAMerge MergeAction = "merge"
+	// MAConflict resolves a path both sides changed in different ways
+	MAConflict MergeAction = "conflict"
+)
+
+// MergeDecision records what a merge did with a single path
+type MergeDecision struct {
+	Path   string      `json:"path"`
+	Action MergeAction `json:"action"`
+	// Local & Remote versions of the path. nil for a side that doesn't have it
+	Local  *cid.Cid `json:"local,omitempty"`
+	Remote *cid.Cid `json:"remote,omitempty"`
+	// Resolution, Copy & Fields are set for conflicts, see ConflictResolution
+	Resolution Resolution `json:"resolution,omitempty"`
+	Copy       string     `json:"copy,omitempty"`
+	Fields     []string   `json:"fields,omitempty"`
+}
+
+// ChangeDecision reports a one-sided change classified by ClassifyEntry as a
+// MergeDecision. ok is false for changes a merge doesn't act on
+func ChangeDecision(path string, ch EntryChange, a, b *cid.Cid) (d MergeDecision, ok bool) {
+	d = MergeDecision{Path: path, Local: a, Remote: b}
+	switch {
+	case ch.Type == CTNone || ch.Type == CTConflict:
+		return d, false
+	case !ch.Remote:
+		d.Action = MAKeepLocal
+	case ch.Type == CTAdd:
+		d.Action = MAAddRemote
+	case ch.Type == CTModify:
+		d.Action = MAFastForward
+	case ch.Type == CTDelete:
+		d.Action = MARemoveRemote
+	}
+	return d, true
+}
+
+// ConflictCopyName is the name a remote version is kept under when a conflict
+// keeps both versions
+func ConflictCopyName(name string, remote cid.Cid) string {
+	return fmt.Sprintf("%s.conflict-%s", name, remote)
+}
+
+// MergeOptions configure a merge
+type MergeOptions struct {
+	// Resolver decides the outcome of conflicts. When nil, edits win over
+	// deletes & conflicting edits keep the version with more changes since the
+	// common ancestor, breaking ties by CID
+	Resolver ConflictResolver
+	// Structured merges the data of LDFiles & node metadata field by field
+	// with MergeData, instead of treating each version as an opaque value.
+	// Only values both sides changed in different ways go to the Resolver
+	Structured bool
+	// Text merges UTF-8 text files line by line against the common ancestor
+	// with MergeText. Overlapping edits go to the Resolver, which defaults to
+	// RMarkers for text conflicts
+	Text bool
+}
+
+// MergeOption configures a merge
+type MergeOption func(o *MergeOptions)
+
+// WithConflictResolver sets the conflict resolver for a merge
+func WithConflictResolver(r ConflictResolver) MergeOption {
+	return func(o *MergeOptions) {
+		o.Resolver = r
+	}
+}
+
+// WithStructuredMerge enables field-level merges of LDFiles & node metadata
+func WithStructuredMerge() MergeOption {
+	return func(o *MergeOptions) {
+		o.Structured = true
+	}
+}
+
+// WithTextMerge enables line-based merges of text files
+func WithTextMerge() MergeOption {
+	return func(o *MergeOptions) {
+		o.Text = true
+	}
+}
+
+// NewMergeOptions applies opts to empty MergeOptions
+func NewMergeOptions(opts ...MergeOption) MergeOptions {
+	o := MergeOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,85 @@
+package base
+
+import (
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	multihash "github.com/multiformats/go-multihash"
+)
+
+func TestClassifyEntry(t *testing.T) {
+	sum := func(s string) *cid.Cid {
+		id, err := cid.Prefix{
+			Version:  1,
+			Codec:    cid.Raw,
+			MhType:   multihash.SHA2_256,
+			MhLength: -1,
+		}.Sum([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &id
+	}
+	o, a, b := sum("ancestor"), sum("local"), sum("remote")
+
+	cases := []struct {
+		name    string
+		o, a, b *cid.Cid
+		expect  EntryChange
+	}{
+		{"unchanged", o, o, o, EntryChange{Type: CTNone}},
+		{"both_deleted", o, nil, nil, EntryChange{Type: CTNone}},
+		{"same_change", o, a, a, EntryChange{Type: CTNone}},
+		{"local_add", nil, a, nil, EntryChange{Type: CTAdd}},
+		{"remote_add", nil, nil, b, EntryChange{Type: CTAdd, Remote: true}},
+		{"local_modify", o, a, o, EntryChange{Type: CTModify}},
+		{"remote_modify", o, o, b, EntryChange{Type: CTModify, Remote: true}},
+		{"local_delete", o, nil, o, EntryChange{Type: CTDelete}},
+		{"remote_delete", o, o, nil, EntryChange{Type: CTDelete, Remote: true}},
+		{"both_modify", o, a, b, EntryChange{Type: CTConflict}},
+		{"both_add", nil, a, b, EntryChange{Type: CTConflict}},
+		{"local_delete_remote_modify", o, nil, b, EntryChange{Type: CTConflict}},
+		{"local_modify_remote_delete", o, a, nil, EntryChange{Type: CTConflict}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ClassifyEntry(c.o, c.a, c.b)
+			if c.expect != got {
+				t.Errorf("result mismatch. want: %#v got: %#v", c.expect, got)
+			}
+		})
+	}
+}
+
+func TestChangeDecision(t *testing.T) {
+	cases := []struct {
+		name   string
+		ch     EntryChange
+		ok     bool
+		action MergeAction
+	}{
+		{"none", EntryChange{Type: CTNone}, false, ""},
+		{"conflict", EntryChange{Type: CTConflict}, false, ""},
+		{"local_add", EntryChange{Type: CTAdd}, true, MAKeepLocal},
+		{"local_delete", EntryChange{Type: CTDelete}, true, MAKeepLocal},
+		{"remote_add", EntryChange{Type: CTAdd, Remote: true}, true, MAAddRemote},
+		{"remote_modify", EntryChange{Type: CTModify, Remote: true}, true, MAFastForward},
+		{"remote_delete", EntryChange{Type: CTDelete, Remote: true}, true, MARemoveRemote},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, ok := ChangeDecision("a/b.txt", c.ch, nil, nil)
+			if c.ok != ok {
+				t.Fatalf("ok mismatch. want: %t got: %t", c.ok, ok)
+			}
+			if ok && c.action != d.Action {
+				t.Errorf("action mismatch. want: %q got: %q", c.action, d.Action)
+			}
+			if ok && d.Path != "a/b.txt" {
+				t.Errorf("path mismatch. want: %q got: %q", "a/b.txt", d.Path)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package base
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergeData three-way merges structured data decoded from JSON or CBOR. o is
+// the common ancestor of local version a & remote version b, nil when there is
+// no common ancestor or it has no data. Maps merge key by key. Arrays with the ancestor's length
+// on both sides merge index by index, arrays both sides only appended to keep
+// both sides' additions, local first. Values both sides changed in different
+// ways are conflicts, merged with b's value when preferB is true & a's value
+// otherwise. conflicts lists the JSON pointer of each conflicting value
+func MergeData(o, a, b interface{}, preferB bool) (merged interface{}, conflicts []string) {
+	dm := &dataMerge{preferB: preferB}
+	merged = dm.value("", o, a, b)
+	return merged, dm.conflicts
+}
+
+// absentValue marks a map key that doesn't exist in one version of a map
+type absentValue struct{}
+
+var absent = absentValue{}
+
+type dataMerge struct {
+	preferB   bool
+	conflicts []string
+}
+
+func (dm *dataMerge) value(path string, o, a, b interface{}) interface{} {
+	switch {
+	case reflect.DeepEqual(a, b):
+		return a
+	case reflect.DeepEqual(o, a):
+		return b
+	case reflect.DeepEqual(o, b):
+		return a
+	}
+
+	if am, ok := a.(map[string]interface{}); ok {
+		if bm, ok := b.(map[string]interface{}); ok {
+			om, _ := o.(map[string]interface{})
+			return dm.maps(path, om, am, bm)
+		}
+	}
+	if as, ok := a.([]interface{}); ok {
+		if bs, ok := b.([]interface{}); ok {
+			if os, ok := o.([]interface{}); ok {
+				if merged, ok := dm.arrays(path, os, as, bs); ok {
+					return merged
+				}
+			}
+		}
+	}
+
+	dm.conflicts = append(dm.conflicts, path)
+	if dm.preferB {
+		return b
+	}
+	return a
+}
+
+func (dm *dataMerge) maps(path string, o, a, b map[string]interface{}) map[string]interface{} {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range o {
+		_, inA := a[k]
+		_, inB := b[k]
+		if !inA && !inB {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		v := dm.value(path+"/"+escapePointerToken(k), mapValue(o, k), mapValue(a, k), mapValue(b, k))
+		if v != absent {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func (dm *dataMerge) arrays(path string, o, a, b []interface{}) (merged []interface{}, ok bool) {
+	if len(a) == len(o) && len(b) == len(o) {
+		merged = make([]interface{}, len(o))
+		for i := range o {
+			merged[i] = dm.value(fmt.Sprintf("%s/%d", path, i), o[i], a[i], b[i])
+		}
+		return merged, true
+	}
+
+	if isPrefix(o, a) && isPrefix(o, b) {
+		merged = make([]interface{}, 0, len(a)+len(b)-len(o))
+		merged = append(merged, a...)
+		return append(merged, b[len(o):]...), true
+	}
+	return nil, false
+}
+
+func mapValue(m map[string]interface{}, key string) interface{} {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return absent
+}
+
+func isPrefix(prefix, s []interface{}) bool {
+	return len(prefix) <= len(s) && reflect.DeepEqual(prefix, s[:len(prefix)])
+}
+
+// escapePointerToken escapes a map key for use in a JSON pointer (RFC 6901)
+func escapePointerToken(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
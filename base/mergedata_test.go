@@ -0,0 +1,47 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeData(t *testing.T) {
+	type m = map[string]interface{}
+	type a = []interface{}
+
+	cases := []struct {
+		name      string
+		o, a, b   interface{}
+		preferB   bool
+		expect    interface{}
+		conflicts []string
+	}{
+		{"unchanged", m{"a": 1}, m{"a": 1}, m{"a": 1}, false, m{"a": 1}, nil},
+		{"local_change", m{"a": 1}, m{"a": 2}, m{"a": 1}, false, m{"a": 2}, nil},
+		{"remote_change", m{"a": 1}, m{"a": 1}, m{"a": 2}, false, m{"a": 2}, nil},
+		{"disjoint_keys", m{"a": 1, "b": 1}, m{"a": 2, "b": 1}, m{"a": 1, "b": 2}, false, m{"a": 2, "b": 2}, nil},
+		{"both_add_keys", m{}, m{"a": 1}, m{"b": 2}, false, m{"a": 1, "b": 2}, nil},
+		{"remote_delete", m{"a": 1, "b": 1}, m{"a": 2, "b": 1}, m{"a": 1}, false, m{"a": 2}, nil},
+		{"nested", m{"a": m{"x": 1, "y": 1}}, m{"a": m{"x": 2, "y": 1}}, m{"a": m{"x": 1, "y": 2}}, false, m{"a": m{"x": 2, "y": 2}}, nil},
+		{"elementwise", a{1, 2}, a{3, 2}, a{1, 4}, false, a{3, 4}, nil},
+		{"both_append", a{1}, a{1, 2}, a{1, 3}, false, a{1, 2, 3}, nil},
+		{"no_ancestor", nil, m{"a": 1}, m{"b": 2}, false, m{"a": 1, "b": 2}, nil},
+		{"conflict_local", m{"a": 1}, m{"a": 2}, m{"a": 3}, false, m{"a": 2}, []string{"/a"}},
+		{"conflict_remote", m{"a": 1}, m{"a": 2}, m{"a": 3}, true, m{"a": 3}, []string{"/a"}},
+		{"delete_modify", m{"a": 1}, m{}, m{"a": 3}, false, m{}, []string{"/a"}},
+		{"array_conflict", a{1, 2}, a{1}, a{2}, true, a{2}, []string{""}},
+		{"escaped_pointer", m{"a/b~": 1}, m{"a/b~": 2}, m{"a/b~": 3}, false, m{"a/b~": 2}, []string{"/a~1b~0"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, conflicts := MergeData(c.o, c.a, c.b, c.preferB)
+			if !reflect.DeepEqual(c.expect, got) {
+				t.Errorf("result mismatch. want: %#v got: %#v", c.expect, got)
+			}
+			if !reflect.DeepEqual(c.conflicts, conflicts) {
+				t.Errorf("conflicts mismatch. want: %#v got: %#v", c.conflicts, conflicts)
+			}
+		})
+	}
+}
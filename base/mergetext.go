@@ -0,0 +1,223 @@
+package base
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	conflictMarkerLocal  = "<<<<<<< local\n"
+	conflictMarkerSep    = "=======\n"
+	conflictMarkerRemote = ">>>>>>> remote\n"
+)
+
+// IsText reports whether data looks like UTF-8 text
+func IsText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
+
+// MergeText three-way merges text line by line. o is the common ancestor of
+// local version a & remote version b, empty when there is no common ancestor.
+// Edits from both sides apply when they change different lines. Edits that
+// overlap or touch are conflicts, merged with a's lines when prefer is RLocal,
+// b's lines when prefer is RRemote & both sides' lines between conflict
+// markers otherwise. conflicts counts the conflicting hunks
+func MergeText(o, a, b string, prefer Resolution) (merged string, conflicts int) {
+	lines := newLineIndex()
+	oRunes, oLines := lines.runes(o)
+	aRunes, _ := lines.runes(a)
+	bRunes, _ := lines.runes(b)
+
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = 0
+	ha := lineHunks(dmp.DiffMainRunes(oRunes, aRunes, false), lines)
+	hb := lineHunks(dmp.DiffMainRunes(oRunes, bRunes, false), lines)
+
+	out := &strings.Builder{}
+	pos, i, j := 0, 0, 0
+	for i < len(ha) || j < len(hb) {
+		// start a group at the first hunk, then grow it with every hunk from
+		// either side that overlaps or touches it
+		var start, end int
+		if j == len(hb) || (i < len(ha) && ha[i].start <= hb[j].start) {
+			start, end = ha[i].start, ha[i].end
+		} else {
+			start, end = hb[j].start, hb[j].end
+		}
+		ga, gb := i, j
+		for grown := true; grown; {
+			grown = false
+			for ; i < len(ha) && ha[i].start <= end; i++ {
+				end, grown = maxInt(end, ha[i].end), true
+			}
+			for ; j < len(hb) && hb[j].start <= end; j++ {
+				end, grown = maxInt(end, hb[j].end), true
+			}
+		}
+
+		writeLines(out, oLines[pos:start])
+		aLines := applyHunks(oLines, ha[ga:i], start, end)
+		bLines := applyHunks(oLines, hb[gb:j], start, end)
+		switch {
+		case ga == i:
+			writeLines(out, bLines)
+		case gb == j || equalLines(aLines, bLines):
+			writeLines(out, aLines)
+		case prefer == RLocal:
+			conflicts++
+			writeLines(out, aLines)
+		case prefer == RRemote:
+			conflicts++
+			writeLines(out, bLines)
+		default:
+			conflicts++
+			out.WriteString(conflictMarkerLocal)
+			writeMarkedLines(out, aLines)
+			out.WriteString(conflictMarkerSep)
+			writeMarkedLines(out, bLines)
+			out.WriteString(conflictMarkerRemote)
+		}
+		pos = end
+	}
+	writeLines(out, oLines[pos:])
+	return out.String(), conflicts
+}
+
+// textHunk replaces ancestor lines [start, end) with lines
+type textHunk struct {
+	start, end int
+	lines      []string
+}
+
+// lineHunks converts a line diff from the ancestor into hunks
+func lineHunks(diffs []diffmatchpatch.Diff, lines *lineIndex) (hunks []textHunk) {
+	var (
+		pos int
+		cur *textHunk
+	)
+	for _, d := range diffs {
+		rs := []rune(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if cur != nil {
+				hunks = append(hunks, *cur)
+				cur = nil
+			}
+			pos += len(rs)
+			continue
+		}
+
+		if cur == nil {
+			cur = &textHunk{start: pos, end: pos}
+		}
+		if d.Type == diffmatchpatch.DiffDelete {
+			pos += len(rs)
+			cur.end = pos
+		} else {
+			for _, r := range rs {
+				cur.lines = append(cur.lines, lines.line(r))
+			}
+		}
+	}
+	if cur != nil {
+		hunks = append(hunks, *cur)
+	}
+	return hunks
+}
+
+// applyHunks returns ancestor lines [start, end) with hunks applied
+func applyHunks(o []string, hunks []textHunk, start, end int) []string {
+	var res []string
+	pos := start
+	for _, h := range hunks {
+		res = append(res, o[pos:h.start]...)
+		res = append(res, h.lines...)
+		pos = h.end
+	}
+	return append(res, o[pos:end]...)
+}
+
+func writeLines(w *strings.Builder, lines []string) {
+	for _, l := range lines {
+		w.WriteString(l)
+	}
+}
+
+// writeMarkedLines writes lines between conflict markers, which must start on
+// a new line
+func writeMarkedLines(w *strings.Builder, lines []string) {
+	writeLines(w, lines)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		w.WriteString("\n")
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// lineIndex assigns each distinct line a rune, so line diffs can run as rune
+// diffs. go-diff's own line mode doesn't share line hashes between texts
+type lineIndex struct {
+	ids   map[string]rune
+	lines []string
+}
+
+func newLineIndex() *lineIndex {
+	return &lineIndex{ids: map[string]rune{}}
+}
+
+// runes splits text into lines that keep their line endings, returning the rune
+// for each line
+func (li *lineIndex) runes(text string) ([]rune, []string) {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	rs := make([]rune, len(lines))
+	for i, l := range lines {
+		r, ok := li.ids[l]
+		if !ok {
+			r = lineRune(len(li.lines))
+			li.ids[l] = r
+			li.lines = append(li.lines, l)
+		}
+		rs[i] = r
+	}
+	return rs, lines
+}
+
+func (li *lineIndex) line(r rune) string {
+	if r >= 0xE000 {
+		r -= 0x800
+	}
+	return li.lines[r-1]
+}
+
+// lineRune maps a line number to a valid rune, skipping the surrogate range
+// that can't round-trip through a string
+func lineRune(i int) rune {
+	r := rune(i + 1)
+	if r >= 0xD800 {
+		r += 0x800
+	}
+	return r
+}
@@ -0,0 +1,73 @@
+package base
+
+import (
+	"testing"
+)
+
+func TestMergeText(t *testing.T) {
+	const o = "one\ntwo\nthree\nfour\nfive\n"
+
+	cases := []struct {
+		name      string
+		o, a, b   string
+		prefer    Resolution
+		expect    string
+		conflicts int
+	}{
+		{"unchanged", o, o, o, "", o, 0},
+		{"local_edit", o, "one\nTWO\nthree\nfour\nfive\n", o, "", "one\nTWO\nthree\nfour\nfive\n", 0},
+		{"remote_edit", o, o, "one\ntwo\nthree\nfour\nFIVE\n", "", "one\ntwo\nthree\nfour\nFIVE\n", 0},
+		{"separate_edits", o,
+			"ONE\ntwo\nthree\nfour\nfive\n",
+			"one\ntwo\nthree\nfour\nFIVE\n",
+			"", "ONE\ntwo\nthree\nfour\nFIVE\n", 0},
+		{"same_edit", o,
+			"one\nTWO\nthree\nfour\nfive\n",
+			"one\nTWO\nthree\nfour\nfive\n",
+			"", "one\nTWO\nthree\nfour\nfive\n", 0},
+		{"insert_and_delete", o,
+			"zero\none\ntwo\nthree\nfour\nfive\n",
+			"one\ntwo\nfour\nfive\n",
+			"", "zero\none\ntwo\nfour\nfive\n", 0},
+		{"conflict_markers", o,
+			"one\ntwo\nTHREE (local)\nfour\nfive\n",
+			"one\ntwo\nTHREE (remote)\nfour\nfive\n",
+			"", "one\ntwo\n<<<<<<< local\nTHREE (local)\n=======\nTHREE (remote)\n>>>>>>> remote\nfour\nfive\n", 1},
+		{"conflict_prefer_local", o,
+			"ONE\ntwo\nTHREE (local)\nfour\nfive\n",
+			"one\ntwo\nTHREE (remote)\nfour\nFIVE\n",
+			RLocal, "ONE\ntwo\nTHREE (local)\nfour\nFIVE\n", 1},
+		{"conflict_prefer_remote", o,
+			"ONE\ntwo\nTHREE (local)\nfour\nfive\n",
+			"one\ntwo\nTHREE (remote)\nfour\nFIVE\n",
+			RRemote, "ONE\ntwo\nTHREE (remote)\nfour\nFIVE\n", 1},
+		{"adjacent_edits_conflict", o,
+			"one\nTWO\nthree\nfour\nfive\n",
+			"one\ntwo\nTHREE\nfour\nfive\n",
+			"", "one\n<<<<<<< local\nTWO\nthree\n=======\ntwo\nTHREE\n>>>>>>> remote\nfour\nfive\n", 1},
+		{"no_trailing_newline", "a\nb", "a\nb (local)", "a\nb (remote)",
+			"", "a\n<<<<<<< local\nb (local)\n=======\nb (remote)\n>>>>>>> remote\n", 1},
+		{"no_ancestor", "", "same\n", "same\n", "", "same\n", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, conflicts := MergeText(c.o, c.a, c.b, c.prefer)
+			if c.expect != got {
+				t.Errorf("result mismatch. want:\n%s\ngot:\n%s", c.expect, got)
+			}
+			if c.conflicts != conflicts {
+				t.Errorf("conflict count mismatch. want: %d got: %d", c.conflicts, conflicts)
+			}
+		})
+	}
+}
+
+func TestIsText(t *testing.T) {
+	if !IsText([]byte("hello, 世界\n")) {
+		t.Error("expected utf-8 text to be text")
+	}
+	if IsText([]byte{0xff, 0xfe, 0x00}) {
+		t.Error("expected binary data not to be text")
+	}
+}
@@ -21,6 +21,19 @@ func (p Path) String() string {
 	return strings.Join(p, "/")
 }
 
+// HasPrefix reports whether p is equal to or a descendant of prefix
+func (p Path) HasPrefix(prefix Path) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+	for i, name := range prefix {
+		if p[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
 func (p Path) Shift() (head string, ch Path) {
 	switch len(p) {
 	case 0:
@@ -31,3 +31,21 @@ func TestPath(t *testing.T) {
 		t.Errorf("expected tail to equal nil. got: %v", tail)
 	}
 }
+
+func TestPathHasPrefix(t *testing.T) {
+	cases := []struct {
+		p, prefix string
+		expect    bool
+	}{
+		{"public/foo/bar.txt", "public/foo", true},
+		{"public/foo", "public/foo", true},
+		{"public/foo", "public/foo/bar.txt", false},
+		{"public/foobar", "public/foo", false},
+	}
+
+	for _, c := range cases {
+		if got := MustPath(c.p).HasPrefix(MustPath(c.prefix)); got != c.expect {
+			t.Errorf("%q.HasPrefix(%q) mismatch. want: %t got: %t", c.p, c.prefix, c.expect, got)
+		}
+	}
+}
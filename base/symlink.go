@@ -0,0 +1,66 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MaxSymlinkDepth is the maximum number of symlinks followed while resolving
+// a single path
+const MaxSymlinkDepth = 40
+
+var (
+	// ErrSymlinkLoop is returned when resolving a path revisits a symlink
+	ErrSymlinkLoop = errors.New("symlink loop")
+	// ErrSymlinkDepth is returned when resolving a path follows more than
+	// MaxSymlinkDepth symlinks
+	ErrSymlinkDepth = errors.New("too many levels of symbolic links")
+	// ErrNotSymlink is returned when reading the target of a node that isn't a
+	// symlink
+	ErrNotSymlink = errors.New("not a symlink")
+)
+
+// Symlink is a node that points to another path
+type Symlink interface {
+	Node
+	Target() string
+}
+
+// SymlinkResolver tracks symlinks followed while resolving a single path.
+// The zero value is ready to use
+type SymlinkResolver struct {
+	depth int
+	seen  map[string]struct{}
+}
+
+// Follow returns the path that results from replacing the symlink at link with
+// target, appending rest. Relative targets are resolved from the directory
+// containing the link, absolute targets from the root of the tree being
+// searched. Resolution can't escape the root: leading ".." elements of
+// the result are dropped
+func (r *SymlinkResolver) Follow(link Path, target string, rest Path) (Path, error) {
+	if target == "" {
+		return nil, fmt.Errorf("symlink %q has an empty target", link)
+	}
+
+	key := link.String() + "\x00" + rest.String()
+	if _, ok := r.seen[key]; ok {
+		return nil, fmt.Errorf("resolving %q: %w", link, ErrSymlinkLoop)
+	}
+	if r.depth++; r.depth > MaxSymlinkDepth {
+		return nil, fmt.Errorf("resolving %q: %w", link, ErrSymlinkDepth)
+	}
+	if r.seen == nil {
+		r.seen = map[string]struct{}{}
+	}
+	r.seen[key] = struct{}{}
+
+	resolved := target
+	if !strings.HasPrefix(target, "/") {
+		resolved = path.Join(link[:len(link)-1].String(), target)
+	}
+	resolved = path.Clean("/" + path.Join(resolved, rest.String()))
+	return NewPath(resolved)
+}
@@ -0,0 +1,40 @@
+package base
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSymlinkResolverFollow(t *testing.T) {
+	cases := []struct {
+		link, target, rest, expect string
+	}{
+		{"a/link", "b", "", "a/b"},
+		{"a/link", "../b", "c.txt", "b/c.txt"},
+		{"a/b/link", "/c", "d", "c/d"},
+		{"link", "../../escape", "", "escape"},
+	}
+
+	for _, c := range cases {
+		var rest Path
+		if c.rest != "" {
+			rest = MustPath(c.rest)
+		}
+		r := &SymlinkResolver{}
+		got, err := r.Follow(MustPath(c.link), c.target, rest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != c.expect {
+			t.Errorf("%q -> %q mismatch. want: %q got: %q", c.link, c.target, c.expect, got)
+		}
+	}
+
+	r := &SymlinkResolver{}
+	if _, err := r.Follow(MustPath("a"), "b", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Follow(MustPath("a"), "b", nil); !errors.Is(err, ErrSymlinkLoop) {
+		t.Errorf("expected revisiting a link to return ErrSymlinkLoop. got: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package base
+
+import (
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Tx tracks nodes written during a batch of mutations. Rewriting a node that
+// was first written within the same transaction replaces the staged version
+// instead of adding another entry to the node's history.
+// All methods are safe to call on a nil *Tx, which represents "no transaction"
+type Tx struct {
+	lk     sync.Mutex
+	staged map[cid.Cid]struct{}
+}
+
+// NewTx creates an empty transaction
+func NewTx() *Tx {
+	return &Tx{staged: map[cid.Cid]struct{}{}}
+}
+
+// Stage records id as written within the transaction
+func (tx *Tx) Stage(id cid.Cid) {
+	if tx == nil {
+		return
+	}
+	tx.lk.Lock()
+	defer tx.lk.Unlock()
+	tx.staged[id] = struct{}{}
+}
+
+// IsStaged reports whether id was written within the transaction
+func (tx *Tx) IsStaged(id cid.Cid) bool {
+	if tx == nil || !id.Defined() {
+		return false
+	}
+	tx.lk.Lock()
+	defer tx.lk.Unlock()
+	_, ok := tx.staged[id]
+	return ok
+}
@@ -0,0 +1,209 @@
+// Package car reads & writes content-addressable archives (CAR files), which
+// bundle a set of blocks with the CIDs of their roots. Archives are written in
+// the CARv1 format. Both CARv1 archives & the CARv1 payload of CARv2 archives
+// can be read
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+)
+
+// maxSectionSize bounds the size of a header or block section read from an
+// archive, guarding against corrupt length prefixes
+const maxSectionSize = 32 << 20
+
+// v2HeaderSize is the size of the fixed CARv2 header that follows the pragma
+const v2HeaderSize = 40
+
+// v2PragmaSize is the size of the CARv2 pragma, which is a CARv1 header with
+// version 2 & no roots
+const v2PragmaSize = 11
+
+// ErrInvalidArchive wraps errors for archives that can't be read
+var ErrInvalidArchive = errors.New("invalid CAR archive")
+
+// Writer writes blocks to a CARv1 archive
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter writes an archive header listing roots to w & returns a Writer
+// for the blocks of the archive. Flush must be called after the last block
+func NewWriter(w io.Writer, roots ...cid.Cid) (*Writer, error) {
+	header, err := cbornode.DumpObject(map[string]interface{}{
+		"roots":   roots,
+		"version": 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cw := &Writer{w: bufio.NewWriter(w)}
+	if err := cw.writeSection(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Put adds a block to the archive
+func (cw *Writer) Put(blk blocks.Block) error {
+	return cw.writeSection(blk.Cid().Bytes(), blk.RawData())
+}
+
+// Flush writes any buffered data to the underlying writer
+func (cw *Writer) Flush() error {
+	return cw.w.Flush()
+}
+
+func (cw *Writer) writeSection(parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(size))
+	if _, err := cw.w.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := cw.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader reads blocks from an archive
+type Reader struct {
+	r *bufio.Reader
+	// Roots are the root CIDs listed in the archive header
+	Roots []cid.Cid
+}
+
+// NewReader reads the archive header from r & returns a Reader for the blocks
+// of the archive
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+	version, roots, err := cr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case 1:
+		cr.Roots = roots
+		return cr, nil
+	case 2:
+		header := make([]byte, v2HeaderSize)
+		if _, err := io.ReadFull(cr.r, header); err != nil {
+			return nil, fmt.Errorf("%w: reading CARv2 header: %s", ErrInvalidArchive, err)
+		}
+		// characteristics come first, followed by the offset & size of the
+		// CARv1 payload
+		offset := binary.LittleEndian.Uint64(header[16:24])
+		size := binary.LittleEndian.Uint64(header[24:32])
+		if offset < v2PragmaSize+v2HeaderSize {
+			return nil, fmt.Errorf("%w: CARv2 payload offset %d overlaps the header", ErrInvalidArchive, offset)
+		}
+		if _, err := io.CopyN(ioutil.Discard, cr.r, int64(offset-v2PragmaSize-v2HeaderSize)); err != nil {
+			return nil, fmt.Errorf("%w: seeking to CARv2 payload: %s", ErrInvalidArchive, err)
+		}
+
+		payload, err := NewReader(io.LimitReader(cr.r, int64(size)))
+		if err != nil {
+			return nil, err
+		}
+		if len(payload.Roots) == 0 {
+			return nil, fmt.Errorf("%w: CARv2 payload isn't a CARv1 archive", ErrInvalidArchive)
+		}
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, version)
+	}
+}
+
+// Next returns the next block in the archive, or io.EOF after the last block.
+// Blocks are checked against their CIDs
+func (cr *Reader) Next() (blocks.Block, error) {
+	section, err := cr.readSection()
+	if err != nil {
+		return nil, err
+	}
+	n, id, err := cid.CidFromBytes(section)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading block CID: %s", ErrInvalidArchive, err)
+	}
+	data := section[n:]
+
+	check, err := id.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !check.Equals(id) {
+		return nil, fmt.Errorf("%w: block data doesn't match CID %s", ErrInvalidArchive, id)
+	}
+	return blocks.NewBlockWithCid(data, id)
+}
+
+func (cr *Reader) readHeader() (version int, roots []cid.Cid, err error) {
+	section, err := cr.readSection()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: missing header", ErrInvalidArchive)
+		}
+		return 0, nil, err
+	}
+
+	header := map[string]interface{}{}
+	if err := cbornode.DecodeInto(section, &header); err != nil {
+		return 0, nil, fmt.Errorf("%w: decoding header: %s", ErrInvalidArchive, err)
+	}
+	switch v := header["version"].(type) {
+	case int:
+		version = v
+	case uint64:
+		version = int(v)
+	case int64:
+		version = int(v)
+	default:
+		return 0, nil, fmt.Errorf("%w: header is missing a version", ErrInvalidArchive)
+	}
+
+	list, _ := header["roots"].([]interface{})
+	for _, r := range list {
+		id, ok := r.(cid.Cid)
+		if !ok {
+			return 0, nil, fmt.Errorf("%w: header root %v isn't a CID", ErrInvalidArchive, r)
+		}
+		roots = append(roots, id)
+	}
+	return version, roots, nil
+}
+
+// readSection reads a length-prefixed section, returning io.EOF if the archive
+// ends before the section starts
+func (cr *Reader) readSection() ([]byte, error) {
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: reading section length: %s", ErrInvalidArchive, err)
+	}
+	if size == 0 || size > maxSectionSize {
+		return nil, fmt.Errorf("%w: invalid section length %d", ErrInvalidArchive, size)
+	}
+	section := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, section); err != nil {
+		return nil, fmt.Errorf("%w: reading section: %s", ErrInvalidArchive, err)
+	}
+	return section, nil
+}
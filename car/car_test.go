@@ -0,0 +1,94 @@
+package car
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	blks := []blocks.Block{rawBlock(t, "one"), rawBlock(t, "two"), rawBlock(t, "three")}
+	data := writeArchive(t, blks[0].Cid(), blks)
+
+	r, err := NewReader(bytes.NewReader(data))
+	require.Nil(t, err)
+	assert.Equal(t, []cid.Cid{blks[0].Cid()}, r.Roots)
+	assert.Equal(t, blks, readAll(t, r))
+}
+
+func TestReadCARv2(t *testing.T) {
+	blks := []blocks.Block{rawBlock(t, "one"), rawBlock(t, "two")}
+	payload := writeArchive(t, blks[1].Cid(), blks)
+
+	const padding = 7
+	v2 := &bytes.Buffer{}
+	v2.Write([]byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02})
+	header := make([]byte, v2HeaderSize)
+	binary.LittleEndian.PutUint64(header[16:24], v2PragmaSize+v2HeaderSize+padding)
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(payload)))
+	v2.Write(header)
+	v2.Write(make([]byte, padding))
+	v2.Write(payload)
+	// trailing index data must be ignored
+	v2.Write([]byte("index"))
+
+	r, err := NewReader(v2)
+	require.Nil(t, err)
+	assert.Equal(t, []cid.Cid{blks[1].Cid()}, r.Roots)
+	assert.Equal(t, blks, readAll(t, r))
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := NewReader(bytes.NewReader(nil))
+	assert.True(t, errors.Is(err, ErrInvalidArchive), "empty archives are invalid")
+
+	blk := rawBlock(t, "one")
+	data := writeArchive(t, blk.Cid(), []blocks.Block{blk})
+	// corrupt the last byte of block data
+	data[len(data)-1] ^= 0xff
+	r, err := NewReader(bytes.NewReader(data))
+	require.Nil(t, err)
+	_, err = r.Next()
+	assert.True(t, errors.Is(err, ErrInvalidArchive), "blocks that don't match their CIDs are invalid")
+}
+
+func rawBlock(t *testing.T, data string) blocks.Block {
+	t.Helper()
+	id, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte(data))
+	require.Nil(t, err)
+	blk, err := blocks.NewBlockWithCid([]byte(data), id)
+	require.Nil(t, err)
+	return blk
+}
+
+func writeArchive(t *testing.T, root cid.Cid, blks []blocks.Block) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, root)
+	require.Nil(t, err)
+	for _, blk := range blks {
+		require.Nil(t, w.Put(blk))
+	}
+	require.Nil(t, w.Flush())
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, r *Reader) (blks []blocks.Block) {
+	t.Helper()
+	for {
+		blk, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return blks
+		}
+		require.Nil(t, err)
+		blks = append(blks, blk)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -17,6 +22,7 @@ import (
 	golog "github.com/ipfs/go-log"
 	wnfs "github.com/qri-io/wnfs-go"
 	base "github.com/qri-io/wnfs-go/base"
+	dagsync "github.com/qri-io/wnfs-go/dagsync"
 	fsdiff "github.com/qri-io/wnfs-go/fsdiff"
 	gateway "github.com/qri-io/wnfs-go/gateway"
 	public "github.com/qri-io/wnfs-go/public"
@@ -42,14 +48,27 @@ func main() {
 				Aliases: []string{"v"},
 				Usage:   "print verbose output",
 			},
+			&cli.StringFlag{
+				Name:  "cipher",
+				Usage: "cipher suite private writes are encrypted with. one of aes-gcm, chacha20-poly1305, xchacha20-poly1305",
+			},
 		},
 		Before: func(c *cli.Context) (err error) {
 			if c.Bool("verbose") {
 				golog.SetLogLevel("wnfs", "debug")
 			}
 
-			repo, err = OpenRepo(ctx)
-			return err
+			if repo, err = OpenRepo(ctx); err != nil {
+				return err
+			}
+			if s := c.String("cipher"); s != "" {
+				cipher, err := wnfs.ParseCipher(s)
+				if err != nil {
+					return err
+				}
+				repo.WNFS().SetCipher(cipher)
+			}
+			return nil
 		},
 		Commands: []*cli.Command{
 			// read commands
@@ -273,30 +292,146 @@ size:	%d
 					return repo.Commit(fs)
 				},
 			},
+			{
+				Name:  "revoke",
+				Usage: "rotate the keys of a private file or directory, cutting off old key holders",
+				Action: func(c *cli.Context) error {
+					fs := repo.WNFS()
+					if err := fs.Revoke(c.Args().Get(0)); err != nil {
+						return err
+					}
+					return repo.Commit(fs)
+				},
+			},
 			{
 				Name:  "merge",
 				Usage: "",
+				Flags: append(mergeFlags(),
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "report what the merge would do without changing this repo",
+					},
+				),
 				Action: func(c *cli.Context) error {
+					opts, err := mergeOptions(c)
+					if err != nil {
+						return err
+					}
 					a := repo.WNFS()
 					cmdCtx, cancel := context.WithCancel(ctx)
 					defer cancel()
 
-					path := c.Args().Get(0)
-					if filepath.Base(path) != repoDirname {
-						path = filepath.Join(path, repoDirname)
-					}
-					fmt.Printf("reading wnfs repo from %q ...", path)
-					bRepo, err := OpenRepoPath(cmdCtx, path)
+					asJSON := c.Bool("json")
+					bRepo, err := openOtherRepo(cmdCtx, c.Args().Get(0), asJSON)
 					if err != nil {
 						return err
 					}
 					b := bRepo.WNFS()
-					fmt.Printf("done\n")
 
-					if err = wnfs.Merge(cmdCtx, a, b); err != nil {
+					merge := wnfs.Merge
+					if c.Bool("dry-run") {
+						merge = wnfs.MergePreview
+					}
+					res, err := merge(cmdCtx, a, b, opts...)
+					if err != nil {
+						return err
+					}
+					if err := printMergeReport(res.Report(), asJSON); err != nil {
+						return err
+					}
+					if c.Bool("dry-run") || res.Type == base.MTInSync || res.Type == base.MTLocalAhead {
+						return nil
+					}
+					return repo.SaveCommit(wnfs.CommitResult{
+						Root:        res.Root,
+						PrivateName: res.PrivateName,
+						PrivateKey:  res.PrivateKey,
+					})
+				},
+			},
+			{
+				Name:  "pull",
+				Usage: "fetch the blocks another repo has that this one doesn't & merge its filesystem into this repo",
+				Flags: mergeFlags(),
+				Action: func(c *cli.Context) error {
+					cmdCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+					remote, err := openOtherRepo(cmdCtx, c.Args().Get(0), c.Bool("json"))
+					if err != nil {
+						return err
+					}
+					return pullRepo(cmdCtx, c, repo, remote)
+				},
+			},
+			{
+				Name:  "push",
+				Usage: "send the blocks another repo doesn't have & merge this repo's filesystem into it",
+				Flags: mergeFlags(),
+				Action: func(c *cli.Context) error {
+					cmdCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+					remote, err := openOtherRepo(cmdCtx, c.Args().Get(0), c.Bool("json"))
+					if err != nil {
+						return err
+					}
+					return pullRepo(cmdCtx, c, remote, repo)
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "write the last commit to a CAR archive",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "public-only",
+						Usage: "leave the encrypted private hierarchy out of the archive",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					cmdCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+
+					root := repo.state.RootCID
+					if !root.Defined() {
+						return fmt.Errorf("nothing to export, repo has no commits")
+					}
+					f, err := os.Create(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+
+					var opts []wnfs.ExportOption
+					if c.Bool("public-only") {
+						opts = append(opts, wnfs.ExportPublicOnly())
+					}
+					if err := wnfs.Export(cmdCtx, repo.Store().Blockservice(), root, f, opts...); err != nil {
+						return err
+					}
+					fmt.Printf("exported %s to %q\n", root, c.Args().Get(0))
+					return f.Close()
+				},
+			},
+			{
+				Name:  "import",
+				Usage: "load the blocks of a CAR archive into this repo",
+				Action: func(c *cli.Context) error {
+					cmdCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+
+					f, err := os.Open(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+
+					roots, err := wnfs.Import(cmdCtx, repo.Store().Blockservice(), f)
+					if err != nil {
 						return err
 					}
-					return repo.Commit(a)
+					for _, id := range roots {
+						fmt.Printf("imported root %s\n", id)
+					}
+					return nil
 				},
 			},
 
@@ -368,10 +503,24 @@ size:	%d
 			{
 				Name:  "gateway",
 				Usage: "",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "write-token",
+						Value:   "",
+						Usage:   "bearer token that enables writing to this repo at /roots/default",
+						EnvVars: []string{"WNFS_GATEWAY_WRITE_TOKEN"},
+					},
+				},
 				Action: func(c *cli.Context) error {
 					s := &gateway.Server{
 						Factory: repo.Factory(),
 					}
+					if token := c.String("write-token"); token != "" {
+						s.Authorize = gateway.BearerTokenAuth(token)
+						s.Roots = map[string]*gateway.Root{
+							"default": gateway.NewRoot(repo.WNFS(), repo.SaveCommit),
+						}
+					}
 
 					port := c.Args().Get(0)
 					if port == "" {
@@ -384,6 +533,44 @@ size:	%d
 				},
 			},
 
+			// WebDAV server
+			{
+				Name:  "webdav",
+				Usage: "serve this repo over WebDAV",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "commit-interval",
+						Value: 0,
+						Usage: "how often to commit changes. zero commits after every change",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					port := c.Args().Get(0)
+					if port == "" {
+						port = ":8080"
+					} else if !strings.HasPrefix(port, ":") {
+						port = ":" + port
+					}
+
+					dav := gateway.NewWebDAV(gateway.NewRoot(repo.WNFS(), repo.SaveCommit), "", c.Duration("commit-interval"))
+					srv := &http.Server{Addr: port, Handler: dav}
+
+					sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+					defer stop()
+					go func() {
+						<-sigCtx.Done()
+						srv.Shutdown(context.Background())
+					}()
+
+					fmt.Printf("serving webdav on %s\n", port)
+					if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+						dav.Close()
+						return err
+					}
+					return dav.Close()
+				},
+			},
+
 			// plumbing & diagnostic commands
 			{
 				Name: "block",
@@ -475,6 +662,193 @@ size:	%d
 	}
 }
 
+// printMergeReport writes a merge report to stdout as json or a table with a
+// row per path
+func printMergeReport(rep wnfs.MergeReport, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if rep.Public != "" {
+		fmt.Printf("/public:\t%s\n", rep.Public)
+	}
+	if rep.Private != "" {
+		fmt.Printf("/private:\t%s\n", rep.Private)
+	}
+	fmt.Printf("root:\t\t%s\t%s\n", rep.Type, rep.Root)
+	if len(rep.Paths) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nPATH\tACTION\tRESOLUTION\tCOPY")
+	for _, d := range rep.Paths {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Path, d.Action, d.Resolution, d.Copy)
+	}
+	return w.Flush()
+}
+
+// mergeFlags are the flags of commands that merge another repo
+func mergeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "resolve",
+			Value: "",
+			Usage: "how to resolve conflicts: local, remote, lww, keep-both or ask",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the merge report as json",
+		},
+		&cli.BoolFlag{
+			Name:  "structured",
+			Usage: "merge data files & metadata field by field",
+		},
+		&cli.BoolFlag{
+			Name:  "text",
+			Usage: "merge text files line by line, marking overlapping edits",
+		},
+	}
+}
+
+// mergeOptions reads merge options from the flags of a merging command
+func mergeOptions(c *cli.Context) ([]base.MergeOption, error) {
+	resolver, err := conflictResolver(c.String("resolve"))
+	if err != nil {
+		return nil, err
+	}
+	var opts []base.MergeOption
+	if resolver != nil {
+		opts = append(opts, base.WithConflictResolver(resolver))
+	}
+	if c.Bool("structured") {
+		opts = append(opts, base.WithStructuredMerge())
+	}
+	if c.Bool("text") {
+		opts = append(opts, base.WithTextMerge())
+	}
+	return opts, nil
+}
+
+// openOtherRepo opens the repo at path, which may be the repo directory or
+// the directory that contains it
+func openOtherRepo(ctx context.Context, path string, quiet bool) (*Repo, error) {
+	if filepath.Base(path) != repoDirname {
+		path = filepath.Join(path, repoDirname)
+	}
+	if !quiet {
+		fmt.Printf("reading wnfs repo from %q ...", path)
+	}
+	r, err := OpenRepoPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !quiet {
+		fmt.Printf("done\n")
+	}
+	return r, nil
+}
+
+// pullRepo copies the blocks of the last commit of src that dst is missing &
+// merges the src filesystem into dst
+func pullRepo(ctx context.Context, c *cli.Context, dst, src *Repo) error {
+	opts, err := mergeOptions(c)
+	if err != nil {
+		return err
+	}
+	if !src.state.RootCID.Defined() {
+		return fmt.Errorf("%q has no commits", src.path)
+	}
+
+	remote := dagsync.NewBlockstoreRemote(src.Store().Blockservice().Blockstore())
+	res, stats, err := wnfs.Pull(ctx, dst.WNFS(), remote, src.state.RootCID, src.state.GetRootKey(), src.state.GetPrivateName(), opts...)
+	if err != nil {
+		return err
+	}
+	asJSON := c.Bool("json")
+	if !asJSON {
+		fmt.Printf("transferred %d blocks (%s)\n", stats.Blocks, humanize.Bytes(uint64(stats.Bytes)))
+	}
+	if err := printMergeReport(res.Report(), asJSON); err != nil {
+		return err
+	}
+	if res.Type == base.MTInSync || res.Type == base.MTLocalAhead {
+		return nil
+	}
+	return dst.SaveCommit(wnfs.CommitResult{
+		Root:        res.Root,
+		PrivateName: res.PrivateName,
+		PrivateKey:  res.PrivateKey,
+	})
+}
+
+// conflictResolver returns the merge conflict resolver named by flag, nil for
+// the default resolution
+func conflictResolver(flag string) (base.ConflictResolver, error) {
+	switch flag {
+	case "":
+		return nil, nil
+	case "local":
+		return base.PreferLocal, nil
+	case "remote":
+		return base.PreferRemote, nil
+	case "lww":
+		return base.LastWriterWins, nil
+	case "keep-both":
+		return base.KeepBoth, nil
+	case "ask":
+		in := bufio.NewReader(os.Stdin)
+		return base.ResolverFunc(func(c base.Conflict) (base.Resolution, error) {
+			fmt.Printf("conflict: %s\n", c.Path)
+			fmt.Printf("  local:\t%s\n", describeConflictVersion(c.Local))
+			fmt.Printf("  remote:\t%s\n", describeConflictVersion(c.Remote))
+			if c.Hunks > 0 {
+				fmt.Printf("  %d overlapping edits\n", c.Hunks)
+			}
+			for {
+				if c.Hunks > 0 {
+					fmt.Printf("keep [l]ocal, [r]emote, [b]oth or [m]ark overlapping edits? ")
+				} else {
+					fmt.Printf("keep [l]ocal, [r]emote or [b]oth? ")
+				}
+				line, err := in.ReadString('\n')
+				if err != nil {
+					return "", err
+				}
+				switch strings.TrimSpace(line) {
+				case "l", "local":
+					return base.RLocal, nil
+				case "r", "remote":
+					return base.RRemote, nil
+				case "b", "both":
+					return base.RKeepBoth, nil
+				case "m", "mark":
+					if c.Hunks > 0 {
+						return base.RMarkers, nil
+					}
+				}
+			}
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown conflict resolution %q", flag)
+	}
+}
+
+func describeConflictVersion(v *base.ConflictVersion) string {
+	if v == nil {
+		return "deleted"
+	}
+	if !v.IsFile {
+		return fmt.Sprintf("directory %s", v.Cid)
+	}
+	return fmt.Sprintf("%s, modified %s", humanize.Bytes(uint64(v.Size)), humanize.Time(time.Unix(v.Mtime, 0)))
+}
+
 func errExit(msg string, v ...interface{}) {
 	fmt.Printf(msg, v...)
 	os.Exit(1)
@@ -125,13 +125,17 @@ func (r *Repo) Commit(fs wnfs.WNFS) error {
 	if err != nil {
 		return err
 	}
+	return r.SaveCommit(res)
+}
 
+// SaveCommit records a commit of the repo filesystem as the current state
+func (r *Repo) SaveCommit(res wnfs.CommitResult) error {
 	r.state.RootCID = res.Root
 	r.state.PrivateRootName = res.PrivateName
 	r.state.RootKey = res.PrivateKey
 
 	if r.state.PrivateRootName != nil && r.state.RootKey != nil {
-		if err = r.dec.PutDecryptionFields(r.state.RootCID, *r.state.PrivateRootName, *r.state.RootKey); err != nil {
+		if err := r.dec.PutDecryptionFields(r.state.RootCID, *r.state.PrivateRootName, *r.state.RootKey); err != nil {
 			return fmt.Errorf("updating decryption store: %w", err)
 		}
 	}
@@ -0,0 +1,532 @@
+// Package dagmod edits chunked UnixFS file DAGs in place. Only the leaves
+// touched by an edit are read & rewritten, untouched subtrees are linked into
+// the result as-is
+package dagmod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	golog "github.com/ipfs/go-log"
+	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+)
+
+var log = golog.Logger("wnfs")
+
+// ErrNegativeOffset is returned when reading, writing or truncating at an
+// offset before the start of a file
+var ErrNegativeOffset = errors.New("negative offset")
+
+// Codec converts between file content & leaf nodes
+type Codec interface {
+	// Overhead is the number of bytes a leaf adds to the content it stores.
+	// Codecs with overhead can only edit DAGs where every leaf except the last
+	// holds a full chunk, which is how the chunkers in this module lay out
+	// files
+	Overhead() int
+	// DecodeLeaf returns the content stored in a leaf node
+	DecodeLeaf(nd ipld.Node) ([]byte, error)
+	// EncodeLeaf creates a leaf node that stores data
+	EncodeLeaf(data []byte) (ipld.Node, error)
+}
+
+// Params configures the layout of nodes written by a Modifier. Params should
+// match the ones used to create the DAG being edited
+type Params struct {
+	// ChunkSize is the number of content bytes in each new leaf
+	ChunkSize int
+	// MaxLinks is the maximum number of children of an internal node
+	MaxLinks int
+	// CidBuilder creates CIDs for internal nodes
+	CidBuilder cid.Builder
+}
+
+// node is a lazily-loaded node in a file DAG
+type node struct {
+	link      *ipld.Link // stored version of this node, nil if modified
+	blockSize uint64     // unixfs block size of the stored version
+	size      uint64     // content size
+
+	loaded   bool
+	leaf     bool
+	data     []byte  // leaf content
+	children []*node // internal node children
+}
+
+// Modifier edits a file DAG. Edits are buffered in memory until Commit
+type Modifier struct {
+	ctx    context.Context
+	dag    ipld.DAGService
+	codec  Codec
+	params Params
+	root   *node
+}
+
+// New creates a modifier for an empty file
+func New(ctx context.Context, dag ipld.DAGService, codec Codec, params Params) *Modifier {
+	return &Modifier{
+		ctx:    ctx,
+		dag:    dag,
+		codec:  codec,
+		params: params,
+		root:   &node{loaded: true, leaf: true, data: []byte{}},
+	}
+}
+
+// Open creates a modifier for the file DAG rooted at id
+func Open(ctx context.Context, dag ipld.DAGService, id cid.Cid, codec Codec, params Params) (*Modifier, error) {
+	nd, err := dag.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("loading file root %s: %w", id, err)
+	}
+	link, err := ipld.MakeLink(nd)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Modifier{
+		ctx:    ctx,
+		dag:    dag,
+		codec:  codec,
+		params: params,
+		root:   &node{link: link},
+	}
+	if err := m.decode(m.root, nd); err != nil {
+		return nil, err
+	}
+	if m.root.leaf {
+		m.root.data = append([]byte(nil), m.root.data...)
+		m.root.blockSize = uint64(len(m.root.data) + codec.Overhead())
+	} else {
+		fsn, err := unixfs.ExtractFSNode(nd)
+		if err != nil {
+			return nil, err
+		}
+		m.root.blockSize = fsn.FileSize()
+	}
+	return m, nil
+}
+
+// Size returns the length of the file content
+func (m *Modifier) Size() int64 { return int64(m.root.size) }
+
+// ReadAt reads len(p) bytes starting at off. Leaves loaded to satisfy a read
+// aren't kept in memory
+func (m *Modifier) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	size := m.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := len(p)
+	if int64(n) > size-off {
+		n = int(size - off)
+	}
+	if err := m.readAt(m.root, p[:n], uint64(off)); err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *Modifier) readAt(n *node, p []byte, off uint64) error {
+	v, err := m.view(n)
+	if err != nil {
+		return err
+	}
+	if v.leaf {
+		copy(p, v.data[off:])
+		return nil
+	}
+	return span(v, off, uint64(len(p)), func(ch *node, chOff, lo, hi uint64) error {
+		return m.readAt(ch, p[lo:hi], chOff)
+	})
+}
+
+// WriteAt writes p starting at off. Writing past the end of the file fills
+// the gap with zeros
+func (m *Modifier) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	if off > m.Size() {
+		if err := m.Truncate(off); err != nil {
+			return 0, err
+		}
+	}
+
+	inPlace := m.Size() - off
+	if inPlace > int64(len(p)) {
+		inPlace = int64(len(p))
+	}
+	if inPlace > 0 {
+		if err := m.writeAt(m.root, p[:inPlace], uint64(off)); err != nil {
+			return 0, err
+		}
+	}
+	if err := m.append(p[inPlace:]); err != nil {
+		return int(inPlace), err
+	}
+	return len(p), nil
+}
+
+func (m *Modifier) writeAt(n *node, p []byte, off uint64) error {
+	if err := m.load(n); err != nil {
+		return err
+	}
+	n.link = nil
+	if n.leaf {
+		copy(n.data[off:], p)
+		return nil
+	}
+	return span(n, off, uint64(len(p)), func(ch *node, chOff, lo, hi uint64) error {
+		return m.writeAt(ch, p[lo:hi], chOff)
+	})
+}
+
+// Truncate changes the size of the file. Growing the file fills the new space
+// with zeros
+func (m *Modifier) Truncate(size int64) error {
+	cur := m.Size()
+	switch {
+	case size < 0:
+		return ErrNegativeOffset
+	case size == cur:
+		return nil
+	case size == 0:
+		m.root = &node{loaded: true, leaf: true, data: []byte{}}
+		return nil
+	case size < cur:
+		return m.truncate(m.root, uint64(size))
+	}
+
+	gap := size - cur
+	zeros := make([]byte, m.params.ChunkSize)
+	for gap > 0 {
+		n := int64(len(zeros))
+		if n > gap {
+			n = gap
+		}
+		if err := m.append(zeros[:n]); err != nil {
+			return err
+		}
+		gap -= n
+	}
+	return nil
+}
+
+func (m *Modifier) truncate(n *node, size uint64) error {
+	if err := m.load(n); err != nil {
+		return err
+	}
+	n.link = nil
+	n.size = size
+	if n.leaf {
+		n.data = n.data[:size]
+		return nil
+	}
+
+	var start uint64
+	for i, ch := range n.children {
+		end := start + ch.size
+		if end >= size {
+			n.children = n.children[:i+1]
+			if end > size {
+				return m.truncate(ch, size-start)
+			}
+			return nil
+		}
+		start = end
+	}
+	return nil
+}
+
+// append adds p to the end of the file, filling the last leaf before adding
+// new ones
+func (m *Modifier) append(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	path, err := m.rightmost()
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if room := m.params.ChunkSize - len(last.data); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		last.data = append(last.data, p[:room]...)
+		for _, n := range path {
+			n.link = nil
+			n.size += uint64(room)
+		}
+		p = p[room:]
+	}
+
+	for len(p) > 0 {
+		size := m.params.ChunkSize
+		if size > len(p) {
+			size = len(p)
+		}
+		leaf := &node{loaded: true, leaf: true, data: append([]byte(nil), p[:size]...), size: uint64(size)}
+		if err := m.push(leaf, len(path)-1); err != nil {
+			return err
+		}
+		if path, err = m.rightmost(); err != nil {
+			return err
+		}
+		p = p[size:]
+	}
+	return nil
+}
+
+// rightmost loads the path from the root to the last leaf
+func (m *Modifier) rightmost() ([]*node, error) {
+	n := m.root
+	path := []*node{}
+	for {
+		if err := m.load(n); err != nil {
+			return nil, err
+		}
+		path = append(path, n)
+		if n.leaf {
+			return path, nil
+		}
+		n = n.children[len(n.children)-1]
+	}
+}
+
+// push adds leaf to the end of the file, keeping the balanced layout: the
+// rightmost subtree is filled first, and the tree grows by a level once the
+// root is full
+func (m *Modifier) push(leaf *node, height int) error {
+	ok, err := m.pushInto(m.root, height, leaf)
+	if err != nil || ok {
+		return err
+	}
+	m.root = &node{
+		loaded:   true,
+		children: []*node{m.root, chain(height, leaf)},
+		size:     m.root.size + leaf.size,
+	}
+	return nil
+}
+
+func (m *Modifier) pushInto(n *node, height int, leaf *node) (bool, error) {
+	if height == 0 {
+		return false, nil
+	}
+	if height > 1 {
+		last := n.children[len(n.children)-1]
+		if err := m.load(last); err != nil {
+			return false, err
+		}
+		ok, err := m.pushInto(last, height-1, leaf)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			n.link = nil
+			n.size += leaf.size
+			return true, nil
+		}
+	}
+	if len(n.children) >= m.params.MaxLinks {
+		return false, nil
+	}
+	n.children = append(n.children, chain(height-1, leaf))
+	n.link = nil
+	n.size += leaf.size
+	return true, nil
+}
+
+// chain wraps leaf in height single-child internal nodes
+func chain(height int, leaf *node) *node {
+	n := leaf
+	for i := 0; i < height; i++ {
+		n = &node{loaded: true, children: []*node{n}, size: leaf.size}
+	}
+	return n
+}
+
+// Commit writes all modified nodes, returning the root CID of the edited
+// file. Unmodified subtrees are linked, not rewritten
+func (m *Modifier) Commit() (cid.Cid, error) {
+	// drop single-child roots left behind by truncation
+	for m.root.loaded && !m.root.leaf && len(m.root.children) == 1 {
+		m.root = m.root.children[0]
+	}
+
+	link, _, err := m.build(m.root)
+	if err != nil {
+		return cid.Undef, err
+	}
+	log.Debugw("dagmod.Commit", "root", link.Cid, "size", m.root.size)
+	return link.Cid, nil
+}
+
+func (m *Modifier) build(n *node) (*ipld.Link, uint64, error) {
+	if n.link != nil {
+		return n.link, n.blockSize, nil
+	}
+
+	var (
+		nd        ipld.Node
+		blockSize uint64
+		err       error
+	)
+	if n.leaf {
+		if nd, err = m.codec.EncodeLeaf(n.data); err != nil {
+			return nil, 0, err
+		}
+		blockSize = uint64(len(n.data) + m.codec.Overhead())
+	} else {
+		fsn := unixfs.NewFSNode(unixfs.TFile)
+		pn := merkledag.NodeWithData(nil)
+		pn.SetCidBuilder(m.params.CidBuilder)
+		for _, ch := range n.children {
+			l, bs, err := m.build(ch)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := pn.AddRawLink("", l); err != nil {
+				return nil, 0, err
+			}
+			fsn.AddBlockSize(bs)
+		}
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		pn.SetData(data)
+		nd = pn
+		blockSize = fsn.FileSize()
+	}
+
+	if err := m.dag.Add(m.ctx, nd); err != nil {
+		return nil, 0, err
+	}
+	if n.link, err = ipld.MakeLink(nd); err != nil {
+		return nil, 0, err
+	}
+	n.blockSize = blockSize
+	return n.link, blockSize, nil
+}
+
+// load reads a node from the DAG for modification
+func (m *Modifier) load(n *node) error {
+	if n.loaded {
+		return nil
+	}
+	nd, err := m.dag.Get(m.ctx, n.link.Cid)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", n.link.Cid, err)
+	}
+	if err := m.decode(n, nd); err != nil {
+		return err
+	}
+	if n.leaf {
+		// decoded leaf content may share memory with the stored block
+		n.data = append([]byte(nil), n.data...)
+	}
+	return nil
+}
+
+// view returns a loaded version of n without keeping leaf content in memory
+func (m *Modifier) view(n *node) (*node, error) {
+	if n.loaded {
+		return n, nil
+	}
+	nd, err := m.dag.Get(m.ctx, n.link.Cid)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", n.link.Cid, err)
+	}
+	if len(nd.Links()) == 0 {
+		v := &node{}
+		return v, m.decode(v, nd)
+	}
+	return n, m.decode(n, nd)
+}
+
+func (m *Modifier) decode(n *node, nd ipld.Node) error {
+	if len(nd.Links()) == 0 {
+		data, err := m.codec.DecodeLeaf(nd)
+		if err != nil {
+			return fmt.Errorf("decoding leaf %s: %w", nd.Cid(), err)
+		}
+		n.leaf = true
+		n.data = data
+		n.size = uint64(len(data))
+		n.loaded = true
+		return nil
+	}
+
+	fsn, err := unixfs.ExtractFSNode(nd)
+	if err != nil {
+		return fmt.Errorf("decoding node %s: %w", nd.Cid(), err)
+	}
+	if len(fsn.Data()) > 0 {
+		return fmt.Errorf("node %s: editing files with data in internal nodes is not supported", nd.Cid())
+	}
+	if fsn.NumChildren() != len(nd.Links()) {
+		return fmt.Errorf("node %s: block sizes don't match links", nd.Cid())
+	}
+
+	n.children = make([]*node, len(nd.Links()))
+	n.size = 0
+	for i, l := range nd.Links() {
+		bs := fsn.BlockSize(i)
+		ch := &node{link: l, blockSize: bs, size: m.contentSize(bs)}
+		n.children[i] = ch
+		n.size += ch.size
+	}
+	n.loaded = true
+	return nil
+}
+
+// contentSize converts a unixfs block size to the size of the content it
+// stores, removing per-leaf overhead
+func (m *Modifier) contentSize(blockSize uint64) uint64 {
+	overhead := uint64(m.codec.Overhead())
+	if overhead == 0 {
+		return blockSize
+	}
+	full := uint64(m.params.ChunkSize) + overhead
+	leaves := (blockSize + full - 1) / full
+	return blockSize - leaves*overhead
+}
+
+// span calls fn for each child of n that overlaps length bytes starting at
+// off, passing the offset into the child & the range of the span it covers
+func span(n *node, off, length uint64, fn func(ch *node, chOff, lo, hi uint64) error) error {
+	var start uint64
+	for _, ch := range n.children {
+		end := start + ch.size
+		if end > off && start < off+length {
+			lo, hi := start, end
+			if lo < off {
+				lo = off
+			}
+			if hi > off+length {
+				hi = off + length
+			}
+			if err := fn(ch, lo-start, lo-off, hi-off); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package dagmod
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
+	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+// rawCodec stores content in raw leaves
+type rawCodec struct{}
+
+func (rawCodec) Overhead() int { return 0 }
+func (rawCodec) DecodeLeaf(nd ipld.Node) ([]byte, error) {
+	return nd.RawData(), nil
+}
+func (rawCodec) EncodeLeaf(data []byte) (ipld.Node, error) {
+	return merkledag.NewRawNodeWPrefix(data, merkledag.V1CidPrefix())
+}
+
+// prefixCodec adds a fixed-size prefix to each leaf, standing in for codecs
+// with overhead like encryption
+type prefixCodec struct{}
+
+var leafPrefix = []byte("pfx:")
+
+func (prefixCodec) Overhead() int { return len(leafPrefix) }
+func (prefixCodec) DecodeLeaf(nd ipld.Node) ([]byte, error) {
+	return bytes.TrimPrefix(nd.RawData(), leafPrefix), nil
+}
+func (prefixCodec) EncodeLeaf(data []byte) (ipld.Node, error) {
+	return merkledag.NewRawNodeWPrefix(append(append([]byte{}, leafPrefix...), data...), merkledag.V1CidPrefix())
+}
+
+func testParams() Params {
+	return Params{ChunkSize: 4, MaxLinks: 3, CidBuilder: merkledag.V1CidPrefix()}
+}
+
+func TestModifierEdits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dag := merkledag.NewDAGService(mockblocks.NewOfflineMemBlockservice())
+
+	for name, codec := range map[string]Codec{"raw": rawCodec{}, "prefix": prefixCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			model := []byte{}
+			mod := New(ctx, dag, codec, testParams())
+
+			for i := 0; i < 200; i++ {
+				switch rnd.Intn(3) {
+				case 0: // write
+					off := rnd.Intn(len(model) + 10)
+					p := make([]byte, rnd.Intn(20))
+					rnd.Read(p)
+					_, err := mod.WriteAt(p, int64(off))
+					require.Nil(t, err)
+					if end := off + len(p); end > len(model) {
+						model = append(model, make([]byte, end-len(model))...)
+					}
+					copy(model[off:], p)
+				case 1: // truncate
+					size := rnd.Intn(len(model) + 10)
+					require.Nil(t, mod.Truncate(int64(size)))
+					if size > len(model) {
+						model = append(model, make([]byte, size-len(model))...)
+					}
+					model = model[:size]
+				case 2: // commit & reopen
+					id, err := mod.Commit()
+					require.Nil(t, err)
+					mod, err = Open(ctx, dag, id, codec, testParams())
+					require.Nil(t, err)
+				}
+
+				require.Equal(t, int64(len(model)), mod.Size(), "step %d", i)
+				got := make([]byte, len(model))
+				n, err := mod.ReadAt(got, 0)
+				if err != nil {
+					require.Equal(t, io.EOF, err)
+				}
+				require.Equal(t, len(model), n)
+				require.Equal(t, model, got, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestModifierReusesLeaves(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dag := merkledag.NewDAGService(mockblocks.NewOfflineMemBlockservice())
+
+	mod := New(ctx, dag, rawCodec{}, testParams())
+	_, err := mod.WriteAt([]byte("aaaabbbbccccddddeeeeffffgggghhhh"), 0)
+	require.Nil(t, err)
+	before, err := mod.Commit()
+	require.Nil(t, err)
+
+	mod, err = Open(ctx, dag, before, rawCodec{}, testParams())
+	require.Nil(t, err)
+	_, err = mod.WriteAt([]byte("XX"), 9)
+	require.Nil(t, err)
+	after, err := mod.Commit()
+	require.Nil(t, err)
+
+	a := leafCids(ctx, t, dag, before)
+	b := leafCids(ctx, t, dag, after)
+	require.Equal(t, len(a), len(b))
+	for i := range a {
+		if i == 2 {
+			assert.NotEqual(t, a[i], b[i], "edited leaf should be rewritten")
+			continue
+		}
+		assert.Equal(t, a[i], b[i], "leaf %d should be reused", i)
+	}
+
+	_, err = mod.WriteAt([]byte("x"), -1)
+	assert.ErrorIs(t, err, ErrNegativeOffset)
+}
+
+func leafCids(ctx context.Context, t *testing.T, dag ipld.DAGService, id cid.Cid) []cid.Cid {
+	t.Helper()
+	nd, err := dag.Get(ctx, id)
+	require.Nil(t, err)
+	if len(nd.Links()) == 0 {
+		return []cid.Cid{id}
+	}
+	ids := []cid.Cid{}
+	for _, l := range nd.Links() {
+		ids = append(ids, leafCids(ctx, t, dag, l.Cid)...)
+	}
+	return ids
+}
@@ -0,0 +1,160 @@
+package dagmod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	cid "github.com/ipfs/go-cid"
+	base "github.com/qri-io/wnfs-go/base"
+)
+
+var (
+	// ErrNotWritable is returned when opening a file for editing without
+	// O_WRONLY or O_RDWR
+	ErrNotWritable = errors.New("file must be opened with O_WRONLY or O_RDWR")
+	// ErrWriteOnly is returned when reading from a file opened with O_WRONLY
+	ErrWriteOnly = errors.New("file is open for writing only")
+	// ErrWriteAtInAppendMode is returned when calling WriteAt on a file opened
+	// with O_APPEND
+	ErrWriteAtInAppendMode = errors.New("invalid use of WriteAt on file opened with O_APPEND")
+)
+
+// CommitFunc stores an edited file. root is the CID of the edited content,
+// size its length in bytes
+type CommitFunc func(root cid.Cid, size int64) error
+
+// File is an open file backed by a Modifier. Edits are passed to commit when
+// the file is closed
+type File struct {
+	name   string
+	mod    *Modifier
+	flag   int
+	offset int64
+	dirty  bool
+	closed bool
+	commit CommitFunc
+}
+
+var _ base.WritableFile = (*File)(nil)
+
+// NewFile opens mod as a file. flag is a combination of os package O_* flags
+// & must include O_WRONLY or O_RDWR. created marks files that don't exist
+// yet, which are committed on close even if they aren't written to
+func NewFile(name string, mod *Modifier, flag int, created bool, commit CommitFunc) (*File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return nil, ErrNotWritable
+	}
+
+	f := &File{
+		name:   name,
+		mod:    mod,
+		flag:   flag,
+		dirty:  created,
+		commit: commit,
+	}
+	if flag&os.O_TRUNC != 0 && mod.Size() > 0 {
+		if err := mod.Truncate(0); err != nil {
+			return nil, err
+		}
+		f.dirty = true
+	}
+	return f, nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	return base.NewFSFileInfo(f.name, f.mod.Size(), 0, base.Timestamp(), nil), nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, ErrWriteOnly
+	}
+	return f.mod.ReadAt(p, off)
+}
+
+// Write writes p at the current offset, or the end of the file if the file
+// was opened with O_APPEND
+func (f *File) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = f.mod.Size()
+	}
+	n, err := f.mod.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	f.dirty = true
+	return n, err
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, ErrWriteAtInAppendMode
+	}
+	f.dirty = true
+	return f.mod.WriteAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.mod.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file without moving the offset
+func (f *File) Truncate(size int64) error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.dirty = true
+	return f.mod.Truncate(size)
+}
+
+// Close commits any edits. Closing an unmodified file writes nothing
+func (f *File) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if !f.dirty {
+		return nil
+	}
+
+	root, err := f.mod.Commit()
+	if err != nil {
+		return err
+	}
+	return f.commit(root, f.mod.Size())
+}
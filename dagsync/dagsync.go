@@ -0,0 +1,327 @@
+// Package dagsync copies DAGs between block stores, transferring only the
+// blocks the receiving side is missing. The sides exchange want & have lists
+// one level of the DAG at a time. A block the receiver already has is assumed
+// to come with every block it links to, so its subtree is never walked
+package dagsync
+
+import (
+	"context"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	golog "github.com/ipfs/go-log"
+	merkledag "github.com/ipfs/go-merkledag"
+)
+
+var log = golog.Logger("wnfs")
+
+// batchSize is the maximum number of CIDs in a single want or have request
+const batchSize = 256
+
+// Remote is the far side of a sync
+type Remote interface {
+	// Have reports which of ids the remote stores, in the order of ids
+	Have(ctx context.Context, ids []cid.Cid) ([]bool, error)
+	// Want fetches blocks from the remote in the order of ids. Wanting a block
+	// the remote doesn't have is an error
+	Want(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error)
+	// Send stores blocks on the remote
+	Send(ctx context.Context, blks []blocks.Block) error
+}
+
+// NewBlockstoreRemote creates a Remote that syncs with a blockstore in the
+// same process
+func NewBlockstoreRemote(bs blockstore.Blockstore) Remote {
+	return blockstoreRemote{bs: bs}
+}
+
+type blockstoreRemote struct {
+	bs blockstore.Blockstore
+}
+
+var _ Remote = (*blockstoreRemote)(nil)
+
+func (r blockstoreRemote) Have(ctx context.Context, ids []cid.Cid) ([]bool, error) {
+	return hasBlocks(ctx, r.bs, ids)
+}
+
+func (r blockstoreRemote) Want(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error) {
+	return getBlocks(ctx, r.bs, ids)
+}
+
+func (r blockstoreRemote) Send(ctx context.Context, blks []blocks.Block) error {
+	return r.bs.PutMany(ctx, blks)
+}
+
+// Stats counts the blocks a sync transferred
+type Stats struct {
+	// Blocks is the number of blocks transferred
+	Blocks int
+	// Bytes is the total size of transferred blocks
+	Bytes int64
+}
+
+// Add returns the sum of two Stats
+func (s Stats) Add(o Stats) Stats {
+	return Stats{Blocks: s.Blocks + o.Blocks, Bytes: s.Bytes + o.Bytes}
+}
+
+// Push sends every block reachable from roots that remote doesn't have.
+// Blocks are sent after the blocks they link to, so an interrupted push never
+// leaves the remote with a block whose subtree is incomplete
+func Push(ctx context.Context, local blockstore.Blockstore, remote Remote, roots ...cid.Cid) (stats Stats, err error) {
+	missing, err := walk(ctx, roots, remote.Have, func(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error) {
+		return getBlocks(ctx, local, ids)
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for start := 0; start < len(missing); start += batchSize {
+		batch := missing[start:minInt(start+batchSize, len(missing))]
+		if err := remote.Send(ctx, batch); err != nil {
+			return stats, fmt.Errorf("sending blocks: %w", err)
+		}
+		stats = stats.add(batch)
+	}
+	log.Debugw("dagsync.Push", "roots", len(roots), "blocks", stats.Blocks, "bytes", stats.Bytes)
+	return stats, nil
+}
+
+// Pull fetches every block reachable from roots that local doesn't have.
+// Fetched blocks are checked against their CIDs & held in memory until the
+// whole DAG has arrived, so an interrupted pull leaves local unchanged
+func Pull(ctx context.Context, local blockstore.Blockstore, remote Remote, roots ...cid.Cid) (stats Stats, err error) {
+	has := func(ctx context.Context, ids []cid.Cid) ([]bool, error) {
+		return hasBlocks(ctx, local, ids)
+	}
+	missing, err := walk(ctx, roots, has, func(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error) {
+		blks, err := remote.Want(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, blk := range blks {
+			if err := verifyBlock(blk); err != nil {
+				return nil, err
+			}
+		}
+		return blks, nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for start := 0; start < len(missing); start += batchSize {
+		batch := missing[start:minInt(start+batchSize, len(missing))]
+		if err := local.PutMany(ctx, batch); err != nil {
+			return stats, fmt.Errorf("storing blocks: %w", err)
+		}
+		stats = stats.add(batch)
+	}
+	log.Debugw("dagsync.Pull", "roots", len(roots), "blocks", stats.Blocks, "bytes", stats.Bytes)
+	return stats, nil
+}
+
+// Walk calls visit once for every block in bs reachable from roots, visiting
+// blocks breadth-first
+func Walk(ctx context.Context, bs blockstore.Blockstore, visit func(blk blocks.Block) error, roots ...cid.Cid) error {
+	seen := map[cid.Cid]struct{}{}
+	var queue []cid.Cid
+	push := func(ids []cid.Cid) {
+		for _, id := range ids {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				queue = append(queue, id)
+			}
+		}
+	}
+
+	push(roots)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		blk, err := bs.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting block %s: %w", id, err)
+		}
+		if err := visit(blk); err != nil {
+			return err
+		}
+		links, err := Links(blk)
+		if err != nil {
+			return err
+		}
+		push(links)
+	}
+	return nil
+}
+
+// Links lists the CIDs a block links to. Links of dag-cbor & dag-pb blocks are
+// followed, blocks in any other codec have no links
+func Links(blk blocks.Block) ([]cid.Cid, error) {
+	var links []*ipld.Link
+	switch blk.Cid().Prefix().Codec {
+	case cid.DagCBOR:
+		nd, err := cbornode.DecodeBlock(blk)
+		if err != nil {
+			return nil, fmt.Errorf("decoding block %s: %w", blk.Cid(), err)
+		}
+		links = nd.Links()
+	case cid.DagProtobuf:
+		nd, err := merkledag.DecodeProtobufBlock(blk)
+		if err != nil {
+			return nil, fmt.Errorf("decoding block %s: %w", blk.Cid(), err)
+		}
+		links = nd.Links()
+	default:
+		return nil, nil
+	}
+
+	ids := make([]cid.Cid, len(links))
+	for i, l := range links {
+		ids[i] = l.Cid
+	}
+	return ids, nil
+}
+
+type batchFunc func(ctx context.Context, ids []cid.Cid) ([]bool, error)
+type fetchFunc func(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error)
+
+// walk visits the DAGs under roots breadth-first, asking has about each level
+// & fetching the blocks it reports missing to find the next level. It returns
+// the missing blocks ordered so every block follows the blocks it links to
+func walk(ctx context.Context, roots []cid.Cid, has batchFunc, get fetchFunc) ([]blocks.Block, error) {
+	seen := map[cid.Cid]struct{}{}
+	missing := map[cid.Cid]blocks.Block{}
+	links := map[cid.Cid][]cid.Cid{}
+
+	var queue []cid.Cid
+	for _, id := range roots {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			queue = append(queue, id)
+		}
+	}
+
+	for len(queue) > 0 {
+		batch := queue[:minInt(batchSize, len(queue))]
+		queue = queue[len(batch):]
+
+		have, err := has(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("checking blocks: %w", err)
+		}
+		if len(have) != len(batch) {
+			return nil, fmt.Errorf("checking %d blocks returned %d results", len(batch), len(have))
+		}
+		var wants []cid.Cid
+		for i, id := range batch {
+			if !have[i] {
+				wants = append(wants, id)
+			}
+		}
+		if len(wants) == 0 {
+			continue
+		}
+
+		blks, err := get(ctx, wants)
+		if err != nil {
+			return nil, fmt.Errorf("fetching blocks: %w", err)
+		}
+		if len(blks) != len(wants) {
+			return nil, fmt.Errorf("wanted %d blocks, got %d", len(wants), len(blks))
+		}
+		for i, blk := range blks {
+			if !blk.Cid().Equals(wants[i]) {
+				return nil, fmt.Errorf("wanted block %s, got %s", wants[i], blk.Cid())
+			}
+			ls, err := Links(blk)
+			if err != nil {
+				return nil, err
+			}
+			missing[wants[i]] = blk
+			links[wants[i]] = ls
+			for _, l := range ls {
+				if _, ok := seen[l]; !ok {
+					seen[l] = struct{}{}
+					queue = append(queue, l)
+				}
+			}
+		}
+	}
+
+	ordered := make([]blocks.Block, 0, len(missing))
+	done := map[cid.Cid]struct{}{}
+	var visit func(id cid.Cid)
+	visit = func(id cid.Cid) {
+		if _, ok := done[id]; ok {
+			return
+		}
+		done[id] = struct{}{}
+		for _, l := range links[id] {
+			if _, ok := missing[l]; ok {
+				visit(l)
+			}
+		}
+		ordered = append(ordered, missing[id])
+	}
+	for _, id := range roots {
+		if _, ok := missing[id]; ok {
+			visit(id)
+		}
+	}
+	return ordered, nil
+}
+
+func (s Stats) add(blks []blocks.Block) Stats {
+	for _, blk := range blks {
+		s.Blocks++
+		s.Bytes += int64(len(blk.RawData()))
+	}
+	return s
+}
+
+func hasBlocks(ctx context.Context, bs blockstore.Blockstore, ids []cid.Cid) ([]bool, error) {
+	have := make([]bool, len(ids))
+	for i, id := range ids {
+		var err error
+		if have[i], err = bs.Has(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return have, nil
+}
+
+func getBlocks(ctx context.Context, bs blockstore.Blockstore, ids []cid.Cid) ([]blocks.Block, error) {
+	blks := make([]blocks.Block, len(ids))
+	for i, id := range ids {
+		var err error
+		if blks[i], err = bs.Get(ctx, id); err != nil {
+			return nil, fmt.Errorf("getting block %s: %w", id, err)
+		}
+	}
+	return blks, nil
+}
+
+// verifyBlock checks the data of a block hashes to its CID
+func verifyBlock(blk blocks.Block) error {
+	id, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !id.Equals(blk.Cid()) {
+		return fmt.Errorf("block data doesn't match CID %s", blk.Cid())
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package dagsync
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestPushPull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	raw := func(data string) blocks.Block {
+		t.Helper()
+		id, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte(data))
+		require.Nil(t, err)
+		blk, err := blocks.NewBlockWithCid([]byte(data), id)
+		require.Nil(t, err)
+		return blk
+	}
+	node := func(links map[string]interface{}) blocks.Block {
+		t.Helper()
+		nd, err := cbornode.WrapObject(links, mh.SHA2_256, -1)
+		require.Nil(t, err)
+		return nd
+	}
+
+	l1, l2, l3 := raw("one"), raw("two"), raw("three")
+	mid1 := node(map[string]interface{}{"one": l1.Cid(), "two": l2.Cid()})
+	mid2 := node(map[string]interface{}{"three": l3.Cid(), "one": l1.Cid()})
+	root := node(map[string]interface{}{"mid1": mid1.Cid(), "mid2": mid2.Cid()})
+	all := []blocks.Block{l1, l2, l3, mid1, mid2, root}
+
+	a := mockblocks.NewMemBlockstore()
+	require.Nil(t, a.PutMany(ctx, all))
+	// b already has the mid1 subtree
+	b := mockblocks.NewMemBlockstore()
+	require.Nil(t, b.PutMany(ctx, []blocks.Block{l1, l2, mid1}))
+
+	stats, err := Push(ctx, a, NewBlockstoreRemote(b), root.Cid())
+	require.Nil(t, err)
+	assert.Equal(t, 3, stats.Blocks, "only root, mid2 & three should be sent")
+	assert.Equal(t, int64(len(root.RawData())+len(mid2.RawData())+len(l3.RawData())), stats.Bytes)
+	assertHasAll(ctx, t, b, all)
+
+	stats, err = Push(ctx, a, NewBlockstoreRemote(b), root.Cid())
+	require.Nil(t, err)
+	assert.Equal(t, Stats{}, stats, "pushing again should send nothing")
+
+	c := mockblocks.NewMemBlockstore()
+	stats, err = Pull(ctx, c, NewBlockstoreRemote(b), root.Cid())
+	require.Nil(t, err)
+	assert.Equal(t, len(all), stats.Blocks)
+	assertHasAll(ctx, t, c, all)
+
+	stats, err = Pull(ctx, c, NewBlockstoreRemote(b), root.Cid())
+	require.Nil(t, err)
+	assert.Equal(t, Stats{}, stats, "pulling again should fetch nothing")
+
+	d := mockblocks.NewMemBlockstore()
+	_, err = Pull(ctx, d, tamperedRemote{NewBlockstoreRemote(b)}, root.Cid())
+	assert.NotNil(t, err, "pulling blocks that don't match their CIDs should fail")
+	has, err := d.Has(ctx, root.Cid())
+	require.Nil(t, err)
+	assert.False(t, has, "a failed pull shouldn't store any blocks")
+}
+
+func TestLinks(t *testing.T) {
+	leaf, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte("leaf"))
+	require.Nil(t, err)
+	nd, err := cbornode.WrapObject(map[string]interface{}{"leaf": leaf, "name": "node"}, mh.SHA2_256, -1)
+	require.Nil(t, err)
+
+	links, err := Links(nd)
+	require.Nil(t, err)
+	assert.Equal(t, []cid.Cid{leaf}, links)
+
+	blk, err := blocks.NewBlockWithCid([]byte("leaf"), leaf)
+	require.Nil(t, err)
+	links, err = Links(blk)
+	require.Nil(t, err)
+	assert.Empty(t, links, "raw blocks have no links")
+}
+
+func assertHasAll(ctx context.Context, t *testing.T, bs blockstore.Blockstore, blks []blocks.Block) {
+	t.Helper()
+	for _, blk := range blks {
+		has, err := bs.Has(ctx, blk.Cid())
+		require.Nil(t, err)
+		assert.True(t, has, "missing block %s", blk.Cid())
+	}
+}
+
+// tamperedRemote serves blocks with corrupted data
+type tamperedRemote struct {
+	Remote
+}
+
+func (r tamperedRemote) Want(ctx context.Context, ids []cid.Cid) ([]blocks.Block, error) {
+	blks, err := r.Remote.Want(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, blk := range blks {
+		data := append([]byte("tampered"), blk.RawData()...)
+		if blks[i], err = blocks.NewBlockWithCid(data, blk.Cid()); err != nil {
+			return nil, err
+		}
+	}
+	return blks, nil
+}
@@ -0,0 +1,93 @@
+package wnfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	blockservice "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	car "github.com/qri-io/wnfs-go/car"
+	dagsync "github.com/qri-io/wnfs-go/dagsync"
+)
+
+// ExportOption configures an export
+type ExportOption func(*exportOptions)
+
+type exportOptions struct {
+	publicOnly bool
+}
+
+// ExportPublicOnly leaves private node revisions & their encrypted content
+// out of an export. The private HAMT is still included so the exported root
+// opens without a key
+func ExportPublicOnly() ExportOption {
+	return func(o *exportOptions) {
+		o.publicOnly = true
+	}
+}
+
+// Export writes the filesystem committed at root to w as a CAR archive with
+// root as its only root. The archive holds the history of the commit, the
+// public hierarchy, the private HAMT & every encrypted private node revision,
+// but no keys: opening the private hierarchy of an imported archive takes the
+// same key & private name as the original
+func Export(ctx context.Context, bserv blockservice.BlockService, root cid.Cid, w io.Writer, opts ...ExportOption) error {
+	o := &exportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	bs := bserv.Blockstore()
+	roots := []cid.Cid{root}
+	if !o.publicOnly {
+		headers, err := privateHeaders(ctx, bs, root)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, headers...)
+	}
+
+	cw, err := car.NewWriter(w, root)
+	if err != nil {
+		return err
+	}
+	count := 0
+	err = dagsync.Walk(ctx, bs, func(blk blocks.Block) error {
+		count++
+		return cw.Put(blk)
+	}, roots...)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", root, err)
+	}
+	log.Debugw("Export", "root", root, "publicOnly", o.publicOnly, "blocks", count)
+	return cw.Flush()
+}
+
+// Import loads the blocks of a CAR archive into bserv, returning the roots
+// listed by the archive. Both CARv1 & CARv2 archives can be imported
+func Import(ctx context.Context, bserv blockservice.BlockService, r io.Reader) ([]cid.Cid, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := bserv.Blockstore()
+	count := 0
+	for {
+		blk, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, err
+		}
+		count++
+	}
+	log.Debugw("Import", "roots", cr.Roots, "blocks", count)
+	return cr.Roots, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
 
 	"github.com/ipfs/go-cid"
 	golog "github.com/ipfs/go-log"
@@ -21,16 +23,32 @@ func init() {
 
 type Server struct {
 	Factory wnfs.Factory
+	// Roots are the filesystems the write API can modify, keyed by name
+	Roots map[string]*Root
+	// Authorize checks write requests. Writes are disabled when Authorize is
+	// nil
+	Authorize Authorizer
 }
 
 func (s *Server) Serve(addr string) error {
+	return s.Echo().Start(addr)
+}
+
+// Echo returns an echo instance with all gateway routes registered
+func (s *Server) Echo() *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
 	e.GET("/:cid", s.HandleIndex)
 	e.GET("/:cid/*", s.HandleIndex)
+	e.HEAD("/:cid", s.HandleIndex)
+	e.HEAD("/:cid/*", s.HandleIndex)
 	e.GET("/history/:cid/*", s.HandleHistory)
 	e.GET("/diff/:cid/*", s.HandleDiff)
-	return e.Start(addr)
+
+	e.PUT("/roots/:name/*", s.HandlePut)
+	e.DELETE("/roots/:name/*", s.HandleDelete)
+	e.Add(MethodMkcol, "/roots/:name/*", s.HandleMkcol)
+	return e
 }
 
 func (s *Server) HandleIndex(e echo.Context) error {
@@ -41,8 +59,8 @@ func (s *Server) HandleIndex(e echo.Context) error {
 	}
 
 	switch n.Type() {
-	case base.NTFile:
-		if _, err = io.Copy(e.Response(), n); err != nil {
+	case base.NTFile, base.NTUnixFSFile:
+		if err = serveFile(e, n); err != nil {
 			log.Errorw("writing response error", "err", err)
 			return err
 		}
@@ -133,6 +151,37 @@ func (s *Server) HandleDiff(e echo.Context) error {
 	return nil
 }
 
+// serveFile writes the content of a file node, answering Range & conditional
+// requests. ETags are derived from the node CID, which changes with every
+// revision of the file
+func serveFile(e echo.Context, n base.Node) error {
+	w, r := e.Response(), e.Request()
+	if id := n.Cid(); id.Defined() {
+		w.Header().Set("ETag", etag(id))
+	}
+
+	content, ok := n.(io.ReadSeeker)
+	if !ok {
+		w.Header().Set("Content-Length", strconv.FormatInt(n.Size(), 10))
+		if r.Method == http.MethodHead {
+			return nil
+		}
+		_, err := io.Copy(w, n)
+		return err
+	}
+
+	// ServeContent handles Range, If-Range & If-None-Match headers, and sets
+	// Content-Type from the file extension, sniffing content if the extension
+	// isn't recognized
+	http.ServeContent(w, r, n.Name(), n.ModTime(), content)
+	return nil
+}
+
+// etag formats a CID as a strong entity tag
+func etag(id cid.Cid) string {
+	return fmt.Sprintf("%q", id.String())
+}
+
 func (s *Server) open(ctx context.Context, e echo.Context) (wnfs.Node, error) {
 	idstr, path := e.Param("cid"), e.Param("*")
 	log.Infow("open", "cid", idstr, "path", path)
@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	wnfs "github.com/qri-io/wnfs-go"
+	base "github.com/qri-io/wnfs-go/base"
+	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestRangeRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+
+	content := make([]byte, 600*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	err = fsys.Write("public/image.png", base.NewMemfileBytes("image.png", content))
+	require.Nil(t, err)
+	err = fsys.Write("public/notes", base.NewMemfileBytes("notes", []byte("<html><body>hi</body></html>")))
+	require.Nil(t, err)
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	f, err := fsys.Open("public/image.png")
+	require.Nil(t, err)
+	fileTag := etag(f.(base.Node).Cid())
+
+	s := &Server{Factory: wnfs.Factory{BlockService: bserv, Ratchets: rs}}
+	e := s.Echo()
+	do := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, fmt.Sprintf("/%s/%s", res.Root, path), nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "public/image.png", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, fmt.Sprintf("%d", len(content)), rec.Header().Get("Content-Length"))
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, fileTag, rec.Header().Get("ETag"))
+	assert.Equal(t, content, rec.Body.Bytes())
+
+	rec = do(http.MethodGet, "public/image.png", map[string]string{"Range": "bytes=300000-300099"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, fmt.Sprintf("bytes 300000-300099/%d", len(content)), rec.Header().Get("Content-Range"))
+	assert.Equal(t, content[300000:300100], rec.Body.Bytes())
+
+	rec = do(http.MethodGet, "public/image.png", map[string]string{"Range": "bytes=-10"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, content[len(content)-10:], rec.Body.Bytes())
+
+	// a stale If-Range validator gets the full file
+	rec = do(http.MethodGet, "public/image.png", map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, len(content), rec.Body.Len())
+
+	rec = do(http.MethodGet, "public/image.png", map[string]string{"Range": "bytes=0-9", "If-Range": fileTag})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, content[:10], rec.Body.Bytes())
+
+	rec = do(http.MethodGet, "public/image.png", map[string]string{"If-None-Match": fileTag})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = do(http.MethodHead, "public/image.png", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, fmt.Sprintf("%d", len(content)), rec.Header().Get("Content-Length"))
+	assert.Equal(t, 0, rec.Body.Len())
+
+	// files without a recognized extension have their content type sniffed
+	rec = do(http.MethodGet, "public/notes", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(rec.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "<html><body>hi</body></html>", string(body))
+}
+
+func TestWriteAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	commits := []wnfs.CommitResult{}
+	s := &Server{
+		Factory: wnfs.Factory{BlockService: bserv, Ratchets: rs},
+		Roots: map[string]*Root{
+			"main": NewRoot(fsys, func(res wnfs.CommitResult) error {
+				commits = append(commits, res)
+				return nil
+			}),
+		},
+		Authorize: BearerTokenAuth("secret"),
+	}
+	e := s.Echo()
+	do := func(method, path, token, body string) (*httptest.ResponseRecorder, WriteResult) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		res := WriteResult{}
+		if rec.Code < 300 {
+			require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res), rec.Body.String())
+		}
+		return rec, res
+	}
+
+	rec, _ := do(http.MethodPut, "/roots/main/public/hello.txt", "", "hello")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec, _ = do(http.MethodPut, "/roots/main/public/hello.txt", "wrong", "hello")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec, _ = do(http.MethodPut, "/roots/missing/public/hello.txt", "", "hello")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "unauthorized requests must not reveal which roots exist")
+	rec, _ = do(http.MethodPut, "/roots/missing/public/hello.txt", "secret", "hello")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, 0, len(commits))
+
+	// tokens must be sent with the bearer scheme
+	req := httptest.NewRequest(http.MethodPut, "/roots/main/public/hello.txt", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, 0, len(commits))
+
+	rec, res := do(http.MethodPut, "/roots/main/public/hello.txt", "secret", "hello")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, commits[len(commits)-1].Root.String(), res.Root)
+
+	rec, _ = do(MethodMkcol, "/roots/main/public/dir", "secret", "")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec, _ = do(http.MethodPut, "/roots/main/public/hello.txt?meta", "secret", `{"title":"greeting"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = do(http.MethodPut, "/roots/main/public/dir/nope.txt?meta", "secret", `{}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = do(http.MethodPut, "/roots/main/private/hello.txt?meta", "secret", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "private metadata can't be written through the public header")
+
+	rec, res = do(http.MethodDelete, "/roots/main/public/dir", "secret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = do(http.MethodDelete, "/roots/main/public/dir", "secret", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, 4, len(commits))
+
+	// the returned root is readable through the gateway
+	req = httptest.NewRequest(http.MethodGet, "/"+res.Root+"/public/hello.txt", nil)
+	get := httptest.NewRecorder()
+	e.ServeHTTP(get, req)
+	assert.Equal(t, http.StatusOK, get.Code)
+	assert.Equal(t, "hello", get.Body.String())
+
+	loaded, err := s.Factory.Load(ctx, commits[len(commits)-1].Root)
+	require.Nil(t, err)
+	ents, err := loaded.Ls("public")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ents))
+	f, err := loaded.Open("public/hello.txt")
+	require.Nil(t, err)
+	md, err := f.(base.Node).Metadata()
+	require.Nil(t, err)
+	meta, err := ioutil.ReadAll(md)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"title":"greeting"}`, string(meta))
+}
+
+// failingRollback is a filesystem that can't roll back
+type failingRollback struct {
+	wnfs.WNFS
+}
+
+func (failingRollback) Rollback() error { return fmt.Errorf("rollback failed") }
+
+func TestWriteRollbackFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	commits := 0
+	s := &Server{
+		Roots: map[string]*Root{
+			"main": NewRoot(failingRollback{fsys}, func(wnfs.CommitResult) error {
+				commits++
+				return nil
+			}),
+		},
+		Authorize: BearerTokenAuth("secret"),
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/roots/main/public/missing.txt", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Echo().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, 0, commits)
+}
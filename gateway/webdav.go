@@ -0,0 +1,669 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/qri-io/wnfs-go"
+	"github.com/qri-io/wnfs-go/base"
+	"github.com/qri-io/wnfs-go/public"
+	"golang.org/x/net/webdav"
+)
+
+// MetadataNamespace is the XML namespace of WebDAV properties that expose
+// node metadata
+const MetadataNamespace = "urn:wnfs:metadata"
+
+// WebDAV serves a filesystem over WebDAV. PROPFIND, GET, PUT, MKCOL, DELETE,
+// MOVE & COPY map to Ls, Open, Write, Mkdir, Rm, Mv & Cp. File metadata is
+// exposed as dead properties in MetadataNamespace
+type WebDAV struct {
+	root     *Root
+	prefix   string
+	interval time.Duration
+	handler  *webdav.Handler
+
+	dirty bool // staged, uncommitted changes exist. guarded by root.lk
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+var _ http.Handler = (*WebDAV)(nil)
+
+// NewWebDAV creates a WebDAV handler for root, serving paths beneath prefix.
+// Each change is staged on its own, so a change that fails part way through is
+// rolled back without losing earlier changes. Staged changes are committed
+// every commitInterval, or after every change if commitInterval is zero. Call
+// Close to commit pending changes
+func NewWebDAV(root *Root, prefix string, commitInterval time.Duration) *WebDAV {
+	d := &WebDAV{
+		root:     root,
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		interval: commitInterval,
+	}
+	d.handler = &webdav.Handler{
+		Prefix:     d.prefix,
+		FileSystem: davFS{d},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Infow("webdav", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+
+	if commitInterval > 0 {
+		d.stop = make(chan struct{})
+		d.done = make(chan struct{})
+		go d.commitLoop()
+	}
+	return d
+}
+
+func (d *WebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "COPY" {
+		status, err := d.handleCopy(r)
+		if err != nil {
+			log.Infow("webdav", "method", r.Method, "path", r.URL.Path, "err", err)
+		}
+		w.WriteHeader(status)
+		if status >= 400 {
+			w.Write([]byte(http.StatusText(status)))
+		}
+		return
+	}
+	d.handler.ServeHTTP(w, r)
+}
+
+// Flush commits pending changes
+func (d *WebDAV) Flush() error {
+	d.root.lk.Lock()
+	defer d.root.lk.Unlock()
+	return d.flush()
+}
+
+func (d *WebDAV) flush() error {
+	if !d.dirty {
+		return nil
+	}
+	res, err := d.root.commit()
+	if err != nil {
+		return err
+	}
+	d.dirty = false
+	log.Debugw("webdav commit", "root", res.Root)
+	return nil
+}
+
+// Close stops committing on an interval & commits pending changes
+func (d *WebDAV) Close() error {
+	if d.stop != nil {
+		close(d.stop)
+		<-d.done
+		d.stop = nil
+	}
+	return d.Flush()
+}
+
+func (d *WebDAV) commitLoop() {
+	defer close(d.done)
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := d.Flush(); err != nil {
+				log.Errorw("webdav commit", "err", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// read calls fn with exclusive access to the filesystem
+func (d *WebDAV) read(fn func(fsys wnfs.WNFS) error) error {
+	d.root.lk.Lock()
+	defer d.root.lk.Unlock()
+	return fn(d.root.fs)
+}
+
+// change stages the changes fn makes to the filesystem & marks them for
+// committing. Changes are committed right away unless they're committed on an
+// interval
+func (d *WebDAV) change(fn func(fsys wnfs.WNFS) error) error {
+	d.root.lk.Lock()
+	defer d.root.lk.Unlock()
+	err := d.root.fs.Stage(func(wnfs.PosixFS) error {
+		return fn(d.root.fs)
+	})
+	if err != nil {
+		return err
+	}
+	d.dirty = true
+	if d.interval > 0 {
+		return nil
+	}
+	return d.flush()
+}
+
+// handleCopy copies a resource with Cp. x/net/webdav implements COPY by
+// reading & writing every file, which would rewrite file history
+func (d *WebDAV) handleCopy(r *http.Request) (int, error) {
+	src, err := d.resourcePath(r.URL.Path)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	dstURL, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || r.Header.Get("Destination") == "" {
+		return http.StatusBadRequest, errors.New("invalid destination")
+	}
+	if dstURL.Host != "" && dstURL.Host != r.Host {
+		return http.StatusBadGateway, errors.New("destination is on another server")
+	}
+	dst, err := d.resourcePath(dstURL.Path)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	if src == dst {
+		return http.StatusForbidden, errors.New("source & destination are the same")
+	}
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "infinity" {
+		return http.StatusBadRequest, fmt.Errorf("unsupported depth %q", depth)
+	}
+	overwrite := r.Header.Get("Overwrite") != "F"
+
+	status := http.StatusCreated
+	err = d.change(func(fsys wnfs.WNFS) error {
+		if _, err := stat(fsys, src); err != nil {
+			status = http.StatusNotFound
+			return err
+		}
+		if _, err := stat(fsys, parentPath(dst)); err != nil {
+			status = http.StatusConflict
+			return err
+		}
+		if _, err := stat(fsys, dst); err == nil {
+			if !overwrite {
+				status = http.StatusPreconditionFailed
+				return fmt.Errorf("%q: %w", dst, fs.ErrExist)
+			}
+			if err := fsys.Rm(dst); err != nil {
+				status = http.StatusForbidden
+				return err
+			}
+			status = http.StatusNoContent
+		}
+		if err := fsys.Cp(dst, src, fsys); err != nil {
+			status = http.StatusForbidden
+			return err
+		}
+		return nil
+	})
+	return status, err
+}
+
+// resourcePath converts a URL path to a filesystem path
+func (d *WebDAV) resourcePath(urlPath string) (string, error) {
+	p := strings.TrimPrefix(urlPath, d.prefix)
+	if len(p) == len(urlPath) && d.prefix != "" {
+		return "", os.ErrNotExist
+	}
+	return fsPath(p)
+}
+
+// fsPath converts a WebDAV resource name to a filesystem path. The
+// filesystem root is "."
+func fsPath(name string) (string, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	if p == "" {
+		return ".", nil
+	}
+	switch head := strings.SplitN(p, "/", 2)[0]; head {
+	case wnfs.FileHierarchyNamePublic, wnfs.FileHierarchyNamePrivate:
+		return p, nil
+	default:
+		return "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return "."
+}
+
+// isHierarchyRoot reports whether p can't be created, moved or removed
+func isHierarchyRoot(p string) bool {
+	return p == "." || !strings.Contains(p, "/")
+}
+
+func stat(fsys wnfs.WNFS, p string) (fs.FileInfo, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, davError("stat", p, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return newDavInfo(f, fi), nil
+}
+
+// davError converts filesystem errors to the os errors x/net/webdav checks for
+func davError(op, p string, err error) error {
+	switch {
+	case errors.Is(err, base.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	case errors.Is(err, fs.ErrExist):
+		return &os.PathError{Op: op, Path: p, Err: os.ErrExist}
+	default:
+		return err
+	}
+}
+
+// davFS adapts a filesystem to x/net/webdav
+type davFS struct {
+	d *WebDAV
+}
+
+var _ webdav.FileSystem = (*davFS)(nil)
+
+func (dfs davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, err := fsPath(name)
+	if err != nil {
+		return err
+	}
+	return dfs.d.change(func(fsys wnfs.WNFS) error {
+		if _, err := stat(fsys, p); err == nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+		// Mkdir creates parents, WebDAV requires they exist
+		if _, err := stat(fsys, parentPath(p)); err != nil {
+			return err
+		}
+		return fsys.Mkdir(p)
+	})
+}
+
+func (dfs davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p, err := fsPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 && flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if isHierarchyRoot(p) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		err := dfs.d.read(func(fsys wnfs.WNFS) error {
+			fi, err := stat(fsys, p)
+			if err == nil && fi.IsDir() {
+				return fmt.Errorf("%q is a directory", name)
+			}
+			_, err = stat(fsys, parentPath(p))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return dfs.d.upload(p), nil
+	}
+
+	var f fs.File
+	err = dfs.d.read(func(fsys wnfs.WNFS) (err error) {
+		f, err = fsys.Open(p)
+		return davError("open", name, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{d: dfs.d, path: p, File: f}, nil
+}
+
+func (dfs davFS) RemoveAll(ctx context.Context, name string) error {
+	p, err := fsPath(name)
+	if err != nil {
+		return err
+	}
+	if isHierarchyRoot(p) {
+		return &os.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return dfs.d.change(func(fsys wnfs.WNFS) error {
+		return davError("remove", name, fsys.Rm(p))
+	})
+}
+
+func (dfs davFS) Rename(ctx context.Context, oldName, newName string) error {
+	from, err := fsPath(oldName)
+	if err != nil {
+		return err
+	}
+	to, err := fsPath(newName)
+	if err != nil {
+		return err
+	}
+	if isHierarchyRoot(from) || isHierarchyRoot(to) {
+		return &os.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	return dfs.d.change(func(fsys wnfs.WNFS) error {
+		return davError("rename", oldName, fsys.Mv(from, to))
+	})
+}
+
+func (dfs davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, err := fsPath(name)
+	if err != nil {
+		return nil, err
+	}
+	var fi fs.FileInfo
+	err = dfs.d.read(func(fsys wnfs.WNFS) (err error) {
+		fi, err = stat(fsys, p)
+		return err
+	})
+	return fi, err
+}
+
+// davInfo adds CID-derived ETags to file info
+type davInfo struct {
+	fs.FileInfo
+	id cid.Cid
+}
+
+var _ webdav.ETager = (*davInfo)(nil)
+
+func newDavInfo(f fs.File, fi fs.FileInfo) davInfo {
+	info := davInfo{FileInfo: fi}
+	if n, ok := f.(interface{ Cid() cid.Cid }); ok {
+		info.id = n.Cid()
+	}
+	return info
+}
+
+func (i davInfo) ETag(ctx context.Context) (string, error) {
+	if !i.id.Defined() {
+		return "", webdav.ErrNotImplemented
+	}
+	return etag(i.id), nil
+}
+
+// davFile is a node opened for reading
+type davFile struct {
+	d    *WebDAV
+	path string
+	fs.File
+	entries []fs.FileInfo // directory entries, loaded on first Readdir
+	pos     int
+}
+
+var (
+	_ webdav.File            = (*davFile)(nil)
+	_ webdav.DeadPropsHolder = (*davFile)(nil)
+)
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, base.ErrNotSeekable
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("%q: file is open for reading", f.path)
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return newDavInfo(f.File, fi), nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.entries == nil {
+		err := f.d.read(func(fsys wnfs.WNFS) error {
+			ents, err := fsys.Ls(f.path)
+			if err != nil {
+				return err
+			}
+			f.entries = make([]fs.FileInfo, 0, len(ents))
+			for _, ent := range ents {
+				fi, err := stat(fsys, path.Join(f.path, ent.Name()))
+				if err != nil {
+					return err
+				}
+				f.entries = append(f.entries, fi)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rest := f.entries[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.pos += count
+	return rest[:count], nil
+}
+
+// DeadProps exposes each top level field of file metadata as a property
+func (f *davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	meta, err := f.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[xml.Name]webdav.Property, len(meta))
+	for key, val := range meta {
+		text, ok := val.(string)
+		if !ok {
+			data, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			text = string(data)
+		}
+		buf := &strings.Builder{}
+		if err := xml.EscapeText(buf, []byte(text)); err != nil {
+			return nil, err
+		}
+		name := xml.Name{Space: MetadataNamespace, Local: key}
+		props[name] = webdav.Property{XMLName: name, InnerXML: []byte(buf.String())}
+	}
+	return props, nil
+}
+
+// Patch sets & removes metadata fields. Properties outside MetadataNamespace
+// are rejected. Values are stored as strings
+func (f *davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	status := http.StatusOK
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		status = http.StatusForbidden
+	}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			if prop.XMLName.Space != MetadataNamespace {
+				status = http.StatusForbidden
+			}
+		}
+	}
+
+	if status == http.StatusOK {
+		meta, err := f.metadata()
+		if err != nil {
+			return nil, err
+		}
+		for _, patch := range patches {
+			for _, prop := range patch.Props {
+				if patch.Remove {
+					delete(meta, prop.XMLName.Local)
+					continue
+				}
+				text, err := innerText(prop.InnerXML)
+				if err != nil {
+					return nil, err
+				}
+				meta[prop.XMLName.Local] = text
+			}
+		}
+
+		err = f.d.change(func(fsys wnfs.WNFS) error {
+			data, err := fsys.Cat(f.path)
+			if err != nil {
+				return err
+			}
+			name := path.Base(f.path)
+			return fsys.Write(f.path, public.WrapFileMetadata(base.NewMemfileBytes(name, data), meta))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pstat := webdav.Propstat{Status: status}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	sort.Slice(pstat.Props, func(i, j int) bool { return pstat.Props[i].XMLName.Local < pstat.Props[j].XMLName.Local })
+	return []webdav.Propstat{pstat}, nil
+}
+
+// metadata returns file metadata as a JSON object. Files without metadata
+// return an empty object
+func (f *davFile) metadata() (map[string]interface{}, error) {
+	meta := map[string]interface{}{}
+	n, ok := f.File.(base.Node)
+	if !ok {
+		return meta, nil
+	}
+	md, err := n.Metadata()
+	if errors.Is(err, base.ErrNoLink) || (err == nil && md == nil) {
+		return meta, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v, err := md.Data()
+	if err != nil {
+		return nil, err
+	}
+	// round trip through JSON to normalize decoded CBOR values
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%q: metadata is not a JSON object: %w", f.path, err)
+	}
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	return meta, nil
+}
+
+// innerText returns the character data of an XML fragment
+func innerText(innerXML []byte) (string, error) {
+	dec := xml.NewDecoder(strings.NewReader("<v>" + string(innerXML) + "</v>"))
+	text := &strings.Builder{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return text.String(), nil
+		} else if err != nil {
+			return "", err
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			text.Write(cd)
+		}
+	}
+}
+
+// upload is a file opened for writing. Written bytes are streamed into Write
+// as they arrive, holding the root lock until the file is closed
+type upload struct {
+	name string
+	pw   *io.PipeWriter
+	size int64
+	done chan error
+	once sync.Once
+	err  error
+}
+
+var _ webdav.File = (*upload)(nil)
+
+func (d *WebDAV) upload(p string) *upload {
+	pr, pw := io.Pipe()
+	u := &upload{name: path.Base(p), pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := d.change(func(fsys wnfs.WNFS) error {
+			return fsys.Write(p, base.NewMemfileReader(u.name, pr))
+		})
+		// unblock writes if Write stopped reading early
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+	return u
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	n, err := u.pw.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+func (u *upload) Close() error {
+	u.once.Do(func() {
+		u.pw.Close()
+		u.err = <-u.done
+	})
+	return u.err
+}
+
+func (u *upload) Stat() (os.FileInfo, error) {
+	return base.NewFSFileInfo(u.name, u.size, 0, base.Timestamp(), nil), nil
+}
+
+func (u *upload) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("%q: file is open for writing", u.name)
+}
+
+func (u *upload) Seek(offset int64, whence int) (int64, error) {
+	return 0, base.ErrNotSeekable
+}
+
+func (u *upload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%q: not a directory", u.name)
+}
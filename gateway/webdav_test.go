@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	wnfs "github.com/qri-io/wnfs-go"
+	base "github.com/qri-io/wnfs-go/base"
+	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestWebDAV(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	commits := []wnfs.CommitResult{}
+	dav := NewWebDAV(NewRoot(fsys, func(res wnfs.CommitResult) error {
+		commits = append(commits, res)
+		return nil
+	}), "/dav", 0)
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		dav.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	require.Equal(t, http.StatusMultiStatus, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/dav/public/")
+	assert.Contains(t, rec.Body.String(), "/dav/private/")
+
+	for _, dir := range []string{"public", "private"} {
+		t.Run(dir, func(t *testing.T) {
+			prefix := "/dav/" + dir
+
+			rec := do("MKCOL", prefix+"/docs", "", nil)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			rec = do("MKCOL", prefix+"/docs", "", nil)
+			assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+			rec = do("MKCOL", prefix+"/missing/docs", "", nil)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+
+			rec = do(http.MethodPut, prefix+"/docs/hello.txt", "hello", nil)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+			rec = do(http.MethodGet, prefix+"/docs/hello.txt", "", nil)
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "hello", rec.Body.String())
+			rec = do(http.MethodGet, prefix+"/docs/hello.txt", "", map[string]string{"Range": "bytes=1-3"})
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "ell", rec.Body.String())
+
+			rec = do("PROPFIND", prefix+"/docs", "", map[string]string{"Depth": "1"})
+			require.Equal(t, http.StatusMultiStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), prefix+"/docs/hello.txt")
+			assert.Contains(t, rec.Body.String(), "<D:getcontentlength>5</D:getcontentlength>")
+
+			rec = do("PROPPATCH", prefix+"/docs/hello.txt", `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:W="urn:wnfs:metadata">
+	<D:set><D:prop><W:title>a &amp; b</W:title></D:prop></D:set>
+</D:propertyupdate>`, nil)
+			require.Equal(t, http.StatusMultiStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), "200 OK")
+
+			rec = do("PROPFIND", prefix+"/docs/hello.txt", `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`, map[string]string{"Depth": "0"})
+			require.Equal(t, http.StatusMultiStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), "a &amp; b")
+
+			rec = do("COPY", prefix+"/docs/hello.txt", "", map[string]string{"Destination": prefix + "/docs/copy.txt"})
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			rec = do("COPY", prefix+"/docs/hello.txt", "", map[string]string{"Destination": prefix + "/docs/copy.txt", "Overwrite": "F"})
+			assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+			rec = do("COPY", prefix+"/docs/hello.txt", "", map[string]string{"Destination": prefix + "/docs/copy.txt"})
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+
+			rec = do("MOVE", prefix+"/docs/copy.txt", "", map[string]string{"Destination": prefix + "/moved.txt"})
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			rec = do(http.MethodGet, prefix+"/moved.txt", "", nil)
+			assert.Equal(t, "hello", rec.Body.String())
+			rec = do(http.MethodGet, prefix+"/docs/copy.txt", "", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			rec = do(http.MethodDelete, prefix+"/docs", "", nil)
+			require.Equal(t, http.StatusNoContent, rec.Code)
+			rec = do(http.MethodGet, prefix+"/docs/hello.txt", "", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		})
+	}
+
+	rec = do(http.MethodDelete, "/dav/public", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = do(http.MethodPut, "/dav/other.txt", "nope", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// every successful change is committed
+	require.Equal(t, 14, len(commits))
+	last := commits[len(commits)-1]
+	loaded, err := wnfs.FromCID(ctx, bserv, rs, last.Root, *last.PrivateKey, *last.PrivateName)
+	require.Nil(t, err)
+	data, err := loaded.Cat("private/moved.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestWebDAVCommitInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+
+	commits := []wnfs.CommitResult{}
+	// an interval long enough that only Flush & Close commit
+	dav := NewWebDAV(NewRoot(fsys, func(res wnfs.CommitResult) error {
+		commits = append(commits, res)
+		return nil
+	}), "", 1<<40)
+
+	for _, name := range []string{"a", "b", "c"} {
+		req := httptest.NewRequest(http.MethodPut, "/public/"+name, strings.NewReader(name))
+		rec := httptest.NewRecorder()
+		dav.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	assert.Equal(t, 0, len(commits))
+
+	// failed changes don't discard changes staged before them
+	req := httptest.NewRequest("COPY", "/public/a", nil)
+	req.Header.Set("Destination", "/public/missing/a")
+	rec := httptest.NewRecorder()
+	dav.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, 0, len(commits))
+
+	require.Nil(t, dav.Flush())
+	assert.Equal(t, 1, len(commits))
+	require.Nil(t, dav.Flush())
+	assert.Equal(t, 1, len(commits), "flushing without changes shouldn't commit")
+
+	req = httptest.NewRequest(http.MethodDelete, "/public/a", nil)
+	dav.ServeHTTP(httptest.NewRecorder(), req)
+	require.Nil(t, dav.Close())
+	require.Equal(t, 2, len(commits))
+
+	f, err := fsys.Open("public/b")
+	require.Nil(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.Nil(t, err)
+	assert.Equal(t, "b", string(data))
+	_, err = fsys.Open("public/a")
+	assert.ErrorIs(t, err, base.ErrNotFound)
+}
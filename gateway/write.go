@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/qri-io/wnfs-go"
+	"github.com/qri-io/wnfs-go/base"
+	"github.com/qri-io/wnfs-go/public"
+)
+
+// MethodMkcol creates a directory, following WebDAV
+const MethodMkcol = "MKCOL"
+
+// ErrUnauthorized is returned by an Authorizer to reject a write request
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authorizer checks a request to write to the root called rootName, returning
+// an error if the write isn't allowed
+type Authorizer func(r *http.Request, rootName string) error
+
+// BearerTokenAuth allows requests with an "Authorization: Bearer <token>"
+// header that matches token to write to any root
+func BearerTokenAuth(token string) Authorizer {
+	const prefix = "Bearer "
+	return func(r *http.Request, rootName string) error {
+		header := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(header, prefix) {
+			return ErrUnauthorized
+		}
+		if subtle.ConstantTimeCompare([]byte(header[len(prefix):]), []byte(token)) != 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+// Root is a named filesystem the server writes to. Root serializes access to
+// the filesystem between the write API & WebDAV handlers
+type Root struct {
+	lk       sync.Mutex
+	fs       wnfs.WNFS
+	onCommit func(res wnfs.CommitResult) error
+}
+
+// NewRoot wraps a filesystem for writing. onCommit is called with the result
+// of each commit, and can be used to persist the new root. onCommit may be nil
+func NewRoot(fs wnfs.WNFS, onCommit func(res wnfs.CommitResult) error) *Root {
+	return &Root{fs: fs, onCommit: onCommit}
+}
+
+// WriteResult is the response body of a successful write
+type WriteResult struct {
+	// Root is the CID of the filesystem after the write was committed
+	Root string `json:"root"`
+}
+
+// HandlePut writes the request body as the file at path. Adding the "meta"
+// query param instead sets the metadata of an existing file to the JSON
+// request body
+func (s *Server) HandlePut(e echo.Context) error {
+	if _, ok := e.QueryParams()["meta"]; ok {
+		return s.write(e, http.StatusOK, setMetadata)
+	}
+	return s.write(e, http.StatusCreated, func(fsys wnfs.WNFS, path string, r *http.Request) error {
+		return fsys.Write(path, base.NewMemfileReader(baseName(path), r.Body))
+	})
+}
+
+// HandleDelete removes a file or directory
+func (s *Server) HandleDelete(e echo.Context) error {
+	return s.write(e, http.StatusOK, func(fsys wnfs.WNFS, path string, _ *http.Request) error {
+		return fsys.Rm(path)
+	})
+}
+
+// HandleMkcol creates a directory
+func (s *Server) HandleMkcol(e echo.Context) error {
+	return s.write(e, http.StatusCreated, func(fsys wnfs.WNFS, path string, _ *http.Request) error {
+		return fsys.Mkdir(path)
+	})
+}
+
+// setMetadata rewrites a public file with new metadata. Private files keep
+// their metadata in encrypted blocks public.WrapFileMetadata doesn't write,
+// so they're rejected rather than silently dropping the metadata
+func setMetadata(fsys wnfs.WNFS, path string, r *http.Request) error {
+	if strings.SplitN(path, "/", 2)[0] == wnfs.FileHierarchyNamePrivate {
+		return echo.NewHTTPError(http.StatusBadRequest, "metadata can only be set on public files")
+	}
+
+	var meta interface{}
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "metadata must be JSON: "+err.Error())
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return echo.NewHTTPError(http.StatusBadRequest, "metadata can only be set on files")
+	}
+
+	// rewrite the file with the same content & new metadata
+	data, err := fsys.Cat(path)
+	if err != nil {
+		return err
+	}
+	return fsys.Write(path, public.WrapFileMetadata(base.NewMemfileBytes(baseName(path), data), meta))
+}
+
+// write authorizes a request, applies fn to the named root & commits,
+// responding with the new root CID. Failed writes are rolled back, if the
+// rollback fails the root is left uncommitted & the request fails with a 500
+func (s *Server) write(e echo.Context, status int, fn func(fsys wnfs.WNFS, path string, r *http.Request) error) error {
+	name, path := e.Param("name"), e.Param("*")
+	log.Infow("write", "method", e.Request().Method, "root", name, "path", path)
+
+	// authorize before looking up the root, so unauthorized callers can't
+	// discover which roots exist
+	if s.Authorize == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "writes are disabled")
+	}
+	if err := s.Authorize(e.Request(), name); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	root, ok := s.Roots[name]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "root not found")
+	}
+	if path == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "path is required")
+	}
+
+	root.lk.Lock()
+	defer root.lk.Unlock()
+
+	if err := fn(root.fs, path, e.Request()); err != nil {
+		log.Infow("write", "root", name, "path", path, "err", err)
+		if rbErr := root.fs.Rollback(); rbErr != nil {
+			log.Errorw("rolling back failed write", "root", name, "err", rbErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "rolling back failed write: "+rbErr.Error())
+		}
+		return writeError(err)
+	}
+
+	res, err := root.commit()
+	if err != nil {
+		log.Errorw("committing write", "root", name, "err", err)
+		return err
+	}
+	return e.JSON(status, WriteResult{Root: res.Root.String()})
+}
+
+// commit writes all changes to the root. callers must hold the root lock
+func (r *Root) commit() (wnfs.CommitResult, error) {
+	res, err := r.fs.Commit()
+	if err != nil {
+		return res, err
+	}
+	if r.onCommit != nil {
+		if err := r.onCommit(res); err != nil {
+			return res, fmt.Errorf("saving commit: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// writeError maps filesystem errors to HTTP errors
+func writeError(err error) error {
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return err
+	case errors.Is(err, base.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, fs.ErrExist):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+}
+
+func baseName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
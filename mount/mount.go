@@ -0,0 +1,541 @@
+// Package mount adapts a WNFS filesystem to the inode-based operations of
+// kernel filesystem interfaces like FUSE. It doesn't depend on any kernel
+// interface: bindings translate kernel requests into calls on Ops
+package mount
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	golog "github.com/ipfs/go-log"
+	wnfs "github.com/qri-io/wnfs-go"
+	base "github.com/qri-io/wnfs-go/base"
+	private "github.com/qri-io/wnfs-go/private"
+)
+
+var log = golog.Logger("wnfs")
+
+// Inode identifies a node for as long as the node exists
+type Inode uint64
+
+// RootInode is the inode of the filesystem root, the directory that contains
+// the public & private hierarchies
+const RootInode Inode = 1
+
+var (
+	// ErrStale is returned for inodes that don't refer to a node, either
+	// because they were never looked up or the node was removed
+	ErrStale = errors.New("stale inode")
+	// ErrIsDir is returned when reading or writing a directory
+	ErrIsDir = errors.New("is a directory")
+	// ErrNotDir is returned when listing or creating children of a file
+	ErrNotDir = errors.New("not a directory")
+	// ErrNotEmpty is returned when a rename would replace a directory that
+	// has children
+	ErrNotEmpty = errors.New("directory not empty")
+)
+
+// Attr describes a node
+type Attr struct {
+	Inode Inode
+	Size  int64
+	Mode  fs.FileMode
+	Mtime time.Time
+}
+
+// IsDir reports whether attr describes a directory
+func (a Attr) IsDir() bool { return a.Mode.IsDir() }
+
+// DirEntry is a child of a directory
+type DirEntry struct {
+	Name  string
+	Inode Inode
+	Mode  fs.FileMode
+}
+
+// Ops are the operations a kernel binding calls. Nodes are addressed by inode,
+// children by parent inode & name. Writes are buffered per inode until Flush
+type Ops interface {
+	Lookup(parent Inode, name string) (Attr, error)
+	Getattr(ino Inode) (Attr, error)
+	Readdir(ino Inode) ([]DirEntry, error)
+	Read(ino Inode, p []byte, off int64) (int, error)
+	Write(ino Inode, p []byte, off int64) (int, error)
+	Truncate(ino Inode, size int64) error
+	Flush(ino Inode) error
+	Create(parent Inode, name string) (Attr, error)
+	Mkdir(parent Inode, name string) (Attr, error)
+	Unlink(parent Inode, name string) error
+	Rename(parent Inode, name string, newParent Inode, newName string) error
+}
+
+// FS implements Ops on a WNFS filesystem.
+//
+// Inode numbers are derived from node identity the first time a path is
+// looked up: the CID of public nodes & the INumber of private nodes. Public
+// CIDs change with every write, so once assigned an inode stays with its path
+// until the node is removed or renamed. Collisions are resolved by probing
+// for the next free number
+type FS struct {
+	lk       sync.Mutex
+	fsys     wnfs.WNFS
+	paths    map[Inode]string
+	inodes   map[string]Inode
+	writable map[Inode]wnfs.WritableFile
+}
+
+var _ Ops = (*FS)(nil)
+
+// New creates a VFS adapter for fsys. Changes are applied to fsys, call Sync
+// to commit them
+func New(fsys wnfs.WNFS) *FS {
+	return &FS{
+		fsys:     fsys,
+		paths:    map[Inode]string{RootInode: "."},
+		inodes:   map[string]Inode{".": RootInode},
+		writable: map[Inode]wnfs.WritableFile{},
+	}
+}
+
+// Lookup finds the child of parent called name
+func (m *FS) Lookup(parent Inode, name string) (Attr, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.childPath(parent, name)
+	if err != nil {
+		return Attr{}, err
+	}
+	return m.lookup(p)
+}
+
+// Getattr describes a node
+func (m *FS) Getattr(ino Inode) (Attr, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.path(ino)
+	if err != nil {
+		return Attr{}, err
+	}
+	return m.lookup(p)
+}
+
+// Readdir lists the children of a directory, sorted by name
+func (m *FS) Readdir(ino Inode) ([]DirEntry, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.path(ino)
+	if err != nil {
+		return nil, err
+	}
+	if attr, err := m.lookup(p); err != nil {
+		return nil, err
+	} else if !attr.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: ErrNotDir}
+	}
+
+	ents, err := m.fsys.Ls(p)
+	if err != nil {
+		return nil, pathError("readdir", p, err)
+	}
+	res := make([]DirEntry, 0, len(ents))
+	for _, ent := range ents {
+		attr, err := m.lookup(path.Join(p, ent.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, DirEntry{Name: ent.Name(), Inode: attr.Inode, Mode: attr.Mode})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// Read reads file content at off, including unflushed writes
+func (m *FS) Read(ino Inode, p []byte, off int64) (int, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if wf, ok := m.writable[ino]; ok {
+		return wf.ReadAt(p, off)
+	}
+
+	pathStr, err := m.path(ino)
+	if err != nil {
+		return 0, err
+	}
+	f, err := m.fsys.Open(pathStr)
+	if err != nil {
+		return 0, pathError("read", pathStr, err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return 0, err
+	} else if fi.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: pathStr, Err: ErrIsDir}
+	}
+
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return base.ReadAt(rs, p, off)
+	}
+	return 0, &fs.PathError{Op: "read", Path: pathStr, Err: base.ErrNotSeekable}
+}
+
+// Write writes p to a file at off. Writes to an inode are buffered until Flush
+func (m *FS) Write(ino Inode, p []byte, off int64) (int, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	wf, err := m.openWritable(ino)
+	if err != nil {
+		return 0, err
+	}
+	return wf.WriteAt(p, off)
+}
+
+// Truncate changes the size of a file
+func (m *FS) Truncate(ino Inode, size int64) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	wf, err := m.openWritable(ino)
+	if err != nil {
+		return err
+	}
+	return wf.Truncate(size)
+}
+
+// Flush writes buffered changes to an inode into the filesystem. Flushing an
+// inode without buffered changes is a no-op
+func (m *FS) Flush(ino Inode) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.flush(ino)
+}
+
+// Create adds an empty file called name to parent
+func (m *FS) Create(parent Inode, name string) (Attr, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.newChildPath(parent, name)
+	if err != nil {
+		return Attr{}, err
+	}
+	if err := m.fsys.Write(p, base.NewMemfileBytes(name, nil)); err != nil {
+		return Attr{}, pathError("create", p, err)
+	}
+	return m.lookup(p)
+}
+
+// Mkdir adds an empty directory called name to parent
+func (m *FS) Mkdir(parent Inode, name string) (Attr, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.newChildPath(parent, name)
+	if err != nil {
+		return Attr{}, err
+	}
+	if err := m.fsys.Mkdir(p); err != nil {
+		return Attr{}, pathError("mkdir", p, err)
+	}
+	return m.lookup(p)
+}
+
+// Unlink removes the child of parent called name. Directories are removed
+// with all their children
+func (m *FS) Unlink(parent Inode, name string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	p, err := m.childPath(parent, name)
+	if err != nil {
+		return err
+	}
+	if isHierarchyRoot(p) {
+		return &fs.PathError{Op: "unlink", Path: p, Err: fs.ErrPermission}
+	}
+	if err := m.flushTree(p); err != nil {
+		return err
+	}
+	if err := m.fsys.Rm(p); err != nil {
+		return pathError("unlink", p, err)
+	}
+	m.forget(p)
+	return nil
+}
+
+// Rename moves the child of parent called name to newParent, replacing any
+// file or empty directory called newName. Renamed nodes keep their inodes
+func (m *FS) Rename(parent Inode, name string, newParent Inode, newName string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	from, err := m.childPath(parent, name)
+	if err != nil {
+		return err
+	}
+	to, err := m.childPath(newParent, newName)
+	if err != nil {
+		return err
+	}
+	if isHierarchyRoot(from) || isHierarchyRoot(to) {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrPermission}
+	}
+	if from == to {
+		return nil
+	}
+
+	src, err := m.lookup(from)
+	if err != nil {
+		return err
+	}
+	if err := m.flushTree(from); err != nil {
+		return err
+	}
+	if dst, err := m.lookup(to); err == nil {
+		if err := m.replaceable(src, dst, to); err != nil {
+			return err
+		}
+		if err := m.flushTree(to); err != nil {
+			return err
+		}
+		if err := m.fsys.Rm(to); err != nil {
+			return pathError("rename", to, err)
+		}
+		m.forget(to)
+	}
+
+	if err := m.fsys.Mv(from, to); err != nil {
+		return pathError("rename", from, err)
+	}
+	m.move(from, to)
+	return nil
+}
+
+// Sync flushes all buffered writes & commits the filesystem
+func (m *FS) Sync() (wnfs.CommitResult, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for ino := range m.writable {
+		if err := m.flush(ino); err != nil {
+			return wnfs.CommitResult{}, err
+		}
+	}
+	return m.fsys.Commit()
+}
+
+// replaceable checks rename can replace dst with src, following rename(2)
+func (m *FS) replaceable(src, dst Attr, to string) error {
+	switch {
+	case src.IsDir() && !dst.IsDir():
+		return &fs.PathError{Op: "rename", Path: to, Err: ErrNotDir}
+	case !src.IsDir() && dst.IsDir():
+		return &fs.PathError{Op: "rename", Path: to, Err: ErrIsDir}
+	case dst.IsDir():
+		ents, err := m.fsys.Ls(to)
+		if err != nil {
+			return pathError("rename", to, err)
+		}
+		if len(ents) > 0 {
+			return &fs.PathError{Op: "rename", Path: to, Err: ErrNotEmpty}
+		}
+	}
+	return nil
+}
+
+// lookup opens the node at p, assigning it an inode if it doesn't have one
+func (m *FS) lookup(p string) (Attr, error) {
+	f, err := m.fsys.Open(p)
+	if err != nil {
+		return Attr{}, pathError("lookup", p, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Attr{}, err
+	}
+
+	ino, ok := m.inodes[p]
+	if !ok {
+		ino = m.assign(p, deriveInode(p, f))
+	}
+	attr := Attr{
+		Inode: ino,
+		Size:  fi.Size(),
+		Mode:  fi.Mode(),
+		Mtime: fi.ModTime(),
+	}
+	if fi.IsDir() {
+		attr.Mode |= fs.ModeDir
+	}
+	if wf, ok := m.writable[ino]; ok {
+		if wfi, err := wf.Stat(); err == nil {
+			attr.Size = wfi.Size()
+		}
+	}
+	return attr, nil
+}
+
+// deriveInode creates an inode number from the identity of a node. Nodes
+// without an identity use their path
+func deriveInode(p string, f fs.File) Inode {
+	if n, ok := f.(interface{ INumber() private.INumber }); ok {
+		in := n.INumber()
+		return Inode(binary.BigEndian.Uint64(in[:8]))
+	}
+
+	h := fnv.New64a()
+	if n, ok := f.(interface{ Cid() cid.Cid }); ok && n.Cid().Defined() {
+		h.Write(n.Cid().Bytes())
+	} else {
+		h.Write([]byte(p))
+	}
+	return Inode(h.Sum64())
+}
+
+// assign records ino as the inode of p, probing for the next free inode if
+// ino is taken
+func (m *FS) assign(p string, ino Inode) Inode {
+	for {
+		if ino > RootInode {
+			if _, taken := m.paths[ino]; !taken {
+				break
+			}
+		}
+		ino++
+	}
+	m.paths[ino] = p
+	m.inodes[p] = ino
+	return ino
+}
+
+// forget drops the inodes of p & its descendants
+func (m *FS) forget(p string) {
+	for q, ino := range m.inodes {
+		if q == p || strings.HasPrefix(q, p+"/") {
+			delete(m.inodes, q)
+			delete(m.paths, ino)
+		}
+	}
+}
+
+// move reassigns the inodes of from & its descendants to their new paths
+func (m *FS) move(from, to string) {
+	for q, ino := range m.inodes {
+		if q == from || strings.HasPrefix(q, from+"/") {
+			moved := to + strings.TrimPrefix(q, from)
+			delete(m.inodes, q)
+			m.inodes[moved] = ino
+			m.paths[ino] = moved
+		}
+	}
+}
+
+func (m *FS) path(ino Inode) (string, error) {
+	p, ok := m.paths[ino]
+	if !ok {
+		return "", fmt.Errorf("inode %d: %w", ino, ErrStale)
+	}
+	return p, nil
+}
+
+func (m *FS) childPath(parent Inode, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", &fs.PathError{Op: "lookup", Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := m.path(parent)
+	if err != nil {
+		return "", err
+	}
+	if p == "." {
+		if name != wnfs.FileHierarchyNamePublic && name != wnfs.FileHierarchyNamePrivate {
+			return "", &fs.PathError{Op: "lookup", Path: name, Err: fs.ErrNotExist}
+		}
+		return name, nil
+	}
+	return p + "/" + name, nil
+}
+
+// newChildPath returns the path of a child that doesn't exist yet. The
+// filesystem root can't have new children
+func (m *FS) newChildPath(parent Inode, name string) (string, error) {
+	if parent == RootInode {
+		return "", &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+	}
+	p, err := m.childPath(parent, name)
+	if err != nil {
+		return "", err
+	}
+	if attr, err := m.lookup(path.Dir(p)); err != nil {
+		return "", err
+	} else if !attr.IsDir() {
+		return "", &fs.PathError{Op: "create", Path: p, Err: ErrNotDir}
+	}
+	if _, err := m.lookup(p); err == nil {
+		return "", &fs.PathError{Op: "create", Path: p, Err: fs.ErrExist}
+	}
+	return p, nil
+}
+
+func (m *FS) openWritable(ino Inode) (wnfs.WritableFile, error) {
+	if wf, ok := m.writable[ino]; ok {
+		return wf, nil
+	}
+	p, err := m.path(ino)
+	if err != nil {
+		return nil, err
+	}
+	if attr, err := m.lookup(p); err != nil {
+		return nil, err
+	} else if attr.IsDir() {
+		return nil, &fs.PathError{Op: "write", Path: p, Err: ErrIsDir}
+	}
+	wf, err := m.fsys.OpenFile(p, os.O_RDWR)
+	if err != nil {
+		return nil, pathError("write", p, err)
+	}
+	m.writable[ino] = wf
+	return wf, nil
+}
+
+func (m *FS) flush(ino Inode) error {
+	wf, ok := m.writable[ino]
+	if !ok {
+		return nil
+	}
+	delete(m.writable, ino)
+	log.Debugw("mount flush", "inode", ino, "path", m.paths[ino])
+	return wf.Close()
+}
+
+// flushTree flushes p & its descendants
+func (m *FS) flushTree(p string) error {
+	for ino := range m.writable {
+		if q := m.paths[ino]; q == p || strings.HasPrefix(q, p+"/") {
+			if err := m.flush(ino); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isHierarchyRoot reports whether p can't be removed or renamed
+func isHierarchyRoot(p string) bool {
+	return p == "." || !strings.Contains(p, "/")
+}
+
+// pathError converts filesystem errors to fs package errors
+func pathError(op, p string, err error) error {
+	if errors.Is(err, base.ErrNotFound) {
+		return &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return err
+}
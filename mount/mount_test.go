@@ -0,0 +1,127 @@
+package mount_test
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io/fs"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	wnfs "github.com/qri-io/wnfs-go"
+	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
+	mount "github.com/qri-io/wnfs-go/mount"
+	mounttest "github.com/qri-io/wnfs-go/mount/mounttest"
+	private "github.com/qri-io/wnfs-go/private"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestOps(t *testing.T) {
+	for _, dir := range []string{"public", "private"} {
+		t.Run(dir, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := mount.New(newFS(ctx, t))
+			mounttest.Run(t, m, dir, 1, 80)
+
+			_, err := m.Sync()
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestInodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bserv := mockblocks.NewOfflineMemBlockservice()
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := wnfs.NewEmptyFS(ctx, bserv, rs, wnfs.NewKey())
+	require.Nil(t, err)
+	require.Nil(t, fsys.Mkdir("public/dir"))
+	require.Nil(t, fsys.Mkdir("private/dir"))
+	m := mount.New(fsys)
+	c := mounttest.Client{Ops: m}
+
+	root, err := m.Getattr(mount.RootInode)
+	require.Nil(t, err)
+	assert.True(t, root.IsDir())
+	ents, err := m.Readdir(mount.RootInode)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(ents))
+	assert.Equal(t, "private", ents[0].Name)
+	assert.Equal(t, "public", ents[1].Name)
+	_, err = m.Lookup(mount.RootInode, "other")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = m.Create(mount.RootInode, "other")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	pub, err := c.Create("public/dir/file.txt")
+	require.Nil(t, err)
+	f, err := fsys.Open("public/dir/file.txt")
+	require.Nil(t, err)
+	h := fnv.New64a()
+	h.Write(f.(interface{ Cid() cid.Cid }).Cid().Bytes())
+	assert.Equal(t, mount.Inode(h.Sum64()), pub.Inode, "public inodes derive from the node CID")
+
+	priv, err := c.Create("private/dir/file.txt")
+	require.Nil(t, err)
+	f, err = fsys.Open("private/dir/file.txt")
+	require.Nil(t, err)
+	in := f.(interface{ INumber() private.INumber }).INumber()
+	assert.Equal(t, mount.Inode(binary.BigEndian.Uint64(in[:8])), priv.Inode, "private inodes derive from the node INumber")
+
+	for _, attr := range []mount.Attr{pub, priv} {
+		_, err = m.Write(attr.Inode, []byte("hello"), 0)
+		require.Nil(t, err)
+		got, err := m.Getattr(attr.Inode)
+		require.Nil(t, err)
+		assert.Equal(t, int64(5), got.Size, "size includes unflushed writes")
+		require.Nil(t, m.Flush(attr.Inode))
+	}
+
+	// inodes survive writes & renames
+	require.Nil(t, c.Rename("public/dir", "public/moved"))
+	moved, err := c.Walk("public/moved/file.txt")
+	require.Nil(t, err)
+	assert.Equal(t, pub.Inode, moved.Inode)
+	data, err := c.ReadFile("public/moved/file.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+	_, err = c.Walk("public/dir")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = m.Read(mount.Inode(2), make([]byte, 1), 0)
+	assert.ErrorIs(t, err, mount.ErrStale)
+	_, err = m.Read(root.Inode, make([]byte, 1), 0)
+	assert.ErrorIs(t, err, mount.ErrIsDir)
+
+	// renames can't move nodes between hierarchies
+	err = c.Rename("private/dir/file.txt", "public/file.txt")
+	assert.ErrorIs(t, err, wnfs.ErrCrossHierarchyMove)
+
+	require.Nil(t, c.Remove("private/dir/file.txt"))
+	_, err = m.Getattr(priv.Inode)
+	assert.ErrorIs(t, err, mount.ErrStale)
+
+	_, err = m.Write(pub.Inode, []byte(" world"), 5)
+	require.Nil(t, err)
+	res, err := m.Sync()
+	require.Nil(t, err)
+	loaded, err := wnfs.FromCID(ctx, bserv, rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	got, err := loaded.Cat("public/moved/file.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "hello world", string(got))
+	_, err = loaded.Cat("private/dir/file.txt")
+	assert.NotNil(t, err)
+}
+
+func newFS(ctx context.Context, t *testing.T) wnfs.WNFS {
+	t.Helper()
+	fsys, err := wnfs.NewEmptyFS(ctx, mockblocks.NewOfflineMemBlockservice(), ratchet.NewMemStore(ctx), wnfs.NewKey())
+	require.Nil(t, err)
+	return fsys
+}
@@ -0,0 +1,386 @@
+// Package mounttest drives mount.Ops in-process, the way a kernel binding
+// would, for testing adapters & the bindings layered on them
+package mounttest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	mount "github.com/qri-io/wnfs-go/mount"
+	require "github.com/stretchr/testify/require"
+)
+
+// readSize is the size of Read calls made by Client, small enough to split
+// most test files across calls
+const readSize = 64
+
+// Client calls Ops with slash-separated paths, resolving each path from the
+// root inode with Lookup
+type Client struct {
+	Ops mount.Ops
+}
+
+// Walk resolves p to a node
+func (c Client) Walk(p string) (mount.Attr, error) {
+	attr, err := c.Ops.Getattr(mount.RootInode)
+	if err != nil {
+		return attr, err
+	}
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return attr, nil
+	}
+	for _, name := range strings.Split(p, "/") {
+		if attr, err = c.Ops.Lookup(attr.Inode, name); err != nil {
+			return attr, err
+		}
+	}
+	return attr, nil
+}
+
+// WriteFile creates or replaces the content of the file at p & flushes it
+func (c Client) WriteFile(p string, data []byte) error {
+	attr, err := c.Walk(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		parent, name, err := c.parent(p)
+		if err != nil {
+			return err
+		}
+		if attr, err = c.Ops.Create(parent, name); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := c.Ops.Truncate(attr.Inode, 0); err != nil {
+		return err
+	}
+
+	if _, err := c.Ops.Write(attr.Inode, data, 0); err != nil {
+		return err
+	}
+	return c.Ops.Flush(attr.Inode)
+}
+
+// ReadFile reads the content of the file at p
+func (c Client) ReadFile(p string) ([]byte, error) {
+	attr, err := c.Walk(p)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadInode(attr.Inode)
+}
+
+// ReadInode reads the content of a file
+func (c Client) ReadInode(ino mount.Inode) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	p := make([]byte, readSize)
+	for {
+		n, err := c.Ops.Read(ino, p, int64(buf.Len()))
+		buf.Write(p[:n])
+		if err == io.EOF || (err == nil && n == 0) {
+			return buf.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadDir lists the directory at p
+func (c Client) ReadDir(p string) ([]mount.DirEntry, error) {
+	attr, err := c.Walk(p)
+	if err != nil {
+		return nil, err
+	}
+	return c.Ops.Readdir(attr.Inode)
+}
+
+// Mkdir creates the directory at p. The parent of p must exist
+func (c Client) Mkdir(p string) (mount.Attr, error) {
+	parent, name, err := c.parent(p)
+	if err != nil {
+		return mount.Attr{}, err
+	}
+	return c.Ops.Mkdir(parent, name)
+}
+
+// Create creates an empty file at p. The parent of p must exist
+func (c Client) Create(p string) (mount.Attr, error) {
+	parent, name, err := c.parent(p)
+	if err != nil {
+		return mount.Attr{}, err
+	}
+	return c.Ops.Create(parent, name)
+}
+
+// Remove removes the node at p
+func (c Client) Remove(p string) error {
+	parent, name, err := c.parent(p)
+	if err != nil {
+		return err
+	}
+	return c.Ops.Unlink(parent, name)
+}
+
+// Rename moves the node at from to
+func (c Client) Rename(from, to string) error {
+	fromParent, fromName, err := c.parent(from)
+	if err != nil {
+		return err
+	}
+	toParent, toName, err := c.parent(to)
+	if err != nil {
+		return err
+	}
+	return c.Ops.Rename(fromParent, fromName, toParent, toName)
+}
+
+func (c Client) parent(p string) (mount.Inode, string, error) {
+	dir, name := path.Split(strings.Trim(path.Clean("/"+p), "/"))
+	attr, err := c.Walk(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	return attr.Inode, name, nil
+}
+
+// names are few so random operations collide often
+var names = []string{"a", "b", "c", "d"}
+
+// model is the expected state of a directory
+type model struct {
+	root   string
+	files  map[string][]byte
+	dirs   map[string]bool
+	inodes map[string]mount.Inode
+}
+
+// Run applies steps random operations beneath dir, an existing empty
+// directory, checking the results of each against a model of the expected
+// filesystem. Inodes must stay the same across writes & renames
+func Run(t *testing.T, ops mount.Ops, dir string, seed int64, steps int) {
+	t.Helper()
+	c := Client{Ops: ops}
+	rnd := rand.New(rand.NewSource(seed))
+
+	attr, err := c.Walk(dir)
+	require.Nil(t, err)
+	require.True(t, attr.IsDir(), "%q must be a directory", dir)
+	m := &model{
+		root:   dir,
+		files:  map[string][]byte{},
+		dirs:   map[string]bool{dir: true},
+		inodes: map[string]mount.Inode{dir: attr.Inode},
+	}
+
+	for i := 0; i < steps; i++ {
+		switch rnd.Intn(7) {
+		case 0: // create
+			p := path.Join(m.pick(rnd, m.dirs), names[rnd.Intn(len(names))])
+			attr, err := c.Create(p)
+			if m.exists(p) {
+				require.ErrorIs(t, err, fs.ErrExist, "step %d: create %q", i, p)
+				break
+			}
+			require.Nil(t, err, "step %d: create %q", i, p)
+			m.files[p] = []byte{}
+			m.inodes[p] = attr.Inode
+		case 1: // write
+			p := m.pick(rnd, m.files)
+			if p == "" {
+				break
+			}
+			off := rnd.Intn(len(m.files[p]) + 50)
+			data := make([]byte, rnd.Intn(200))
+			rnd.Read(data)
+			n, err := ops.Write(m.inodes[p], data, int64(off))
+			require.Nil(t, err, "step %d: write %q", i, p)
+			require.Equal(t, len(data), n)
+			m.files[p] = writeAt(m.files[p], data, off)
+		case 2: // truncate
+			p := m.pick(rnd, m.files)
+			if p == "" {
+				break
+			}
+			size := rnd.Intn(len(m.files[p]) + 20)
+			require.Nil(t, ops.Truncate(m.inodes[p], int64(size)), "step %d: truncate %q", i, p)
+			m.files[p] = writeAt(m.files[p], nil, size)[:size]
+		case 3: // flush
+			p := m.pick(rnd, m.files)
+			if p == "" {
+				break
+			}
+			require.Nil(t, ops.Flush(m.inodes[p]), "step %d: flush %q", i, p)
+		case 4: // mkdir
+			p := path.Join(m.pick(rnd, m.dirs), names[rnd.Intn(len(names))])
+			attr, err := c.Mkdir(p)
+			if m.exists(p) {
+				require.ErrorIs(t, err, fs.ErrExist, "step %d: mkdir %q", i, p)
+				break
+			}
+			require.Nil(t, err, "step %d: mkdir %q", i, p)
+			require.True(t, attr.IsDir())
+			m.dirs[p] = true
+			m.inodes[p] = attr.Inode
+		case 5: // unlink
+			p := m.pickNode(rnd)
+			if p == "" {
+				break
+			}
+			require.Nil(t, c.Remove(p), "step %d: unlink %q", i, p)
+			m.remove(p)
+		case 6: // rename
+			from := m.pickNode(rnd)
+			if from == "" {
+				break
+			}
+			to := path.Join(m.pick(rnd, m.dirs), names[rnd.Intn(len(names))])
+			if to == from || strings.HasPrefix(to, from+"/") {
+				break
+			}
+			err := c.Rename(from, to)
+			if !m.exists(to) || m.replaceable(from, to) {
+				require.Nil(t, err, "step %d: rename %q -> %q", i, from, to)
+				m.remove(to)
+				m.move(from, to)
+			} else {
+				require.NotNil(t, err, "step %d: rename %q -> %q should fail", i, from, to)
+			}
+		}
+
+		m.check(t, c, i)
+	}
+}
+
+func (m *model) check(t *testing.T, c Client, step int) {
+	t.Helper()
+	seen := map[mount.Inode]string{}
+	for p, ino := range m.inodes {
+		attr, err := c.Walk(p)
+		require.Nil(t, err, "step %d: walk %q", step, p)
+		require.Equal(t, ino, attr.Inode, "step %d: inode of %q changed", step, p)
+		require.Equal(t, m.dirs[p], attr.IsDir(), "step %d: %q", step, p)
+		if other, ok := seen[ino]; ok {
+			t.Fatalf("step %d: %q & %q have the same inode %d", step, p, other, ino)
+		}
+		seen[ino] = p
+	}
+
+	for p, content := range m.files {
+		attr, err := c.Ops.Getattr(m.inodes[p])
+		require.Nil(t, err, "step %d: getattr %q", step, p)
+		require.Equal(t, int64(len(content)), attr.Size, "step %d: size of %q", step, p)
+		got, err := c.ReadInode(m.inodes[p])
+		require.Nil(t, err, "step %d: read %q", step, p)
+		require.Equal(t, content, got, "step %d: content of %q", step, p)
+	}
+
+	for dir := range m.dirs {
+		ents, err := c.ReadDir(dir)
+		require.Nil(t, err, "step %d: readdir %q", step, dir)
+		got := make([]string, 0, len(ents))
+		for _, ent := range ents {
+			require.Equal(t, m.inodes[path.Join(dir, ent.Name)], ent.Inode, "step %d: readdir %q", step, dir)
+			got = append(got, ent.Name)
+		}
+		require.Equal(t, m.children(dir), got, "step %d: readdir %q", step, dir)
+	}
+}
+
+func (m *model) exists(p string) bool {
+	_, isFile := m.files[p]
+	return isFile || m.dirs[p]
+}
+
+// replaceable reports whether from can replace to in a rename
+func (m *model) replaceable(from, to string) bool {
+	if m.dirs[from] != m.dirs[to] {
+		return false
+	}
+	return !m.dirs[to] || len(m.children(to)) == 0
+}
+
+func (m *model) children(dir string) []string {
+	names := []string{}
+	for p := range m.inodes {
+		if path.Dir(p) == dir && p != m.root {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *model) remove(p string) {
+	for q := range m.inodes {
+		if q == p || strings.HasPrefix(q, p+"/") {
+			delete(m.inodes, q)
+			delete(m.files, q)
+			delete(m.dirs, q)
+		}
+	}
+}
+
+func (m *model) move(from, to string) {
+	for q, ino := range m.inodes {
+		if q == from || strings.HasPrefix(q, from+"/") {
+			moved := to + strings.TrimPrefix(q, from)
+			m.inodes[moved] = ino
+			delete(m.inodes, q)
+			if content, ok := m.files[q]; ok {
+				m.files[moved] = content
+				delete(m.files, q)
+			}
+			if m.dirs[q] {
+				m.dirs[moved] = true
+				delete(m.dirs, q)
+			}
+		}
+	}
+}
+
+// pickNode picks any node other than the root, returning "" if there are none
+func (m *model) pickNode(rnd *rand.Rand) string {
+	nodes := map[string]bool{}
+	for p := range m.inodes {
+		if p != m.root {
+			nodes[p] = true
+		}
+	}
+	return m.pick(rnd, nodes)
+}
+
+// pick chooses a random key from set, returning "" for an empty set. keys are
+// sorted first to keep runs deterministic
+func (m *model) pick(rnd *rand.Rand, set interface{}) string {
+	keys := []string{}
+	switch s := set.(type) {
+	case map[string]bool:
+		for k := range s {
+			keys = append(keys, k)
+		}
+	case map[string][]byte:
+		for k := range s {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return keys[rnd.Intn(len(keys))]
+}
+
+func writeAt(buf, p []byte, off int) []byte {
+	if end := off + len(p); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[off:], p)
+	return buf
+}
@@ -0,0 +1,249 @@
+package wnfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	blockservice "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	base "github.com/qri-io/wnfs-go/base"
+	private "github.com/qri-io/wnfs-go/private"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	public "github.com/qri-io/wnfs-go/public"
+)
+
+// MergePreview reports what merging bFs into aFs would do without changing
+// either filesystem. The merge runs against scratch copies of both
+// filesystems that read through to the original stores & keep all writes in
+// memory. result.Root is the root a merge would produce, which isn't stored.
+// Uncommitted changes are included, but neither side may have a batch open
+func MergePreview(ctx context.Context, aFs, bFs WNFS, opts ...base.MergeOption) (result MergeResult, err error) {
+	a, ok := aFs.(*fileSystem)
+	if !ok {
+		return result, fmt.Errorf("'a' is not a wnfs filesystem")
+	}
+	b, ok := bFs.(*fileSystem)
+	if !ok {
+		return result, fmt.Errorf("'b' is not a wnfs filesystem")
+	}
+
+	if a, err = a.scratchCopy(ctx); err != nil {
+		return result, fmt.Errorf("copying 'a': %w", err)
+	}
+	if b, err = b.scratchCopy(ctx); err != nil {
+		return result, fmt.Errorf("copying 'b': %w", err)
+	}
+	return Merge(ctx, a, b, opts...)
+}
+
+// scratchCopy opens the current state of fsys on scratch stores layered over
+// the stores of fsys. Writes to the copy never reach fsys
+func (fsys *fileSystem) scratchCopy(ctx context.Context) (*fileSystem, error) {
+	r := fsys.root
+	if r.batch != nil {
+		return nil, ErrBatchInProgress
+	}
+
+	bserv := blockservice.New(newScratchBlockstore(r.store.Blockservice().Blockstore()), nil)
+	store := public.NewStore(ctx, bserv)
+	c := &rootTree{
+		store:   store,
+		id:      r.id,
+		tx:      r.tx,
+		txName:  r.txName,
+		rootKey: r.rootKey,
+	}
+	h := *r.h
+	c.h = &h
+
+	if r.metadata != nil {
+		md, err := r.metadata.Data()
+		if err != nil {
+			return nil, err
+		}
+		c.metadata = public.NewLDFile(store, "", md)
+	}
+
+	var err error
+	if id := r.Public.Cid(); id.Defined() {
+		if c.Public, err = public.LoadTree(ctx, store, FileHierarchyNamePublic, id); err != nil {
+			return nil, err
+		}
+	} else {
+		c.Public = public.NewEmptyTree(store, FileHierarchyNamePublic)
+	}
+
+	hamt := cid.Undef
+	if r.Private != nil {
+		hamt = r.Private.Cid()
+	} else if r.h.Private != nil {
+		hamt = *r.h.Private
+	}
+	rs := newScratchRatchetStore(ctx, r.pstore.RatchetStore())
+	if c.pstore, err = private.LoadStore(ctx, bserv, rs, hamt); err != nil {
+		return nil, err
+	}
+	c.pstore.SetCipher(r.pstore.Cipher())
+	if r.Private != nil {
+		pn, err := r.Private.PrivateName()
+		if err != nil {
+			return nil, err
+		}
+		if c.Private, err = private.LoadRoot(ctx, c.pstore, FileHierarchyNamePrivate, r.Private.Key(), pn); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fileSystem{ctx: ctx, store: store, root: c}, nil
+}
+
+// scratchBlockstore reads through to a base blockstore & keeps writes in
+// memory. The base blockstore is never written to
+type scratchBlockstore struct {
+	lk    sync.Mutex
+	base  blockstore.Blockstore
+	added map[cid.Cid]blocks.Block
+}
+
+var _ blockstore.Blockstore = (*scratchBlockstore)(nil)
+
+func newScratchBlockstore(bs blockstore.Blockstore) *scratchBlockstore {
+	return &scratchBlockstore{base: bs, added: map[cid.Cid]blocks.Block{}}
+}
+
+// DeleteBlock removes a block written to the scratch store. Blocks in the
+// base blockstore can't be deleted
+func (sb *scratchBlockstore) DeleteBlock(_ context.Context, id cid.Cid) error {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+	delete(sb.added, id)
+	return nil
+}
+
+func (sb *scratchBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
+	if blk := sb.get(id); blk != nil {
+		return true, nil
+	}
+	return sb.base.Has(ctx, id)
+}
+
+func (sb *scratchBlockstore) Get(ctx context.Context, id cid.Cid) (blocks.Block, error) {
+	if blk := sb.get(id); blk != nil {
+		return blk, nil
+	}
+	return sb.base.Get(ctx, id)
+}
+
+func (sb *scratchBlockstore) GetSize(ctx context.Context, id cid.Cid) (int, error) {
+	if blk := sb.get(id); blk != nil {
+		return len(blk.RawData()), nil
+	}
+	return sb.base.GetSize(ctx, id)
+}
+
+func (sb *scratchBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	// skip blocks the base already has so AllKeysChan never repeats a CID
+	if has, err := sb.base.Has(ctx, blk.Cid()); err != nil {
+		return err
+	} else if has {
+		return nil
+	}
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+	sb.added[blk.Cid()] = blk
+	return nil
+}
+
+func (sb *scratchBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := sb.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllKeysChan lists the keys of the base blockstore followed by keys written
+// to the scratch store
+func (sb *scratchBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	baseKeys, err := sb.base.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sb.lk.Lock()
+	added := make([]cid.Cid, 0, len(sb.added))
+	for id := range sb.added {
+		added = append(added, id)
+	}
+	sb.lk.Unlock()
+
+	keys := make(chan cid.Cid)
+	go func() {
+		defer close(keys)
+		for id := range baseKeys {
+			select {
+			case keys <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, id := range added {
+			select {
+			case keys <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return keys, nil
+}
+
+func (sb *scratchBlockstore) HashOnRead(enabled bool) {
+	// noop
+}
+
+func (sb *scratchBlockstore) get(id cid.Cid) blocks.Block {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+	return sb.added[id]
+}
+
+// scratchRatchetStore reads through to a base ratchet store & keeps writes in
+// memory. Flush is a no-op
+type scratchRatchetStore struct {
+	base  ratchet.Store
+	added ratchet.Store
+}
+
+var _ ratchet.Store = (*scratchRatchetStore)(nil)
+
+func newScratchRatchetStore(ctx context.Context, rs ratchet.Store) *scratchRatchetStore {
+	return &scratchRatchetStore{base: rs, added: ratchet.NewMemStore(ctx)}
+}
+
+func (s *scratchRatchetStore) PutRatchet(ctx context.Context, name string, r *ratchet.Spiral) (updated bool, err error) {
+	if _, err := s.base.OldestKnownRatchet(ctx, name); err == nil {
+		return false, nil
+	}
+	return s.added.PutRatchet(ctx, name, r)
+}
+
+func (s *scratchRatchetStore) OldestKnownRatchet(ctx context.Context, name string) (*ratchet.Spiral, error) {
+	if r, err := s.base.OldestKnownRatchet(ctx, name); err == nil {
+		return r, nil
+	}
+	return s.added.OldestKnownRatchet(ctx, name)
+}
+
+func (s *scratchRatchetStore) ForEach(ctx context.Context, visit func(name string, r *ratchet.Spiral) error) error {
+	if err := s.base.ForEach(ctx, visit); err != nil {
+		return err
+	}
+	return s.added.ForEach(ctx, visit)
+}
+
+func (s *scratchRatchetStore) Flush() error { return nil }
@@ -0,0 +1,55 @@
+package private
+
+import (
+	"crypto/cipher"
+	"fmt"
+
+	chacha20poly1305 "golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher names the AEAD cipher suite a private node is encrypted with. Each
+// node revision records its cipher in the plaintext of its header block, so
+// nodes written with different ciphers can be mixed in a single tree
+type Cipher string
+
+const (
+	// CipherAESGCM is AES-256 in Galois/counter mode, the default. Headers that
+	// don't name a cipher are encrypted with AES-GCM
+	CipherAESGCM Cipher = "aes-gcm"
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305 as specified in RFC 8439. It's
+	// faster than AES-GCM on chips without AES instructions
+	CipherChaCha20Poly1305 Cipher = "chacha20-poly1305"
+	// CipherXChaCha20Poly1305 is ChaCha20-Poly1305 with 24 byte nonces, which
+	// are large enough to be picked at random without risking reuse
+	CipherXChaCha20Poly1305 Cipher = "xchacha20-poly1305"
+)
+
+// DefaultCipher is the cipher new stores encrypt with
+const DefaultCipher = CipherAESGCM
+
+// Ciphers lists the supported cipher suites
+var Ciphers = []Cipher{CipherAESGCM, CipherChaCha20Poly1305, CipherXChaCha20Poly1305}
+
+// ParseCipher checks s names a supported cipher suite
+func ParseCipher(s string) (Cipher, error) {
+	c := Cipher(s)
+	for _, sc := range Ciphers {
+		if c == sc {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported cipher %q", s)
+}
+
+func newCipher(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case "", CipherAESGCM:
+		return newAESGCMCipher(key)
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", string(c))
+	}
+}
@@ -40,12 +40,18 @@ type ReadSeekCloser interface {
 
 // NewDagReader creates a new reader object that reads the data represented by
 // the given node, using the passed in DAGService for data retrieval.
-func NewDagReader(ctx context.Context, n ipld.Node, serv ipld.NodeGetter, auth cipher.AEAD) (DagReader, error) {
+// chunkSize is the size the file was split with by the cipher chunker, and is
+// used to convert the ciphertext sizes stored in the DAG to plaintext sizes
+func NewDagReader(ctx context.Context, n ipld.Node, serv ipld.NodeGetter, auth cipher.AEAD, chunkSize uint32) (DagReader, error) {
 	var size uint64
+	sizes := plaintextSizer{
+		leafSize: uint64(chunkSize) + uint64(auth.NonceSize()),
+		overhead: uint64(auth.NonceSize() + auth.Overhead()),
+	}
 
 	switch n := n.(type) {
 	case *mdag.RawNode:
-		size = uint64(len(n.RawData()))
+		size = sizes.plaintextSize(uint64(len(n.RawData())))
 
 	case *mdag.ProtoNode:
 		fsNode, err := unixfs.FSNodeFromBytes(n.Data())
@@ -55,7 +61,7 @@ func NewDagReader(ctx context.Context, n ipld.Node, serv ipld.NodeGetter, auth c
 
 		switch fsNode.Type() {
 		case unixfs.TFile, unixfs.TRaw:
-			size = fsNode.FileSize()
+			size = sizes.plaintextSize(fsNode.FileSize())
 
 		case unixfs.TDirectory, unixfs.THAMTShard:
 			// Dont allow reading directories
@@ -74,7 +80,7 @@ func NewDagReader(ctx context.Context, n ipld.Node, serv ipld.NodeGetter, auth c
 			if !ok {
 				return nil, mdag.ErrNotProtobuf
 			}
-			return NewDagReader(ctx, childpb, serv, auth)
+			return NewDagReader(ctx, childpb, serv, auth, chunkSize)
 		case unixfs.TSymlink:
 			return nil, ErrCantReadSymlinks
 		default:
@@ -88,6 +94,7 @@ func NewDagReader(ctx context.Context, n ipld.Node, serv ipld.NodeGetter, auth c
 
 	return &dagReader{
 		cipher:    auth,
+		sizes:     sizes,
 		ctx:       ctxWithCancel,
 		cancel:    cancel,
 		serv:      serv,
@@ -102,6 +109,10 @@ type dagReader struct {
 	// decryption cipher
 	cipher cipher.AEAD
 
+	// Converts the ciphertext block sizes stored in UnixFS nodes to the
+	// plaintext sizes offsets are measured in.
+	sizes plaintextSizer
+
 	// Structure to perform the DAG iteration and search, the reader
 	// just needs to add logic to the `Visitor` callback passed to
 	// `Iterate` and `Seek`.
@@ -219,6 +230,14 @@ func (dr *dagReader) saveNodeData(node ipld.Node) error {
 	if err != nil {
 		return err
 	}
+	if len(ciphertext) == 0 {
+		// empty files are stored as a single, empty leaf
+		dr.currentNodeData = bytes.NewReader(nil)
+		return nil
+	}
+	if len(ciphertext) < int(dr.sizes.overhead) {
+		return errors.New("leaf is too short to be encrypted")
+	}
 
 	plaintext := pool.Get(len(ciphertext))
 	plaintext, err = dr.cipher.Open(plaintext[:0], ciphertext[:dr.cipher.NonceSize()], ciphertext[dr.cipher.NonceSize():], nil)
@@ -411,7 +430,7 @@ func (dr *dagReader) Seek(offset int64, whence int) (int64, error) {
 				// `dagWalker`) to find where we need to go down to next in
 				// the search.
 				for {
-					childSize := fsNode.BlockSize(int(dr.dagWalker.ActiveChildIndex()))
+					childSize := dr.sizes.plaintextSize(fsNode.BlockSize(int(dr.dagWalker.ActiveChildIndex())))
 
 					if childSize > uint64(left) {
 						// This child's data contains the position requested
@@ -495,3 +514,19 @@ func (dr *dagReader) resetPosition() {
 	// TODO: This could be avoided (along with storing the `dr.rootNode` and
 	// `dr.serv` just for this call) if `Reset` is supported in the `Walker`.
 }
+
+// plaintextSizer converts the ciphertext sizes of file DAG nodes to the size
+// of the plaintext they store. Every leaf except the last in a file holds a
+// full chunk, and each leaf adds a nonce & authentication tag to its content
+type plaintextSizer struct {
+	leafSize uint64 // ciphertext size of a full leaf
+	overhead uint64 // bytes added to each leaf by encryption
+}
+
+func (ps plaintextSizer) plaintextSize(ciphertextSize uint64) uint64 {
+	if ciphertextSize == 0 {
+		return 0
+	}
+	leaves := (ciphertextSize + ps.leafSize - 1) / ps.leafSize
+	return ciphertextSize - leaves*ps.overhead
+}
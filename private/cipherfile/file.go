@@ -18,7 +18,9 @@ type cipherFile struct {
 
 var _ files.File = (*cipherFile)(nil)
 
-func NewCipherFile(ctx context.Context, dserv ipld.DAGService, nd ipld.Node, auth cipher.AEAD) (files.Node, error) {
+// NewCipherFile opens the encrypted file DAG rooted at nd. chunkSize must
+// match the size the file was split with
+func NewCipherFile(ctx context.Context, dserv ipld.DAGService, nd ipld.Node, auth cipher.AEAD, chunkSize uint32) (files.Node, error) {
 	switch dn := nd.(type) {
 	case *dag.ProtoNode:
 		fsn, err := unixfs.FSNodeFromBytes(dn.Data())
@@ -38,7 +40,7 @@ func NewCipherFile(ctx context.Context, dserv ipld.DAGService, nd ipld.Node, aut
 		return nil, fmt.Errorf("unknown node type: %T", nd)
 	}
 
-	dr, err := NewDagReader(ctx, nd, dserv, auth)
+	dr, err := NewDagReader(ctx, nd, dserv, auth, chunkSize)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,11 @@
 package private
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"sync"
@@ -11,6 +13,8 @@ import (
 	cid "github.com/ipfs/go-cid"
 	"github.com/qri-io/wnfs-go/base"
 	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/sha3"
 )
 
 var EmptyKey = Key([32]byte{})
@@ -36,6 +40,87 @@ func (k *Key) Decode(s string) error {
 
 func (k Key) IsEmpty() bool { return k == EmptyKey }
 
+// snapshotKeyPrefix separates snapshot key derivation from other hashes of
+// revision keys
+var snapshotKeyPrefix = []byte("wnfs/snapshot")
+
+// SnapshotKey derives the key that decrypts the revision k is the key of.
+// Snapshot keys are one-way: they don't decrypt the ratchet stored with a
+// revision, so holders can't find or read any other revision
+func (k Key) SnapshotKey() Key {
+	return sha3.Sum256(append(append([]byte{}, snapshotKeyPrefix...), k[:]...))
+}
+
+// HKDF info strings of the sub-keys each kind of encrypted object is sealed
+// with
+var (
+	headerKeyInfo  = []byte("wnfs/header")
+	contentKeyInfo = []byte("wnfs/content")
+	linksKeyInfo   = []byte("wnfs/links")
+)
+
+// HeaderKey derives the sub-key that seals the header fields k protects
+func (k Key) HeaderKey() Key { return k.deriveKey(headerKeyInfo) }
+
+// ContentKey derives the sub-key that seals file content. The content key of
+// a snapshot key decrypts file content & nothing else, so it can be handed
+// out to share the content of a revision alone
+func (k Key) ContentKey() Key { return k.deriveKey(contentKeyInfo) }
+
+// LinksKey derives the sub-key that seals the links of a tree
+func (k Key) LinksKey() Key { return k.deriveKey(linksKeyInfo) }
+
+// deriveKey expands k into a sub-key with HKDF-SHA256
+func (k Key) deriveKey(info []byte) (sub Key) {
+	// reading a single key from HKDF-SHA256 can't fail
+	io.ReadFull(hkdf.New(sha256.New, k[:], nil, info), sub[:])
+	return sub
+}
+
+// FormatVersion is the version of the private block format. Header blocks
+// record their format version in plaintext, headers without one are FormatV1
+type FormatVersion int
+
+const (
+	// FormatV1 is the original format. It seals header info, the ratchet
+	// included, links, child keys & content with the revision key, so v1 nodes
+	// can't be opened with a snapshot key
+	FormatV1 FormatVersion = 1
+	// FormatV2 seals header info, links & content with the snapshot key of their
+	// revision, and the ratchet & child keys with the revision key
+	FormatV2 FormatVersion = 2
+	// FormatV3 seals each kind of object with its own sub-key, derived from the
+	// snapshot or revision key with HKDF
+	FormatV3 FormatVersion = 3
+	// LatestFormat is the format blocks are written in. Nodes loaded from older
+	// formats are upgraded when they're next written
+	LatestFormat = FormatV3
+)
+
+// headerKey is the key header fields protected by k are sealed with
+func (v FormatVersion) headerKey(k Key) Key {
+	if v < FormatV3 {
+		return k
+	}
+	return k.HeaderKey()
+}
+
+// contentKey is the key content protected by k is sealed with
+func (v FormatVersion) contentKey(k Key) Key {
+	if v < FormatV3 {
+		return k
+	}
+	return k.ContentKey()
+}
+
+// linksKey is the key links protected by k are sealed with
+func (v FormatVersion) linksKey(k Key) Key {
+	if v < FormatV3 {
+		return k
+	}
+	return k.LinksKey()
+}
+
 func (k Key) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + k.Encode() + `"`), nil
 }
@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	gopath "path"
+	"sort"
 
+	cid "github.com/ipfs/go-cid"
 	base "github.com/qri-io/wnfs-go/base"
 	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
 )
 
-func Merge(ctx context.Context, aNode, bNode base.Node) (result base.MergeResult, err error) {
+// Merge merges bNode into aNode, writing to aNode's store. Links both sides
+// changed since their common ancestor are resolved with the configured
+// ConflictResolver
+func Merge(ctx context.Context, aNode, bNode base.Node, opts ...base.MergeOption) (result base.MergeResult, err error) {
 	dstStore, err := NodeStore(aNode)
 	if err != nil {
 		return result, err
@@ -33,14 +40,30 @@ func Merge(ctx context.Context, aNode, bNode base.Node) (result base.MergeResult
 	}
 
 	log.Debugw("Merge", "a", a.Cid(), "b", b.Cid())
-	result, err = merge(ctx, dstStore, a, b)
+	st := &mergeState{opts: base.NewMergeOptions(opts...)}
+	result, err = merge(ctx, dstStore, st, "", a, b)
 	if err != nil {
 		return result, err
 	}
+	result.Conflicts = st.conflicts
+	result.Decisions = st.decisions
 	return result, err
 }
 
-func merge(ctx context.Context, destFS Store, a, b privateNode) (result base.MergeResult, err error) {
+// mergeState carries options & records decisions across a recursive merge
+type mergeState struct {
+	opts      base.MergeOptions
+	conflicts []base.ConflictResolution
+	decisions []base.MergeDecision
+}
+
+// resolved records the resolution of a conflict
+func (st *mergeState) resolved(cr base.ConflictResolution) {
+	st.conflicts = append(st.conflicts, cr)
+	st.decisions = append(st.decisions, cr.Decision())
+}
+
+func merge(ctx context.Context, destFS Store, st *mergeState, path string, a, b privateNode) (result base.MergeResult, err error) {
 	acid := a.Cid()
 	if a, ok := a.(*Root); ok {
 		// TODO(b5): need to manually fetch cid from HAMT here b/c a.Cid() reports the
@@ -110,7 +133,7 @@ func merge(ctx context.Context, destFS Store, a, b privateNode) (result base.Mer
 	log.Debugw("merge", "ratchetDistance", ratchetDistance, "a", a.Cid(), "b", b.Cid())
 	if ratchetDistance == 0 {
 		// ratchets are equal & cids are inequal, histories have diverged
-		merged, err := mergeDivergedNodes(ctx, destFS, a, b, ratchetDistance)
+		merged, err := mergeDivergedNodes(ctx, destFS, st, path, a, b, ratchetDistance)
 		if err != nil {
 			return result, err
 		}
@@ -156,7 +179,7 @@ func merge(ctx context.Context, destFS Store, a, b privateNode) (result base.Mer
 		}
 
 		// cids at matching ratchet positions are inequal, histories have diverged
-		merged, err := mergeDivergedNodes(ctx, destFS, a, b, ratchetDistance)
+		merged, err := mergeDivergedNodes(ctx, destFS, st, path, a, b, ratchetDistance)
 		if err != nil {
 			return result, err
 		}
@@ -189,6 +212,12 @@ func merge(ctx context.Context, destFS Store, a, b privateNode) (result base.Mer
 		}
 		k := Key(b.Ratchet().Key())
 
+		st.decisions = append(st.decisions, base.MergeDecision{
+			Path:   path,
+			Action: base.MAFastForward,
+			Local:  &acid,
+			Remote: &bcid,
+		})
 		return base.MergeResult{
 			Type: base.MTFastForward,
 
@@ -204,18 +233,21 @@ func merge(ctx context.Context, destFS Store, a, b privateNode) (result base.Mer
 	}
 
 	// cids at matching ratchet positions are inequal, histories have diverged
-	merged, err := mergeDivergedNodes(ctx, destFS, a, b, ratchetDistance)
+	merged, err := mergeDivergedNodes(ctx, destFS, st, path, a, b, ratchetDistance)
 	if err != nil {
 		return result, err
 	}
 	return toMergeResult(merged, base.MTMergeCommit)
 }
 
-func mergeDivergedNodes(ctx context.Context, destFS Store, a, b privateNode, ratchetDistance int) (merged privateNode, err error) {
-	// if b is preferred over a, switch values
-	if ratchetDistance < 0 || (ratchetDistance == 0 && base.LessCID(b.Cid(), a.Cid())) {
-		log.Debugw("mergeDivergedNodes, swapping b <-> a", "ratchetDistance", ratchetDistance, "bIsLess", base.LessCID(b.Cid(), a.Cid()))
-		a, b = b, a
+// mergeAncestorSearchLimit caps the number of prior revisions checked when
+// searching for the common ancestor of two diverged nodes
+const mergeAncestorSearchLimit = 1000
+
+func mergeDivergedNodes(ctx context.Context, destFS Store, st *mergeState, path string, a, b privateNode, ratchetDistance int) (merged privateNode, err error) {
+	ancestor, err := commonAncestor(ctx, a, b, ratchetDistance)
+	if err != nil {
+		return nil, err
 	}
 
 	bStore, err := NodeStore(b)
@@ -227,20 +259,127 @@ func mergeDivergedNodes(ctx context.Context, destFS Store, a, b privateNode, rat
 		return nil, err
 	}
 
+	oTree, _ := ancestor.(*Tree)
 	if root, ok := a.(*Root); ok {
-		return mergeDivergedRoot(ctx, destFS, root, b)
+		return mergeDivergedRoot(ctx, destFS, st, root, b, oTree)
 	}
 
 	aTree, aIsTree := a.(*Tree)
 	bTree, bIsTree := b.(*Tree)
 	if aIsTree && bIsTree {
-		return mergeDivergedTrees(ctx, destFS, aTree, bTree)
+		return mergeDivergedTrees(ctx, destFS, st, path, aTree, bTree, oTree)
 	}
 
+	swap := preferB(a, b, ratchetDistance)
+	res := base.RLocal
+	if swap {
+		res = base.RRemote
+	}
+	if st.opts.Resolver != nil {
+		res, err = st.opts.Resolver.Resolve(base.Conflict{
+			Path:   path,
+			Local:  nodeConflictVersion(a),
+			Remote: nodeConflictVersion(b),
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch res {
+		case base.RLocal:
+			swap = false
+		case base.RRemote:
+			swap = true
+		default:
+			return nil, fmt.Errorf("cannot resolve conflicting merge roots with %q", res)
+		}
+	}
+
+	aid, bid := a.Cid(), b.Cid()
+	st.resolved(base.ConflictResolution{
+		Path:       path,
+		Resolution: res,
+		Local:      &aid,
+		Remote:     &bid,
+	})
+
+	// if b is preferred over a, switch values
+	if swap {
+		log.Debugw("mergeDivergedNodes, swapping b <-> a", "ratchetDistance", ratchetDistance, "bIsLess", base.LessCID(b.Cid(), a.Cid()))
+		a, b = b, a
+	}
 	return mergeDivergedNode(ctx, destFS, a, b)
 }
 
-func mergeDivergedRoot(ctx context.Context, destfs Store, a *Root, b privateNode) (*Root, error) {
+// commonAncestor finds the most recent revision a & b share by stepping back
+// from the older of the two ratchets, comparing the CIDs each side stores at
+// the same private name. returns nil if no shared revision is found
+func commonAncestor(ctx context.Context, a, b privateNode, ratchetDistance int) (privateNode, error) {
+	aStore, err := NodeStore(a)
+	if err != nil {
+		return nil, err
+	}
+	bStore, err := NodeStore(b)
+	if err != nil {
+		return nil, err
+	}
+
+	older := a
+	if ratchetDistance > 0 {
+		older = b
+	}
+
+	old, err := aStore.RatchetStore().OldestKnownRatchet(ctx, older.INumber().Encode())
+	if errors.Is(err, ratchet.ErrRatchetNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	recent := older.Ratchet()
+	ratchets, err := recent.Previous(old, mergeAncestorSearchLimit)
+	if err != nil {
+		// ratchets that can't be walked back to the oldest known revision have
+		// no history to search
+		log.Debugw("commonAncestor previous revs", "err", err)
+		return nil, nil
+	}
+	ratchets = append([]*ratchet.Spiral{recent}, ratchets...)
+
+	bnf := older.BareNamefilter()
+	for _, rcht := range ratchets {
+		key := Key(rcht.Key())
+		knf, err := AddKey(bnf, key)
+		if err != nil {
+			return nil, err
+		}
+		pn, err := ToName(knf)
+		if err != nil {
+			return nil, err
+		}
+
+		aID, err := cidFromPrivateName(ctx, aStore, pn)
+		if errors.Is(err, base.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		bID, err := cidFromPrivateName(ctx, bStore, pn)
+		if errors.Is(err, base.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if aID.Equals(bID) {
+			log.Debugw("commonAncestor", "name", older.Name(), "cid", aID)
+			return LoadNode(ctx, bStore, older.Name(), aID, key)
+		}
+	}
+
+	return nil, nil
+}
+
+func mergeDivergedRoot(ctx context.Context, destfs Store, st *mergeState, a *Root, b privateNode, o *Tree) (*Root, error) {
 	var bTree *Tree
 	switch t := b.(type) {
 	case *Tree:
@@ -260,7 +399,7 @@ func mergeDivergedRoot(ctx context.Context, destfs Store, a *Root, b privateNode
 		return nil, err
 	}
 
-	mergedTree, err := mergeDivergedTrees(ctx, destfs, a.Tree, bTree)
+	mergedTree, err := mergeDivergedTrees(ctx, destfs, st, "", a.Tree, bTree, o)
 	if err != nil {
 		return nil, err
 	}
@@ -280,87 +419,553 @@ func mergeDivergedRoot(ctx context.Context, destfs Store, a *Root, b privateNode
 	return root, nil
 }
 
-func mergeDivergedTrees(ctx context.Context, destfs Store, a, b *Tree) (res *Tree, err error) {
+// mergeDivergedTrees merges the links of remote tree b into local tree a,
+// classifying each link against the common ancestor o. Links only one side
+// added, modified or deleted take that side's version. Directories both sides
+// changed merge recursively. All other conflicts are resolved by
+// mergeDivergedConflict
+func mergeDivergedTrees(ctx context.Context, destfs Store, st *mergeState, dir string, a, b, o *Tree) (res *Tree, err error) {
 	log.Debugw("mergeDivergedTrees", "a.name", a.name, "a", a.cid, "b", b.cid)
-	checked := map[string]struct{}{}
+	for _, t := range []*Tree{a, b, o} {
+		if t == nil {
+			continue
+		}
+		if err := t.ensureLinks(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-	for remName, remInfo := range b.links {
-		localInfo, existsLocally := a.links[remName]
+	aid, bid := a.cid, b.cid
+	st.decisions = append(st.decisions, base.MergeDecision{
+		Path:   dir,
+		Action: base.MAMerge,
+		Local:  &aid,
+		Remote: &bid,
+	})
+
+	names := make([]string, 0, len(a.links)+len(b.links))
+	for name := range a.links {
+		names = append(names, name)
+	}
+	for name := range b.links {
+		if _, ok := a.links[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		aInfo, inA := a.links[name]
+		bInfo, inB := b.links[name]
+		var oInfo PrivateLink
+		inO := false
+		if o != nil {
+			oInfo, inO = o.links[name]
+		}
 
-		if !existsLocally {
-			// remote has a file local is missing. Add it.
-			log.Debugw("adding missing remote file", "name", remName, "cid", remInfo.Cid)
-			a.links.Add(remInfo)
-			checked[remName] = struct{}{}
-			continue
+		aid, bid := linkCid(aInfo, inA), linkCid(bInfo, inB)
+		ch := base.ClassifyEntry(linkCid(oInfo, inO), aid, bid)
+		log.Debugw("mergeDivergedTrees", "name", name, "change", ch.Type, "remote", ch.Remote)
+		if d, ok := base.ChangeDecision(gopath.Join(dir, name), ch, aid, bid); ok {
+			st.decisions = append(st.decisions, d)
 		}
 
-		if localInfo.Cid.Equals(remInfo.Cid) {
-			// both files are equal. no need to merge
-			checked[remName] = struct{}{}
-			continue
+		switch ch.Type {
+		case base.CTAdd, base.CTModify:
+			if ch.Remote {
+				a.links.Add(bInfo)
+			}
+		case base.CTDelete:
+			if ch.Remote {
+				a.links.Remove(name)
+			}
+		case base.CTConflict:
+			if err := mergeDivergedConflict(ctx, destfs, st, gopath.Join(dir, name), a, b, o, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	a.header.Info.Mtime = base.Timestamp().Unix()
+
+	// the merged tree must be written past the ratchet positions both sides
+	// have used
+	merged := &Tree{
+		store:   destfs,
+		ratchet: furthestRatchet(a.ratchet, b.ratchet),
+		name:    a.name,
+		links:   a.links,
+		header: Header{
+			Info: a.header.Info,
+		},
+	}
+	if st.opts.Structured {
+		if err := mergeTreeMetadata(st, dir, merged, a, b, o); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = merged.Put()
+	return merged, err
+}
+
+// mergeDivergedConflict merges a link both a & b changed since the common
+// ancestor o. Directories merge recursively. With structured merges enabled,
+// LDFiles & the metadata of files with the same content merge field by field.
+// With text merges enabled, text files merge line by line. Other conflicts go
+// to the configured resolver. Without a resolver, edits win over deletes, text
+// conflicts get conflict markers & conflicting edits tie-break on ratchet
+// position
+func mergeDivergedConflict(ctx context.Context, destfs Store, st *mergeState, path string, a, b, o *Tree, name string) error {
+	localInfo, inA := a.links[name]
+	remInfo, inB := b.links[name]
+
+	var (
+		lcl, rem privateNode
+		cm       *contentMerge
+	)
+	if inA && inB {
+		var err error
+		if lcl, err = LoadNode(ctx, a.store, localInfo.Name, localInfo.Cid, localInfo.Key); err != nil {
+			return err
+		}
+		if rem, err = LoadNode(ctx, b.store, remInfo.Name, remInfo.Cid, remInfo.Key); err != nil {
+			return err
+		}
+
+		lclTree, lclIsTree := lcl.(*Tree)
+		remTree, remIsTree := rem.(*Tree)
+		if lclIsTree && remIsTree {
+			var oTree *Tree
+			if o != nil {
+				if oInfo, ok := o.links[name]; ok {
+					n, err := LoadNode(ctx, o.store, oInfo.Name, oInfo.Cid, oInfo.Key)
+					if err != nil {
+						return err
+					}
+					oTree, _ = n.(*Tree)
+				}
+			}
+
+			merged, err := mergeDivergedTrees(ctx, destfs, st, path, lclTree, remTree, oTree)
+			if err != nil {
+				return err
+			}
+			res, err := toMergeResult(merged, base.MTMergeCommit)
+			if err != nil {
+				return err
+			}
+			return addMergeResultLink(a, res)
+		}
+
+		if cm, err = newContentMerge(ctx, st.opts, o, name, lcl, rem); err != nil {
+			return err
+		}
+		if cm != nil && !cm.conflicted() {
+			if err := cm.write(destfs, a, name, base.RLocal); err != nil {
+				return err
+			}
+			st.decisions = append(st.decisions, base.MergeDecision{
+				Path:   path,
+				Action: base.MAMerge,
+				Local:  linkCid(localInfo, inA),
+				Remote: linkCid(remInfo, inB),
+			})
+			return nil
 		}
+	}
 
-		// node exists in both trees & CIDs are inequal. merge recursively
-		lcl, err := LoadNode(ctx, a.store, localInfo.Name, localInfo.Cid, localInfo.Key)
+	rec := base.ConflictResolution{
+		Path:   path,
+		Local:  linkCid(localInfo, inA),
+		Remote: linkCid(remInfo, inB),
+	}
+	hunks := 0
+	if cm != nil {
+		rec.Fields, hunks = cm.fields, cm.hunks
+	}
+
+	switch {
+	case st.opts.Resolver != nil:
+		res, err := st.opts.Resolver.Resolve(base.Conflict{
+			Path:   path,
+			Local:  linkConflictVersion(localInfo, inA),
+			Remote: linkConflictVersion(remInfo, inB),
+			Fields: rec.Fields,
+			Hunks:  hunks,
+		})
 		if err != nil {
-			return res, err
+			return err
+		}
+		rec.Resolution = res
+	case !inA:
+		// remote edit undeletes a local delete
+		rec.Resolution = base.RRemote
+	case !inB:
+		// local edit undeletes a remote delete
+		rec.Resolution = base.RLocal
+	case hunks > 0:
+		rec.Resolution = base.RMarkers
+	default:
+		// true conflict, tie-break on ratchet position
+		ratchetDistance, err := lcl.Ratchet().Compare(*rem.Ratchet(), 100000)
+		if errors.Is(err, ratchet.ErrUnknownRatchetRelation) {
+			// versions were created independently, tie-break on CID
+			rec.Resolution = base.RLocal
+			if base.LessCID(rem.Cid(), lcl.Cid()) {
+				rec.Resolution = base.RRemote
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		rec.Resolution = base.RLocal
+		if preferB(lcl, rem, ratchetDistance) {
+			rec.Resolution = base.RRemote
+		}
+		if cm != nil {
+			break
 		}
-		rem, err := LoadNode(ctx, b.store, remInfo.Name, remInfo.Cid, remInfo.Key)
+		// the conflict is recorded here, not by the nested merge
+		res, err := merge(ctx, destfs, &mergeState{opts: st.opts}, path, lcl, rem)
 		if err != nil {
-			return res, err
+			return err
 		}
+		st.resolved(rec)
+		return addMergeResultLink(a, res)
+	}
+	log.Debugw("resolved conflict", "path", path, "resolution", rec.Resolution)
+
+	switch res := rec.Resolution; {
+	case cm != nil && (res == base.RLocal || res == base.RRemote || (res == base.RMarkers && hunks > 0)):
+		// conflicting fields & lines take the chosen resolution, all other
+		// changes merge
+		if err := cm.write(destfs, a, name, res); err != nil {
+			return err
+		}
+	case res == base.RLocal:
+		// local version is already linked
+	case res == base.RRemote:
+		if inB {
+			a.links.Add(remInfo)
+		} else {
+			a.links.Remove(name)
+		}
+	case res == base.RKeepBoth:
+		if inA && inB {
+			rec.Copy = base.ConflictCopyName(name, remInfo.Cid)
+			cp := remInfo
+			cp.Name = rec.Copy
+			a.links.Add(cp)
+		} else if inB {
+			a.links.Add(remInfo)
+		}
+	default:
+		return fmt.Errorf("%s: unknown conflict resolution %q", path, res)
+	}
 
-		res, err := merge(ctx, destfs, lcl, rem)
-		if err != nil {
+	st.resolved(rec)
+	return nil
+}
+
+// contentMerge merges the content of a link both sides of a merge changed:
+// the data of an LDFile or the metadata of a file field by field, or a text
+// file line by line
+type contentMerge struct {
+	// conflicting JSON pointers of a structured merge
+	fields []string
+	// conflicting line ranges of a text merge
+	hunks int
+	merge func(res base.Resolution) interface{}
+	put   func(destfs Store, merged interface{}) (PutResult, error)
+}
+
+// newContentMerge prepares a merge of the content of link name, returning nil
+// if opts don't enable merging the content of local & remote versions lcl &
+// rem
+func newContentMerge(ctx context.Context, opts base.MergeOptions, o *Tree, name string, lcl, rem privateNode) (*contentMerge, error) {
+	var anc privateNode
+	if o != nil {
+		if oInfo, ok := o.links[name]; ok {
+			n, err := LoadNode(ctx, o.store, oInfo.Name, oInfo.Cid, oInfo.Key)
+			if err != nil {
+				return nil, err
+			}
+			anc = n
+		}
+	}
+
+	switch l := lcl.(type) {
+	case *LDFile:
+		r, ok := rem.(*LDFile)
+		if !ok || !opts.Structured {
+			return nil, nil
+		}
+		var od interface{}
+		if anc, ok := anc.(*LDFile); ok {
+			od = anc.content
+		}
+		return newDataMerge(od, l.content, r.content, func(destfs Store, data interface{}) (PutResult, error) {
+			l.store = destfs
+			l.ratchet = furthestRatchet(l.ratchet, r.ratchet)
+			l.SetContents(data)
+			return l.Put()
+		}), nil
+	case *File:
+		r, ok := rem.(*File)
+		if !ok {
+			return nil, nil
+		}
+		sameContent := l.header.ContentID.Equals(r.header.ContentID)
+		switch {
+		case sameContent && opts.Structured:
+			return newMetadataMerge(l, r, anc, func(destfs Store, data interface{}) (PutResult, error) {
+				// content isn't rewritten, only re-encrypted for the new revision
+				contentKey := l.contentKey()
+				l.store = destfs
+				l.ratchet = furthestRatchet(l.ratchet, r.ratchet)
+				l.metadata, l.header.Metadata = nil, cid.Undef
+				if data != nil {
+					if err := l.SetMetadata(data); err != nil {
+						return PutResult{}, err
+					}
+				}
+				return l.putStored(contentKey)
+			})
+		case !sameContent && opts.Text:
+			return newTextMerge(l, r, anc, func(destfs Store, text interface{}) (PutResult, error) {
+				data := []byte(text.(string))
+				l.store = destfs
+				l.ratchet = furthestRatchet(l.ratchet, r.ratchet)
+				l.SetContents(base.NewMemfileBytes(name, data))
+				return l.Put()
+			})
+		}
+	}
+	return nil, nil
+}
+
+func newDataMerge(o, a, b interface{}, put func(Store, interface{}) (PutResult, error)) *contentMerge {
+	_, fields := base.MergeData(o, a, b, false)
+	return &contentMerge{
+		fields: fields,
+		merge: func(res base.Resolution) interface{} {
+			merged, _ := base.MergeData(o, a, b, res == base.RRemote)
+			return merged
+		},
+		put: put,
+	}
+}
+
+func newMetadataMerge(l, r *File, anc privateNode, put func(Store, interface{}) (PutResult, error)) (*contentMerge, error) {
+	a, err := metadataData(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := metadataData(r)
+	if err != nil {
+		return nil, err
+	}
+	var o interface{}
+	if anc, ok := anc.(*File); ok {
+		if o, err = metadataData(anc); err != nil {
 			return nil, err
 		}
+	}
+	return newDataMerge(o, a, b, put), nil
+}
 
-		key := &Key{}
-		if err = key.Decode(res.Key); err != nil {
+// newTextMerge prepares a line merge of files l & r, returning nil if either
+// file, or their common ancestor, isn't text
+func newTextMerge(l, r *File, anc privateNode, put func(Store, interface{}) (PutResult, error)) (*contentMerge, error) {
+	var o string
+	if anc, ok := anc.(*File); ok {
+		text, isText, err := fileText(anc)
+		if err != nil || !isText {
 			return nil, err
 		}
+		o = text
+	}
+	a, isText, err := fileText(l)
+	if err != nil || !isText {
+		return nil, err
+	}
+	b, isText, err := fileText(r)
+	if err != nil || !isText {
+		return nil, err
+	}
+
+	_, hunks := base.MergeText(o, a, b, base.RMarkers)
+	return &contentMerge{
+		hunks: hunks,
+		merge: func(res base.Resolution) interface{} {
+			merged, _ := base.MergeText(o, a, b, res)
+			return merged
+		},
+		put: put,
+	}, nil
+}
+
+// conflicted reports whether both sides changed the same fields or lines
+func (cm *contentMerge) conflicted() bool {
+	return len(cm.fields) > 0 || cm.hunks > 0
+}
 
-		l := PrivateLink{
-			Link: base.Link{
-				Name:   res.Name,
-				Size:   res.Size,
-				Cid:    res.Cid,
-				IsFile: res.IsFile,
-				// TODO(b5): audit fields
-			},
-			Key:     *key,
-			Pointer: Name(res.PrivateName),
+// write links the merged content into a as name, resolving conflicting fields
+// or lines with res
+func (cm *contentMerge) write(destfs Store, a *Tree, name string, res base.Resolution) error {
+	put, err := cm.put(destfs, cm.merge(res))
+	if err != nil {
+		return err
+	}
+	a.links.Add(put.ToPrivateLink(name))
+	return nil
+}
+
+// mergeTreeMetadata three-way merges the metadata of trees a & b field by
+// field, resolving conflicting fields with the configured resolver. The merged
+// metadata is set on merged
+func mergeTreeMetadata(st *mergeState, dir string, merged, a, b, o *Tree) error {
+	aMd, err := metadataData(a)
+	if err != nil {
+		return err
+	}
+	bMd, err := metadataData(b)
+	if err != nil {
+		return err
+	}
+	var oMd interface{}
+	if o != nil {
+		if oMd, err = metadataData(o); err != nil {
+			return err
 		}
-		log.Debugw("adding link", "link", l)
-		a.links.Add(l)
-		checked[remName] = struct{}{}
 	}
 
-	// iterate all of a's files making sure they're present on destFS
-	for aName, aInfo := range a.links {
-		if _, ok := checked[aName]; !ok {
-			log.Debugw("copying blocks for file", "name", aName, "cid", aInfo.Cid)
-			// if err := CopyBlocks(ctx, aInfo.Cid, a.fs, destfs); err != nil {
-			// 	return nil, err
-			// }
+	md, fields := base.MergeData(oMd, aMd, bMd, false)
+	if len(fields) > 0 {
+		res := base.RLocal
+		if st.opts.Resolver != nil {
+			if res, err = st.opts.Resolver.Resolve(base.Conflict{
+				Path:   dir,
+				Local:  nodeConflictVersion(a),
+				Remote: nodeConflictVersion(b),
+				Fields: fields,
+			}); err != nil {
+				return err
+			}
 		}
+		// metadata has no copy to keep, keep-both keeps local fields
+		if res == base.RRemote {
+			md, _ = base.MergeData(oMd, aMd, bMd, true)
+		}
+		aid, bid := a.cid, b.cid
+		st.resolved(base.ConflictResolution{
+			Path:       dir,
+			Resolution: res,
+			Local:      &aid,
+			Remote:     &bid,
+			Fields:     fields,
+		})
 	}
 
-	a.header.Info.Mtime = base.Timestamp().Unix()
+	if md == nil {
+		return nil
+	}
+	return merged.SetMetadata(md)
+}
 
-	merged := &Tree{
-		store:   destfs,
-		ratchet: a.ratchet,
-		name:    a.name,
-		links:   a.links,
-		header: Header{
-			Info: a.header.Info,
+// fileText reads the content of f, reporting whether it's text
+func fileText(f *File) (text string, isText bool, err error) {
+	if err := f.ensureContent(); err != nil {
+		return "", false, err
+	}
+	data, err := ioutil.ReadAll(f.content)
+	// the content reader is spent, reopen it on next read
+	f.content = nil
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), base.IsText(data), nil
+}
+
+// metadataData returns the data of a node's metadata, nil if it has none
+func metadataData(n base.Node) (interface{}, error) {
+	md, err := n.Metadata()
+	if errors.Is(err, base.ErrNoLink) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return md.Data()
+}
+
+// furthestRatchet returns whichever of ratchets a & b is further along. b is
+// copied so writes advancing the result never alter the remote ratchet
+func furthestRatchet(a, b *ratchet.Spiral) *ratchet.Spiral {
+	if d, err := a.Compare(*b, 100000); err == nil && d < 0 {
+		return b.Copy()
+	}
+	return a
+}
+
+// addMergeResultLink links the result of merging a child node into t
+func addMergeResultLink(t *Tree, res base.MergeResult) error {
+	key := &Key{}
+	if err := key.Decode(res.Key); err != nil {
+		return err
+	}
+
+	l := PrivateLink{
+		Link: base.Link{
+			Name:   res.Name,
+			Size:   res.Size,
+			Cid:    res.Cid,
+			IsFile: res.IsFile,
+			// TODO(b5): audit fields
 		},
+		Key:     *key,
+		Pointer: Name(res.PrivateName),
 	}
+	log.Debugw("adding link", "link", l)
+	t.links.Add(l)
+	return nil
+}
 
-	_, err = merged.Put()
-	return merged, err
+// preferB is the deterministic tie-break between conflicting versions a & b,
+// preferring the version further along the ratchet, then the lesser CID
+func preferB(a, b privateNode, ratchetDistance int) bool {
+	return ratchetDistance < 0 || (ratchetDistance == 0 && base.LessCID(b.Cid(), a.Cid()))
+}
+
+// linkConflictVersion describes a link as a side of a conflict, nil if the
+// link doesn't exist
+func linkConflictVersion(l PrivateLink, exists bool) *base.ConflictVersion {
+	if !exists {
+		return nil
+	}
+	return &base.ConflictVersion{
+		Cid:    l.Cid,
+		Size:   l.Size,
+		Mtime:  l.Mtime,
+		IsFile: l.IsFile,
+	}
+}
+
+func nodeConflictVersion(n privateNode) *base.ConflictVersion {
+	return &base.ConflictVersion{
+		Cid:    n.Cid(),
+		Size:   n.Size(),
+		Mtime:  n.ModTime().Unix(),
+		IsFile: !n.IsDir(),
+	}
+}
+
+// linkCid returns a pointer to the CID of a link, nil if the link doesn't exist
+func linkCid(l PrivateLink, exists bool) *cid.Cid {
+	if !exists {
+		return nil
+	}
+	return &l.Cid
 }
 
 func mergeDivergedNode(ctx context.Context, destfs Store, a, b privateNode) (result privateNode, err error) {
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"sort"
 	"time"
@@ -21,6 +22,7 @@ import (
 	golog "github.com/ipfs/go-log"
 	multihash "github.com/multiformats/go-multihash"
 	base "github.com/qri-io/wnfs-go/base"
+	dagmod "github.com/qri-io/wnfs-go/dagmod"
 	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
 	public "github.com/qri-io/wnfs-go/public"
 )
@@ -31,6 +33,8 @@ type Info interface {
 	base.FileInfo
 	Ratchet() *ratchet.Spiral
 	PrivateName() (Name, error)
+	// Cipher is the cipher suite the revision is encrypted with
+	Cipher() Cipher
 }
 
 func Stat(f fs.File) (Info, error) {
@@ -50,6 +54,8 @@ type privateNode interface {
 
 	INumber() INumber
 	Ratchet() *ratchet.Spiral
+	SnapshotKey() Key
+	Format() FormatVersion
 	PrivateName() (Name, error)
 	BareNamefilter() BareNamefilter
 	Update(content fs.File) (PutResult, error)
@@ -165,6 +171,36 @@ func (r *Root) Mkdir(path base.Path) (res base.PutResult, err error) {
 	return res, r.putRoot()
 }
 
+func (r *Root) Mv(from, to base.Path) (res base.PutResult, err error) {
+	res, err = r.Tree.Mv(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return res, r.putRoot()
+}
+
+// Revoke rotates the keys of the node at path & its descendants, see
+// Tree.Revoke
+func (r *Root) Revoke(path base.Path) (res base.PutResult, err error) {
+	res, err = r.Tree.Revoke(path)
+	if err != nil {
+		return nil, err
+	}
+	return res, r.putRoot()
+}
+
+func (r *Root) Symlink(target string, path base.Path) (res base.PutResult, err error) {
+	res, err = r.Tree.Symlink(target, path)
+	if err != nil {
+		return nil, err
+	}
+	return res, r.putRoot()
+}
+
+func (r *Root) OpenFile(path base.Path, flag int) (base.WritableFile, error) {
+	return r.Tree.openFile(r, path, flag)
+}
+
 func (r *Root) Put() (base.PutResult, error) {
 	ctx := context.TODO()
 	log.Debugw("Root.Put", "name", r.name, "hamtCID", r.store.HAMT().CID(), "key", Key(r.ratchet.Key()).Encode())
@@ -182,6 +218,17 @@ func (r *Root) Put() (base.PutResult, error) {
 }
 
 func (r *Root) putRoot() error {
+	if r.store.Tx() != nil {
+		// HAMT & ratchet writes are deferred until the transaction is flushed
+		return nil
+	}
+	return r.Flush()
+}
+
+// Flush writes the HAMT & ratchet store. Outside of a transaction changes are
+// flushed as they're made, within a transaction Flush must be called once the
+// transaction closes
+func (r *Root) Flush() error {
 	ctx := context.TODO()
 	if r.store.HAMT() != nil {
 		if err := r.store.HAMT().Write(ctx); err != nil {
@@ -192,7 +239,7 @@ func (r *Root) putRoot() error {
 	if err != nil {
 		return err
 	}
-	log.Debugw("putRoot", "privateName", string(pn), "name", r.name, "hamtCID", r.store.HAMT().CID(), "key", Key(r.ratchet.Key()).Encode())
+	log.Debugw("Root.Flush", "privateName", string(pn), "name", r.name, "hamtCID", r.store.HAMT().CID(), "key", Key(r.ratchet.Key()).Encode())
 	return r.store.RatchetStore().Flush()
 }
 
@@ -203,6 +250,7 @@ type Tree struct {
 
 	header   Header
 	ratchet  *ratchet.Spiral
+	snapshot Key // key of trees loaded without a ratchet
 	metadata *LDFile
 	links    PrivateLinks
 }
@@ -242,18 +290,18 @@ func LoadTree(store Store, name string, key Key, id cid.Cid) (*Tree, error) {
 		return nil, err
 	}
 
-	ratchet, err := ratchet.DecodeSpiral(header.Info.Ratchet)
+	r, snapshot, err := loadedRatchet(&header, key)
 	if err != nil {
 		return nil, fmt.Errorf("decoding ratchet: %w", err)
 	}
-	header.Info.Ratchet = ""
 
 	return &Tree{
-		store:   store,
-		name:    name,
-		ratchet: ratchet,
-		cid:     id,
-		header:  header,
+		store:    store,
+		name:     name,
+		ratchet:  r,
+		snapshot: snapshot,
+		cid:      id,
+		header:   header,
 	}, nil
 }
 
@@ -313,20 +361,19 @@ func (pt *Tree) ensureLinks(ctx context.Context) error {
 			return err
 		}
 
-		pt.links, err = unmarshalPrivateLinksBlock(blk, pt.Key())
+		pt.links, err = unmarshalPrivateLinksBlock(blk, pt.header.Format, pt.header.Cipher, pt.Key())
 		return err
 	}
 	return nil
 }
 
 func (pt *Tree) PrivateName() (Name, error) {
-	knf, err := AddKey(pt.header.Info.BareNamefilter, Key(pt.ratchet.Key()))
-	if err != nil {
-		return "", err
-	}
-	return ToName(knf)
+	return privateName(pt.header.Info.BareNamefilter, pt.ratchet)
 }
-func (pt *Tree) Key() Key { return pt.ratchet.Key() }
+func (pt *Tree) Key() Key              { return nodeKey(pt.ratchet, pt.snapshot) }
+func (pt *Tree) SnapshotKey() Key      { return snapshotKey(pt.ratchet, pt.snapshot) }
+func (pt *Tree) Cipher() Cipher        { return pt.header.cipher() }
+func (pt *Tree) Format() FormatVersion { return pt.header.Format }
 
 func (pt *Tree) Read(p []byte) (n int, err error) {
 	return -1, fmt.Errorf("cannot read directory")
@@ -391,6 +438,67 @@ func (pt *Tree) Add(path base.Path, f fs.File) (res base.PutResult, err error) {
 	return pt.Put()
 }
 
+// OpenFile opens the file at path for editing. flag is a combination of os
+// package O_* flags & must include O_WRONLY or O_RDWR. Symlinks are followed.
+// Edits are written to this tree when the returned file is closed, rewriting
+// only the chunks of file content an edit touched. Content is re-encrypted
+// when the edit starts a new revision, so each revision is sealed with its own
+// content key
+func (pt *Tree) OpenFile(path base.Path, flag int) (base.WritableFile, error) {
+	return pt.openFile(pt, path, flag)
+}
+
+// openFile opens a file within pt, adding edits to dst on close
+func (pt *Tree) openFile(dst base.Tree, path base.Path, flag int) (base.WritableFile, error) {
+	if len(path) == 0 || path[0] == "" {
+		return nil, errors.New("invalid path: empty")
+	}
+
+	var (
+		file       *File
+		contentKey Key
+		created    bool
+	)
+	f, resolved, err := pt.resolve(path, true)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, fmt.Errorf("%q: %w", path, fs.ErrExist)
+		}
+		var ok bool
+		if file, ok = f.(*File); !ok {
+			return nil, fmt.Errorf("%q: not a file", path)
+		}
+		contentKey = file.contentKey()
+	case errors.Is(err, base.ErrNotFound) && flag&os.O_CREATE != 0:
+		// new files are named within their parent when they're added on close.
+		// content is sealed with the content key of the first revision
+		if file, err = NewFile(pt.store, IdentityBareNamefilter(), nil); err != nil {
+			return nil, err
+		}
+		file.name = resolved[len(resolved)-1]
+		file.header.Cipher = pt.store.Cipher()
+		first := file.ratchet.Copy()
+		first.Inc()
+		contentKey = LatestFormat.contentKey(Key(first.Key()).SnapshotKey())
+		created = true
+	default:
+		return nil, err
+	}
+
+	mod, err := pt.store.EditEncryptedFile(file.header.ContentID, file.header.Cipher, contentKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return dagmod.NewFile(file.name, mod, flag, created, func(root cid.Cid, size int64) error {
+		file.content = nil
+		file.header.ContentID = root
+		file.header.Info.Size = size
+		_, err := dst.Add(resolved, editedFile{File: file, contentKey: contentKey, created: created})
+		return err
+	})
+}
+
 func (pt *Tree) Copy(path base.Path, srcPathStr string, srcFS fs.FS) (res base.PutResult, err error) {
 	log.Debugw("Tree.copy", "path", path, "srcPath", srcPathStr)
 	if len(path) == 0 {
@@ -427,31 +535,85 @@ func (pt *Tree) Copy(path base.Path, srcPathStr string, srcFS fs.FS) (res base.P
 }
 
 func (pt *Tree) Get(path base.Path) (fs.File, error) {
-	head, tail := path.Shift()
-	if head == "" {
-		return pt, nil
-	}
+	return pt.get(path, true)
+}
 
-	if err := pt.ensureLinks(context.TODO()); err != nil {
-		return nil, err
+// Readlink returns the target of the symlink at path
+func (pt *Tree) Readlink(path base.Path) (string, error) {
+	f, err := pt.get(path, false)
+	if err != nil {
+		return "", err
 	}
+	sl, ok := f.(base.Symlink)
+	if !ok {
+		return "", fmt.Errorf("%q: %w", path, base.ErrNotSymlink)
+	}
+	return sl.Target(), nil
+}
 
-	link := pt.links.Get(head)
-	if link == nil {
-		return nil, base.ErrNotFound
+// get resolves path, following any symlinks along the way. A symlink at the
+// end of path is only followed if followLast is true
+func (pt *Tree) get(path base.Path, followLast bool) (fs.File, error) {
+	f, _, err := pt.resolve(path, followLast)
+	return f, err
+}
+
+// resolve is get, also returning path with all symlinks replaced by their
+// targets. The returned path is set when resolve fails with ErrNotFound
+func (pt *Tree) resolve(path base.Path, followLast bool) (fs.File, base.Path, error) {
+	resolver := &base.SymlinkResolver{}
+	for {
+		f, link, rest, err := pt.walk(path, followLast)
+		if err != nil {
+			return nil, path, err
+		}
+		if link == nil {
+			return f, path, nil
+		}
+		if path, err = resolver.Follow(link, f.(*Symlink).Target(), rest); err != nil {
+			return nil, nil, err
+		}
 	}
+}
+
+// walk descends path without following symlinks. walk stops at the first
+// symlink that needs following, returning the symlink, the path to the symlink
+// and the remaining path
+func (pt *Tree) walk(path base.Path, followLast bool) (f fs.File, link, rest base.Path, err error) {
+	ctx := context.TODO()
+	dir := pt
+	for i, name := range path {
+		if name == "" {
+			continue
+		}
 
-	if tail != nil {
-		ch, err := LoadTree(pt.store, head, link.Key, link.Cid)
+		if err := dir.ensureLinks(ctx); err != nil {
+			return nil, nil, nil, err
+		}
+		l := dir.links.Get(name)
+		if l == nil {
+			return nil, nil, nil, base.ErrNotFound
+		}
+		n, err := LoadNode(ctx, dir.store, name, l.Cid, l.Key)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 
-		// recurse
-		return ch.Get(tail)
-	}
+		last := i == len(path)-1
+		if sl, ok := n.(*Symlink); ok && (followLast || !last) {
+			return sl, path[:i+1], path[i+1:], nil
+		}
+		if last {
+			return n, nil, nil, nil
+		}
 
-	return LoadNode(context.TODO(), pt.store, head, link.Cid, link.Key)
+		ch, ok := n.(*Tree)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%q: not a directory", path[:i+1])
+		}
+		dir = ch
+	}
+	return dir, nil, nil, nil
 }
 
 func (pt *Tree) Rm(path base.Path) (base.PutResult, error) {
@@ -487,6 +649,278 @@ func (pt *Tree) Rm(path base.Path) (base.PutResult, error) {
 	return pt.Put()
 }
 
+// Mv relinks the node at from to the path to. The moved node is not
+// re-encrypted, keeping its key, ratchet & bare namefilter. Only directories
+// between this tree and the moved node are updated
+func (pt *Tree) Mv(from, to base.Path) (base.PutResult, error) {
+	if len(from) == 0 || from[0] == "" || len(to) == 0 || to[0] == "" {
+		return nil, fmt.Errorf("invalid path: empty")
+	}
+	if to.HasPrefix(from) {
+		return nil, fmt.Errorf("cannot move %q into itself", from)
+	}
+	if err := pt.ensureLinks(context.TODO()); err != nil {
+		return nil, err
+	}
+
+	fromHead, fromTail := from.Shift()
+	toHead, toTail := to.Shift()
+	if fromTail != nil && toTail != nil && fromHead == toHead {
+		// both paths share a parent directory, recurse
+		link := pt.links.Get(fromHead)
+		if link == nil {
+			return nil, base.ErrNotFound
+		}
+		child, err := LoadTree(pt.store, link.Name, link.Key, link.Cid)
+		if err != nil {
+			return nil, err
+		}
+		res, err := child.Mv(fromTail, toTail)
+		if err != nil {
+			return nil, err
+		}
+		pt.updateUserlandLink(fromHead, res)
+		return pt.Put()
+	}
+
+	// this tree is the closest common ancestor of from & to
+	if _, err := pt.Get(to); err == nil {
+		return nil, fmt.Errorf("%q: %w", to, fs.ErrExist)
+	}
+	link, err := pt.detach(from)
+	if err != nil {
+		return nil, err
+	}
+	if err = pt.attach(to, link); err != nil {
+		return nil, err
+	}
+	return pt.Put()
+}
+
+// detach removes the link at path, returning the removed link. intermediate
+// directories are written, this tree is not
+func (pt *Tree) detach(path base.Path) (PrivateLink, error) {
+	if err := pt.ensureLinks(context.TODO()); err != nil {
+		return PrivateLink{}, err
+	}
+	head, tail := path.Shift()
+	link := pt.links.Get(head)
+	if link == nil {
+		return PrivateLink{}, base.ErrNotFound
+	}
+
+	if tail == nil {
+		pt.removeUserlandLink(head)
+		return *link, nil
+	}
+
+	child, err := LoadTree(pt.store, link.Name, link.Key, link.Cid)
+	if err != nil {
+		return PrivateLink{}, err
+	}
+	removed, err := child.detach(tail)
+	if err != nil {
+		return PrivateLink{}, err
+	}
+	res, err := child.Put()
+	if err != nil {
+		return PrivateLink{}, err
+	}
+	pt.updateUserlandLink(head, res)
+	return removed, nil
+}
+
+// attach adds link at path, creating any missing intermediate directories.
+// intermediate directories are written, this tree is not
+func (pt *Tree) attach(path base.Path, link PrivateLink) error {
+	if err := pt.ensureLinks(context.TODO()); err != nil {
+		return err
+	}
+	head, tail := path.Shift()
+	if tail == nil {
+		if existing := pt.links.Get(head); existing != nil {
+			return fmt.Errorf("%q: %w", head, fs.ErrExist)
+		}
+		link.Name = head
+		pt.links.Add(link)
+		pt.header.Info.Mtime = base.Timestamp().Unix()
+		return nil
+	}
+
+	child, err := pt.getOrCreateDirectChildTree(head)
+	if err != nil {
+		return err
+	}
+	if err = child.attach(tail, link); err != nil {
+		return err
+	}
+	res, err := child.Put()
+	if err != nil {
+		return err
+	}
+	pt.updateUserlandLink(head, res)
+	return nil
+}
+
+// Revoke cuts off access granted by handing out the key, name or ratchet of
+// the node at path or any node below it. The node & its descendants are
+// re-encrypted as new nodes with fresh INumbers, namefilters & ratchets, which
+// old keys can't derive, & linked in place of the old nodes. Old revisions stay
+// readable by old key holders, revisions written after Revoke don't. The
+// rotated nodes start a new history
+func (pt *Tree) Revoke(path base.Path) (base.PutResult, error) {
+	ctx := context.TODO()
+	head, tail := path.Shift()
+	if head == "" {
+		return nil, fmt.Errorf("invalid path: empty")
+	}
+	if err := pt.ensureLinks(ctx); err != nil {
+		return nil, err
+	}
+	link := pt.links.Get(head)
+	if link == nil {
+		return nil, base.ErrNotFound
+	}
+
+	if tail == nil {
+		n, err := LoadNode(ctx, pt.store, head, link.Cid, link.Key)
+		if err != nil {
+			return nil, err
+		}
+		res, err := rotateNode(ctx, pt.store, pt.header.Info.BareNamefilter, n)
+		if err != nil {
+			return nil, fmt.Errorf("rotating %q: %w", head, err)
+		}
+		pt.updateUserlandLink(head, res)
+	} else {
+		child, err := LoadTree(pt.store, link.Name, link.Key, link.Cid)
+		if err != nil {
+			return nil, err
+		}
+		res, err := child.Revoke(tail)
+		if err != nil {
+			return nil, err
+		}
+		pt.updateUserlandLink(head, res)
+	}
+
+	return pt.Put()
+}
+
+// rotateNode writes a copy of n & its descendants as new nodes under parent
+func rotateNode(ctx context.Context, store Store, parent BareNamefilter, n privateNode) (PutResult, error) {
+	switch n := n.(type) {
+	case *Tree:
+		if err := n.ensureLinks(ctx); err != nil {
+			return PutResult{}, err
+		}
+		rotated, err := NewEmptyTree(store, parent, n.name)
+		if err != nil {
+			return PutResult{}, err
+		}
+		rotated.header.Info.Mode = n.header.Info.Mode
+		rotated.header.Info.Ctime = n.header.Info.Ctime
+		rotated.header.Info.Mtime = n.header.Info.Mtime
+		for _, l := range n.links.SortedSlice() {
+			ch, err := LoadNode(ctx, store, l.Name, l.Cid, l.Key)
+			if err != nil {
+				return PutResult{}, err
+			}
+			res, err := rotateNode(ctx, store, rotated.header.Info.BareNamefilter, ch)
+			if err != nil {
+				return PutResult{}, fmt.Errorf("rotating %q: %w", l.Name, err)
+			}
+			rotated.links.Add(res.ToPrivateLink(l.Name))
+		}
+		if md, err := n.Metadata(); err == nil {
+			data, err := md.Data()
+			if err != nil {
+				return PutResult{}, err
+			}
+			if err := rotated.SetMetadata(data); err != nil {
+				return PutResult{}, err
+			}
+		} else if !errors.Is(err, base.ErrNoLink) {
+			return PutResult{}, err
+		}
+		res, err := rotated.Put()
+		if err != nil {
+			return PutResult{}, err
+		}
+		return res.(PutResult), nil
+	case *File:
+		rotated, err := NewFile(store, parent, n)
+		if err != nil {
+			return PutResult{}, err
+		}
+		rotated.name = n.name
+		rotated.header.Info.Mode = n.header.Info.Mode
+		rotated.header.Info.Ctime = n.header.Info.Ctime
+		rotated.header.Info.Mtime = n.header.Info.Mtime
+		return rotated.Put()
+	case *LDFile:
+		data, err := n.Data()
+		if err != nil {
+			return PutResult{}, err
+		}
+		rotated, err := NewLDFile(store, n.name, data, parent)
+		if err != nil {
+			return PutResult{}, err
+		}
+		return rotated.Put()
+	case *Symlink:
+		rotated, err := NewSymlink(store, parent, n.name, n.Target())
+		if err != nil {
+			return PutResult{}, err
+		}
+		return rotated.Put()
+	default:
+		return PutResult{}, fmt.Errorf("unexpected private node type %T", n)
+	}
+}
+
+// Symlink creates a symlink at path that points to target. Relative targets
+// are resolved from the directory containing the symlink. target doesn't need
+// to exist
+func (pt *Tree) Symlink(target string, path base.Path) (res base.PutResult, err error) {
+	if len(path) == 0 || path[0] == "" {
+		return nil, fmt.Errorf("invalid path: empty")
+	}
+	if target == "" {
+		return nil, fmt.Errorf("symlink target cannot be empty")
+	}
+	if err := pt.ensureLinks(context.TODO()); err != nil {
+		return nil, err
+	}
+
+	head, tail := path.Shift()
+	if tail == nil {
+		if pt.links.Get(head) != nil {
+			return nil, fmt.Errorf("%q: %w", head, fs.ErrExist)
+		}
+		sl, err := NewSymlink(pt.store, pt.header.Info.BareNamefilter, head, target)
+		if err != nil {
+			return nil, err
+		}
+		if res, err = sl.Put(); err != nil {
+			return nil, err
+		}
+	} else {
+		childDir, err := pt.getOrCreateDirectChildTree(head)
+		if err != nil {
+			return nil, err
+		}
+
+		// recurse
+		if res, err = childDir.Symlink(target, tail); err != nil {
+			return nil, err
+		}
+	}
+
+	pt.updateUserlandLink(head, res)
+	return pt.Put()
+}
+
 func (pt *Tree) Mkdir(path base.Path) (res base.PutResult, err error) {
 	if len(path) < 1 {
 		return res, errors.New("invalid path: empty")
@@ -519,6 +953,9 @@ func (pt *Tree) History(ctx context.Context, maxRevs int) ([]base.HistoryEntry,
 }
 
 func history(ctx context.Context, n privateNode, maxRevs int) ([]base.HistoryEntry, error) {
+	if n.Ratchet() == nil {
+		return nil, ErrSnapshot
+	}
 	st, err := n.Stat()
 	if err != nil {
 		return nil, err
@@ -650,6 +1087,17 @@ func (pt *Tree) createOrUpdateChildFile(ctx context.Context, name string, f fs.F
 	if err := pt.ensureLinks(ctx); err != nil {
 		return nil, err
 	}
+	if ef, ok := f.(editedFile); ok {
+		if ef.created {
+			// name files created by OpenFile within the tree they're added to
+			bnf, err := NewBareNamefilter(pt.header.Info.BareNamefilter, ef.header.Info.INumber)
+			if err != nil {
+				return nil, err
+			}
+			ef.header.Info.BareNamefilter = bnf
+		}
+		return ef.putStored(ef.contentKey)
+	}
 	if link := pt.links.Get(name); link != nil {
 		prev, err := LoadNode(ctx, pt.store, link.Name, link.Cid, link.Key)
 		if err != nil {
@@ -680,13 +1128,20 @@ func (pt *Tree) createOrUpdateChildFile(ctx context.Context, name string, f fs.F
 
 func (pt *Tree) Put() (base.PutResult, error) {
 	ctx := context.TODO()
-	pt.ratchet.Inc()
+	if pt.ratchet == nil {
+		return nil, ErrSnapshot
+	}
+	if !pt.store.Tx().IsStaged(pt.cid) {
+		pt.ratchet.Inc()
+	}
+	pt.header.Cipher = pt.store.Cipher()
+	pt.header.Format = LatestFormat
 	log.Debugw("Tree.Put", "name", pt.name, "len(links)", len(pt.links), "newRatchet", pt.ratchet.Summary())
 	key := pt.ratchet.Key()
 	pt.header.Info.Ratchet = pt.ratchet.Encode()
 	pt.header.Info.Size = pt.links.SizeSum()
 
-	linksBlk, err := pt.links.marshalEncryptedBlock(key)
+	linksBlk, err := pt.links.marshalEncryptedBlock(pt.header.Cipher, key)
 	if err != nil {
 		return nil, err
 	}
@@ -710,6 +1165,7 @@ func (pt *Tree) Put() (base.PutResult, error) {
 		return nil, err
 	}
 	pt.cid = blk.Cid()
+	pt.store.Tx().Stage(pt.cid)
 
 	privName, err := pt.PrivateName()
 	if err != nil {
@@ -754,6 +1210,7 @@ type File struct {
 	header Header
 
 	ratchet  *ratchet.Spiral
+	snapshot Key // key of files loaded without a ratchet
 	metadata *LDFile
 	content  io.ReadCloser
 }
@@ -762,6 +1219,8 @@ var (
 	_ privateNode           = (*File)(nil)
 	_ base.WritableMetaNode = (*File)(nil)
 	_ fs.File               = (*File)(nil)
+	_ io.Seeker             = (*File)(nil)
+	_ io.ReaderAt           = (*File)(nil)
 	_ Info                  = (*File)(nil)
 )
 
@@ -769,12 +1228,13 @@ func NewFile(store Store, parent BareNamefilter, content fs.File) (*File, error)
 	var meta interface{}
 	if mdn, ok := content.(base.Metadata); ok {
 		md, err := mdn.Metadata()
-		if err != nil {
+		if err != nil && !errors.Is(err, base.ErrNoLink) {
 			return nil, err
 		}
-		meta, err = md.Data()
-		if err != nil {
-			return nil, err
+		if md != nil {
+			if meta, err = md.Data(); err != nil {
+				return nil, err
+			}
 		}
 		log.Debugw("setting file meta", "meta", meta)
 	}
@@ -818,21 +1278,30 @@ func LoadFile(ctx context.Context, store Store, name string, key Key, id cid.Cid
 		return nil, fmt.Errorf("decoding s-node %q header: %w", name, err)
 	}
 
-	ratchet, err := ratchet.DecodeSpiral(header.Info.Ratchet)
+	r, snapshot, err := loadedRatchet(&header, key)
 	if err != nil {
 		return nil, err
 	}
-	header.Info.Ratchet = ""
 
 	return &File{
-		store:   store,
-		ratchet: ratchet,
-		name:    name,
-		cid:     id,
-		header:  header,
+		store:    store,
+		ratchet:  r,
+		snapshot: snapshot,
+		name:     name,
+		cid:      id,
+		header:   header,
 	}, nil
 }
 
+// editedFile is a file with content written by OpenFile
+type editedFile struct {
+	*File
+	// contentKey seals the edited content
+	contentKey Key
+	// created is set for files OpenFile created that haven't been written
+	created bool
+}
+
 func (pf *File) Ratchet() *ratchet.Spiral       { return pf.ratchet }
 func (pf *File) BareNamefilter() BareNamefilter { return pf.header.Info.BareNamefilter }
 func (pf *File) INumber() INumber               { return pf.header.Info.INumber }
@@ -865,11 +1334,7 @@ func (pf *File) Metadata() (f base.LDFile, err error) {
 }
 
 func (pf *File) PrivateName() (Name, error) {
-	knf, err := AddKey(pf.header.Info.BareNamefilter, Key(pf.ratchet.Key()))
-	if err != nil {
-		return "", err
-	}
-	return ToName(knf)
+	return privateName(pf.header.Info.BareNamefilter, pf.ratchet)
 }
 
 func (pf *File) AsHistoryEntry() base.HistoryEntry {
@@ -878,7 +1343,10 @@ func (pf *File) AsHistoryEntry() base.HistoryEntry {
 	}
 }
 
-func (pf *File) Key() Key { return pf.ratchet.Key() }
+func (pf *File) Key() Key              { return nodeKey(pf.ratchet, pf.snapshot) }
+func (pf *File) SnapshotKey() Key      { return snapshotKey(pf.ratchet, pf.snapshot) }
+func (pf *File) Cipher() Cipher        { return pf.header.cipher() }
+func (pf *File) Format() FormatVersion { return pf.header.Format }
 
 func (pf *File) Read(p []byte) (n int, err error) {
 	if err = pf.ensureContent(); err != nil {
@@ -887,6 +1355,41 @@ func (pf *File) Read(p []byte) (n int, err error) {
 	return pf.content.Read(p)
 }
 
+// Seek sets the offset of the next Read. Content set with SetContents can
+// only be seeked if it implements io.Seeker
+func (pf *File) Seek(offset int64, whence int) (int64, error) {
+	if err := pf.ensureContent(); err != nil {
+		return 0, err
+	}
+	s, ok := pf.content.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", pf.name, base.ErrNotSeekable)
+	}
+	return s.Seek(offset, whence)
+}
+
+// ReadAt reads stored content starting at off. ReadAt opens a DAG reader of
+// its own, and doesn't move the offset of Read
+func (pf *File) ReadAt(p []byte, off int64) (int, error) {
+	if !pf.header.ContentID.Defined() {
+		return 0, fmt.Errorf("%q: file has no stored content", pf.name)
+	}
+	if off >= pf.Size() {
+		return 0, io.EOF
+	}
+	key := pf.contentKey()
+	r, err := pf.store.GetEncryptedFile(pf.header.ContentID, pf.header.Cipher, key[:])
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", pf.name, base.ErrNotSeekable)
+	}
+	return base.ReadAt(rs, p, off)
+}
+
 func (pf *File) Close() error {
 	if pf.content == nil {
 		return nil
@@ -902,10 +1405,19 @@ func (pf *File) SetContents(f fs.File) {
 	pf.content = f
 }
 
+// contentKey returns the key file content is encrypted with
+func (pf *File) contentKey() Key {
+	// v1 content is sealed with the revision key
+	if pf.header.Format == FormatV1 {
+		return pf.Key()
+	}
+	return pf.header.Format.contentKey(pf.SnapshotKey())
+}
+
 func (pf *File) ensureContent() (err error) {
 	if pf.content == nil {
-		key := pf.ratchet.Key()
-		pf.content, err = pf.store.GetEncryptedFile(pf.header.ContentID, key[:])
+		key := pf.contentKey()
+		pf.content, err = pf.store.GetEncryptedFile(pf.header.ContentID, pf.header.Cipher, key[:])
 		log.Debugw("opening file contents", "name", pf.name, "cid", pf.cid, "err", err)
 	}
 	return err
@@ -935,17 +1447,19 @@ func (pf *File) Update(change fs.File) (result PutResult, err error) {
 
 	if mdn, ok := change.(base.Metadata); ok {
 		md, err := mdn.Metadata()
-		if err != nil {
-			return PutResult{}, err
-		}
-		meta, err := md.Data()
-		if err != nil {
+		if err != nil && !errors.Is(err, base.ErrNoLink) {
 			return PutResult{}, err
 		}
-		log.Debugw("setting update file meta", "meta", meta)
-		pf.metadata, err = newLDFileRatchet(pf.store, base.MetadataLinkName, meta, pf.BareNamefilter(), pf.ratchet)
-		if err != nil {
-			return PutResult{}, err
+		if md != nil {
+			meta, err := md.Data()
+			if err != nil {
+				return PutResult{}, err
+			}
+			log.Debugw("setting update file meta", "meta", meta)
+			pf.metadata, err = newLDFileRatchet(pf.store, base.MetadataLinkName, meta, pf.BareNamefilter(), pf.ratchet)
+			if err != nil {
+				return PutResult{}, err
+			}
 		}
 	}
 
@@ -954,18 +1468,65 @@ func (pf *File) Update(change fs.File) (result PutResult, err error) {
 }
 
 func (pf *File) Put() (PutResult, error) {
-	ctx := pf.store.Context()
-	store := pf.store
+	if pf.ratchet == nil {
+		return PutResult{}, ErrSnapshot
+	}
+	key := pf.nextKey()
+	contentKey := LatestFormat.contentKey(key.SnapshotKey())
+	pf.header.Cipher = pf.store.Cipher()
+	res, err := pf.store.PutEncryptedFile(base.NewMemfileReader(pf.name, pf.content), pf.header.Cipher, contentKey[:])
+	if err != nil {
+		return PutResult{}, err
+	}
+	pf.header.ContentID = res.Cid
+	pf.header.Info.Size = res.Size
 
-	// generate a new version key by advancing the ratchet
+	return pf.putHeader(key)
+}
+
+// nextKey generates a new version key by advancing the ratchet. files
+// rewritten within a transaction keep the key they were first written with
+func (pf *File) nextKey() Key {
 	// TODO(b5): what happens if anything errors after advancing the ratchet?
 	// assuming we need to make a point of throwing away the file & cleaning the HAMT
-	pf.ratchet.Inc()
-	key := pf.ratchet.Key()
+	if pf.ratchet != nil && !pf.store.Tx().IsStaged(pf.cid) {
+		pf.ratchet.Inc()
+	}
+	return pf.Key()
+}
 
-	res, err := store.PutEncryptedFile(base.NewMemfileReader(pf.name, pf.content), key[:])
-	if err != nil {
-		return PutResult{}, err
+// putStored writes a new revision of a file with content that's already in
+// the store, sealed with contentKey. Content sealed with any key other than
+// the content key of the new revision is re-encrypted, so the keys of one
+// revision never decrypt the content of another
+func (pf *File) putStored(contentKey Key) (PutResult, error) {
+	if pf.ratchet == nil {
+		return PutResult{}, ErrSnapshot
+	}
+	key := pf.nextKey()
+	if next := LatestFormat.contentKey(key.SnapshotKey()); contentKey != next {
+		r, err := pf.store.GetEncryptedFile(pf.header.ContentID, pf.header.Cipher, contentKey[:])
+		if err != nil {
+			return PutResult{}, err
+		}
+		defer r.Close()
+		res, err := pf.store.PutEncryptedFile(base.NewMemfileReader(pf.name, r), pf.header.Cipher, next[:])
+		if err != nil {
+			return PutResult{}, err
+		}
+		pf.header.ContentID = res.Cid
+		pf.header.Info.Size = res.Size
+	}
+	return pf.putHeader(key)
+}
+
+// putHeader writes metadata & the file header encrypted with key, linking to
+// content that's already in the store
+func (pf *File) putHeader(key Key) (PutResult, error) {
+	ctx := pf.store.Context()
+	store := pf.store
+	if pf.ratchet == nil {
+		return PutResult{}, ErrSnapshot
 	}
 
 	if pf.metadata != nil {
@@ -979,8 +1540,7 @@ func (pf *File) Put() (PutResult, error) {
 	}
 
 	// update header details
-	pf.header.ContentID = res.Cid
-	pf.header.Info.Size = res.Size
+	pf.header.Format = LatestFormat
 	pf.header.Info.Ratchet = pf.ratchet.Encode()
 	pf.header.Info.Mtime = base.Timestamp().Unix()
 
@@ -993,6 +1553,7 @@ func (pf *File) Put() (PutResult, error) {
 		return PutResult{}, err
 	}
 	pf.cid = blk.Cid()
+	store.Tx().Stage(pf.cid)
 
 	// create private name from key
 	privName, err := pf.PrivateName()
@@ -1009,13 +1570,13 @@ func (pf *File) Put() (PutResult, error) {
 		return PutResult{}, err
 	}
 
-	log.Debugw("File.Put", "name", pf.name, "cid", pf.cid.String(), "size", res.Size)
+	log.Debugw("File.Put", "name", pf.name, "cid", pf.cid.String(), "size", pf.header.Info.Size)
 	return PutResult{
 		PutResult: public.PutResult{
 			Cid:      pf.cid,
 			Type:     pf.header.Info.Type,
-			Userland: res.Cid,
-			Size:     res.Size,
+			Userland: pf.header.ContentID,
+			Size:     pf.header.Info.Size,
 		},
 		Key:     key,
 		Pointer: privName,
@@ -1052,59 +1613,254 @@ func LoadNode(ctx context.Context, store Store, name string, id cid.Cid, key Key
 		return nil, fmt.Errorf("decoding s-node %q header: %w", name, err)
 	}
 
-	r, err := ratchet.DecodeSpiral(header.Info.Ratchet)
+	r, snapshot, err := loadedRatchet(&header, key)
 	if err != nil {
 		return nil, err
 	}
-	header.Info.Ratchet = ""
 
 	switch header.Info.Type {
 	case base.NTFile:
 		return &File{
-			store:   store,
-			cid:     id,
-			name:    name,
-			header:  header,
-			ratchet: r,
+			store:    store,
+			cid:      id,
+			name:     name,
+			header:   header,
+			ratchet:  r,
+			snapshot: snapshot,
 		}, nil
 	case base.NTLDFile:
 		return &LDFile{
-			store:   store,
-			cid:     id,
-			name:    name,
-			header:  header,
-			ratchet: r,
-			content: header.Value,
+			store:    store,
+			cid:      id,
+			name:     name,
+			header:   header,
+			ratchet:  r,
+			snapshot: snapshot,
+			content:  header.Value,
 		}, nil
 	case base.NTDir:
 		return &Tree{
-			store:   store,
-			cid:     id,
-			name:    name,
-			header:  header,
-			ratchet: r,
+			store:    store,
+			cid:      id,
+			name:     name,
+			header:   header,
+			ratchet:  r,
+			snapshot: snapshot,
+		}, nil
+	case base.NTSymlink:
+		return &Symlink{
+			store:    store,
+			cid:      id,
+			name:     name,
+			header:   header,
+			ratchet:  r,
+			snapshot: snapshot,
 		}, nil
 	default:
 		return nil, fmt.Errorf("unrecognized private node type %s for cid %s", header.Info.Type, id)
 	}
 }
 
-type PrivateLinks map[string]PrivateLink
+// Symlink is a node that points to another path. The target is stored in the
+// encrypted header
+type Symlink struct {
+	store    Store
+	name     string  // not persisted. used to implement fs.File interface
+	cid      cid.Cid // cid header was loaded from. empty if new
+	header   Header
+	ratchet  *ratchet.Spiral
+	snapshot Key // key of symlinks loaded without a ratchet
+}
+
+var (
+	_ privateNode  = (*Symlink)(nil)
+	_ base.Symlink = (*Symlink)(nil)
+)
 
-func unmarshalPrivateLinksBlock(blk blocks.Block, key Key) (PrivateLinks, error) {
-	aead, err := newCipher(key[:])
+func NewSymlink(store Store, parent BareNamefilter, name, target string) (*Symlink, error) {
+	in := NewINumber()
+	bnf, err := NewBareNamefilter(parent, in)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext := blk.RawData()
-	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+
+	info := NewHeaderInfo(base.NTSymlink, in, bnf)
+	info.Target = target
+	return &Symlink{
+		store:   store,
+		name:    name,
+		ratchet: ratchet.NewSpiral(),
+		header:  Header{Info: info},
+	}, nil
+}
+
+func (s *Symlink) Target() string                 { return s.header.Info.Target }
+func (s *Symlink) Name() string                   { return s.name }
+func (s *Symlink) Size() int64                    { return s.header.Info.Size }
+func (s *Symlink) ModTime() time.Time             { return time.Unix(s.header.Info.Mtime, 0) }
+func (s *Symlink) Mode() fs.FileMode              { return fs.ModeSymlink | fs.FileMode(s.header.Info.Mode) }
+func (s *Symlink) Type() base.NodeType            { return s.header.Info.Type }
+func (s *Symlink) IsDir() bool                    { return false }
+func (s *Symlink) Sys() interface{}               { return s.store }
+func (s *Symlink) Stat() (fs.FileInfo, error)     { return s, nil }
+func (s *Symlink) Cid() cid.Cid                   { return s.cid }
+func (s *Symlink) INumber() INumber               { return s.header.Info.INumber }
+func (s *Symlink) Ratchet() *ratchet.Spiral       { return s.ratchet }
+func (s *Symlink) BareNamefilter() BareNamefilter { return s.header.Info.BareNamefilter }
+func (s *Symlink) PrivateFS() Store               { return s.store }
+func (s *Symlink) Key() Key                       { return nodeKey(s.ratchet, s.snapshot) }
+func (s *Symlink) SnapshotKey() Key               { return snapshotKey(s.ratchet, s.snapshot) }
+func (s *Symlink) Cipher() Cipher                 { return s.header.cipher() }
+func (s *Symlink) Format() FormatVersion          { return s.header.Format }
+func (s *Symlink) Close() error                   { return nil }
+
+func (s *Symlink) Read(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("cannot read symlink %q", s.name)
+}
+
+func (s *Symlink) Metadata() (base.LDFile, error) {
+	return nil, base.ErrNoLink
+}
+
+func (s *Symlink) PrivateName() (Name, error) {
+	return privateName(s.header.Info.BareNamefilter, s.ratchet)
+}
+
+func (s *Symlink) AsHistoryEntry() base.HistoryEntry {
+	n, _ := s.PrivateName()
+	return base.HistoryEntry{
+		Cid:         s.cid,
+		Size:        s.header.Info.Size,
+		Mtime:       s.header.Info.Mtime,
+		Type:        s.header.Info.Type,
+		Key:         s.Key().Encode(),
+		PrivateName: string(n),
+	}
+}
+
+func (s *Symlink) History(ctx context.Context, maxRevs int) ([]base.HistoryEntry, error) {
+	return history(ctx, s, maxRevs)
+}
+
+// Update replaces the symlink with a file, keeping symlink history
+func (s *Symlink) Update(change fs.File) (PutResult, error) {
+	f := &File{
+		store:   s.store,
+		name:    s.name,
+		cid:     s.cid,
+		ratchet: s.ratchet,
+		header: Header{
+			Info: s.header.Info.Copy(),
+		},
+		content: change,
+	}
+	f.header.Info.Type = base.NTFile
+	f.header.Info.Target = ""
+	return f.Put()
+}
+
+func (s *Symlink) Put() (PutResult, error) {
+	ctx := s.store.Context()
+	if s.ratchet == nil {
+		return PutResult{}, ErrSnapshot
+	}
+	if !s.store.Tx().IsStaged(s.cid) {
+		s.ratchet.Inc()
+	}
+	s.header.Cipher = s.store.Cipher()
+	s.header.Format = LatestFormat
+	key := s.ratchet.Key()
+
+	s.header.Info.Ratchet = s.ratchet.Encode()
+	s.header.Info.Size = int64(len(s.header.Info.Target))
+	s.header.Info.Mtime = base.Timestamp().Unix()
+
+	blk, err := s.header.encryptHeaderBlock(key)
+	if err != nil {
+		return PutResult{}, err
+	}
+	if err := s.store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
+		return PutResult{}, err
+	}
+	s.cid = blk.Cid()
+	s.store.Tx().Stage(s.cid)
+
+	privName, err := s.PrivateName()
 	if err != nil {
+		return PutResult{}, err
+	}
+	if _, err = s.store.RatchetStore().PutRatchet(ctx, s.header.Info.INumber.Encode(), s.ratchet); err != nil {
+		return PutResult{}, err
+	}
+	idBytes := CborByteArray(s.cid.Bytes())
+	if err := s.store.HAMT().Root().Set(ctx, string(privName), &idBytes); err != nil {
+		return PutResult{}, err
+	}
+
+	log.Debugw("Symlink.Put", "name", s.name, "privateName", string(privName), "cid", s.cid.String())
+	return PutResult{
+		PutResult: public.PutResult{
+			Cid:  s.cid,
+			Size: s.header.Info.Size,
+			Type: base.NTSymlink,
+		},
+		Key:     key,
+		Pointer: privName,
+	}, nil
+}
+
+type PrivateLinks map[string]PrivateLink
+
+// storedPrivateLink is a link as written to a links block. Links blocks are
+// encrypted with the links key of the snapshot key of their tree & hold the
+// snapshot key of each child. The revision key of each child is sealed with
+// the links key of the revision key of the tree, so snapshot key holders
+// can't read it
+type storedPrivateLink struct {
+	base.Link
+	SnapshotKey Key
+	SealedKey   []byte
+	Pointer     Name
+}
+
+// unmarshalPrivateLinksBlock decrypts a links block written in format v with
+// either the revision key or the snapshot key of its tree. Links opened with
+// a snapshot key carry the snapshot keys of their children
+func unmarshalPrivateLinksBlock(blk blocks.Block, v FormatVersion, c Cipher, key Key) (PrivateLinks, error) {
+	if v == FormatV1 {
+		// v1 links are sealed with the revision key & carry child revision keys
+		plaintext, err := openBytes(c, key, blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		links := PrivateLinks{}
+		err = cbor.Unmarshal(plaintext, &links)
+		return links, err
+	}
+
+	plaintext, _, revision, err := openSnapshot(c, key, blk.RawData(), v.linksKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[string]storedPrivateLink{}
+	if err := cbor.Unmarshal(plaintext, &stored); err != nil {
 		return nil, err
 	}
 
-	links := PrivateLinks{}
-	err = cbor.Unmarshal(plaintext, &links)
-	return links, err
+	links := make(PrivateLinks, len(stored))
+	for name, sl := range stored {
+		l := PrivateLink{Link: sl.Link, Key: sl.SnapshotKey, Pointer: sl.Pointer}
+		if revision {
+			childKey, err := openBytes(c, v.linksKey(key), sl.SealedKey)
+			if err != nil {
+				return nil, fmt.Errorf("decrypting key of link %q: %w", name, err)
+			}
+			copy(l.Key[:], childKey)
+		}
+		links[name] = l
+	}
+	return links, nil
 }
 
 func (pls PrivateLinks) Get(name string) *PrivateLink {
@@ -1146,24 +1902,31 @@ func (pls PrivateLinks) SizeSum() (total int64) {
 	return total
 }
 
-func (pls PrivateLinks) marshalEncryptedBlock(key Key) (blocks.Block, error) {
-	plaintext, err := cbor.Marshal(pls)
+// marshalEncryptedBlock encrypts links in the latest format
+func (pls PrivateLinks) marshalEncryptedBlock(c Cipher, key Key) (blocks.Block, error) {
+	stored := make(map[string]storedPrivateLink, len(pls))
+	for name, l := range pls {
+		sealed, err := sealBytes(c, LatestFormat.linksKey(key), l.Key[:])
+		if err != nil {
+			return nil, err
+		}
+		stored[name] = storedPrivateLink{
+			Link:        l.Link,
+			SnapshotKey: l.Key.SnapshotKey(),
+			SealedKey:   sealed,
+			Pointer:     l.Pointer,
+		}
+	}
+	plaintext, err := cbor.Marshal(stored)
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugw("encrypting private links", "key", key.Encode())
-	aead, err := newCipher(key[:])
+	data, err := sealBytes(c, LatestFormat.linksKey(key.SnapshotKey()), plaintext)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, aead.NonceSize())
-	// TODO(b5): still using random nonces, switching to monotonic long-term
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, err
-	}
-	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
-	data := append(nonce, ciphertext...)
 
 	hash, err := multihash.Sum(data, base.DefaultMultihashType, -1)
 	if err != nil {
@@ -1207,6 +1970,12 @@ type Header struct {
 	Metadata  cid.Cid
 	ContentID cid.Cid
 	Value     interface{} // only present on LDFile nodes
+	// Cipher encrypts the header, links & content of the node. It's stored in
+	// plaintext so readers know how to decrypt the rest of the header
+	Cipher Cipher
+	// Format is the block format the node was read from. Like Cipher it's
+	// stored in plaintext, & picks the keys the rest of the node is sealed with
+	Format FormatVersion
 }
 
 type HeaderInfo struct {
@@ -1220,6 +1989,8 @@ type HeaderInfo struct {
 	INumber        INumber
 	BareNamefilter BareNamefilter
 	Ratchet        string
+
+	Target string `cbor:",omitempty"` // only present on symlinks
 }
 
 func NewHeaderInfo(nt base.NodeType, in INumber, bnf BareNamefilter) HeaderInfo {
@@ -1258,30 +2029,51 @@ func (hi HeaderInfo) Copy() HeaderInfo {
 		INumber:        hi.INumber,
 		BareNamefilter: hi.BareNamefilter,
 		Ratchet:        hi.Ratchet,
+		Target:         hi.Target,
 	}
 }
 
+// cipher is the cipher of the header, headers that don't name one are AES-GCM
+func (h Header) cipher() Cipher {
+	if h.Cipher == "" {
+		return CipherAESGCM
+	}
+	return h.Cipher
+}
+
+// encryptHeaderBlock encrypts header info in the latest format with the
+// header key of the snapshot key of key. The ratchet is split out of the info
+// & encrypted with the header key of key itself, so snapshot key holders can
+// read the revision but can't derive later ones
 func (h Header) encryptHeaderBlock(key Key) (blocks.Block, error) {
-	buf, err := h.Info.CBOR()
+	info := h.Info.Copy()
+	info.Ratchet = ""
+	buf, err := info.CBOR()
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugw("encrypting header info block", "key", key.Encode())
-	aead, err := newCipher(key[:])
+	encInfo, err := sealBytes(h.Cipher, LatestFormat.headerKey(key.SnapshotKey()), buf.Bytes())
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, aead.NonceSize())
-	// TODO(b5): still using random nonces, switching to monotonic long-term
-	if _, err := rand.Read(nonce); err != nil {
+	encRatchet, err := sealBytes(h.Cipher, LatestFormat.headerKey(key), []byte(h.Info.Ratchet))
+	if err != nil {
 		return nil, err
 	}
-
-	encrypted := aead.Seal(nil, nonce, buf.Bytes(), nil)
 	header := map[string]interface{}{
-		"info":    append(nonce, encrypted...),
-		"content": h.ContentID,
+		"format":  int(LatestFormat),
+		"info":    encInfo,
+		"ratchet": encRatchet,
+	}
+	// headers without a cipher field are AES-GCM
+	if h.cipher() != CipherAESGCM {
+		header["cipher"] = string(h.Cipher)
+	}
+	// symlinks have no content
+	if h.ContentID.Defined() {
+		header["content"] = h.ContentID
 	}
 	log.Debugw("content", "cid", h.ContentID)
 	if h.Metadata.Defined() {
@@ -1300,6 +2092,9 @@ func loadHeader(ctx context.Context, s Store, key Key, id cid.Cid) (h Header, er
 	return decodeHeaderBlock(blk, key)
 }
 
+// decodeHeaderBlock decrypts a header block with either the revision key or
+// the snapshot key of the revision. Headers written in any format version are
+// decoded, the format is kept in the returned header
 func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 	env := map[string]interface{}{}
 	if err := cbor.Unmarshal(blk.RawData(), &env); err != nil {
@@ -1311,12 +2106,14 @@ func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 	if !ok {
 		return h, fmt.Errorf("header is missing info field")
 	}
-
-	aead, err := newCipher(key[:])
-	if err != nil {
+	if h.Cipher, err = headerCipher(env); err != nil {
 		return h, err
 	}
-	plaintext, err := aead.Open(nil, encInfo[:aead.NonceSize()], encInfo[aead.NonceSize():], nil)
+	if h.Format, err = headerFormat(env); err != nil {
+		return h, err
+	}
+
+	plaintext, contentKey, revision, err := openInfo(h.Cipher, h.Format, key, encInfo)
 	if err != nil {
 		log.Debugw("decodeHeaderBlock info", "err", err)
 		return h, fmt.Errorf("decrypting info: %w", err)
@@ -1327,6 +2124,21 @@ func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 		return h, err
 	}
 
+	// headers opened with a snapshot key are left without a ratchet. v1 headers
+	// keep the ratchet in their info
+	if revision && h.Format != FormatV1 {
+		encRatchet, ok := env["ratchet"].([]byte)
+		if !ok {
+			return h, fmt.Errorf("header is missing ratchet field")
+		}
+		plaintext, err := openBytes(h.Cipher, h.Format.headerKey(key), encRatchet)
+		if err != nil {
+			log.Debugw("decodeHeaderBlock ratchet", "err", err)
+			return h, fmt.Errorf("decrypting ratchet: %w", err)
+		}
+		h.Info.Ratchet = string(plaintext)
+	}
+
 	if meta, ok := env["metadata"].(cbor.Tag); ok {
 		if h.Metadata, err = cidFromCBORTag(meta); err != nil {
 			log.Debugw("decodeHeaderBlock", "err", err)
@@ -1338,7 +2150,7 @@ func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 	if h.Info.Type == base.NTLDFile {
 		// TODO(b5): this is probably the right place to decode content
 		if encValue, ok := env["value"].([]byte); ok {
-			plaintext, err = aead.Open(nil, encValue[:aead.NonceSize()], encValue[aead.NonceSize():], nil)
+			plaintext, err = openBytes(h.Cipher, contentKey, encValue)
 			if err != nil {
 				log.Debugw("decodeHeaderBlock value", "err", err)
 				return h, err
@@ -1351,7 +2163,7 @@ func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 		} else {
 			return h, fmt.Errorf("LDFile header has no value field")
 		}
-	} else {
+	} else if h.Info.Type != base.NTSymlink {
 		if content, ok := env["content"].(cbor.Tag); ok {
 			if h.ContentID, err = cidFromCBORTag(content); err != nil {
 				log.Debugw("decodeHeaderBlock", "err", err)
@@ -1365,6 +2177,143 @@ func decodeHeaderBlock(blk blocks.Block, key Key) (h Header, err error) {
 	return h, nil
 }
 
+// sealBytes encrypts plaintext with key, prefixing the ciphertext with the
+// nonce it was sealed with
+func sealBytes(c Cipher, key Key, plaintext []byte) ([]byte, error) {
+	aead, err := newCipher(c, key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	// TODO(b5): still using random nonces, switching to monotonic long-term
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openBytes decrypts data encrypted by sealBytes
+func openBytes(c Cipher, key Key, data []byte) ([]byte, error) {
+	aead, err := newCipher(c, key[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// openSnapshot decrypts data sealed with the sub-key of the snapshot key of a
+// revision that subKey picks. key is either the revision key or the snapshot
+// key itself. openSnapshot returns the snapshot key that opened data & reports
+// if key is the revision key
+func openSnapshot(c Cipher, key Key, data []byte, subKey func(Key) Key) (plaintext []byte, snapshot Key, revision bool, err error) {
+	snapshot = key.SnapshotKey()
+	if plaintext, err = openBytes(c, subKey(snapshot), data); err == nil {
+		return plaintext, snapshot, true, nil
+	}
+	if plaintext, err = openBytes(c, subKey(key), data); err != nil {
+		return nil, snapshot, false, err
+	}
+	return plaintext, key, false, nil
+}
+
+// openInfo decrypts the info of a header written in format v with either the
+// revision key or the snapshot key of the revision. openInfo returns the key
+// the content of the revision is sealed with & reports if key is the revision
+// key
+func openInfo(c Cipher, v FormatVersion, key Key, data []byte) (plaintext []byte, contentKey Key, revision bool, err error) {
+	if v == FormatV1 {
+		// v1 nodes seal everything with the revision key
+		plaintext, err = openBytes(c, key, data)
+		return plaintext, key, true, err
+	}
+	plaintext, snapshot, revision, err := openSnapshot(c, key, data, v.headerKey)
+	return plaintext, v.contentKey(snapshot), revision, err
+}
+
+// headerCipher reads the cipher field of a header block envelope
+func headerCipher(env map[string]interface{}) (Cipher, error) {
+	v, ok := env["cipher"]
+	if !ok {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("header cipher field isn't a string")
+	}
+	return ParseCipher(s)
+}
+
+// headerFormat reads the format field of a header block envelope. Headers
+// written before the format was recorded are FormatV1
+func headerFormat(env map[string]interface{}) (FormatVersion, error) {
+	v, ok := env["format"]
+	if !ok {
+		return FormatV1, nil
+	}
+	// decoders differ in the integer type they decode to
+	var n int64
+	switch x := v.(type) {
+	case uint64:
+		n = int64(x)
+	case int64:
+		n = x
+	case int:
+		n = int64(x)
+	default:
+		return 0, fmt.Errorf("header format field isn't an integer")
+	}
+	// v1 doesn't record its version
+	if n < int64(FormatV2) || n > int64(LatestFormat) {
+		return 0, fmt.Errorf("unsupported header format version %d", n)
+	}
+	return FormatVersion(n), nil
+}
+
+// loadedRatchet decodes the ratchet of a loaded header. Headers opened with a
+// snapshot key have no ratchet, nodes loaded from them keep the snapshot key
+// instead
+func loadedRatchet(h *Header, key Key) (r *ratchet.Spiral, snapshot Key, err error) {
+	if h.Info.Ratchet == "" {
+		return nil, key, nil
+	}
+	r, err = ratchet.DecodeSpiral(h.Info.Ratchet)
+	h.Info.Ratchet = ""
+	return r, EmptyKey, err
+}
+
+// nodeKey is the key a node opens with: the key of its ratchet, or the
+// snapshot key of nodes loaded without a ratchet
+func nodeKey(r *ratchet.Spiral, snapshot Key) Key {
+	if r == nil {
+		return snapshot
+	}
+	return r.Key()
+}
+
+// snapshotKey is the snapshot key of the revision of a node
+func snapshotKey(r *ratchet.Spiral, snapshot Key) Key {
+	if r == nil {
+		return snapshot
+	}
+	return Key(r.Key()).SnapshotKey()
+}
+
+// privateName names the revision of bnf at ratchet r. Naming a revision takes
+// its revision key, which nodes loaded with a snapshot key don't have
+func privateName(bnf BareNamefilter, r *ratchet.Spiral) (Name, error) {
+	if r == nil {
+		return "", ErrSnapshot
+	}
+	knf, err := AddKey(bnf, Key(r.Key()))
+	if err != nil {
+		return "", err
+	}
+	return ToName(knf)
+}
+
 func cidFromCBORTag(v interface{}) (cid.Cid, error) {
 	t, ok := v.(cbor.Tag)
 	if !ok {
@@ -1383,6 +2332,7 @@ type LDFile struct {
 	cid   cid.Cid
 
 	ratchet     *ratchet.Spiral
+	snapshot    Key // key of files loaded without a ratchet
 	header      Header
 	content     interface{}
 	jsonContent *bytes.Buffer
@@ -1431,11 +2381,6 @@ func LoadLDFile(ctx context.Context, fs Store, name string, id cid.Cid, key Key)
 }
 
 func decodeLDFileBlock(df *LDFile, blk blocks.Block, key Key) (*LDFile, error) {
-	aead, err := newCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
-
 	env := map[string]interface{}{}
 	if err := cbornode.DecodeInto(blk.RawData(), &env); err != nil {
 		return nil, err
@@ -1445,7 +2390,16 @@ func decodeLDFileBlock(df *LDFile, blk blocks.Block, key Key) (*LDFile, error) {
 	if !ok {
 		return nil, fmt.Errorf("malformed private LDFile node %s: missing info bytes", blk.Cid())
 	}
-	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	c, err := headerCipher(env)
+	if err != nil {
+		return nil, err
+	}
+	v, err := headerFormat(env)
+	if err != nil {
+		return nil, err
+	}
+	df.header.Cipher, df.header.Format = c, v
+	plaintext, contentKey, _, err := openInfo(c, v, key, ciphertext)
 	if err != nil {
 		return nil, err
 	}
@@ -1458,7 +2412,7 @@ func decodeLDFileBlock(df *LDFile, blk blocks.Block, key Key) (*LDFile, error) {
 	if ciphertext, ok = env["value"].([]byte); !ok {
 		return nil, fmt.Errorf("malformed private LDFile node %s: missing content bytes", blk.Cid())
 	}
-	if plaintext, err = aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil); err != nil {
+	if plaintext, err = openBytes(c, contentKey, ciphertext); err != nil {
 		return nil, err
 	}
 	var content interface{}
@@ -1485,12 +2439,11 @@ func (df *LDFile) Data() (interface{}, error)     { return df.content, nil }
 func (df *LDFile) BareNamefilter() BareNamefilter { return df.header.Info.BareNamefilter }
 func (df *LDFile) INumber() INumber               { return df.header.Info.INumber }
 func (df *LDFile) Ratchet() *ratchet.Spiral       { return df.ratchet }
+func (df *LDFile) SnapshotKey() Key               { return snapshotKey(df.ratchet, df.snapshot) }
+func (df *LDFile) Cipher() Cipher                 { return df.header.cipher() }
+func (df *LDFile) Format() FormatVersion          { return df.header.Format }
 func (df *LDFile) PrivateName() (Name, error) {
-	knf, err := AddKey(df.header.Info.BareNamefilter, Key(df.ratchet.Key()))
-	if err != nil {
-		return "", err
-	}
-	return ToName(knf)
+	return privateName(df.header.Info.BareNamefilter, df.ratchet)
 }
 
 func (df *LDFile) Metadata() (base.LDFile, error) {
@@ -1559,7 +2512,14 @@ func (df *LDFile) Update(change fs.File) (result PutResult, err error) {
 }
 
 func (df *LDFile) Put() (result PutResult, err error) {
-	df.ratchet.Inc()
+	if df.ratchet == nil {
+		return result, ErrSnapshot
+	}
+	if !df.store.Tx().IsStaged(df.cid) {
+		df.ratchet.Inc()
+	}
+	df.header.Cipher = df.store.Cipher()
+	df.header.Format = LatestFormat
 	key := df.ratchet.Key()
 	ctx := context.TODO()
 
@@ -1581,6 +2541,7 @@ func (df *LDFile) Put() (result PutResult, err error) {
 	if err = df.store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
 		return result, err
 	}
+	df.store.Tx().Stage(df.cid)
 
 	log.Debugw("wrote public data file", "name", df.name, "cid", df.cid.String())
 	return PutResult{
@@ -1605,36 +2566,43 @@ func (df *LDFile) AsHistoryEntry() base.HistoryEntry {
 }
 
 func (df *LDFile) encodeBlock(key Key) (blocks.Block, error) {
-	aead, err := newCipher(key[:])
+	info := df.header.Info.Copy()
+	info.Ratchet = ""
+	data, err := cbor.Marshal(info)
 	if err != nil {
 		return nil, err
 	}
-
-	data, err := cbor.Marshal(df.header.Info)
+	snapshot := key.SnapshotKey()
+	infoCipher, err := sealBytes(df.header.Cipher, LatestFormat.headerKey(snapshot), data)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, aead.NonceSize())
-	rand.Read(nonce)
-	cipher := aead.Seal(nil, nonce, data, nil)
-	infoCipher := append(nonce, cipher...)
-
 	data, err = cbor.Marshal(df.content)
 	if err != nil {
 		return nil, err
 	}
-	nonce = nonce[:]
-	rand.Read(nonce)
-	cipher = aead.Seal(nil, nonce, data, nil)
-	contentCipher := append(nonce, cipher...)
+	contentCipher, err := sealBytes(df.header.Cipher, LatestFormat.contentKey(snapshot), data)
+	if err != nil {
+		return nil, err
+	}
+
+	ratchetCipher, err := sealBytes(df.header.Cipher, LatestFormat.headerKey(key), []byte(df.header.Info.Ratchet))
+	if err != nil {
+		return nil, err
+	}
 
 	// TODO(b5): link name obfuscation
 	LDFile := map[string]interface{}{
-		"info":  infoCipher,
-		"value": contentCipher,
+		"format":  int(LatestFormat),
+		"info":    infoCipher,
+		"value":   contentCipher,
+		"ratchet": ratchetCipher,
 	}
 
+	if df.header.cipher() != CipherAESGCM {
+		LDFile["cipher"] = string(df.header.Cipher)
+	}
 	if df.header.Metadata.Defined() {
 		LDFile["metadata"] = df.header.Metadata
 	}
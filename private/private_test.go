@@ -10,6 +10,8 @@ import (
 	"time"
 
 	hamt "github.com/filecoin-project/go-hamt-ipld/v3"
+	cbor "github.com/fxamacker/cbor/v2"
+	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
 	ipldcbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -44,21 +46,25 @@ func TestCryptoFile(t *testing.T) {
 	plaintext := strings.Repeat("oh hello. ", 1235340)
 	key := testRootKey[:]
 
-	res, err := store.PutEncryptedFile(base.NewMemfileBytes("", []byte(plaintext)), key)
-	require.Nil(t, err)
+	for _, c := range Ciphers {
+		t.Run(string(c), func(t *testing.T) {
+			res, err := store.PutEncryptedFile(base.NewMemfileBytes("", []byte(plaintext)), c, key)
+			require.Nil(t, err)
 
-	f, err := store.GetEncryptedFile(res.Cid, key)
-	require.Nil(t, err)
+			f, err := store.GetEncryptedFile(res.Cid, c, key)
+			require.Nil(t, err)
 
-	pt2, err := ioutil.ReadAll(f)
-	require.Nil(t, err)
+			pt2, err := ioutil.ReadAll(f)
+			require.Nil(t, err)
 
-	if len(plaintext) != len(pt2) {
-		t.Errorf("decoded length mismatch. want: %d got: %d", len(plaintext), len(pt2))
-	}
+			if len(plaintext) != len(pt2) {
+				t.Errorf("decoded length mismatch. want: %d got: %d", len(plaintext), len(pt2))
+			}
 
-	if plaintext != string(pt2) {
-		t.Errorf("result mismatch:\nwant: %q\ngot:  %q", plaintext, string(pt2))
+			if plaintext != string(pt2) {
+				t.Errorf("result mismatch:\nwant: %q\ngot:  %q", plaintext, string(pt2))
+			}
+		})
 	}
 }
 
@@ -111,6 +117,7 @@ func TestHeaderCoding(t *testing.T) {
 			Ratchet:        ratchet.NewSpiral().Encode(),
 		},
 		ContentID: content,
+		Format:    LatestFormat,
 	}
 	blk, err := h.encryptHeaderBlock(testRootKey)
 	require.Nil(t, err)
@@ -130,13 +137,298 @@ func TestPrivateLinkBlockCoding(t *testing.T) {
 		"foo": PrivateLink{Link: base.Link{Name: "foo", Cid: fooCid, Size: 5, Mtime: 20}, Key: testRootKey, Pointer: Name("apples")},
 	}
 
-	blk, err := links.marshalEncryptedBlock(testRootKey)
+	for _, c := range Ciphers {
+		blk, err := links.marshalEncryptedBlock(c, testRootKey)
+		require.Nil(t, err)
+
+		got, err := unmarshalPrivateLinksBlock(blk, LatestFormat, c, testRootKey)
+		require.Nil(t, err)
+
+		assert.Equal(t, links, got)
+	}
+}
+
+func TestCipherHeaderCoding(t *testing.T) {
+	hash, err := multihash.Sum([]byte("hi"), base.DefaultMultihashType, -1)
 	require.Nil(t, err)
+	content := cid.NewCidV1(cid.DagCBOR, hash)
+
+	for _, c := range []Cipher{CipherChaCha20Poly1305, CipherXChaCha20Poly1305} {
+		h := Header{
+			Info: HeaderInfo{
+				WNFS:           base.LatestVersion,
+				Type:           base.NTFile,
+				Mode:           base.ModeDefault,
+				INumber:        NewINumber(),
+				BareNamefilter: IdentityBareNamefilter(),
+				Ratchet:        ratchet.NewSpiral().Encode(),
+			},
+			ContentID: content,
+			Cipher:    c,
+		}
+		blk, err := h.encryptHeaderBlock(testRootKey)
+		require.Nil(t, err)
+
+		got, err := decodeHeaderBlock(blk, testRootKey)
+		require.Nil(t, err)
+		assert.Equal(t, h, got)
 
-	got, err := unmarshalPrivateLinksBlock(blk, testRootKey)
+		// the cipher is readable without a key
+		env := map[string]interface{}{}
+		require.Nil(t, ipldcbor.DecodeInto(blk.RawData(), &env))
+		assert.Equal(t, string(c), env["cipher"])
+	}
+
+	_, err = ParseCipher("rot13")
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotKeyBlockCoding(t *testing.T) {
+	hash, err := multihash.Sum([]byte("hi"), base.DefaultMultihashType, -1)
 	require.Nil(t, err)
+	content := cid.NewCidV1(cid.DagCBOR, hash)
 
-	assert.Equal(t, links, got)
+	h := Header{
+		Info: HeaderInfo{
+			WNFS:           base.LatestVersion,
+			Type:           base.NTFile,
+			Mode:           base.ModeDefault,
+			INumber:        NewINumber(),
+			BareNamefilter: IdentityBareNamefilter(),
+			Ratchet:        ratchet.NewSpiral().Encode(),
+		},
+		ContentID: content,
+		Format:    LatestFormat,
+	}
+	blk, err := h.encryptHeaderBlock(testRootKey)
+	require.Nil(t, err)
+
+	got, err := decodeHeaderBlock(blk, testRootKey.SnapshotKey())
+	require.Nil(t, err)
+	assert.Equal(t, "", got.Info.Ratchet, "snapshot keys must not decrypt the ratchet")
+	got.Info.Ratchet = h.Info.Ratchet
+	assert.Equal(t, h, got)
+
+	_, err = decodeHeaderBlock(blk, NewKey())
+	assert.NotNil(t, err)
+
+	childKey := NewKey()
+	links := PrivateLinks{
+		"foo": PrivateLink{Link: base.Link{Name: "foo", Cid: content, Size: 5, Mtime: 20}, Key: childKey, Pointer: Name("apples")},
+	}
+	linksBlk, err := links.marshalEncryptedBlock(CipherAESGCM, testRootKey)
+	require.Nil(t, err)
+
+	gotLinks, err := unmarshalPrivateLinksBlock(linksBlk, LatestFormat, CipherAESGCM, testRootKey.SnapshotKey())
+	require.Nil(t, err)
+	assert.Equal(t, childKey.SnapshotKey(), gotLinks["foo"].Key, "snapshot links must only carry child snapshot keys")
+	assert.Equal(t, links["foo"].Link, gotLinks["foo"].Link)
+}
+
+func TestHeaderFormat(t *testing.T) {
+	cases := []struct {
+		env  map[string]interface{}
+		want FormatVersion
+	}{
+		{map[string]interface{}{"info": []byte{}}, FormatV1},
+		{map[string]interface{}{"format": uint64(2), "info": []byte{}, "ratchet": []byte{}}, FormatV2},
+		{map[string]interface{}{"format": uint64(3), "info": []byte{}, "ratchet": []byte{}}, FormatV3},
+	}
+	for _, c := range cases {
+		got, err := headerFormat(c.env)
+		require.Nil(t, err)
+		assert.Equal(t, c.want, got)
+	}
+
+	for _, v := range []uint64{0, 1, uint64(LatestFormat) + 1} {
+		_, err := headerFormat(map[string]interface{}{"format": v})
+		assert.NotNil(t, err, "format %d must not be recorded", v)
+	}
+}
+
+func TestFormatV2BlockDecoding(t *testing.T) {
+	hash, err := multihash.Sum([]byte("hi"), base.DefaultMultihashType, -1)
+	require.Nil(t, err)
+	content := cid.NewCidV1(cid.DagCBOR, hash)
+	snapshot := testRootKey.SnapshotKey()
+
+	h := Header{
+		Info: HeaderInfo{
+			WNFS:           base.LatestVersion,
+			Type:           base.NTFile,
+			Mode:           base.ModeDefault,
+			INumber:        NewINumber(),
+			BareNamefilter: IdentityBareNamefilter(),
+			Ratchet:        ratchet.NewSpiral().Encode(),
+		},
+		ContentID: content,
+		Format:    FormatV2,
+	}
+
+	// v2 headers seal info with the snapshot key itself
+	info := h.Info.Copy()
+	info.Ratchet = ""
+	buf, err := info.CBOR()
+	require.Nil(t, err)
+	encInfo, err := sealBytes(CipherAESGCM, snapshot, buf.Bytes())
+	require.Nil(t, err)
+	encRatchet, err := sealBytes(CipherAESGCM, testRootKey, []byte(h.Info.Ratchet))
+	require.Nil(t, err)
+	blk, err := ipldcbor.WrapObject(map[string]interface{}{
+		"format":  int(FormatV2),
+		"info":    encInfo,
+		"ratchet": encRatchet,
+		"content": content,
+	}, base.DefaultMultihashType, -1)
+	require.Nil(t, err)
+
+	got, err := decodeHeaderBlock(blk, testRootKey)
+	require.Nil(t, err)
+	assert.Equal(t, h, got)
+
+	got, err = decodeHeaderBlock(blk, snapshot)
+	require.Nil(t, err)
+	assert.Equal(t, FormatV2, got.Format)
+	assert.Equal(t, h.Info.INumber, got.Info.INumber)
+
+	// v2 file content is sealed with the snapshot key, v3 with its content key
+	f := &File{snapshot: snapshot, header: Header{Format: FormatV2}}
+	assert.Equal(t, snapshot, f.contentKey())
+	f.header.Format = FormatV3
+	assert.Equal(t, snapshot.ContentKey(), f.contentKey())
+
+	childKey := NewKey()
+	sealedKey, err := sealBytes(CipherAESGCM, testRootKey, childKey[:])
+	require.Nil(t, err)
+	plaintext, err := cbor.Marshal(map[string]storedPrivateLink{
+		"foo": {Link: base.Link{Name: "foo", Cid: content, Size: 5}, SnapshotKey: childKey.SnapshotKey(), SealedKey: sealedKey},
+	})
+	require.Nil(t, err)
+	data, err := sealBytes(CipherAESGCM, snapshot, plaintext)
+	require.Nil(t, err)
+	linksBlk := blocks.NewBlock(data)
+
+	links, err := unmarshalPrivateLinksBlock(linksBlk, FormatV2, CipherAESGCM, testRootKey)
+	require.Nil(t, err)
+	assert.Equal(t, childKey, links["foo"].Key)
+	_, err = unmarshalPrivateLinksBlock(linksBlk, FormatV3, CipherAESGCM, testRootKey)
+	assert.NotNil(t, err)
+
+	blk, err = ipldcbor.WrapObject(map[string]interface{}{
+		"format":  int(LatestFormat) + 1,
+		"info":    encInfo,
+		"ratchet": encRatchet,
+		"content": content,
+	}, base.DefaultMultihashType, -1)
+	require.Nil(t, err)
+	_, err = decodeHeaderBlock(blk, testRootKey)
+	assert.NotNil(t, err, "unknown format versions must not decode")
+}
+
+func TestFormatV1BlockDecoding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestPrivateStore(ctx, t)
+	bstore := store.Blockservice().Blockstore()
+
+	// v1 blocks have no format or ratchet fields. header info, ratchet included,
+	// is sealed with the revision key, as are links & content
+	putHeader := func(info HeaderInfo, r *ratchet.Spiral, content cid.Cid) cid.Cid {
+		t.Helper()
+		info.Ratchet = r.Encode()
+		buf, err := info.CBOR()
+		require.Nil(t, err)
+		encInfo, err := sealBytes(CipherAESGCM, Key(r.Key()), buf.Bytes())
+		require.Nil(t, err)
+		blk, err := ipldcbor.WrapObject(map[string]interface{}{
+			"info":    encInfo,
+			"content": content,
+		}, base.DefaultMultihashType, -1)
+		require.Nil(t, err)
+		require.Nil(t, bstore.Put(ctx, blk))
+		return blk.Cid()
+	}
+
+	fileRatchet := ratchet.NewSpiral()
+	fileRatchet.Inc()
+	fileKey := Key(fileRatchet.Key())
+	res, err := store.PutEncryptedFile(base.NewMemfileBytes("hello.txt", []byte("hello")), CipherAESGCM, fileKey[:])
+	require.Nil(t, err)
+	fileInfo := NewHeaderInfo(base.NTFile, NewINumber(), IdentityBareNamefilter())
+	fileInfo.Size = res.Size
+	fileID := putHeader(fileInfo, fileRatchet, res.Cid)
+
+	links := PrivateLinks{
+		"hello.txt": {Link: base.Link{Name: "hello.txt", Cid: fileID, Size: res.Size, IsFile: true}, Key: fileKey, Pointer: Name("hello")},
+	}
+	plaintext, err := cbor.Marshal(links)
+	require.Nil(t, err)
+	treeRatchet := ratchet.NewSpiral()
+	treeRatchet.Inc()
+	data, err := sealBytes(CipherAESGCM, Key(treeRatchet.Key()), plaintext)
+	require.Nil(t, err)
+	hash, err := multihash.Sum(data, base.DefaultMultihashType, -1)
+	require.Nil(t, err)
+	linksBlk, err := blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, hash))
+	require.Nil(t, err)
+	require.Nil(t, bstore.Put(ctx, linksBlk))
+	treeInfo := NewHeaderInfo(base.NTDir, NewINumber(), IdentityBareNamefilter())
+	treeInfo.Size = links.SizeSum()
+	treeID := putHeader(treeInfo, treeRatchet, linksBlk.Cid())
+
+	tree, err := LoadTree(store, "", Key(treeRatchet.Key()), treeID)
+	require.Nil(t, err)
+	assert.Equal(t, FormatV1, tree.Format())
+	f, err := tree.Get(base.MustPath("hello.txt"))
+	require.Nil(t, err)
+	got, err := ioutil.ReadAll(f)
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	// v1 revisions can't be read with snapshot keys
+	_, err = LoadTree(store, "", Key(treeRatchet.Key()).SnapshotKey(), treeID)
+	assert.NotNil(t, err)
+	_, err = NewSnapshotPointer(f)
+	assert.NotNil(t, err)
+
+	// writing a v1 tree upgrades it, leaving unchanged children as they were
+	_, err = tree.Add(base.MustPath("new.txt"), base.NewMemfileBytes("new.txt", []byte("new")))
+	require.Nil(t, err)
+	assert.Equal(t, LatestFormat, tree.Format())
+	tree, err = LoadTree(store, "", tree.Key(), tree.Cid())
+	require.Nil(t, err)
+	mustFileContents(t, tree, "hello.txt", "hello")
+	mustFileContents(t, tree, "new.txt", "new")
+}
+
+func TestSubKeySeparation(t *testing.T) {
+	snapshot := testRootKey.SnapshotKey()
+	keys := []Key{testRootKey, snapshot, snapshot.HeaderKey(), snapshot.ContentKey(), snapshot.LinksKey(), testRootKey.HeaderKey(), testRootKey.LinksKey()}
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			assert.NotEqual(t, a, b)
+		}
+	}
+
+	h := Header{
+		Info: HeaderInfo{
+			WNFS:           base.LatestVersion,
+			Type:           base.NTSymlink,
+			INumber:        NewINumber(),
+			BareNamefilter: IdentityBareNamefilter(),
+			Ratchet:        ratchet.NewSpiral().Encode(),
+		},
+	}
+	blk, err := h.encryptHeaderBlock(testRootKey)
+	require.Nil(t, err)
+	env := map[string]interface{}{}
+	require.Nil(t, cbor.Unmarshal(blk.RawData(), &env))
+	_, err = openBytes(CipherAESGCM, snapshot, env["info"].([]byte))
+	assert.NotNil(t, err, "v3 header info must not open with the bare snapshot key")
+	_, err = openBytes(CipherAESGCM, snapshot.HeaderKey(), env["info"].([]byte))
+	assert.Nil(t, err)
+	_, err = openBytes(CipherAESGCM, testRootKey, env["ratchet"].([]byte))
+	assert.NotNil(t, err, "v3 ratchets must not open with the bare revision key")
 }
 
 func TestPrivateBlockWriting(t *testing.T) {
@@ -235,6 +527,15 @@ func mustFileContents(t *testing.T, dir base.Tree, path, content string) {
 	assert.Equal(t, content, string(data))
 }
 
+func mustIsDir(t *testing.T, dir base.Tree, path string) {
+	t.Helper()
+	f, err := dir.Get(base.MustPath(path))
+	require.Nil(t, err)
+	fi, err := f.Stat()
+	require.Nil(t, err)
+	assert.True(t, fi.IsDir(), "expected %q to be a directory", path)
+}
+
 func printHamt(label string, h *hamt.Node) {
 	ctx := context.Background()
 	fmt.Printf("HAMT: %s\n", label)
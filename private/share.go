@@ -0,0 +1,241 @@
+package private
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	cid "github.com/ipfs/go-cid"
+	base "github.com/qri-io/wnfs-go/base"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+	box "golang.org/x/crypto/nacl/box"
+)
+
+// ErrNotShared is returned when a share payload can't be decrypted with an
+// exchange key
+var ErrNotShared = errors.New("share isn't addressed to this exchange key")
+
+// ErrSnapshot is returned when naming, listing history of, or writing a node
+// loaded with a snapshot key
+var ErrSnapshot = errors.New("nodes opened with a snapshot key are read-only")
+
+// ExchangeKey is an X25519 key pair. Shares are encrypted to the public half &
+// decrypted with the private half
+type ExchangeKey struct {
+	Public  Key `json:"public"`
+	Private Key `json:"private"`
+}
+
+// NewExchangeKey creates a random exchange key pair
+func NewExchangeKey() (ExchangeKey, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return ExchangeKey{}, err
+	}
+	return ExchangeKey{Public: *pub, Private: *priv}, nil
+}
+
+// SharePointer holds everything needed to read a private node & all of its
+// descendants: the key & name of the shared revision, plus the bare namefilter
+// & ratchet that find later revisions
+type SharePointer struct {
+	Filename       string         `json:"filename"`
+	Key            Key            `json:"key"`
+	Name           Name           `json:"name"`
+	BareNamefilter BareNamefilter `json:"bareNamefilter"`
+	Ratchet        string         `json:"ratchet"`
+}
+
+// NewSharePointer creates a pointer to the stored revision of a private node
+func NewSharePointer(f fs.File) (p SharePointer, err error) {
+	n, ok := f.(privateNode)
+	if !ok {
+		return p, fmt.Errorf("not a private node")
+	}
+	if !n.Cid().Defined() {
+		return p, fmt.Errorf("private node %q hasn't been written", n.Name())
+	}
+	name, err := n.PrivateName()
+	if err != nil {
+		return p, err
+	}
+	return SharePointer{
+		Filename:       n.Name(),
+		Key:            n.Ratchet().Key(),
+		Name:           name,
+		BareNamefilter: n.BareNamefilter(),
+		Ratchet:        n.Ratchet().Encode(),
+	}, nil
+}
+
+// Seal encrypts the pointer to a recipient's public exchange key
+func (p SharePointer) Seal(recipient Key) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	pub := [32]byte(recipient)
+	return box.SealAnonymous(nil, data, &pub, rand.Reader)
+}
+
+// OpenSharePointer decrypts a pointer sealed to the public half of key
+func OpenSharePointer(sealed []byte, key ExchangeKey) (p SharePointer, err error) {
+	pub, priv := [32]byte(key.Public), [32]byte(key.Private)
+	data, ok := box.OpenAnonymous(nil, sealed, &pub, &priv)
+	if !ok {
+		return p, ErrNotShared
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// SnapshotPointer holds everything needed to read a single revision of a
+// private node, along with the revisions of its descendants that revision
+// links to. Snapshot pointers carry no ratchet & can't find or decrypt later
+// revisions
+type SnapshotPointer struct {
+	Filename string  `json:"filename"`
+	Cid      cid.Cid `json:"cid"`
+	Key      Key     `json:"key"`
+}
+
+// NewSnapshotPointer creates a pointer to the stored revision of a private
+// node. Revisions stored in FormatV1 can't be read with a snapshot key, & must
+// be rewritten before they're shared this way
+func NewSnapshotPointer(f fs.File) (p SnapshotPointer, err error) {
+	n, ok := f.(privateNode)
+	if !ok {
+		return p, fmt.Errorf("not a private node")
+	}
+	if !n.Cid().Defined() {
+		return p, fmt.Errorf("private node %q hasn't been written", n.Name())
+	}
+	if n.Format() == FormatV1 {
+		return p, fmt.Errorf("private node %q is stored in format v1, which has no snapshot keys", n.Name())
+	}
+	return SnapshotPointer{
+		Filename: n.Name(),
+		Cid:      n.Cid(),
+		Key:      n.SnapshotKey(),
+	}, nil
+}
+
+// Encode exports the pointer as a string
+func (p SnapshotPointer) Encode() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// DecodeSnapshotPointer imports a pointer exported with Encode
+func DecodeSnapshotPointer(s string) (p SnapshotPointer, err error) {
+	data, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return p, fmt.Errorf("decoding snapshot pointer: %w", err)
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// SharedFS is a read-only filesystem of a shared private node & its
+// descendants. A shared file is the only entry of its filesystem, named "."
+type SharedFS struct {
+	ctx   context.Context
+	store Store
+	name  string
+	id    cid.Cid
+	key   Key
+}
+
+var _ fs.FS = (*SharedFS)(nil)
+
+// LoadSharedFS opens the latest revision in store of the node p points to
+func LoadSharedFS(ctx context.Context, store Store, p SharePointer) (*SharedFS, error) {
+	r, err := ratchet.DecodeSpiral(p.Ratchet)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ratchet: %w", err)
+	}
+	id, err := cidFromPrivateName(ctx, store, p.Name)
+	if err != nil {
+		return nil, fmt.Errorf("finding shared node %q: %w", p.Filename, err)
+	}
+
+	// step the ratchet forward while the next revision exists
+	for {
+		next := r.Copy()
+		next.Inc()
+		knf, err := AddKey(p.BareNamefilter, Key(next.Key()))
+		if err != nil {
+			return nil, err
+		}
+		name, err := ToName(knf)
+		if err != nil {
+			return nil, err
+		}
+		nextID, err := cidFromPrivateName(ctx, store, name)
+		if errors.Is(err, base.ErrNotFound) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r, id = next, nextID
+	}
+
+	log.Debugw("LoadSharedFS", "name", p.Filename, "cid", id)
+	return &SharedFS{
+		ctx:   ctx,
+		store: store,
+		name:  p.Filename,
+		id:    id,
+		key:   r.Key(),
+	}, nil
+}
+
+// LoadSnapshotFS opens the revision p points to. The snapshot key is checked
+// against the revision header before LoadSnapshotFS returns
+func LoadSnapshotFS(ctx context.Context, store Store, p SnapshotPointer) (*SharedFS, error) {
+	if _, err := LoadNode(ctx, store, p.Filename, p.Cid, p.Key); err != nil {
+		return nil, fmt.Errorf("opening snapshot %q: %w", p.Filename, err)
+	}
+	log.Debugw("LoadSnapshotFS", "name", p.Filename, "cid", p.Cid)
+	return &SharedFS{
+		ctx:   ctx,
+		store: store,
+		name:  p.Filename,
+		id:    p.Cid,
+		key:   p.Key,
+	}, nil
+}
+
+// Cid is the header CID of the shared revision
+func (s *SharedFS) Cid() cid.Cid { return s.id }
+
+// Open opens the file at name, relative to the shared node
+func (s *SharedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	n, err := LoadNode(s.ctx, s.store, s.name, s.id, s.key)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return n, nil
+	}
+
+	tree, ok := n.(*Tree)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	path, err := base.NewPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Get(path)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -17,10 +18,12 @@ import (
 	ipldcbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
 	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
 	balanced "github.com/ipfs/go-unixfs/importer/balanced"
 	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
 	mh "github.com/multiformats/go-multihash"
 	base "github.com/qri-io/wnfs-go/base"
+	dagmod "github.com/qri-io/wnfs-go/dagmod"
 	cipherchunker "github.com/qri-io/wnfs-go/private/cipherchunker"
 	cipherfile "github.com/qri-io/wnfs-go/private/cipherfile"
 	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
@@ -30,13 +33,27 @@ import (
 
 type Store interface {
 	Context() context.Context
-	PutEncryptedFile(f fs.File, key []byte) (PutResult, error)
-	GetEncryptedFile(root cid.Cid, key []byte) (io.ReadCloser, error)
+	PutEncryptedFile(f fs.File, c Cipher, key []byte) (PutResult, error)
+	GetEncryptedFile(root cid.Cid, c Cipher, key []byte) (io.ReadCloser, error)
+	// EditEncryptedFile opens the encrypted file DAG at root for in-place
+	// edits. An undefined root creates an empty file
+	EditEncryptedFile(root cid.Cid, c Cipher, key []byte) (*dagmod.Modifier, error)
 
 	HAMT() *HAMT
 	DAGService() ipld.DAGService
 	Blockservice() blockservice.BlockService
 	RatchetStore() ratchet.Store
+
+	// Tx returns the open transaction, nil if no transaction is in progress
+	Tx() *base.Tx
+	// SetTx opens a transaction. Passing nil closes the open transaction
+	SetTx(tx *base.Tx)
+
+	// Cipher is the cipher suite node revisions are written with
+	Cipher() Cipher
+	// SetCipher changes the cipher suite later revisions are written with.
+	// Stored revisions keep the cipher they were written with
+	SetCipher(c Cipher)
 }
 
 // NodeStore extracts a private store from a wnfs.Node
@@ -54,11 +71,13 @@ func NodeStore(n base.Node) (Store, error) {
 
 // warning! cipherStore doesn't pin!
 type cipherStore struct {
-	ctx   context.Context
-	bserv blockservice.BlockService
-	dag   ipld.DAGService
-	hamt  *HAMT
-	rs    ratchet.Store
+	ctx    context.Context
+	bserv  blockservice.BlockService
+	dag    ipld.DAGService
+	hamt   *HAMT
+	rs     ratchet.Store
+	tx     *base.Tx
+	cipher Cipher
 }
 
 var _ Store = (*cipherStore)(nil)
@@ -70,11 +89,12 @@ func NewStore(ctx context.Context, bserv blockservice.BlockService, rs ratchet.S
 	}
 
 	return &cipherStore{
-		ctx:   ctx,
-		bserv: bserv,
-		dag:   merkledag.NewDAGService(bserv),
-		hamt:  h,
-		rs:    rs,
+		ctx:    ctx,
+		bserv:  bserv,
+		dag:    merkledag.NewDAGService(bserv),
+		hamt:   h,
+		rs:     rs,
+		cipher: DefaultCipher,
 	}, nil
 }
 
@@ -91,11 +111,12 @@ func LoadStore(ctx context.Context, bserv blockservice.BlockService, rs ratchet.
 	}
 
 	return &cipherStore{
-		ctx:   ctx,
-		bserv: bserv,
-		dag:   merkledag.NewDAGService(bserv),
-		hamt:  h,
-		rs:    rs,
+		ctx:    ctx,
+		bserv:  bserv,
+		dag:    merkledag.NewDAGService(bserv),
+		hamt:   h,
+		rs:     rs,
+		cipher: DefaultCipher,
 	}, nil
 }
 
@@ -104,9 +125,13 @@ func (cs *cipherStore) DAGService() ipld.DAGService             { return cs.dag
 func (cs *cipherStore) Blockservice() blockservice.BlockService { return cs.bserv }
 func (cs *cipherStore) HAMT() *HAMT                             { return cs.hamt }
 func (cs *cipherStore) RatchetStore() ratchet.Store             { return cs.rs }
+func (cs *cipherStore) Tx() *base.Tx                            { return cs.tx }
+func (cs *cipherStore) SetTx(tx *base.Tx)                       { cs.tx = tx }
+func (cs *cipherStore) Cipher() Cipher                          { return cs.cipher }
+func (cs *cipherStore) SetCipher(c Cipher)                      { cs.cipher = c }
 
-func (cs *cipherStore) GetEncryptedFile(root cid.Cid, key []byte) (io.ReadCloser, error) {
-	auth, err := newAESGCMCipher(key)
+func (cs *cipherStore) GetEncryptedFile(root cid.Cid, c Cipher, key []byte) (io.ReadCloser, error) {
+	auth, err := newCipher(c, key)
 	if err != nil {
 		return nil, err
 	}
@@ -118,14 +143,14 @@ func (cs *cipherStore) GetEncryptedFile(root cid.Cid, key []byte) (io.ReadCloser
 		return nil, fmt.Errorf("getting cid %s: %w", root, err)
 	}
 
-	cf, err := cipherfile.NewCipherFile(cs.ctx, merkledag.NewReadOnlyDagService(ses), nd, auth)
+	cf, err := cipherfile.NewCipherFile(cs.ctx, merkledag.NewReadOnlyDagService(ses), nd, auth, encryptedChunkSize)
 	if err != nil {
 		return nil, err
 	}
 	return cf.(io.ReadCloser), nil
 }
 
-func (cs *cipherStore) PutEncryptedFile(f fs.File, key []byte) (PutResult, error) {
+func (cs *cipherStore) PutEncryptedFile(f fs.File, c Cipher, key []byte) (PutResult, error) {
 	fi, err := f.Stat()
 	if err != nil {
 		return PutResult{}, err
@@ -135,7 +160,7 @@ func (cs *cipherStore) PutEncryptedFile(f fs.File, key []byte) (PutResult, error
 		return PutResult{}, fmt.Errorf("cannot write encrypted directories")
 	}
 
-	auth, err := newAESGCMCipher(key)
+	auth, err := newCipher(c, key)
 	if err != nil {
 		return PutResult{}, err
 	}
@@ -156,27 +181,21 @@ func (cs *cipherStore) PutEncryptedFile(f fs.File, key []byte) (PutResult, error
 }
 
 func (cs *cipherStore) putEncryptedFile(r io.Reader, auth cipher.AEAD) (ipld.Node, error) {
-	prefix, err := merkledag.PrefixForCidVersion(1)
+	builder, err := encryptedFileCidBuilder()
 	if err != nil {
 		return nil, err
 	}
-	prefix.MhType = mh.SHA2_256
 
-	spl, err := cipherchunker.NewCipherSplitter(r, auth, 1024*256)
+	spl, err := cipherchunker.NewCipherSplitter(r, auth, encryptedChunkSize)
 	if err != nil {
 		return nil, err
 	}
 
 	dbp := ihelper.DagBuilderParams{
-		Maxlinks:  1024,
-		RawLeaves: true,
-
-		CidBuilder: cidutil.InlineBuilder{
-			Builder: prefix,
-			Limit:   32,
-		},
-
-		Dagserv: cs.dag,
+		Maxlinks:   encryptedFileMaxLinks,
+		RawLeaves:  true,
+		CidBuilder: builder,
+		Dagserv:    cs.dag,
 	}
 
 	db, err := dbp.New(spl)
@@ -187,8 +206,82 @@ func (cs *cipherStore) putEncryptedFile(r io.Reader, auth cipher.AEAD) (ipld.Nod
 	return balanced.Layout(db)
 }
 
-func newCipher(key []byte) (cipher.AEAD, error) {
-	return newAESGCMCipher(key)
+func (cs *cipherStore) EditEncryptedFile(root cid.Cid, c Cipher, key []byte) (*dagmod.Modifier, error) {
+	auth, err := newCipher(c, key)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := encryptedFileCidBuilder()
+	if err != nil {
+		return nil, err
+	}
+	params := dagmod.Params{
+		// match the plaintext chunk size of the cipher splitter
+		ChunkSize:  encryptedChunkSize - auth.Overhead(),
+		MaxLinks:   encryptedFileMaxLinks,
+		CidBuilder: builder,
+	}
+	codec := cipherLeafCodec{auth: auth, builder: builder}
+
+	if !root.Defined() {
+		return dagmod.New(cs.ctx, cs.dag, codec, params), nil
+	}
+	return dagmod.Open(cs.ctx, cs.dag, root, codec, params)
+}
+
+const (
+	// encryptedChunkSize is the size passed to the cipher splitter
+	encryptedChunkSize = 1024 * 256
+	// encryptedFileMaxLinks is the maximum number of children of a file DAG node
+	encryptedFileMaxLinks = 1024
+)
+
+func encryptedFileCidBuilder() (cid.Builder, error) {
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	prefix.MhType = mh.SHA2_256
+
+	return cidutil.InlineBuilder{
+		Builder: prefix,
+		Limit:   32,
+	}, nil
+}
+
+// cipherLeafCodec reads & writes the encrypted raw leaves putEncryptedFile
+// creates. Each leaf is a random nonce followed by sealed content
+type cipherLeafCodec struct {
+	auth    cipher.AEAD
+	builder cid.Builder
+}
+
+var _ dagmod.Codec = (*cipherLeafCodec)(nil)
+
+func (c cipherLeafCodec) Overhead() int { return c.auth.NonceSize() + c.auth.Overhead() }
+
+func (c cipherLeafCodec) DecodeLeaf(nd ipld.Node) ([]byte, error) {
+	ciphertext, err := unixfs.ReadUnixFSNodeData(nd)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 {
+		// empty files are stored as a single, empty leaf
+		return []byte{}, nil
+	}
+	if len(ciphertext) < c.Overhead() {
+		return nil, fmt.Errorf("leaf %s is too short to be encrypted", nd.Cid())
+	}
+	ns := c.auth.NonceSize()
+	return c.auth.Open(nil, ciphertext[:ns], ciphertext[ns:], nil)
+}
+
+func (c cipherLeafCodec) EncodeLeaf(data []byte) (ipld.Node, error) {
+	nonce := make([]byte, c.auth.NonceSize(), c.Overhead()+len(data))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return merkledag.NewRawNodeWPrefix(c.auth.Seal(nonce, nonce, data, nil), c.builder)
 }
 
 func newAESGCMCipher(key []byte) (cipher.AEAD, error) {
@@ -276,6 +369,21 @@ func (h *HAMT) Merge(ctx context.Context, b *hamt.Node) error {
 	})
 }
 
+// Cids lists the header CIDs stored in the HAMT, one for each revision of
+// each private node
+func (h *HAMT) Cids(ctx context.Context) ([]cid.Cid, error) {
+	var ids []cid.Cid
+	err := h.root.ForEach(ctx, func(k string, val *cbg.Deferred) error {
+		_, id, err := cid.CidFromBytes(val.Raw[2:])
+		if err != nil {
+			return fmt.Errorf("decoding HAMT value %q: %w", k, err)
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
 func (h *HAMT) Diagnostic(ctx context.Context) map[string]string {
 	vs := map[string]string{}
 	h.root.ForEach(ctx, func(k string, val *cbg.Deferred) error {
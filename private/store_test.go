@@ -25,13 +25,13 @@ func TestCopyBlocks(t *testing.T) {
 	storeB := newMemTestPrivateStore(ctx, t)
 	fileContents := []byte(bytes.Repeat([]byte("test"), 200000)) // large to make dag > 1 block
 
-	res, err := storeA.PutEncryptedFile(base.NewMemfileBytes("", fileContents), testRootKey[:])
+	res, err := storeA.PutEncryptedFile(base.NewMemfileBytes("", fileContents), DefaultCipher, testRootKey[:])
 	require.Nil(t, err)
 
 	err = CopyBlocks(ctx, res.Cid, storeA, storeB)
 	require.Nil(t, err)
 
-	data, err := storeB.GetEncryptedFile(res.Cid, testRootKey[:])
+	data, err := storeB.GetEncryptedFile(res.Cid, DefaultCipher, testRootKey[:])
 	require.Nil(t, err)
 
 	got, err := ioutil.ReadAll(data)
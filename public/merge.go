@@ -2,26 +2,50 @@ package public
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
 	"time"
 
+	blockservice "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
 	base "github.com/qri-io/wnfs-go/base"
 )
 
-func Merge(ctx context.Context, a, b base.Node) (result base.MergeResult, err error) {
+// Merge merges b into a, writing to a's store. Entries both sides changed since
+// their common ancestor are resolved with the configured ConflictResolver
+func Merge(ctx context.Context, a, b base.Node, opts ...base.MergeOption) (result base.MergeResult, err error) {
 	dest, err := NodeStore(a)
 	if err != nil {
 		return result, err
 	}
-	return merge(ctx, dest, a, b)
+	st := &mergeState{opts: base.NewMergeOptions(opts...)}
+	if result, err = merge(ctx, dest, st, "", a, b); err != nil {
+		return result, err
+	}
+	result.Conflicts = st.conflicts
+	result.Decisions = st.decisions
+	return result, nil
 }
 
-func merge(ctx context.Context, destStore Store, a, b base.Node) (result base.MergeResult, err error) {
-	var (
-		aCur, bCur   = a, b
-		aHist, bHist = a.AsHistoryEntry(), b.AsHistoryEntry()
-		aGen, bGen   = 0, 0
-	)
+// mergeState carries options & records decisions across a recursive merge
+type mergeState struct {
+	opts      base.MergeOptions
+	conflicts []base.ConflictResolution
+	decisions []base.MergeDecision
+}
+
+// resolved records the resolution of a conflict
+func (st *mergeState) resolved(cr base.ConflictResolution) {
+	st.conflicts = append(st.conflicts, cr)
+	st.decisions = append(st.decisions, cr.Decision())
+}
+
+func merge(ctx context.Context, destStore Store, st *mergeState, path string, a, b base.Node) (result base.MergeResult, err error) {
+	aHist, bHist := a.AsHistoryEntry(), b.AsHistoryEntry()
 
 	aStat, _ := a.Stat()
 	bStat, _ := b.Stat()
@@ -35,96 +59,74 @@ func merge(ctx context.Context, destStore Store, a, b base.Node) (result base.Me
 			// Userland: aHist.Userland,
 			// Metadata: bHist.Metadata,
 			Size:   aHist.Size,
-			IsFile: aHist.Type == base.NTFile,
+			IsFile: isFileType(aHist.Type),
 		}, nil
 	}
 
-	afs, err := NodeStore(a)
-	if err != nil {
-		return result, err
-	}
-	bfs, err := NodeStore(b)
+	common, aGen, bGen, err := commonHistory(ctx, a, b)
 	if err != nil {
 		return result, err
 	}
 
-	for {
-		bCur = b
-		bGen = 0
-		bHist = b.AsHistoryEntry()
-		for {
-			if aHist.Cid.Equals(bHist.Cid) {
-				if aGen == 0 && bGen > 0 {
-					// fast-forward
-					bHist = b.AsHistoryEntry()
-					return base.MergeResult{
-						Type: base.MTFastForward,
-						// TODO(b5):
-						// 	Userland: si.Cid,
-						// 	Metadata: si.Metadata,
-						Cid:    bHist.Cid,
-						Size:   bHist.Size,
-						IsFile: bHist.Type == base.NTFile,
-					}, nil
-				} else if aGen > 0 && bGen == 0 {
-					result.Type = base.MTLocalAhead
-					aHist := a.AsHistoryEntry()
-					return base.MergeResult{
-						Type:   base.MTLocalAhead,
-						Cid:    aHist.Cid,
-						Size:   aHist.Size,
-						IsFile: bHist.Type == base.NTFile,
-					}, nil
-				} else {
-					// both local & remote are greater than zero, have diverged
-					merged, err := mergeNodes(ctx, destStore, a, b, aGen, bGen)
-					if err != nil {
-						return result, err
-					}
-					mergedStat, err := base.Stat(a)
-					if err != nil {
-						return result, err
-					}
-					return base.MergeResult{
-						Type:   base.MTMergeCommit,
-						Cid:    merged.Cid(),
-						IsFile: !mergedStat.IsDir(),
-					}, nil
-				}
-			}
-
-			if bHist.Previous == nil {
-				break
-			}
-			name, err := base.Filename(bCur)
-			if err != nil {
-				return result, err
-			}
-			bCur, err = loadNode(ctx, bfs, name, *bHist.Previous)
-			if err != nil {
-				return result, err
-			}
-			bHist = bCur.AsHistoryEntry()
-			bGen++
-		}
-
-		if aHist.Previous == nil {
-			break
-		}
-		name, err := base.Filename(aCur)
+	if common == nil {
+		// no common history, merge based on height & alpha-sorted-cid
+		merged, err := mergeNodes(ctx, destStore, st, path, a, b, nil, aGen, bGen)
 		if err != nil {
 			return result, err
 		}
-		aCur, err = loadNode(ctx, afs, name, *aHist.Previous)
+		mergedStat, err := base.Stat(a)
 		if err != nil {
 			return result, err
 		}
-		aHist = aCur.AsHistoryEntry()
-		aGen++
+
+		return base.MergeResult{
+			Type:   base.MTMergeCommit,
+			Cid:    merged.Cid(),
+			IsFile: !mergedStat.IsDir(),
+		}, nil
+	}
+
+	if aGen == 0 {
+		// a is in b's history, fast-forward
+		st.decisions = append(st.decisions, base.MergeDecision{
+			Path:   path,
+			Action: base.MAFastForward,
+			Local:  &aHist.Cid,
+			Remote: &bHist.Cid,
+		})
+		return base.MergeResult{
+			Type: base.MTFastForward,
+			// TODO(b5):
+			// 	Userland: si.Cid,
+			// 	Metadata: si.Metadata,
+			Cid:    bHist.Cid,
+			Size:   bHist.Size,
+			IsFile: isFileType(bHist.Type),
+		}, nil
+	} else if bGen == 0 {
+		// b is in a's history, no-op for local merge
+		return base.MergeResult{
+			Type:   base.MTLocalAhead,
+			Cid:    aHist.Cid,
+			Size:   aHist.Size,
+			IsFile: isFileType(aHist.Type),
+		}, nil
 	}
 
-	// no common history, merge based on heigh & alpha-sorted-cid
-	merged, err := mergeNodes(ctx, destStore, a, b, aGen, bGen)
+	// both local & remote are ahead of the common ancestor, have diverged
+	bfs, err := NodeStore(b)
+	if err != nil {
+		return result, err
+	}
+	name, err := base.Filename(b)
+	if err != nil {
+		return result, err
+	}
+	ancestor, err := loadNode(ctx, bfs, name, *common)
+	if err != nil {
+		return result, err
+	}
+	merged, err := mergeNodes(ctx, destStore, st, path, a, b, ancestor, aGen, bGen)
 	if err != nil {
 		return result, err
 	}
@@ -132,7 +134,6 @@ func merge(ctx context.Context, destStore Store, a, b base.Node) (result base.Me
 	if err != nil {
 		return result, err
 	}
-
 	return base.MergeResult{
 		Type:   base.MTMergeCommit,
 		Cid:    merged.Cid(),
@@ -140,105 +141,709 @@ func merge(ctx context.Context, destStore Store, a, b base.Node) (result base.Me
 	}, nil
 }
 
+// commonHistory finds the nearest common ancestor of a & b, and the distance
+// from each node to it. common is nil when a & b share no history, in which
+// case aGen & bGen are the lengths of each node's history
+func commonHistory(ctx context.Context, a, b base.Node) (common *cid.Cid, aGen, bGen int, err error) {
+	afs, err := NodeStore(a)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	bfs, err := NodeStore(b)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	aAncestry := map[cid.Cid]int{}
+	err = walkHistory(ctx, afs.Blockservice(), a.Cid(), func(id cid.Cid, gen int) bool {
+		aAncestry[id] = gen
+		if gen > aGen {
+			aGen = gen
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// walk b's history breadth-first, the first version a shares is the nearest
+	// common ancestor
+	err = walkHistory(ctx, bfs.Blockservice(), b.Cid(), func(id cid.Cid, gen int) bool {
+		bGen = gen
+		if g, ok := aAncestry[id]; ok {
+			common = &id
+			aGen = g
+			return false
+		}
+		return true
+	})
+	return common, aGen, bGen, err
+}
+
+// preferB is the deterministic tie-break between conflicting versions a & b
+// that have changed aGen & bGen times since their common ancestor. The version
+// with more changes wins, then the lesser CID
+func preferB(a, b base.Node, aGen, bGen int) bool {
+	return aGen < bGen || (aGen == bGen && base.LessCID(b.Cid(), a.Cid()))
+}
+
+// walkHistory visits id & every prior version of id breadth-first, following
+// both previous & merge links. gen is the shortest distance from id to the
+// visited version. The walk ends when visit returns false. Merge links to
+// versions that were never copied into bserv end the walk along that branch
+func walkHistory(ctx context.Context, bserv blockservice.BlockService, id cid.Cid, visit func(id cid.Cid, gen int) bool) error {
+	type entry struct {
+		id  cid.Cid
+		gen int
+	}
+	seen := map[cid.Cid]struct{}{id: {}}
+	queue := []entry{{id, 0}}
+
+	for len(queue) > 0 {
+		ent := queue[0]
+		queue = queue[1:]
+		if !visit(ent.id, ent.gen) {
+			return nil
+		}
+
+		h, err := loadHeader(ctx, bserv, ent.id)
+		if err != nil {
+			return err
+		}
+		if h.Previous != nil {
+			if _, ok := seen[*h.Previous]; !ok {
+				seen[*h.Previous] = struct{}{}
+				queue = append(queue, entry{*h.Previous, ent.gen + 1})
+			}
+		}
+		if h.Merge != nil {
+			if _, ok := seen[*h.Merge]; !ok {
+				has, err := bserv.Blockstore().Has(ctx, *h.Merge)
+				if err != nil {
+					return err
+				}
+				if has {
+					seen[*h.Merge] = struct{}{}
+					queue = append(queue, entry{*h.Merge, ent.gen + 1})
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // 1. commits have diverged.
-// 2. pick winner:
+// 2. if both are directories, three-way merge entries against the common
+// ancestor. local is the previous version of the merged directory, remote is
+// the merge link
+// 3. in all other cases the change is a conflict. pick winner:
 // 	* if "A" is winner "merge" value will be "B" head
 // 	* if "B" is winner "merge value will be "A" head
 // 	* in both cases the result itself to be a new CID
-// 3. perform merge:
-// 	* if both are directories, merge recursively
-// 	* in all other cases, replace prior contents with winning CID
-// always writes to a's filesystem
-func mergeNodes(ctx context.Context, destStore Store, a, b base.Node, aGen, bGen int) (merged base.Node, err error) {
+// always writes to destStore. ancestor is nil when a & b share no history
+func mergeNodes(ctx context.Context, destStore Store, st *mergeState, path string, a, b, ancestor base.Node, aGen, bGen int) (merged base.Node, err error) {
 	log.Debugw("merge nodes", "aName", a.Name(), "bName", b.Name(), "destStore", fmt.Sprintf("%#v", destStore))
-	// if b is preferred over a, switch values
-	if aGen < bGen || (aGen == bGen && base.LessCID(b.Cid(), a.Cid())) {
-		a, b = b, a
-	}
 
 	aTree, aIsTree := a.(*Tree)
 	bTree, bIsTree := b.(*Tree)
 	if aIsTree && bIsTree {
-		return mergeTrees(ctx, destStore, aTree, bTree)
+		oTree, _ := ancestor.(*Tree)
+		return mergeTrees(ctx, destStore, st, path, aTree, bTree, oTree)
+	}
+
+	swap := preferB(a, b, aGen, bGen)
+	res := base.RLocal
+	if swap {
+		res = base.RRemote
+	}
+	if st.opts.Resolver != nil {
+		res, err = st.opts.Resolver.Resolve(base.Conflict{
+			Path:   path,
+			Local:  nodeConflictVersion(a),
+			Remote: nodeConflictVersion(b),
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch res {
+		case base.RLocal:
+			swap = false
+		case base.RRemote:
+			swap = true
+		default:
+			return nil, fmt.Errorf("cannot resolve conflicting merge roots with %q", res)
+		}
 	}
 
+	aid, bid := a.Cid(), b.Cid()
+	st.resolved(base.ConflictResolution{
+		Path:       path,
+		Resolution: res,
+		Local:      &aid,
+		Remote:     &bid,
+	})
+
+	// if b is preferred over a, switch values
+	if swap {
+		a, b = b, a
+	}
 	return mergeNode(ctx, destStore, a, b)
 }
 
-func mergeTrees(ctx context.Context, destStore Store, a, b *Tree) (*Tree, error) {
+// mergeTrees merges the entries of remote tree b into local tree a, classifying
+// each entry against the common ancestor o. Entries only one side added,
+// modified or deleted take that side's version. Directories both sides changed
+// merge recursively. All other conflicts are resolved by mergeConflict
+func mergeTrees(ctx context.Context, destStore Store, st *mergeState, dir string, a, b, o *Tree) (*Tree, error) {
 	log.Debugw("mergeTrees", "a_skeleton", a.skeleton)
-	checked := map[string]struct{}{}
+	aid, bid := a.cid, b.cid
+	st.decisions = append(st.decisions, base.MergeDecision{
+		Path:   dir,
+		Action: base.MAMerge,
+		Local:  &aid,
+		Remote: &bid,
+	})
+
+	names := make([]string, 0, len(a.skeleton)+len(b.skeleton))
+	for name := range a.skeleton {
+		names = append(names, name)
+	}
+	for name := range b.skeleton {
+		if _, ok := a.skeleton[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
 
-	for remName, remInfo := range b.skeleton {
-		localInfo, existsLocally := a.skeleton[remName]
-		log.Debugw("merging trees", "name", remName, "existsLocally", existsLocally)
+	for _, name := range names {
+		aInfo, inA := a.skeleton[name]
+		bInfo, inB := b.skeleton[name]
+		oInfo, inO := o.entry(name)
 
-		if !existsLocally {
-			// remote has a file local is missing, add it.
-			n, err := loadNodeFromSkeletonInfo(ctx, b.store, remName, remInfo)
-			if err != nil {
-				return nil, err
+		aid, bid := entryCid(aInfo, inA), entryCid(bInfo, inB)
+		ch := base.ClassifyEntry(entryCid(oInfo, inO), aid, bid)
+		log.Debugw("merging trees", "name", name, "change", ch.Type, "remote", ch.Remote)
+		if d, ok := base.ChangeDecision(path.Join(dir, name), ch, aid, bid); ok {
+			st.decisions = append(st.decisions, d)
+		}
+
+		var err error
+		switch ch.Type {
+		case base.CTNone:
+			err = keepEntry(destStore, a, name)
+		case base.CTAdd, base.CTModify:
+			if ch.Remote {
+				err = adoptEntry(ctx, destStore, a, b.store, name, bInfo)
+			} else {
+				err = keepEntry(destStore, a, name)
+			}
+		case base.CTDelete:
+			if ch.Remote {
+				a.removeUserlandLink(name)
+			}
+		case base.CTConflict:
+			err = mergeConflict(ctx, destStore, st, path.Join(dir, name), a, b, o, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.h.Merge = &b.cid
+	a.h.Info.Mtime = base.Timestamp().Unix()
+	a.store = destStore
+	if st.opts.Structured {
+		if err := mergeTreeMetadata(st, dir, a, b, o); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := a.Put(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// mergeConflict merges an entry both a & b changed since the common ancestor
+// o. Directories merge recursively. With structured merges enabled, LDFiles &
+// the metadata of files with the same content merge field by field. With text
+// merges enabled, text files merge line by line. Other conflicts go to the
+// configured resolver. Without a resolver, edits win over deletes, text
+// conflicts get conflict markers & conflicting edits tie-break on history
+func mergeConflict(ctx context.Context, destStore Store, st *mergeState, p string, a, b, o *Tree, name string) error {
+	aInfo, inA := a.skeleton[name]
+	bInfo, inB := b.skeleton[name]
+
+	var (
+		lcl, rem base.Node
+		cm       *contentMerge
+	)
+	if inA && inB {
+		var err error
+		if lcl, err = loadNodeFromSkeletonInfo(ctx, a.store, name, aInfo); err != nil {
+			return err
+		}
+		if rem, err = loadNodeFromSkeletonInfo(ctx, b.store, name, bInfo); err != nil {
+			return err
+		}
+
+		lclTree, lclIsTree := lcl.(*Tree)
+		remTree, remIsTree := rem.(*Tree)
+		if lclIsTree && remIsTree {
+			var oTree *Tree
+			if oInfo, ok := o.entry(name); ok {
+				n, err := loadNodeFromSkeletonInfo(ctx, o.store, name, oInfo)
+				if err != nil {
+					return err
+				}
+				oTree, _ = n.(*Tree)
 			}
-			log.Debugw("mergeTrees add file", "dir", a.Name(), "file", remName, "cid", n.Cid())
 
-			if err := base.CopyBlocks(destStore.Context(), n.Cid(), b.store.Blockservice(), destStore.Blockservice()); err != nil {
-				return nil, err
+			merged, err := mergeTrees(ctx, destStore, st, p, lclTree, remTree, oTree)
+			if err != nil {
+				return err
 			}
+			setEntry(a, name, merged, merged.skeletonInfo())
+			return nil
+		}
 
-			a.skeleton[remName] = remInfo
-			a.userland.Add(base.Link{
-				Name:   n.Name(),
-				Size:   n.Size(),
-				Cid:    n.Cid(),
-				Mtime:  n.ModTime().Unix(),
-				IsFile: (n.Type() == base.NTFile || n.Type() == base.NTLDFile),
+		if cm, err = newContentMerge(ctx, st.opts, o, name, lcl, rem); err != nil {
+			return err
+		}
+		if cm != nil && !cm.conflicted() {
+			if err := cm.write(destStore, a, name, base.RLocal); err != nil {
+				return err
+			}
+			st.decisions = append(st.decisions, base.MergeDecision{
+				Path:   p,
+				Action: base.MAMerge,
+				Local:  entryCid(aInfo, inA),
+				Remote: entryCid(bInfo, inB),
 			})
-			checked[remName] = struct{}{}
-			continue
+			return nil
 		}
+	}
 
-		if localInfo.Cid.Equals(remInfo.Cid) {
-			// both files are equal. no need to merge
-			checked[remName] = struct{}{}
-			continue
+	var (
+		res    base.Resolution
+		err    error
+		fields []string
+		hunks  int
+	)
+	if cm != nil {
+		fields, hunks = cm.fields, cm.hunks
+	}
+	switch {
+	case st.opts.Resolver != nil:
+		res, err = st.opts.Resolver.Resolve(base.Conflict{
+			Path:   p,
+			Local:  linkConflictVersion(a, name),
+			Remote: linkConflictVersion(b, name),
+			Fields: fields,
+			Hunks:  hunks,
+		})
+		if err != nil {
+			return err
+		}
+	case !inA:
+		// remote edit undeletes a local delete
+		res = base.RRemote
+	case !inB:
+		// local edit undeletes a remote delete
+		res = base.RLocal
+	case hunks > 0:
+		res = base.RMarkers
+	default:
+		_, aGen, bGen, err := commonHistory(ctx, lcl, rem)
+		if err != nil {
+			return err
 		}
+		res = base.RLocal
+		if preferB(lcl, rem, aGen, bGen) {
+			res = base.RRemote
+		}
+	}
+	log.Debugw("resolved conflict", "path", p, "resolution", res)
+
+	rec := base.ConflictResolution{
+		Path:       p,
+		Resolution: res,
+		Local:      entryCid(aInfo, inA),
+		Remote:     entryCid(bInfo, inB),
+		Fields:     fields,
+	}
 
-		// node exists in both trees & CIDs are inequal. merge recursively
-		lcl, err := loadNodeFromSkeletonInfo(ctx, a.store, remName, localInfo)
+	switch {
+	case cm != nil && (res == base.RLocal || res == base.RRemote || (res == base.RMarkers && hunks > 0)):
+		// conflicting fields & lines take the chosen resolution, all other
+		// changes merge
+		err = cm.write(destStore, a, name, res)
+	case res == base.RLocal:
+		if inA {
+			err = keepEntry(destStore, a, name)
+		}
+	case res == base.RRemote:
+		if inB {
+			err = adoptEntry(ctx, destStore, a, b.store, name, bInfo)
+		} else {
+			a.removeUserlandLink(name)
+		}
+	case res == base.RKeepBoth:
+		switch {
+		case inA && inB:
+			if err = keepEntry(destStore, a, name); err != nil {
+				return err
+			}
+			rec.Copy = base.ConflictCopyName(name, bInfo.Cid)
+			err = adoptEntry(ctx, destStore, a, b.store, rec.Copy, bInfo)
+		case inB:
+			err = adoptEntry(ctx, destStore, a, b.store, name, bInfo)
+		default:
+			err = keepEntry(destStore, a, name)
+		}
+	default:
+		return fmt.Errorf("%s: unknown conflict resolution %q", p, res)
+	}
+	if err != nil {
+		return err
+	}
+
+	st.resolved(rec)
+	return nil
+}
+
+// contentMerge merges the content of an entry both sides of a merge changed:
+// the data of an LDFile or the metadata of a file field by field, or a text
+// file line by line
+type contentMerge struct {
+	// conflicting JSON pointers of a structured merge
+	fields []string
+	// conflicting line ranges of a text merge
+	hunks int
+	merge func(res base.Resolution) interface{}
+	put   func(destStore Store, merged interface{}) (base.PutResult, error)
+}
+
+// newContentMerge prepares a merge of the content of entry name, returning nil
+// if opts don't enable merging the content of local & remote versions lcl &
+// rem
+func newContentMerge(ctx context.Context, opts base.MergeOptions, o *Tree, name string, lcl, rem base.Node) (*contentMerge, error) {
+	var anc base.Node
+	if oInfo, ok := o.entry(name); ok {
+		n, err := loadNodeFromSkeletonInfo(ctx, o.store, name, oInfo)
 		if err != nil {
 			return nil, err
 		}
-		rem, err := loadNodeFromSkeletonInfo(ctx, b.store, remName, remInfo)
-		if err != nil {
+		anc = n
+	}
+
+	switch l := lcl.(type) {
+	case *LDFile:
+		r, ok := rem.(*LDFile)
+		if !ok || !opts.Structured {
+			return nil, nil
+		}
+		var od interface{}
+		if anc, ok := anc.(*LDFile); ok {
+			od = anc.content
+		}
+		return newDataMerge(od, l.content, r.content, func(destStore Store, data interface{}) (base.PutResult, error) {
+			l.store = destStore
+			l.SetFile(data)
+			return l.Put()
+		}), nil
+	case *File:
+		r, ok := rem.(*File)
+		if !ok {
+			return nil, nil
+		}
+		rid := r.cid
+		sameContent := l.h.Userland != nil && r.h.Userland != nil && l.h.Userland.Equals(*r.h.Userland)
+		switch {
+		case sameContent && opts.Structured:
+			return newMetadataMerge(l, r, anc, func(destStore Store, data interface{}) (base.PutResult, error) {
+				l.store = destStore
+				l.metadata, l.h.Metadata = nil, nil
+				if data != nil {
+					l.SetMetadata(data)
+				}
+				l.h.Merge = &rid
+				return l.putHeader()
+			})
+		case !sameContent && opts.Text:
+			return newTextMerge(l, r, anc, func(destStore Store, text interface{}) (base.PutResult, error) {
+				data := []byte(text.(string))
+				l.store = destStore
+				l.SetFile(base.NewMemfileBytes(name, data))
+				l.h.Info.Size = int64(len(data))
+				l.h.Merge = &rid
+				return l.Put()
+			})
+		}
+	}
+	return nil, nil
+}
+
+func newDataMerge(o, a, b interface{}, put func(Store, interface{}) (base.PutResult, error)) *contentMerge {
+	_, fields := base.MergeData(o, a, b, false)
+	return &contentMerge{
+		fields: fields,
+		merge: func(res base.Resolution) interface{} {
+			merged, _ := base.MergeData(o, a, b, res == base.RRemote)
+			return merged
+		},
+		put: put,
+	}
+}
+
+func newMetadataMerge(l, r *File, anc base.Node, put func(Store, interface{}) (base.PutResult, error)) (*contentMerge, error) {
+	a, err := fileMetadata(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fileMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	var o interface{}
+	if anc, ok := anc.(*File); ok {
+		if o, err = fileMetadata(anc); err != nil {
 			return nil, err
 		}
+	}
+	return newDataMerge(o, a, b, put), nil
+}
 
-		res, err := merge(ctx, destStore, lcl, rem)
-		if err != nil {
+// newTextMerge prepares a line merge of files l & r, returning nil if either
+// file, or their common ancestor, isn't text
+func newTextMerge(l, r *File, anc base.Node, put func(Store, interface{}) (base.PutResult, error)) (*contentMerge, error) {
+	var o string
+	if anc, ok := anc.(*File); ok {
+		text, isText, err := fileText(anc)
+		if err != nil || !isText {
 			return nil, err
 		}
-		a.skeleton[remName] = mergeResultToSkeletonInfo(res)
-		a.userland.Add(res.ToLink(remName))
-		checked[remName] = struct{}{}
+		o = text
+	}
+	a, isText, err := fileText(l)
+	if err != nil || !isText {
+		return nil, err
+	}
+	b, isText, err := fileText(r)
+	if err != nil || !isText {
+		return nil, err
+	}
+
+	_, hunks := base.MergeText(o, a, b, base.RMarkers)
+	return &contentMerge{
+		hunks: hunks,
+		merge: func(res base.Resolution) interface{} {
+			merged, _ := base.MergeText(o, a, b, res)
+			return merged
+		},
+		put: put,
+	}, nil
+}
+
+// conflicted reports whether both sides changed the same fields or lines
+func (cm *contentMerge) conflicted() bool {
+	return len(cm.fields) > 0 || cm.hunks > 0
+}
+
+// write stores the merged content as the entry name in a, resolving
+// conflicting fields or lines with res
+func (cm *contentMerge) write(destStore Store, a *Tree, name string, res base.Resolution) error {
+	// metadata merges reuse the local file's content
+	if err := keepEntry(destStore, a, name); err != nil {
+		return err
+	}
+	put, err := cm.put(destStore, cm.merge(res))
+	if err != nil {
+		return err
+	}
+	a.updateUserlandLink(name, put)
+	return nil
+}
+
+// mergeTreeMetadata three-way merges the metadata of trees a & b field by
+// field, resolving conflicting fields with the configured resolver. The merged
+// metadata is set on a
+func mergeTreeMetadata(st *mergeState, dir string, a, b, o *Tree) error {
+	aMd, err := treeMetadata(a)
+	if err != nil {
+		return err
+	}
+	bMd, err := treeMetadata(b)
+	if err != nil {
+		return err
+	}
+	var oMd interface{}
+	if o != nil {
+		if oMd, err = treeMetadata(o); err != nil {
+			return err
+		}
 	}
 
-	// iterate all of a's files making sure they're present on destStore
-	for aName, aInfo := range a.skeleton {
-		if _, ok := checked[aName]; !ok {
-			log.Debugw("copying blocks for a file", "name", aName, "cid", aInfo.Cid)
-			if err := base.CopyBlocks(destStore.Context(), aInfo.Cid, a.store.Blockservice(), destStore.Blockservice()); err != nil {
-				return nil, err
+	merged, fields := base.MergeData(oMd, aMd, bMd, false)
+	if len(fields) > 0 {
+		res := base.RLocal
+		if st.opts.Resolver != nil {
+			if res, err = st.opts.Resolver.Resolve(base.Conflict{
+				Path:   dir,
+				Local:  nodeConflictVersion(a),
+				Remote: nodeConflictVersion(b),
+				Fields: fields,
+			}); err != nil {
+				return err
 			}
 		}
+		// metadata has no copy to keep, keep-both keeps local fields
+		if res == base.RRemote {
+			merged, _ = base.MergeData(oMd, aMd, bMd, true)
+		}
+		aid, bid := a.cid, b.cid
+		st.resolved(base.ConflictResolution{
+			Path:       dir,
+			Resolution: res,
+			Local:      &aid,
+			Remote:     &bid,
+			Fields:     fields,
+		})
 	}
 
-	a.h.Merge = &b.cid
-	a.h.Info.Mtime = base.Timestamp().Unix()
-	a.store = destStore
-	if _, err := a.Put(); err != nil {
+	switch {
+	case reflect.DeepEqual(merged, aMd):
+		return nil
+	case merged == nil:
+		a.metadata, a.h.Metadata = nil, nil
+		return nil
+	default:
+		return a.SetMetadata(merged)
+	}
+}
+
+// fileMetadata returns the data of a file's metadata, nil if it has none
+func fileMetadata(f *File) (interface{}, error) {
+	md, err := f.Metadata()
+	if errors.Is(err, base.ErrNoLink) {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
-	return a, nil
+	return md.Data()
+}
+
+// fileText reads the content of f, reporting whether it's text
+func fileText(f *File) (text string, isText bool, err error) {
+	if err := f.ensureContent(); err != nil {
+		return "", false, err
+	}
+	data, err := ioutil.ReadAll(f.content)
+	// the content reader is spent, reopen it on next read
+	f.content = nil
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), base.IsText(data), nil
+}
+
+// treeMetadata returns the data of a tree's metadata, nil if it has none
+func treeMetadata(t *Tree) (interface{}, error) {
+	if _, err := t.Metadata(); err != nil {
+		return nil, err
+	}
+	if t.metadata == nil {
+		return nil, nil
+	}
+	return t.metadata.Data()
+}
+
+// linkConflictVersion describes the entry name in t as a side of a conflict,
+// nil if the entry doesn't exist
+func linkConflictVersion(t *Tree, name string) *base.ConflictVersion {
+	l := t.userland.Get(name)
+	if l == nil {
+		return nil
+	}
+	return &base.ConflictVersion{
+		Cid:    l.Cid,
+		Size:   l.Size,
+		Mtime:  l.Mtime,
+		IsFile: l.IsFile,
+	}
+}
+
+func nodeConflictVersion(n base.Node) *base.ConflictVersion {
+	return &base.ConflictVersion{
+		Cid:    n.Cid(),
+		Size:   n.Size(),
+		Mtime:  n.ModTime().Unix(),
+		IsFile: !n.IsDir(),
+	}
+}
+
+// keepEntry copies the blocks of the entry name in a to destStore
+func keepEntry(destStore Store, a *Tree, name string) error {
+	return base.CopyBlocks(destStore.Context(), a.skeleton[name].Cid, a.store.Blockservice(), destStore.Blockservice())
+}
+
+// entry gets a skeleton entry by name, returning false for a nil tree
+func (t *Tree) entry(name string) (SkeletonInfo, bool) {
+	if t == nil {
+		return SkeletonInfo{}, false
+	}
+	info, ok := t.skeleton[name]
+	return info, ok
+}
+
+func (t *Tree) skeletonInfo() SkeletonInfo {
+	info := SkeletonInfo{
+		Cid:         t.cid,
+		SubSkeleton: t.skeleton,
+	}
+	if t.h.Userland != nil {
+		info.Userland = *t.h.Userland
+	}
+	if t.h.Metadata != nil {
+		info.Metadata = *t.h.Metadata
+	}
+	return info
+}
+
+// entryCid returns a pointer to the CID of a skeleton entry, nil if the entry
+// doesn't exist
+func entryCid(info SkeletonInfo, exists bool) *cid.Cid {
+	if !exists {
+		return nil
+	}
+	return &info.Cid
+}
+
+// adoptEntry sets the entry name in a to the version stored in src, copying
+// blocks to destStore
+func adoptEntry(ctx context.Context, destStore Store, a *Tree, src Store, name string, info SkeletonInfo) error {
+	n, err := loadNodeFromSkeletonInfo(ctx, src, name, info)
+	if err != nil {
+		return err
+	}
+	log.Debugw("mergeTrees adopt entry", "dir", a.Name(), "file", name, "cid", n.Cid())
+
+	if err := base.CopyBlocks(destStore.Context(), n.Cid(), src.Blockservice(), destStore.Blockservice()); err != nil {
+		return err
+	}
+	setEntry(a, name, n, info)
+	return nil
+}
+
+// setEntry links n into t as name
+func setEntry(t *Tree, name string, n base.Node, info SkeletonInfo) {
+	t.skeleton[name] = info
+	t.userland.Add(base.Link{
+		Name:   name,
+		Size:   n.Size(),
+		Cid:    n.Cid(),
+		Mtime:  n.ModTime().Unix(),
+		IsFile: isFileType(n.Type()),
+	})
 }
 
 // construct a new node from a, with merge field set to b.Cid, store new node on
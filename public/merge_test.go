@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	cid "github.com/ipfs/go-cid"
 	base "github.com/qri-io/wnfs-go/base"
 	assert "github.com/stretchr/testify/assert"
 	require "github.com/stretchr/testify/require"
@@ -96,6 +97,39 @@ func TestTreeMergeCommit(t *testing.T) {
 		mustFileContents(t, a, "hello.txt", "hello!")
 	})
 
+	t.Run("both_edit_directory", func(t *testing.T) {
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("dir/hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("dir/goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+
+		// directory is changed on both sides, each change is merged against the
+		// common ancestor
+		_, err = a.Add(base.MustPath("dir/bonjour.txt"), base.NewMemfileBytes("bonjour.txt", []byte("bonjour!")))
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("dir/hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello **2**")))
+		require.Nil(t, err)
+		_, err = b.Rm(base.MustPath("dir/goodbye.txt"))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		dir, err := a.Get(base.MustPath("dir"))
+		require.Nil(t, err)
+		mustDirChildren(t, dir.(*Tree), []string{
+			"bonjour.txt",
+			"hello.txt",
+		})
+		mustFileContents(t, a, "dir/hello.txt", "hello **2**")
+	})
+
 	t.Run("remote_overwrites_local_file", func(t *testing.T) {
 		a := NewEmptyTree(store, "")
 		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
@@ -151,10 +185,6 @@ func TestTreeMergeCommit(t *testing.T) {
 	})
 
 	t.Run("remote_deletes_local_file", func(t *testing.T) {
-		t.Logf(`
-TODO (b5): This implementation makes it difficult to delete files. The file here
-is restored upon merge, and would need to be removed in *both* trees to be 
-removed entirely. should consult spec for correctness`[1:])
 		a := NewEmptyTree(store, "")
 		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
 		require.Nil(t, err)
@@ -175,15 +205,10 @@ removed entirely. should consult spec for correctness`[1:])
 		require.Nil(t, err)
 		mustDirChildren(t, a, []string{
 			"goodbye.txt",
-			"hello.txt",
 		})
 	})
 
 	t.Run("local_deletes_file", func(t *testing.T) {
-		t.Logf(`
-TODO (b5): This implementation makes it difficult to delete files. The file here
-is restored upon merge, and would need to be removed in *both* trees to be 
-removed entirely. should consult spec for correctness`[1:])
 		a := NewEmptyTree(store, "")
 		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
 		require.Nil(t, err)
@@ -204,15 +229,54 @@ removed entirely. should consult spec for correctness`[1:])
 		require.Nil(t, err)
 		mustDirChildren(t, a, []string{
 			"goodbye.txt",
-			"hello.txt",
 		})
 	})
 
 	t.Run("remote_deletes_local_dir", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("dir/hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Rm(base.MustPath("dir"))
+		require.Nil(t, err)
+
+		// add to a to diverge histories
+		_, err = a.Add(base.MustPath("goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"goodbye.txt",
+		})
 	})
 	t.Run("local_deletes_remote_dir", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("dir/hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = a.Rm(base.MustPath("dir"))
+		require.Nil(t, err)
+
+		// add to b to diverge histories
+		_, err = b.Add(base.MustPath("goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"goodbye.txt",
+		})
 	})
 
 	t.Run("remote_overwrites_local_file_with_directory", func(t *testing.T) {
@@ -240,26 +304,491 @@ removed entirely. should consult spec for correctness`[1:])
 			"goodbye.txt",
 			"hello",
 		})
+		mustIsDir(t, a, "hello")
 	})
 	t.Run("local_overwrites_remote_file_with_directory", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello"), base.NewMemfileBytes("hello", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		_, err = a.Rm(base.MustPath("hello"))
+		require.Nil(t, err)
+		_, err = a.Mkdir(base.MustPath("hello"))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"goodbye.txt",
+			"hello",
+		})
+		mustIsDir(t, a, "hello")
 	})
 
 	t.Run("remote_overwrites_local_directory_with_file", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello/world.txt"), base.NewMemfileBytes("world.txt", []byte("world!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		_, err = b.Rm(base.MustPath("hello"))
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("hello"), base.NewMemfileBytes("hello", []byte("hello!")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"goodbye.txt",
+			"hello",
+		})
+		mustFileContents(t, a, "hello", "hello!")
 	})
 	t.Run("local_overwrites_remote_directory_with_file", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello/world.txt"), base.NewMemfileBytes("world.txt", []byte("world!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+		require.Nil(t, err)
+
+		_, err = a.Rm(base.MustPath("hello"))
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("hello"), base.NewMemfileBytes("hello", []byte("hello!")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"goodbye.txt",
+			"hello",
+		})
+		mustFileContents(t, a, "hello", "hello!")
 	})
 
 	t.Run("remote_delete_undeleted_by_local_edit", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Rm(base.MustPath("hello.txt"))
+		require.Nil(t, err)
+
+		_, err = a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello **2**")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"hello.txt",
+		})
+		mustFileContents(t, a, "hello.txt", "hello **2**")
 	})
 	t.Run("local_delete_undeleted_by_remote_edit", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = a.Rm(base.MustPath("hello.txt"))
+		require.Nil(t, err)
+
+		_, err = b.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello **2** (remote)")))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"hello.txt",
+		})
+		mustFileContents(t, a, "hello.txt", "hello **2** (remote)")
 	})
 
 	t.Run("merge_remote_into_local_then_sync_local_to_remote", func(t *testing.T) {
-		t.Skip("TODO(b5)")
+		a := NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("bonjour.txt"), base.NewMemfileBytes("bonjour.txt", []byte("bonjour!")))
+		require.Nil(t, err)
+		_, err = b.Rm(base.MustPath("hello.txt"))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"bonjour.txt",
+		})
+
+		// remote is in the history of the merge commit, syncing local to remote
+		// fast-forwards
+		res, err = Merge(ctx, b, a)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTFastForward, res.Type)
+		assert.Equal(t, a.Cid(), res.Cid)
+
+		// and merging again is a no-op for local
+		res, err = Merge(ctx, a, b)
+		require.Nil(t, err)
+		assert.Equal(t, base.MTLocalAhead, res.Type)
+	})
+}
+
+func TestTreeMergeConflictResolvers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestStore(ctx, t)
+
+	// diverge creates two trees that both edit hello.txt since their common
+	// ancestor
+	diverge := func(t *testing.T) (a, b *Tree) {
+		a = NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+		require.Nil(t, err)
+
+		b, err = LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello (remote)")))
+		require.Nil(t, err)
+
+		_, err = a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello (local)")))
+		require.Nil(t, err)
+		return a, b
+	}
+
+	t.Run("prefer_local", func(t *testing.T) {
+		a, b := diverge(t)
+		res, err := Merge(ctx, a, b, base.WithConflictResolver(base.PreferLocal))
+		require.Nil(t, err)
+		assert.Equal(t, base.MTMergeCommit, res.Type)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "hello.txt", "hello (local)")
+
+		require.Equal(t, 1, len(res.Conflicts))
+		assert.Equal(t, "hello.txt", res.Conflicts[0].Path)
+		assert.Equal(t, base.RLocal, res.Conflicts[0].Resolution)
+	})
+
+	t.Run("prefer_remote", func(t *testing.T) {
+		a, b := diverge(t)
+		res, err := Merge(ctx, a, b, base.WithConflictResolver(base.PreferRemote))
+		require.Nil(t, err)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "hello.txt", "hello (remote)")
+
+		require.Equal(t, 1, len(res.Conflicts))
+		assert.Equal(t, base.RRemote, res.Conflicts[0].Resolution)
+	})
+
+	t.Run("keep_both", func(t *testing.T) {
+		a, b := diverge(t)
+		res, err := Merge(ctx, a, b, base.WithConflictResolver(base.KeepBoth))
+		require.Nil(t, err)
+		require.Equal(t, 1, len(res.Conflicts))
+		cr := res.Conflicts[0]
+		assert.Equal(t, base.RKeepBoth, cr.Resolution)
+		require.NotNil(t, cr.Remote)
+		assert.Equal(t, base.ConflictCopyName("hello.txt", *cr.Remote), cr.Copy)
+
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustDirChildren(t, a, []string{
+			"hello.txt",
+			cr.Copy,
+		})
+		mustFileContents(t, a, "hello.txt", "hello (local)")
+		mustFileContents(t, a, cr.Copy, "hello (remote)")
+	})
+
+	t.Run("last_writer_wins_edit_beats_delete", func(t *testing.T) {
+		a, b := diverge(t)
+		_, err := a.Rm(base.MustPath("hello.txt"))
+		require.Nil(t, err)
+
+		res, err := Merge(ctx, a, b, base.WithConflictResolver(base.LastWriterWins))
+		require.Nil(t, err)
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "hello.txt", "hello (remote)")
+
+		require.Equal(t, 1, len(res.Conflicts))
+		assert.Equal(t, base.RRemote, res.Conflicts[0].Resolution)
+		assert.Nil(t, res.Conflicts[0].Local)
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		a, b := diverge(t)
+		var got []base.Conflict
+		resolver := base.ResolverFunc(func(c base.Conflict) (base.Resolution, error) {
+			got = append(got, c)
+			return base.RRemote, nil
+		})
+		res, err := Merge(ctx, a, b, base.WithConflictResolver(resolver))
+		require.Nil(t, err)
+
+		require.Equal(t, 1, len(got))
+		assert.Equal(t, "hello.txt", got[0].Path)
+		require.NotNil(t, got[0].Local)
+		require.NotNil(t, got[0].Remote)
+		assert.True(t, got[0].Local.IsFile)
+		assert.NotEqual(t, got[0].Local.Cid, got[0].Remote.Cid)
+
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "hello.txt", "hello (remote)")
+	})
+}
+
+func TestTreeMergeDecisions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestStore(ctx, t)
+
+	a := NewEmptyTree(store, "")
+	for _, name := range []string{"hello.txt", "goodbye.txt", "shared.txt"} {
+		_, err := a.Add(base.MustPath(name), base.NewMemfileBytes(name, []byte(name)))
+		require.Nil(t, err)
+	}
+
+	b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+	require.Nil(t, err)
+	_, err = b.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello (remote)")))
+	require.Nil(t, err)
+	_, err = b.Rm(base.MustPath("goodbye.txt"))
+	require.Nil(t, err)
+	_, err = b.Add(base.MustPath("remote.txt"), base.NewMemfileBytes("remote.txt", []byte("remote")))
+	require.Nil(t, err)
+	_, err = b.Add(base.MustPath("shared.txt"), base.NewMemfileBytes("shared.txt", []byte("shared (remote)")))
+	require.Nil(t, err)
+
+	_, err = a.Add(base.MustPath("local.txt"), base.NewMemfileBytes("local.txt", []byte("local")))
+	require.Nil(t, err)
+	_, err = a.Add(base.MustPath("shared.txt"), base.NewMemfileBytes("shared.txt", []byte("shared (local)")))
+	require.Nil(t, err)
+
+	res, err := Merge(ctx, a, b, base.WithConflictResolver(base.PreferLocal))
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+
+	got := make([]string, 0, len(res.Decisions))
+	for _, d := range res.Decisions {
+		got = append(got, d.Path+" "+string(d.Action)+" "+string(d.Resolution))
+	}
+	assert.Equal(t, []string{
+		" merge ",
+		"goodbye.txt remove-remote ",
+		"hello.txt fast-forward ",
+		"local.txt keep-local ",
+		"remote.txt add-remote ",
+		"shared.txt conflict local",
+	}, got)
+}
+
+func TestTreeMergeSymlinkIsFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestStore(ctx, t)
+
+	a := NewEmptyTree(store, "")
+	_, err := a.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello")))
+	require.Nil(t, err)
+
+	b, err := LoadTree(ctx, a.store, a.Name(), a.Cid())
+	require.Nil(t, err)
+	_, err = b.Symlink("hello.txt", base.MustPath("link"))
+	require.Nil(t, err)
+	_, err = a.Add(base.MustPath("local.txt"), base.NewMemfileBytes("local.txt", []byte("local")))
+	require.Nil(t, err)
+
+	res, err := Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+
+	merged, err := LoadTree(ctx, store, "", res.Cid)
+	require.Nil(t, err)
+	l := merged.Links().Get("link")
+	require.NotNil(t, l)
+	assert.True(t, l.IsFile, "symlinks are linked as files")
+}
+
+func TestTreeMergeStructured(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestStore(ctx, t)
+
+	// diverge creates two trees that both edit data.json since their common
+	// ancestor
+	diverge := func(t *testing.T, local, remote map[string]interface{}) (a, b *Tree) {
+		a = NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("data.json"), NewLDFile(store, "data.json", map[string]interface{}{
+			"title": "hello",
+			"tags":  []interface{}{"a"},
+		}))
+		require.Nil(t, err)
+
+		b, err = LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("data.json"), NewLDFile(store, "data.json", remote))
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("data.json"), NewLDFile(store, "data.json", local))
+		require.Nil(t, err)
+		return a, b
+	}
+
+	mustData := func(t *testing.T, id cid.Cid) interface{} {
+		t.Helper()
+		tree, err := LoadTree(ctx, store, "", id)
+		require.Nil(t, err)
+		f, err := tree.Get(base.MustPath("data.json"))
+		require.Nil(t, err)
+		df, ok := f.(base.LDFile)
+		require.True(t, ok, "expected an LDFile, got %T", f)
+		data, err := df.Data()
+		require.Nil(t, err)
+		return data
+	}
+
+	t.Run("no_conflict", func(t *testing.T) {
+		a, b := diverge(t,
+			map[string]interface{}{"title": "hello", "tags": []interface{}{"a", "local"}, "local": "yes"},
+			map[string]interface{}{"title": "hello (remote)", "tags": []interface{}{"a", "remote"}},
+		)
+		res, err := Merge(ctx, a, b, base.WithStructuredMerge())
+		require.Nil(t, err)
+		assert.Equal(t, 0, len(res.Conflicts))
+		assert.Equal(t, map[string]interface{}{
+			"title": "hello (remote)",
+			"tags":  []interface{}{"a", "local", "remote"},
+			"local": "yes",
+		}, mustData(t, res.Cid))
+
+		got := make([]string, 0, len(res.Decisions))
+		for _, d := range res.Decisions {
+			got = append(got, d.Path+" "+string(d.Action))
+		}
+		assert.Equal(t, []string{" merge", "data.json merge"}, got)
+	})
+
+	t.Run("field_conflict", func(t *testing.T) {
+		a, b := diverge(t,
+			map[string]interface{}{"title": "hello (local)", "tags": []interface{}{"a"}, "local": "yes"},
+			map[string]interface{}{"title": "hello (remote)", "tags": []interface{}{"a"}},
+		)
+		var got []base.Conflict
+		resolver := base.ResolverFunc(func(c base.Conflict) (base.Resolution, error) {
+			got = append(got, c)
+			return base.RRemote, nil
+		})
+		res, err := Merge(ctx, a, b, base.WithStructuredMerge(), base.WithConflictResolver(resolver))
+		require.Nil(t, err)
+
+		require.Equal(t, 1, len(got))
+		assert.Equal(t, []string{"/title"}, got[0].Fields)
+		require.Equal(t, 1, len(res.Conflicts))
+		assert.Equal(t, base.RRemote, res.Conflicts[0].Resolution)
+		assert.Equal(t, []string{"/title"}, res.Conflicts[0].Fields)
+		assert.Equal(t, map[string]interface{}{
+			"title": "hello (remote)",
+			"tags":  []interface{}{"a"},
+			"local": "yes",
+		}, mustData(t, res.Cid))
+	})
+}
+
+func TestTreeMergeText(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newMemTestStore(ctx, t)
+
+	// diverge creates two trees that both edit notes.txt since their common
+	// ancestor
+	diverge := func(t *testing.T, local, remote string) (a, b *Tree) {
+		a = NewEmptyTree(store, "")
+		_, err := a.Add(base.MustPath("notes.txt"), base.NewMemfileBytes("notes.txt", []byte("one\ntwo\nthree\n")))
+		require.Nil(t, err)
+
+		b, err = LoadTree(ctx, a.store, a.Name(), a.Cid())
+		require.Nil(t, err)
+		_, err = b.Add(base.MustPath("notes.txt"), base.NewMemfileBytes("notes.txt", []byte(remote)))
+		require.Nil(t, err)
+		_, err = a.Add(base.MustPath("notes.txt"), base.NewMemfileBytes("notes.txt", []byte(local)))
+		require.Nil(t, err)
+		return a, b
+	}
+
+	t.Run("no_conflict", func(t *testing.T) {
+		a, b := diverge(t, "ONE\ntwo\nthree\n", "one\ntwo\nTHREE\n")
+		res, err := Merge(ctx, a, b, base.WithTextMerge())
+		require.Nil(t, err)
+		assert.Equal(t, 0, len(res.Conflicts))
+
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "notes.txt", "ONE\ntwo\nTHREE\n")
+	})
+
+	t.Run("conflict_markers", func(t *testing.T) {
+		a, b := diverge(t, "ONE\ntwo (local)\nthree\n", "one\ntwo (remote)\nthree\n")
+		res, err := Merge(ctx, a, b, base.WithTextMerge())
+		require.Nil(t, err)
+		require.Equal(t, 1, len(res.Conflicts))
+		assert.Equal(t, base.RMarkers, res.Conflicts[0].Resolution)
+
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "notes.txt", "<<<<<<< local\nONE\ntwo (local)\n=======\none\ntwo (remote)\n>>>>>>> remote\nthree\n")
+	})
+
+	t.Run("conflict_prefer_remote", func(t *testing.T) {
+		a, b := diverge(t, "one\ntwo (local)\nthree\nfour\n", "one\ntwo (remote)\nthree\n")
+		var got []base.Conflict
+		resolver := base.ResolverFunc(func(c base.Conflict) (base.Resolution, error) {
+			got = append(got, c)
+			return base.RRemote, nil
+		})
+		res, err := Merge(ctx, a, b, base.WithTextMerge(), base.WithConflictResolver(resolver))
+		require.Nil(t, err)
+		require.Equal(t, 1, len(got))
+		assert.Equal(t, 1, got[0].Hunks)
+
+		a, err = LoadTree(ctx, store, "", res.Cid)
+		require.Nil(t, err)
+		mustFileContents(t, a, "notes.txt", "one\ntwo (remote)\nthree\nfour\n")
 	})
 }
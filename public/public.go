@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	golog "github.com/ipfs/go-log"
 	base "github.com/qri-io/wnfs-go/base"
+	dagmod "github.com/qri-io/wnfs-go/dagmod"
 )
 
 var log = golog.Logger("wnfs")
@@ -28,6 +30,7 @@ type Header struct {
 	Metadata *cid.Cid
 	Skeleton *cid.Cid // only present on directories
 	Userland *cid.Cid
+	Target   string // only present on symlinks
 }
 
 func loadHeader(ctx context.Context, bserv blockservice.BlockService, id cid.Cid) (*Header, error) {
@@ -60,6 +63,9 @@ func decodeHeaderBlock(blk blocks.Block) (*Header, error) {
 	h := &Header{
 		Info: InfoFromMap(info),
 	}
+	if target, ok := env["target"].(string); ok {
+		h.Target = target
+	}
 
 	for _, l := range nd.Links() {
 		switch l.Name {
@@ -91,6 +97,9 @@ func (h *Header) encodeBlock() (blocks.Block, error) {
 	if h.Info != nil {
 		LDFile["info"] = h.Info.Map()
 	}
+	if h.Target != "" {
+		LDFile["target"] = h.Target
+	}
 	return cbornode.WrapObject(LDFile, base.DefaultMultihashType, -1)
 }
 
@@ -301,28 +310,85 @@ func (t *Tree) Skeleton() (Skeleton, error) {
 }
 
 func (t *Tree) Get(path base.Path) (fs.File, error) {
-	ctx := context.TODO()
-	head, tail := path.Shift()
-	if head == "" {
-		return t, nil
+	return t.get(path, true)
+}
+
+// Readlink returns the target of the symlink at path
+func (t *Tree) Readlink(path base.Path) (string, error) {
+	f, err := t.get(path, false)
+	if err != nil {
+		return "", err
+	}
+	sl, ok := f.(base.Symlink)
+	if !ok {
+		return "", fmt.Errorf("%q: %w", path, base.ErrNotSymlink)
 	}
+	return sl.Target(), nil
+}
 
-	link := t.userland.Get(head)
-	if link == nil {
-		return nil, base.ErrNotFound
+// get resolves path, following any symlinks along the way. A symlink at the
+// end of path is only followed if followLast is true
+func (t *Tree) get(path base.Path, followLast bool) (fs.File, error) {
+	f, _, err := t.resolve(path, followLast)
+	return f, err
+}
+
+// resolve is get, also returning path with all symlinks replaced by their
+// targets. The returned path is set when resolve fails with ErrNotFound
+func (t *Tree) resolve(path base.Path, followLast bool) (fs.File, base.Path, error) {
+	resolver := &base.SymlinkResolver{}
+	for {
+		f, link, rest, err := t.walk(path, followLast)
+		if err != nil {
+			return nil, path, err
+		}
+		if link == nil {
+			return f, path, nil
+		}
+		if path, err = resolver.Follow(link, f.(*Symlink).Target(), rest); err != nil {
+			return nil, nil, err
+		}
 	}
+}
 
-	if tail != nil {
-		ch, err := LoadTree(ctx, t.store, head, link.Cid)
+// walk descends path without following symlinks. walk stops at the first
+// symlink that needs following, returning the symlink, the path to the symlink
+// and the remaining path
+func (t *Tree) walk(path base.Path, followLast bool) (f fs.File, link, rest base.Path, err error) {
+	ctx := context.TODO()
+	var dir base.Node = t
+	for i, name := range path {
+		if name == "" {
+			continue
+		}
+
+		var n base.Node
+		switch d := dir.(type) {
+		case *Tree:
+			l := d.userland.Get(name)
+			if l == nil {
+				return nil, nil, nil, base.ErrNotFound
+			}
+			n, err = loadNode(ctx, d.store, name, l.Cid)
+		case *UnixFSDir:
+			n, err = d.find(ctx, name)
+		default:
+			return nil, nil, nil, fmt.Errorf("%q: not a directory", path[:i])
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 
-		// recurse
-		return ch.Get(tail)
+		last := i == len(path)-1
+		if sl, ok := n.(*Symlink); ok && (followLast || !last) {
+			return sl, path[:i+1], path[i+1:], nil
+		}
+		if last {
+			return n, nil, nil, nil
+		}
+		dir = n
 	}
-
-	return loadNode(ctx, t.store, link.Name, link.Cid)
+	return dir, nil, nil, nil
 }
 
 func (t *Tree) AsHistoryEntry() base.HistoryEntry {
@@ -335,6 +401,41 @@ func (t *Tree) AsHistoryEntry() base.HistoryEntry {
 	}
 }
 
+// Symlink creates a symlink at path that points to target. Relative targets
+// are resolved from the directory containing the symlink. target doesn't need
+// to exist
+func (t *Tree) Symlink(target string, path base.Path) (res base.PutResult, err error) {
+	if len(path) == 0 || path[0] == "" {
+		return nil, errors.New("invalid path: empty")
+	}
+	if target == "" {
+		return nil, errors.New("symlink target cannot be empty")
+	}
+
+	head, tail := path.Shift()
+	if tail == nil {
+		if t.userland.Get(head) != nil {
+			return nil, fmt.Errorf("%q: %w", head, fs.ErrExist)
+		}
+		if res, err = NewSymlink(t.store, head, target).Put(); err != nil {
+			return nil, err
+		}
+	} else {
+		childDir, err := t.getOrCreateDirectChildTree(head)
+		if err != nil {
+			return nil, err
+		}
+
+		// recurse
+		if res, err = childDir.Symlink(target, tail); err != nil {
+			return nil, err
+		}
+	}
+
+	t.updateUserlandLink(head, res)
+	return t.Put()
+}
+
 func (t *Tree) Mkdir(path base.Path) (res base.PutResult, err error) {
 	if len(path) < 1 {
 		return res, errors.New("invalid path: empty")
@@ -395,6 +496,57 @@ func (t *Tree) Add(path base.Path, f fs.File) (res base.PutResult, err error) {
 	return t.Put()
 }
 
+// OpenFile opens the file at path for editing. flag is a combination of os
+// package O_* flags & must include O_WRONLY or O_RDWR. Symlinks are followed.
+// Edits are written to this tree when the returned file is closed, rewriting
+// only the chunks of file content an edit touched
+func (t *Tree) OpenFile(path base.Path, flag int) (base.WritableFile, error) {
+	if len(path) == 0 || path[0] == "" {
+		return nil, errors.New("invalid path: empty")
+	}
+
+	var (
+		file    *File
+		created bool
+	)
+	f, resolved, err := t.resolve(path, true)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, fmt.Errorf("%q: %w", path, fs.ErrExist)
+		}
+		var ok bool
+		if file, ok = f.(*File); !ok {
+			return nil, fmt.Errorf("%q: not a file", path)
+		}
+	case errors.Is(err, base.ErrNotFound) && flag&os.O_CREATE != 0:
+		if file, err = NewFile(t.store, resolved[len(resolved)-1], nil); err != nil {
+			return nil, err
+		}
+		created = true
+	default:
+		return nil, err
+	}
+
+	var root cid.Cid
+	if file.h.Userland != nil {
+		root = *file.h.Userland
+	}
+	mod, err := t.store.EditFile(root)
+	if err != nil {
+		return nil, err
+	}
+	return dagmod.NewFile(file.name, mod, flag, created, func(root cid.Cid, size int64) error {
+		file.content = nil
+		file.h.Userland = &root
+		file.h.Info.Type = base.NTFile
+		file.h.Info.Size = size
+		file.h.Info.Mtime = base.Timestamp().Unix()
+		_, err := t.Add(resolved, editedFile{file})
+		return err
+	})
+}
+
 func (t *Tree) Copy(path base.Path, srcPathStr string, srcFS fs.FS) (res base.PutResult, err error) {
 	log.Debugw("Tree.copy", "path", path, "srcPath", srcPathStr)
 	if len(path) == 0 {
@@ -435,7 +587,6 @@ func (t *Tree) Copy(path base.Path, srcPathStr string, srcFS fs.FS) (res base.Pu
 }
 
 func (t *Tree) Rm(path base.Path) (base.PutResult, error) {
-	ctx := context.TODO()
 	head, tail := path.Shift()
 	if head == "" {
 		return PutResult{}, fmt.Errorf("invalid path: empty")
@@ -448,7 +599,7 @@ func (t *Tree) Rm(path base.Path) (base.PutResult, error) {
 		if link == nil {
 			return PutResult{}, base.ErrNotFound
 		}
-		child, err := LoadTree(ctx, t.store, head, link.Cid)
+		child, err := t.loadDirectChildTree(head, link.Cid)
 		if err != nil {
 			return nil, err
 		}
@@ -465,6 +616,113 @@ func (t *Tree) Rm(path base.Path) (base.PutResult, error) {
 	return t.Put()
 }
 
+// Mv relinks the node at from to the path to. The moved node is not
+// rewritten, so its CID and history are preserved. Only directories between
+// this tree and the moved node are updated
+func (t *Tree) Mv(from, to base.Path) (base.PutResult, error) {
+	if len(from) == 0 || from[0] == "" || len(to) == 0 || to[0] == "" {
+		return nil, fmt.Errorf("invalid path: empty")
+	}
+	if to.HasPrefix(from) {
+		return nil, fmt.Errorf("cannot move %q into itself", from)
+	}
+
+	fromHead, fromTail := from.Shift()
+	toHead, toTail := to.Shift()
+	if fromTail != nil && toTail != nil && fromHead == toHead {
+		// both paths share a parent directory, recurse
+		link := t.userland.Get(fromHead)
+		if link == nil {
+			return nil, base.ErrNotFound
+		}
+		child, err := t.loadDirectChildTree(fromHead, link.Cid)
+		if err != nil {
+			return nil, err
+		}
+		res, err := child.Mv(fromTail, toTail)
+		if err != nil {
+			return nil, err
+		}
+		t.updateUserlandLink(fromHead, res)
+		return t.Put()
+	}
+
+	// this tree is the closest common ancestor of from & to
+	if _, err := t.Get(to); err == nil {
+		return nil, fmt.Errorf("%q: %w", to, fs.ErrExist)
+	}
+	link, info, err := t.detach(from)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.attach(to, link, info); err != nil {
+		return nil, err
+	}
+	return t.Put()
+}
+
+// detach removes the link at path, returning the removed link. intermediate
+// directories are written, this tree is not
+func (t *Tree) detach(path base.Path) (base.Link, SkeletonInfo, error) {
+	head, tail := path.Shift()
+	link := t.userland.Get(head)
+	if link == nil {
+		return base.Link{}, SkeletonInfo{}, base.ErrNotFound
+	}
+
+	if tail == nil {
+		info := t.skeleton[head]
+		t.removeUserlandLink(head)
+		return *link, info, nil
+	}
+
+	child, err := t.loadDirectChildTree(head, link.Cid)
+	if err != nil {
+		return base.Link{}, SkeletonInfo{}, err
+	}
+	removed, info, err := child.detach(tail)
+	if err != nil {
+		return base.Link{}, SkeletonInfo{}, err
+	}
+	res, err := child.Put()
+	if err != nil {
+		return base.Link{}, SkeletonInfo{}, err
+	}
+	t.updateUserlandLink(head, res)
+	return removed, info, nil
+}
+
+// attach adds link at path, creating any missing intermediate directories.
+// intermediate directories are written, this tree is not
+func (t *Tree) attach(path base.Path, link base.Link, info SkeletonInfo) error {
+	head, tail := path.Shift()
+	if tail == nil {
+		if existing := t.userland.Get(head); existing != nil {
+			return fmt.Errorf("%q: %w", head, fs.ErrExist)
+		}
+		link.Name = head
+		t.userland.Add(link)
+		t.skeleton[head] = info
+		t.h.Info.Mtime = base.Timestamp().Unix()
+		t.h.Merge = nil
+		return nil
+	}
+
+	child, err := t.getOrCreateDirectChildTree(head)
+	if err != nil {
+		return err
+	}
+	if err = child.attach(tail, link, info); err != nil {
+		return err
+	}
+	res, err := child.Put()
+	if err != nil {
+		return err
+	}
+	t.updateUserlandLink(head, res)
+	return nil
+}
+
 func (t *Tree) Put() (base.PutResult, error) {
 	store := t.store
 	ctx := context.TODO()
@@ -497,7 +755,7 @@ func (t *Tree) Put() (base.PutResult, error) {
 	}
 	t.h.Skeleton = &res.Cid
 
-	if t.cid.Defined() {
+	if t.cid.Defined() && !t.store.Tx().IsStaged(t.cid) {
 		// need to copy CID, as we're about to alter it's value
 		id, _ := cid.Parse(t.cid)
 		t.h.Previous = &id
@@ -511,6 +769,7 @@ func (t *Tree) Put() (base.PutResult, error) {
 	}
 
 	t.cid = blk.Cid()
+	t.store.Tx().Stage(t.cid)
 	log.Debugw("wrote public tree", "name", t.name, "cid", t.cid.String(), "userlandLinkCount", t.userland.Len(), "size", t.h.Info.Size, "prev", t.h.Previous)
 
 	return PutResult{
@@ -569,12 +828,30 @@ func loadHistoryEntry(ctx context.Context, bserv blockservice.BlockService, id c
 }
 
 func (t *Tree) getOrCreateDirectChildTree(name string) (*Tree, error) {
-	ctx := context.TODO()
 	link := t.userland.Get(name)
 	if link == nil {
 		return NewEmptyTree(t.store, name), nil
 	}
-	return LoadTree(ctx, t.store, name, link.Cid)
+	return t.loadDirectChildTree(name, link.Cid)
+}
+
+// loadDirectChildTree loads a child directory for modification. Mounted UnixFS
+// directories are converted to trees
+func (t *Tree) loadDirectChildTree(name string, id cid.Cid) (*Tree, error) {
+	ctx := context.TODO()
+	n, err := loadNode(ctx, t.store, name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ch := n.(type) {
+	case *Tree:
+		return ch, nil
+	case *UnixFSDir:
+		return ch.tree(ctx)
+	default:
+		return nil, fmt.Errorf("%q: not a directory", name)
+	}
 }
 
 func (t *Tree) createOrUpdateChild(srcPathStr, name string, f fs.File, srcFS fs.FS) (base.PutResult, error) {
@@ -593,7 +870,6 @@ func (t *Tree) createOrUpdateChild(srcPathStr, name string, f fs.File, srcFS fs.
 }
 
 func (t *Tree) createOrUpdateChildDirectory(srcPathStr, name string, f fs.File, srcFS fs.FS) (base.PutResult, error) {
-	ctx := context.TODO()
 	dir, ok := f.(fs.ReadDirFile)
 	if !ok {
 		return nil, fmt.Errorf("cannot read directory contents")
@@ -603,14 +879,9 @@ func (t *Tree) createOrUpdateChildDirectory(srcPathStr, name string, f fs.File,
 		return nil, fmt.Errorf("reading directory contents: %w", err)
 	}
 
-	var tree *Tree
-	if link := t.userland.Get(name); link != nil {
-		tree, err = LoadTree(ctx, t.store, name, link.Cid)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		tree = NewEmptyTree(t.store, name)
+	tree, err := t.getOrCreateDirectChildTree(name)
+	if err != nil {
+		return nil, err
 	}
 
 	var res base.PutResult
@@ -646,6 +917,9 @@ func (t *Tree) createOrUpdateChildLDFile(name string, sdf base.LDFile) (base.Put
 func (t *Tree) createOrUpdateChildFile(name string, f fs.File) (base.PutResult, error) {
 	ctx := context.TODO()
 
+	if ef, ok := f.(editedFile); ok {
+		return ef.putHeader()
+	}
 	if sdFile, ok := f.(base.LDFile); ok {
 		return t.createOrUpdateChildLDFile(name, sdFile)
 	}
@@ -656,6 +930,18 @@ func (t *Tree) createOrUpdateChildFile(name string, f fs.File) (base.PutResult,
 			return nil, err
 		}
 
+		md, err := base.FileMetadata(f)
+		if err != nil && !errors.Is(err, base.ErrNoLink) {
+			return nil, err
+		}
+		if md != nil {
+			meta, err := md.Data()
+			if err != nil {
+				return nil, err
+			}
+			previousFile.SetMetadata(meta)
+		}
+
 		previousFile.SetFile(f)
 		return previousFile.Put()
 	}
@@ -697,18 +983,21 @@ var (
 	_ base.WritableMetaNode = (*Tree)(nil)
 	_ fs.File               = (*File)(nil)
 	_ base.Node             = (*File)(nil)
+	_ io.Seeker             = (*File)(nil)
+	_ io.ReaderAt           = (*File)(nil)
 )
 
 func NewFile(store Store, name string, content io.ReadCloser) (*File, error) {
 	var meta interface{}
 	if mdn, ok := content.(base.Metadata); ok {
 		md, err := mdn.Metadata()
-		if err != nil {
+		if err != nil && !errors.Is(err, base.ErrNoLink) {
 			return nil, err
 		}
-		meta, err = md.Data()
-		if err != nil {
-			return nil, err
+		if md != nil {
+			if meta, err = md.Data(); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -767,6 +1056,11 @@ func fileFromHeader(ctx context.Context, store Store, h *Header, name string, id
 	}, nil
 }
 
+// editedFile is a file with userland content written by OpenFile
+type editedFile struct {
+	*File
+}
+
 func (f *File) Links() base.Links          { return base.NewLinks() }
 func (f *File) Name() string               { return f.name }
 func (f *File) Size() int64                { return f.h.Info.Size }
@@ -803,6 +1097,40 @@ func (f *File) Read(p []byte) (n int, err error) {
 	return f.content.Read(p)
 }
 
+// Seek sets the offset of the next Read. Content set with SetFile can only be
+// seeked if it implements io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.ensureContent(); err != nil {
+		return 0, err
+	}
+	s, ok := f.content.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", f.name, base.ErrNotSeekable)
+	}
+	return s.Seek(offset, whence)
+}
+
+// ReadAt reads stored content starting at off. ReadAt opens a DAG reader of
+// its own, and doesn't move the offset of Read
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.h.Userland == nil {
+		return 0, fmt.Errorf("%q: file has no stored content", f.name)
+	}
+	if off >= f.Size() {
+		return 0, io.EOF
+	}
+	r, err := f.store.GetFile(f.store.Context(), *f.h.Userland)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return 0, fmt.Errorf("%q: %w", f.name, base.ErrNotSeekable)
+	}
+	return base.ReadAt(rs, p, off)
+}
+
 func (f *File) ensureContent() (err error) {
 	if f.content == nil {
 		ctx := f.store.Context()
@@ -820,6 +1148,8 @@ func (f *File) Close() error {
 
 func (f *File) SetFile(r io.ReadCloser) {
 	f.content = r
+	// replacing the content of a mounted UnixFS file makes it a WNFS file
+	f.h.Info.Type = base.NTFile
 
 	if mdn, ok := r.(base.Metadata); ok {
 		md, err := mdn.Metadata()
@@ -835,14 +1165,19 @@ func (f *File) SetFile(r io.ReadCloser) {
 }
 
 func (f *File) Put() (base.PutResult, error) {
-	store := f.store
-	ctx := context.TODO()
-
-	userlandRes, err := store.PutFile(base.NewMemfileReader("", f.content))
+	userlandRes, err := f.store.PutFile(base.NewMemfileReader("", f.content))
 	if err != nil {
 		return PutResult{}, fmt.Errorf("putting file %q in store: %w", f.name, err)
 	}
 	f.h.Userland = &userlandRes.Cid
+	return f.putHeader()
+}
+
+// putHeader writes metadata & the file header, linking to userland content
+// that's already in the store
+func (f *File) putHeader() (base.PutResult, error) {
+	store := f.store
+	ctx := context.TODO()
 
 	if f.metadata != nil {
 		log.Debugw("putting meta", "name", f.name)
@@ -854,8 +1189,9 @@ func (f *File) Put() (base.PutResult, error) {
 		f.h.Metadata = &id
 	}
 
-	// add previous reference
-	if f.cid.Defined() {
+	// add previous reference. files rewritten within a transaction keep the
+	// reference from the start of the transaction
+	if f.cid.Defined() && !store.Tx().IsStaged(f.cid) {
 		f.h.Previous = &f.cid
 	}
 
@@ -867,6 +1203,7 @@ func (f *File) Put() (base.PutResult, error) {
 	if err := f.store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
 		return nil, err
 	}
+	store.Tx().Stage(f.cid)
 
 	log.Debugw("wrote public file Header", "name", f.name, "cid", f.cid.String(), "info", f.h)
 	return PutResult{
@@ -900,7 +1237,7 @@ func loadNode(ctx context.Context, store Store, name string, id cid.Cid) (n base
 	}
 
 	switch h.Info.Type {
-	case base.NTFile:
+	case base.NTFile, base.NTUnixFSFile:
 		return fileFromHeader(ctx, store, h, name, id)
 	case base.NTLDFile:
 		blk, err := store.Blockservice().GetBlock(ctx, id)
@@ -911,16 +1248,99 @@ func loadNode(ctx context.Context, store Store, name string, id cid.Cid) (n base
 		return decodeLDFileBlock(df, blk)
 	case base.NTDir:
 		return treeFromHeader(ctx, store, h, name, id)
+	case base.NTSymlink:
+		return &Symlink{store: store, name: name, cid: id, h: h}, nil
+	case base.NTUnixFSDir:
+		return &UnixFSDir{store: store, name: name, cid: id, h: h}, nil
 	default:
 		return nil, fmt.Errorf("unrecognized node type: %s", h.Info.Type)
 	}
 }
 
 func loadNodeFromSkeletonInfo(ctx context.Context, store Store, name string, info SkeletonInfo) (n base.Node, err error) {
-	if info.IsFile {
-		return LoadFile(ctx, store, name, info.Cid)
+	// both files & directories come in more than one node type, let the header
+	// decide
+	return loadNode(ctx, store, name, info.Cid)
+}
+
+// Symlink is a node that points to another path
+type Symlink struct {
+	store Store
+	name  string
+	cid   cid.Cid
+	h     *Header
+}
+
+var _ base.Symlink = (*Symlink)(nil)
+
+func NewSymlink(store Store, name, target string) *Symlink {
+	return &Symlink{
+		store: store,
+		name:  name,
+		h: &Header{
+			Info:   NewInfo(base.NTSymlink),
+			Target: target,
+		},
+	}
+}
+
+func (s *Symlink) Target() string             { return s.h.Target }
+func (s *Symlink) Name() string               { return s.name }
+func (s *Symlink) Size() int64                { return s.h.Info.Size }
+func (s *Symlink) ModTime() time.Time         { return time.Unix(s.h.Info.Mtime, 0) }
+func (s *Symlink) Mode() fs.FileMode          { return fs.ModeSymlink | fs.FileMode(s.h.Info.Mode) }
+func (s *Symlink) Type() base.NodeType        { return s.h.Info.Type }
+func (s *Symlink) IsDir() bool                { return false }
+func (s *Symlink) Sys() interface{}           { return s.store }
+func (s *Symlink) Cid() cid.Cid               { return s.cid }
+func (s *Symlink) Stat() (fs.FileInfo, error) { return s, nil }
+func (s *Symlink) Close() error               { return nil }
+
+func (s *Symlink) Read(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("cannot read symlink %q", s.name)
+}
+
+func (s *Symlink) Metadata() (base.LDFile, error) {
+	return nil, base.ErrNoLink
+}
+
+func (s *Symlink) History(ctx context.Context, maxRevs int) ([]base.HistoryEntry, error) {
+	return history(ctx, s, maxRevs)
+}
+
+func (s *Symlink) AsHistoryEntry() base.HistoryEntry {
+	return base.HistoryEntry{
+		Cid:      s.cid,
+		Previous: s.h.Previous,
+		Mtime:    s.h.Info.Mtime,
+		Type:     s.h.Info.Type,
+		Size:     s.h.Info.Size,
 	}
-	return LoadTree(ctx, store, name, info.Cid)
+}
+
+func (s *Symlink) Put() (base.PutResult, error) {
+	ctx := context.TODO()
+	if s.cid.Defined() && !s.store.Tx().IsStaged(s.cid) {
+		s.h.Previous = &s.cid
+	}
+	s.h.Info.Size = int64(len(s.h.Target))
+
+	blk, err := s.h.encodeBlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
+		return nil, err
+	}
+	s.cid = blk.Cid()
+	s.store.Tx().Stage(s.cid)
+
+	log.Debugw("wrote public symlink", "name", s.name, "cid", s.cid.String(), "target", s.h.Target)
+	return PutResult{
+		Cid:  s.cid,
+		Size: s.h.Info.Size,
+		Type: base.NTSymlink,
+	}, nil
 }
 
 type LDFile struct {
@@ -1103,7 +1523,7 @@ func (df *LDFile) Put() (result base.PutResult, err error) {
 		}, nil
 	}
 
-	if df.cid.Defined() {
+	if df.cid.Defined() && !df.store.Tx().IsStaged(df.cid) {
 		df.previous = &df.cid
 	}
 	if df.info == nil {
@@ -1119,6 +1539,7 @@ func (df *LDFile) Put() (result base.PutResult, err error) {
 	if err = df.store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
 		return result, err
 	}
+	df.store.Tx().Stage(df.cid)
 
 	log.Debugw("wrote public data file", "name", df.name, "cid", df.cid.String())
 	return PutResult{
@@ -197,3 +197,12 @@ func mustFileContents(t *testing.T, dir *Tree, path, content string) {
 
 	assert.Equal(t, content, string(data))
 }
+
+func mustIsDir(t *testing.T, dir *Tree, path string) {
+	t.Helper()
+	n, err := dir.Get(base.MustPath(path))
+	require.Nil(t, err)
+	fi, err := n.Stat()
+	require.Nil(t, err)
+	assert.True(t, fi.IsDir(), "expected %q to be a directory", path)
+}
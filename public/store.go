@@ -14,11 +14,13 @@ import (
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	format "github.com/ipfs/go-ipld-format"
 	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
 	balanced "github.com/ipfs/go-unixfs/importer/balanced"
 	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
 	unixfsio "github.com/ipfs/go-unixfs/io"
 	multihash "github.com/multiformats/go-multihash"
 	base "github.com/qri-io/wnfs-go/base"
+	dagmod "github.com/qri-io/wnfs-go/dagmod"
 )
 
 // Store is a store of Content-Addressed block data indexed by merkle
@@ -29,6 +31,14 @@ type Store interface {
 	Blockservice() blockservice.BlockService
 	GetFile(ctx context.Context, root cid.Cid) (io.ReadCloser, error)
 	PutFile(f fs.File) (PutResult, error)
+	// EditFile opens the file DAG at root for in-place edits. An undefined root
+	// creates an empty file
+	EditFile(root cid.Cid) (*dagmod.Modifier, error)
+
+	// Tx returns the open transaction, nil if no transaction is in progress
+	Tx() *base.Tx
+	// SetTx opens a transaction. Passing nil closes the open transaction
+	SetTx(tx *base.Tx)
 }
 
 func NodeStore(n base.Node) (Store, error) {
@@ -47,6 +57,7 @@ type store struct {
 	ctx     context.Context
 	bserv   blockservice.BlockService
 	dagserv format.DAGService
+	tx      *base.Tx
 }
 
 var _ Store = (*store)(nil)
@@ -61,25 +72,21 @@ func NewStore(ctx context.Context, bserv blockservice.BlockService) Store {
 
 func (mds *store) Context() context.Context                { return mds.ctx }
 func (mds *store) Blockservice() blockservice.BlockService { return mds.bserv }
+func (mds *store) Tx() *base.Tx                            { return mds.tx }
+func (mds *store) SetTx(tx *base.Tx)                       { mds.tx = tx }
 
 func (mds *store) PutFile(f fs.File) (PutResult, error) {
 	// dserv := format.NewBufferedDAG(mds.ctx, mds.dagserv)
-	prefix, err := merkledag.PrefixForCidVersion(1)
+	builder, err := fileCidBuilder()
 	if err != nil {
 		return PutResult{}, err
 	}
-	prefix.MhType = multihash.SHA2_256
 
-	spl := chunker.NewSizeSplitter(f, 1024*256)
+	spl := chunker.NewSizeSplitter(f, fileChunkSize)
 	dbp := ihelper.DagBuilderParams{
-		Maxlinks: 1024,
-
-		CidBuilder: cidutil.InlineBuilder{
-			Builder: prefix,
-			Limit:   32,
-		},
-
-		Dagserv: mds.dagserv,
+		Maxlinks:   fileMaxLinks,
+		CidBuilder: builder,
+		Dagserv:    mds.dagserv,
 	}
 
 	db, err := dbp.New(spl)
@@ -103,6 +110,24 @@ func (mds *store) PutFile(f fs.File) (PutResult, error) {
 	}, nil
 }
 
+func (mds *store) EditFile(root cid.Cid) (*dagmod.Modifier, error) {
+	builder, err := fileCidBuilder()
+	if err != nil {
+		return nil, err
+	}
+	params := dagmod.Params{
+		ChunkSize:  fileChunkSize,
+		MaxLinks:   fileMaxLinks,
+		CidBuilder: builder,
+	}
+	codec := fileLeafCodec{builder: builder}
+
+	if !root.Defined() {
+		return dagmod.New(mds.ctx, mds.dagserv, codec, params), nil
+	}
+	return dagmod.Open(mds.ctx, mds.dagserv, root, codec, params)
+}
+
 func (mds *store) GetFile(ctx context.Context, root cid.Cid) (io.ReadCloser, error) {
 	ses := merkledag.NewSession(ctx, mds.dagserv)
 
@@ -114,6 +139,51 @@ func (mds *store) GetFile(ctx context.Context, root cid.Cid) (io.ReadCloser, err
 	return unixfsio.NewDagReader(ctx, nd, ses)
 }
 
+const (
+	// fileChunkSize is the number of content bytes in each file DAG leaf
+	fileChunkSize = 1024 * 256
+	// fileMaxLinks is the maximum number of children of a file DAG node
+	fileMaxLinks = 1024
+)
+
+func fileCidBuilder() (cid.Builder, error) {
+	prefix, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	prefix.MhType = multihash.SHA2_256
+
+	return cidutil.InlineBuilder{
+		Builder: prefix,
+		Limit:   32,
+	}, nil
+}
+
+// fileLeafCodec reads & writes the leaves PutFile creates
+type fileLeafCodec struct {
+	builder cid.Builder
+}
+
+var _ dagmod.Codec = (*fileLeafCodec)(nil)
+
+func (fileLeafCodec) Overhead() int { return 0 }
+
+func (fileLeafCodec) DecodeLeaf(nd format.Node) ([]byte, error) {
+	return unixfs.ReadUnixFSNodeData(nd)
+}
+
+func (c fileLeafCodec) EncodeLeaf(data []byte) (format.Node, error) {
+	fsn := unixfs.NewFSNode(unixfs.TFile)
+	fsn.SetData(data)
+	b, err := fsn.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	nd := merkledag.NodeWithData(b)
+	nd.SetCidBuilder(c.builder)
+	return nd, nil
+}
+
 // Copy blocks from src to dst
 func CopyBlocks(ctx context.Context, id cid.Cid, src, dst Store) error {
 	blk, err := src.Blockservice().GetBlock(ctx, id)
@@ -166,12 +236,17 @@ func (r PutResult) CID() cid.Cid {
 	return r.Cid
 }
 
+// isFileType reports whether nodes of type t are linked as files
+func isFileType(t base.NodeType) bool {
+	return t == base.NTFile || t == base.NTLDFile || t == base.NTSymlink || t == base.NTUnixFSFile
+}
+
 func (r PutResult) ToLink(name string) base.Link {
 	return base.Link{
 		Name:   name,
 		Cid:    r.Cid,
 		Size:   r.Size,
-		IsFile: (r.Type == base.NTFile || r.Type == base.NTLDFile),
+		IsFile: isFileType(r.Type),
 	}
 }
 
@@ -181,6 +256,6 @@ func (r PutResult) ToSkeletonInfo() SkeletonInfo {
 		Metadata:    r.Metadata,
 		Userland:    r.Userland,
 		SubSkeleton: r.Skeleton,
-		IsFile:      (r.Type == base.NTFile || r.Type == base.NTLDFile),
+		IsFile:      isFileType(r.Type),
 	}
 }
@@ -0,0 +1,357 @@
+package public
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfsio "github.com/ipfs/go-unixfs/io"
+	base "github.com/qri-io/wnfs-go/base"
+)
+
+// MountUnixFS links the UnixFS DAG rooted at id into the tree at path without
+// copying or re-chunking any data. Files are mounted as NTUnixFSFile nodes,
+// directories as read-only NTUnixFSDir nodes, and symlinks as WNFS symlinks.
+// Writing beneath a mounted directory converts it to a WNFS directory one
+// level at a time
+func (t *Tree) MountUnixFS(path base.Path, id cid.Cid) (res base.PutResult, err error) {
+	if len(path) == 0 || path[0] == "" {
+		return nil, errors.New("invalid path: empty")
+	}
+
+	head, tail := path.Shift()
+	if tail == nil {
+		if t.userland.Get(head) != nil {
+			return nil, fmt.Errorf("%q: %w", head, fs.ErrExist)
+		}
+		if res, err = mountUnixFS(context.TODO(), t.store, id); err != nil {
+			return nil, err
+		}
+	} else {
+		childDir, err := t.getOrCreateDirectChildTree(head)
+		if err != nil {
+			return nil, err
+		}
+
+		// recurse
+		if res, err = childDir.MountUnixFS(tail, id); err != nil {
+			return nil, err
+		}
+	}
+
+	t.updateUserlandLink(head, res)
+	return t.Put()
+}
+
+// mountUnixFS writes a header that points to the UnixFS DAG rooted at id
+func mountUnixFS(ctx context.Context, store Store, id cid.Cid) (PutResult, error) {
+	nd, err := merkledag.NewDAGService(store.Blockservice()).Get(ctx, id)
+	if err != nil {
+		return PutResult{}, fmt.Errorf("getting UnixFS root %s: %w", id, err)
+	}
+	h, err := unixFSHeader(nd)
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	blk, err := h.encodeBlock()
+	if err != nil {
+		return PutResult{}, err
+	}
+	if err := store.Blockservice().Blockstore().Put(ctx, blk); err != nil {
+		return PutResult{}, err
+	}
+	store.Tx().Stage(blk.Cid())
+
+	log.Debugw("mounted UnixFS", "type", h.Info.Type, "unixFSCid", id, "cid", blk.Cid())
+	res := PutResult{
+		Cid:  blk.Cid(),
+		Size: h.Info.Size,
+		Type: h.Info.Type,
+	}
+	if h.Userland != nil {
+		res.Userland = *h.Userland
+	}
+	return res, nil
+}
+
+// unixFSHeader constructs an unsaved header for a UnixFS node. Symlinks are
+// converted to WNFS symlinks, all other nodes link to the UnixFS node as
+// userland
+func unixFSHeader(nd format.Node) (*Header, error) {
+	id := nd.Cid()
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		h := &Header{Info: NewInfo(base.NTUnixFSFile), Userland: &id}
+		h.Info.Size = int64(len(n.RawData()))
+		return h, nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, fmt.Errorf("decoding UnixFS node %s: %w", id, err)
+		}
+		switch fsn.Type() {
+		case unixfs.TFile, unixfs.TRaw:
+			h := &Header{Info: NewInfo(base.NTUnixFSFile), Userland: &id}
+			h.Info.Size = int64(fsn.FileSize())
+			return h, nil
+		case unixfs.TDirectory, unixfs.THAMTShard:
+			size, err := nd.Size()
+			if err != nil {
+				return nil, err
+			}
+			h := &Header{Info: NewInfo(base.NTUnixFSDir), Userland: &id}
+			h.Info.Size = int64(size)
+			return h, nil
+		case unixfs.TSymlink:
+			return &Header{Info: NewInfo(base.NTSymlink), Target: string(fsn.Data())}, nil
+		default:
+			return nil, fmt.Errorf("unsupported UnixFS node type %s for %s", fsn.Type(), id)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a UnixFS node", id)
+	}
+}
+
+// UnixFSDir is a read-only directory backed by a UnixFS DAG
+type UnixFSDir struct {
+	store Store
+	name  string
+	cid   cid.Cid // header CID, undefined for directories nested within a mount
+	h     *Header // Userland is the UnixFS root
+	// links are the directory entries, listed on the first call to ReadDir
+	links []*format.Link
+	// offset is the number of entries ReadDir has returned
+	offset int
+}
+
+var (
+	_ base.Node      = (*UnixFSDir)(nil)
+	_ fs.ReadDirFile = (*UnixFSDir)(nil)
+)
+
+func (d *UnixFSDir) Name() string               { return d.name }
+func (d *UnixFSDir) Size() int64                { return d.h.Info.Size }
+func (d *UnixFSDir) ModTime() time.Time         { return time.Unix(d.h.Info.Mtime, 0) }
+func (d *UnixFSDir) Mode() fs.FileMode          { return fs.ModeDir | fs.FileMode(d.h.Info.Mode) }
+func (d *UnixFSDir) Type() base.NodeType        { return d.h.Info.Type }
+func (d *UnixFSDir) IsDir() bool                { return true }
+func (d *UnixFSDir) Sys() interface{}           { return d.store }
+func (d *UnixFSDir) Cid() cid.Cid               { return d.cid }
+func (d *UnixFSDir) UnixFSCid() cid.Cid         { return *d.h.Userland }
+func (d *UnixFSDir) Stat() (fs.FileInfo, error) { return d, nil }
+func (d *UnixFSDir) Close() error               { return nil }
+
+func (d *UnixFSDir) Read(p []byte) (n int, err error) {
+	return -1, errors.New("cannot read directory")
+}
+
+func (d *UnixFSDir) Metadata() (base.LDFile, error) {
+	return nil, base.ErrNoLink
+}
+
+func (d *UnixFSDir) History(ctx context.Context, maxRevs int) ([]base.HistoryEntry, error) {
+	return history(ctx, d, maxRevs)
+}
+
+func (d *UnixFSDir) AsHistoryEntry() base.HistoryEntry {
+	return base.HistoryEntry{
+		Cid:      d.cid,
+		Previous: d.h.Previous,
+		Mtime:    d.h.Info.Mtime,
+		Type:     d.h.Info.Type,
+		Size:     d.h.Info.Size,
+	}
+}
+
+func (d *UnixFSDir) directory(ctx context.Context) (unixfsio.Directory, error) {
+	dserv := merkledag.NewDAGService(d.store.Blockservice())
+	nd, err := dserv.Get(ctx, *d.h.Userland)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsio.NewDirectoryFromNode(dserv, nd)
+}
+
+// ReadDir reads entries following those returned by earlier calls. With
+// n > 0 ReadDir returns at most n entries, and io.EOF once none are left. With
+// n <= 0 ReadDir returns every remaining entry
+func (d *UnixFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	ctx := context.TODO()
+	if d.links == nil {
+		dir, err := d.directory(ctx)
+		if err != nil {
+			return nil, err
+		}
+		links := []*format.Link{}
+		err = dir.ForEachLink(ctx, func(l *format.Link) error {
+			links = append(links, l)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.links = links
+	}
+
+	links := d.links[d.offset:]
+	if n > 0 {
+		if len(links) == 0 {
+			return nil, io.EOF
+		}
+		if len(links) > n {
+			links = links[:n]
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(links))
+	for _, l := range links {
+		ch, err := d.child(ctx, l.Name, l.Cid)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, base.NewFSDirEntry(l.Name, !ch.IsDir()))
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
+
+// find loads the directory entry called name
+func (d *UnixFSDir) find(ctx context.Context, name string) (base.Node, error) {
+	dir, err := d.directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := dir.Find(ctx, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, base.ErrNotFound
+		}
+		return nil, err
+	}
+	return d.child(ctx, name, nd.Cid())
+}
+
+// child constructs a node for a link within this directory. Children don't
+// have headers of their own, and share this directory's timestamps & mode
+func (d *UnixFSDir) child(ctx context.Context, name string, id cid.Cid) (base.Node, error) {
+	nd, err := merkledag.NewDAGService(d.store.Blockservice()).Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	h, err := unixFSHeader(nd)
+	if err != nil {
+		return nil, err
+	}
+	h.Info.Mode = d.h.Info.Mode
+	h.Info.Ctime = d.h.Info.Ctime
+	h.Info.Mtime = d.h.Info.Mtime
+
+	switch h.Info.Type {
+	case base.NTUnixFSDir:
+		return &UnixFSDir{store: d.store, name: name, h: h}, nil
+	case base.NTSymlink:
+		return &Symlink{store: d.store, name: name, h: h}, nil
+	default:
+		return &File{store: d.store, name: name, h: h}, nil
+	}
+}
+
+// tree converts the mounted directory into an unsaved WNFS tree, mounting each
+// directory entry as a child. The mount becomes the previous version of the
+// returned tree
+func (d *UnixFSDir) tree(ctx context.Context) (*Tree, error) {
+	dir, err := d.directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewEmptyTree(d.store, d.name)
+	if d.cid.Defined() {
+		id := d.cid
+		t.h.Previous = &id
+	}
+
+	err = dir.ForEachLink(ctx, func(l *format.Link) error {
+		res, err := mountUnixFS(ctx, d.store, l.Cid)
+		if err != nil {
+			return err
+		}
+		t.updateUserlandLink(l.Name, res)
+		return nil
+	})
+	return t, err
+}
+
+// ExportUnixFS writes the public node n as a plain UnixFS DAG, returning the
+// root CID. File content is linked rather than copied. WNFS metadata &
+// history are not part of the export
+func ExportUnixFS(ctx context.Context, n base.Node) (cid.Cid, error) {
+	store, err := NodeStore(n)
+	if err != nil {
+		return cid.Undef, err
+	}
+	dserv := merkledag.NewDAGService(store.Blockservice())
+	return exportUnixFS(ctx, store, dserv, n)
+}
+
+func exportUnixFS(ctx context.Context, store Store, dserv format.DAGService, n base.Node) (cid.Cid, error) {
+	switch x := n.(type) {
+	case *File:
+		return *x.h.Userland, nil
+	case *UnixFSDir:
+		return *x.h.Userland, nil
+	case *LDFile:
+		if err := x.ensureContent(); err != nil {
+			return cid.Undef, err
+		}
+		res, err := store.PutFile(base.NewMemfileReader(x.name, bytes.NewReader(x.jsonContent.Bytes())))
+		if err != nil {
+			return cid.Undef, err
+		}
+		return res.Cid, nil
+	case *Symlink:
+		data, err := unixfs.SymlinkData(x.Target())
+		if err != nil {
+			return cid.Undef, err
+		}
+		nd := merkledag.NodeWithData(data)
+		nd.SetCidBuilder(merkledag.V1CidPrefix())
+		return nd.Cid(), dserv.Add(ctx, nd)
+	case *Tree:
+		dir := unixfsio.NewDirectory(dserv)
+		dir.SetCidBuilder(merkledag.V1CidPrefix())
+		for _, link := range x.userland.SortedSlice() {
+			ch, err := loadNode(ctx, store, link.Name, link.Cid)
+			if err != nil {
+				return cid.Undef, err
+			}
+			id, err := exportUnixFS(ctx, store, dserv, ch)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("exporting %q: %w", link.Name, err)
+			}
+			chNd, err := dserv.Get(ctx, id)
+			if err != nil {
+				return cid.Undef, err
+			}
+			if err := dir.AddChild(ctx, link.Name, chNd); err != nil {
+				return cid.Undef, err
+			}
+		}
+		nd, err := dir.GetNode()
+		if err != nil {
+			return cid.Undef, err
+		}
+		return nd.Cid(), dserv.Add(ctx, nd)
+	default:
+		return cid.Undef, fmt.Errorf("cannot export %T as UnixFS", n)
+	}
+}
@@ -0,0 +1,115 @@
+package public
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	base "github.com/qri-io/wnfs-go/base"
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestUnixFSRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+
+	src := NewEmptyTree(store, "src")
+	_, err := src.Add(base.MustPath("hello.txt"), base.NewMemfileBytes("hello.txt", []byte("hello!")))
+	require.Nil(t, err)
+	_, err = src.Add(base.MustPath("sub/goodbye.txt"), base.NewMemfileBytes("goodbye.txt", []byte("goodbye!")))
+	require.Nil(t, err)
+	_, err = src.Symlink("sub/goodbye.txt", base.MustPath("link"))
+	require.Nil(t, err)
+
+	id, err := ExportUnixFS(ctx, src)
+	require.Nil(t, err)
+
+	root := NewEmptyTree(store, "")
+	_, err = root.MountUnixFS(base.MustPath("mnt"), id)
+	require.Nil(t, err)
+
+	_, err = root.MountUnixFS(base.MustPath("mnt"), id)
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	f, err := root.Get(base.MustPath("mnt"))
+	require.Nil(t, err)
+	mnt, ok := f.(*UnixFSDir)
+	require.True(t, ok, "expected mount to be a *UnixFSDir, got %T", f)
+	assert.Equal(t, id, mnt.UnixFSCid())
+
+	ents, err := mnt.ReadDir(-1)
+	require.Nil(t, err)
+	names := make([]string, 0, len(ents))
+	for _, ent := range ents {
+		names = append(names, ent.Name())
+	}
+	assert.Equal(t, []string{"hello.txt", "link", "sub"}, names)
+
+	// reading in pages continues where the last page ended
+	f, err = root.Get(base.MustPath("mnt"))
+	require.Nil(t, err)
+	paged := f.(*UnixFSDir)
+	names = names[:0]
+	for {
+		ents, err := paged.ReadDir(2)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.Nil(t, err)
+		require.NotEmpty(t, ents)
+		for _, ent := range ents {
+			names = append(names, ent.Name())
+		}
+	}
+	assert.Equal(t, []string{"hello.txt", "link", "sub"}, names)
+	ents, err = paged.ReadDir(0)
+	require.Nil(t, err)
+	assert.Empty(t, ents)
+
+	mustFileContents(t, root, "mnt/hello.txt", "hello!")
+	mustFileContents(t, root, "mnt/sub/goodbye.txt", "goodbye!")
+	mustFileContents(t, root, "mnt/link", "goodbye!")
+
+	target, err := root.Readlink(base.MustPath("mnt/link"))
+	require.Nil(t, err)
+	assert.Equal(t, "sub/goodbye.txt", target)
+
+	t.Run("copy_on_write", func(t *testing.T) {
+		_, err := root.Add(base.MustPath("mnt/sub/new.txt"), base.NewMemfileBytes("new.txt", []byte("new")))
+		require.Nil(t, err)
+
+		f, err := root.Get(base.MustPath("mnt"))
+		require.Nil(t, err)
+		mnt, ok := f.(*Tree)
+		require.True(t, ok, "expected written mount to be a *Tree, got %T", f)
+		mustDirChildren(t, mnt, []string{"hello.txt", "link", "sub"})
+
+		mustFileContents(t, root, "mnt/hello.txt", "hello!")
+		mustFileContents(t, root, "mnt/sub/goodbye.txt", "goodbye!")
+		mustFileContents(t, root, "mnt/sub/new.txt", "new")
+		mustFileContents(t, root, "mnt/link", "goodbye!")
+
+		// mounted file content is linked, not copied
+		f, err = root.Get(base.MustPath("mnt/hello.txt"))
+		require.Nil(t, err)
+		assert.Equal(t, base.NTUnixFSFile, f.(base.Node).Type())
+	})
+
+	t.Run("export_mount", func(t *testing.T) {
+		mnt, err := root.Get(base.MustPath("mnt"))
+		require.Nil(t, err)
+		exported, err := ExportUnixFS(ctx, mnt.(base.Node))
+		require.Nil(t, err)
+
+		_, err = root.MountUnixFS(base.MustPath("again"), exported)
+		require.Nil(t, err)
+		mustFileContents(t, root, "again/hello.txt", "hello!")
+		mustFileContents(t, root, "again/sub/new.txt", "new")
+		mustFileContents(t, root, "again/link", "goodbye!")
+	})
+}
@@ -0,0 +1,121 @@
+package wnfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	base "github.com/qri-io/wnfs-go/base"
+	private "github.com/qri-io/wnfs-go/private"
+)
+
+// ShareExchangeDir is the public directory shares are written to. Each
+// recipient has a subdirectory named by their encoded public exchange key
+const ShareExchangeDir = FileHierarchyNamePublic + "/.well-known/exchange"
+
+// ExchangeKey is an X25519 key pair for receiving shares
+type ExchangeKey = private.ExchangeKey
+
+var NewExchangeKey = private.NewExchangeKey
+
+// SnapshotPointer grants read access to a single revision of a private node
+type SnapshotPointer = private.SnapshotPointer
+
+var DecodeSnapshotPointer = private.DecodeSnapshotPointer
+
+// Share grants the holder of the private half of recipient read access to the
+// private file or directory at path & everything below it, including later
+// revisions. The share is encrypted to recipient & written to the public file
+// SharePath(recipient, name), commit fsys to publish it. path must be
+// committed, uncommitted changes to it aren't shared
+func Share(fsys WNFS, path string, recipient Key, name string) error {
+	if name == "" || name == "." || filepath.Base(name) != name {
+		return fmt.Errorf("invalid share name %q", name)
+	}
+	if err := checkSharePath(path); err != nil {
+		return err
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ptr, err := private.NewSharePointer(f)
+	if err != nil {
+		return fmt.Errorf("sharing %q: %w", path, err)
+	}
+	sealed, err := ptr.Seal(recipient)
+	if err != nil {
+		return err
+	}
+	return fsys.Write(SharePath(recipient, name), base.NewMemfileBytes(name, sealed))
+}
+
+// Snapshot creates a pointer that reads the committed revision of the private
+// file or directory at path & everything below it. Unlike a share, holders of
+// the pointer can't read revisions written after it was created. Export the
+// pointer with its Encode method
+func Snapshot(fsys WNFS, path string) (p SnapshotPointer, err error) {
+	if err := checkSharePath(path); err != nil {
+		return p, err
+	}
+	f, err := fsys.Open(path)
+	if err != nil {
+		return p, err
+	}
+	defer f.Close()
+	if p, err = private.NewSnapshotPointer(f); err != nil {
+		return p, fmt.Errorf("snapshotting %q: %w", path, err)
+	}
+	return p, nil
+}
+
+// LoadSnapshot opens the revision p points to. fsys only supplies blocks, so
+// it doesn't need to be opened with its private key, but must hold the
+// revision. The returned filesystem is read-only
+func LoadSnapshot(ctx context.Context, fsys WNFS, p SnapshotPointer) (fs.FS, error) {
+	sfs, ok := fsys.(*fileSystem)
+	if !ok {
+		return nil, fmt.Errorf("not a wnfs filesystem")
+	}
+	return private.LoadSnapshotFS(ctx, sfs.root.pstore, p)
+}
+
+// checkSharePath errors if path isn't within the private hierarchy
+func checkSharePath(path string) error {
+	p, err := base.NewPath(path)
+	if err != nil {
+		return err
+	}
+	if p[0] != FileHierarchyNamePrivate || len(p) < 2 {
+		return fmt.Errorf("only paths within /%s can be shared", FileHierarchyNamePrivate)
+	}
+	return nil
+}
+
+// SharePath is the path of the share with name for recipient
+func SharePath(recipient Key, name string) string {
+	return ShareExchangeDir + "/" + recipient.Encode() + "/" + name
+}
+
+// LoadSharedTree opens the share with name written to fsys by Share for the
+// public half of key. The sharing filesystem doesn't need to be opened with
+// its private key. The returned filesystem is read-only & shows the latest
+// revision of the shared node in fsys
+func LoadSharedTree(ctx context.Context, fsys WNFS, key ExchangeKey, name string) (fs.FS, error) {
+	sfs, ok := fsys.(*fileSystem)
+	if !ok {
+		return nil, fmt.Errorf("not a wnfs filesystem")
+	}
+	sealed, err := fsys.Cat(SharePath(key.Public, name))
+	if err != nil {
+		return nil, fmt.Errorf("reading share %q: %w", name, err)
+	}
+	ptr, err := private.OpenSharePointer(sealed, key)
+	if err != nil {
+		return nil, err
+	}
+	return private.LoadSharedFS(ctx, sfs.root.pstore, ptr)
+}
@@ -0,0 +1,93 @@
+package wnfs
+
+import (
+	"context"
+	"fmt"
+
+	blockservice "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	base "github.com/qri-io/wnfs-go/base"
+	dagsync "github.com/qri-io/wnfs-go/dagsync"
+	private "github.com/qri-io/wnfs-go/private"
+	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
+)
+
+// Push sends the blocks of the last commit of fsys that remote doesn't have,
+// including the history of the commit & every private node revision
+func Push(ctx context.Context, fsys WNFS, remote dagsync.Remote) (stats dagsync.Stats, err error) {
+	a, ok := fsys.(*fileSystem)
+	if !ok {
+		return stats, fmt.Errorf("not a wnfs filesystem")
+	}
+	root := a.root.tx
+	if !root.Defined() {
+		return stats, fmt.Errorf("filesystem has no commits to push")
+	}
+
+	bs := a.store.Blockservice().Blockstore()
+	headers, err := privateHeaders(ctx, bs, root)
+	if err != nil {
+		return stats, err
+	}
+	return dagsync.Push(ctx, bs, remote, append([]cid.Cid{root}, headers...)...)
+}
+
+// Fetch copies the blocks of the filesystem committed at root that bserv
+// doesn't have from remote, including the history of the commit & every
+// private node revision
+func Fetch(ctx context.Context, bserv blockservice.BlockService, remote dagsync.Remote, root cid.Cid) (stats dagsync.Stats, err error) {
+	bs := bserv.Blockstore()
+	// private node headers are only listed in the HAMT, which must arrive
+	// before they can be found
+	if stats, err = dagsync.Pull(ctx, bs, remote, root); err != nil {
+		return stats, err
+	}
+	headers, err := privateHeaders(ctx, bs, root)
+	if err != nil {
+		return stats, err
+	}
+	hstats, err := dagsync.Pull(ctx, bs, remote, headers...)
+	return stats.Add(hstats), err
+}
+
+// Pull fetches the filesystem committed at root from remote & merges it into
+// fsys. rootKey & rootName open the private hierarchy of the fetched
+// filesystem. Only blocks the store of fsys doesn't have are transferred
+func Pull(ctx context.Context, fsys WNFS, remote dagsync.Remote, root cid.Cid, rootKey Key, rootName PrivateName, opts ...base.MergeOption) (result MergeResult, stats dagsync.Stats, err error) {
+	a, ok := fsys.(*fileSystem)
+	if !ok {
+		return result, stats, fmt.Errorf("not a wnfs filesystem")
+	}
+
+	bserv := a.store.Blockservice()
+	if stats, err = Fetch(ctx, bserv, remote, root); err != nil {
+		return result, stats, fmt.Errorf("fetching %s: %w", root, err)
+	}
+	b, err := FromCID(ctx, bserv, ratchet.NewMemStore(ctx), root, rootKey, rootName)
+	if err != nil {
+		return result, stats, fmt.Errorf("opening %s: %w", root, err)
+	}
+	result, err = Merge(ctx, a, b, opts...)
+	return result, stats, err
+}
+
+// privateHeaders lists the header CIDs in the private HAMT of the root
+func privateHeaders(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) ([]cid.Cid, error) {
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		return nil, fmt.Errorf("loading root %s: %w", root, err)
+	}
+	h, err := decodeRootHeader(blk)
+	if err != nil {
+		return nil, err
+	}
+	if h.Private == nil {
+		return nil, nil
+	}
+	hamt, err := private.LoadHAMT(ctx, bs, *h.Private)
+	if err != nil {
+		return nil, fmt.Errorf("loading private HAMT: %w", err)
+	}
+	return hamt.Cids(ctx)
+}
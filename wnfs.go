@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	gopath "path"
+	"strings"
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -22,6 +24,26 @@ import (
 
 var log = golog.Logger("wnfs")
 
+// ErrCrossHierarchyMove is returned when moving a node between the public &
+// private file hierarchies. Nodes must be written to the destination instead
+var ErrCrossHierarchyMove = errors.New("cannot move between public and private file hierarchies")
+
+// ErrCrossHierarchySymlink is returned when creating a symlink with an
+// absolute target outside of the link's file hierarchy
+var ErrCrossHierarchySymlink = errors.New("symlink target must be in the same file hierarchy as the link")
+
+// ErrBatchInProgress is returned when opening a batch while another batch is
+// still open
+var ErrBatchInProgress = errors.New("batch already in progress")
+
+// ErrNoPrivateRoot is returned when reading private root details of a
+// filesystem without a private file hierarchy, or opened without a root key
+var ErrNoPrivateRoot = errors.New("filesystem has no private root")
+
+// ErrUnixFSNotPublic is returned when mounting or exporting UnixFS outside of
+// the public file hierarchy
+var ErrUnixFSNotPublic = errors.New("UnixFS mounts & exports are only supported in the public file hierarchy")
+
 const (
 	// PreviousLinkName is the string for a historical backpointer in wnfs
 	PreviousLinkName = "previous"
@@ -42,6 +64,13 @@ type WNFS interface {
 	Cid() cid.Cid
 	History(ctx context.Context, pathStr string, generations int) ([]HistoryEntry, error)
 	Commit() (CommitResult, error)
+	Rollback() error
+	Batch(fn func(tx PosixFS) error) error
+	Stage(fn func(tx PosixFS) error) error
+
+	// UnixFS interop
+	MountUnixFS(pathStr string, id cid.Cid) error
+	ExportUnixFS(pathStr string) (cid.Cid, error)
 }
 
 type PosixFS interface {
@@ -53,9 +82,14 @@ type PosixFS interface {
 	Write(pathStr string, f fs.File) error
 	Cat(pathStr string) ([]byte, error)
 	Open(pathStr string) (fs.File, error)
+	OpenFile(pathStr string, flag int) (WritableFile, error)
+
+	// links
+	Symlink(target, linkPathStr string) error
+	Readlink(pathStr string) (string, error)
 
 	// general
-	// Mv(from, to string) error
+	Mv(from, to string) error
 	Cp(pathStr, srcPathStr string, src fs.FS) error
 	Rm(pathStr string) error
 }
@@ -63,15 +97,30 @@ type PosixFS interface {
 type (
 	Node         = base.Node
 	HistoryEntry = base.HistoryEntry
+	WritableFile = base.WritableFile
 	PrivateName  = private.Name
 	Key          = private.Key
+	Cipher       = private.Cipher
 )
 
-var NewKey = private.NewKey
+var (
+	NewKey      = private.NewKey
+	ParseCipher = private.ParseCipher
+)
+
+// Cipher suites private revisions can be encrypted with
+const (
+	CipherAESGCM            = private.CipherAESGCM
+	CipherChaCha20Poly1305  = private.CipherChaCha20Poly1305
+	CipherXChaCha20Poly1305 = private.CipherXChaCha20Poly1305
+)
 
 type PrivateFS interface {
 	RootKey() private.Key
 	PrivateName() (PrivateName, error)
+	Revoke(pathStr string) error
+	// SetCipher changes the cipher suite private revisions are written with
+	SetCipher(c Cipher)
 }
 
 type fileSystem struct {
@@ -147,11 +196,19 @@ func (fsys *fileSystem) ReadDir(n int) ([]fs.DirEntry, error) {
 	return fsys.root.ReadDir(n)
 }
 
+// RootKey returns the key of the private root, or an empty key if the
+// filesystem has no private root
 func (fsys *fileSystem) RootKey() Key {
+	if fsys.root.Private == nil {
+		return Key{}
+	}
 	return fsys.root.Private.Key()
 }
 
 func (fsys *fileSystem) PrivateName() (PrivateName, error) {
+	if fsys.root.Private == nil {
+		return "", ErrNoPrivateRoot
+	}
 	pn, err := fsys.root.Private.PrivateName()
 	if err != nil {
 		return "", err
@@ -159,6 +216,30 @@ func (fsys *fileSystem) PrivateName() (PrivateName, error) {
 	return pn, nil
 }
 
+// Revoke rotates the keys of the private node at pathStr & everything below
+// it. Anyone holding an old key, name or ratchet of a rotated node, including
+// share recipients, can't read revisions written after the rotation
+func (fsys *fileSystem) Revoke(pathStr string) error {
+	log.Debugw("fileSystem.Revoke", "pathStr", pathStr)
+	tree, relPath, err := fsys.fsHierarchyDirectoryNode(pathStr)
+	if err != nil {
+		return err
+	}
+	root, ok := tree.(*private.Root)
+	if !ok {
+		return fmt.Errorf("only paths within /%s can be revoked", FileHierarchyNamePrivate)
+	}
+	_, err = root.Revoke(relPath)
+	return err
+}
+
+// SetCipher changes the cipher suite private revisions are written with.
+// Revisions record their cipher, so stored revisions stay readable. A
+// filesystem opened from a CID starts with the cipher of its private root
+func (fsys *fileSystem) SetCipher(c Cipher) {
+	fsys.root.pstore.SetCipher(c)
+}
+
 func (fsys *fileSystem) Ls(pathStr string) ([]fs.DirEntry, error) {
 	log.Debugw("fileSystem.Ls", "pathStr", pathStr)
 	tree, path, err := fsys.fsHierarchyDirectoryNode(pathStr)
@@ -205,6 +286,19 @@ func (fsys *fileSystem) Open(pathStr string) (fs.File, error) {
 	return tree.Get(path)
 }
 
+// OpenFile opens a file for editing. flag is a combination of os package O_*
+// flags & must include os.O_WRONLY or os.O_RDWR. Edits are written when the
+// returned file is closed
+func (fsys *fileSystem) OpenFile(pathStr string, flag int) (WritableFile, error) {
+	log.Debugw("fileSystem.OpenFile", "pathStr", pathStr, "flag", flag)
+	tree, path, err := fsys.fsHierarchyDirectoryNode(pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.OpenFile(path, flag)
+}
+
 func (fsys *fileSystem) Cat(pathStr string) ([]byte, error) {
 	f, err := fsys.Open(pathStr)
 	if err != nil {
@@ -255,6 +349,108 @@ func (fsys *fileSystem) Rm(pathStr string) error {
 	return err
 }
 
+func (fsys *fileSystem) Mv(from, to string) error {
+	log.Debugw("fileSystem.Mv", "from", from, "to", to)
+	fromTree, fromPath, err := fsys.fsHierarchyDirectoryNode(from)
+	if err != nil {
+		return err
+	}
+	toTree, toPath, err := fsys.fsHierarchyDirectoryNode(to)
+	if err != nil {
+		return err
+	}
+	if fromTree != toTree {
+		return ErrCrossHierarchyMove
+	}
+
+	_, err = fromTree.Mv(fromPath, toPath)
+	return err
+}
+
+// Symlink creates a symlink at linkPathStr that points to target. Relative
+// targets are resolved from the directory containing the link. Absolute
+// targets must be within the same file hierarchy as the link
+func (fsys *fileSystem) Symlink(target, linkPathStr string) error {
+	log.Debugw("fileSystem.Symlink", "target", target, "linkPathStr", linkPathStr)
+	tree, relPath, err := fsys.fsHierarchyDirectoryNode(linkPathStr)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(target, "/") {
+		targetTree, targetPath, err := fsys.fsHierarchyDirectoryNode(target)
+		if err != nil {
+			return err
+		}
+		if targetTree != tree {
+			return ErrCrossHierarchySymlink
+		}
+		// trees store absolute targets relative to the hierarchy root
+		target = "/" + targetPath.String()
+	}
+
+	_, err = tree.Symlink(target, relPath)
+	return err
+}
+
+func (fsys *fileSystem) Readlink(pathStr string) (string, error) {
+	log.Debugw("fileSystem.Readlink", "pathStr", pathStr)
+	tree, relPath, err := fsys.fsHierarchyDirectoryNode(pathStr)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := tree.Readlink(relPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(target, "/") {
+		hierarchy, _ := base.MustPath(pathStr).Shift()
+		target = "/" + hierarchy + strings.TrimSuffix(target, "/")
+	}
+	return target, nil
+}
+
+// MountUnixFS links the UnixFS DAG rooted at id into the public file
+// hierarchy at pathStr. Mounted data is referenced, not copied
+func (fsys *fileSystem) MountUnixFS(pathStr string, id cid.Cid) error {
+	log.Debugw("fileSystem.MountUnixFS", "pathStr", pathStr, "id", id)
+	tree, relPath, err := fsys.fsHierarchyDirectoryNode(pathStr)
+	if err != nil {
+		return err
+	}
+	pub, ok := tree.(*public.Tree)
+	if !ok {
+		return ErrUnixFSNotPublic
+	}
+
+	_, err = pub.MountUnixFS(relPath, id)
+	return err
+}
+
+// ExportUnixFS writes the public node at pathStr as a plain UnixFS DAG,
+// returning the root CID
+func (fsys *fileSystem) ExportUnixFS(pathStr string) (cid.Cid, error) {
+	log.Debugw("fileSystem.ExportUnixFS", "pathStr", pathStr)
+	tree, relPath, err := fsys.fsHierarchyDirectoryNode(pathStr)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if _, ok := tree.(*public.Tree); !ok {
+		return cid.Undef, ErrUnixFSNotPublic
+	}
+
+	f, err := tree.Get(relPath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	n, ok := f.(base.Node)
+	if !ok {
+		return cid.Undef, fmt.Errorf("path %q is not a node", pathStr)
+	}
+	return public.ExportUnixFS(fsys.ctx, n)
+}
+
 func (fsys *fileSystem) History(ctx context.Context, pathStr string, max int) ([]HistoryEntry, error) {
 	if pathStr == "." || pathStr == "" {
 		return fsys.root.history(max)
@@ -291,6 +487,9 @@ func (fsys *fileSystem) fsHierarchyDirectoryNode(pathStr string) (dir base.Tree,
 	case FileHierarchyNamePublic:
 		return fsys.root.Public, tail, nil
 	case FileHierarchyNamePrivate:
+		if fsys.root.Private == nil {
+			return nil, path, ErrNoPrivateRoot
+		}
 		return fsys.root.Private, tail, nil
 	// case FileHierarchyNamePretty:
 	// 	return fsys.root.Pretty, relPath, nil
@@ -299,6 +498,60 @@ func (fsys *fileSystem) fsHierarchyDirectoryNode(pathStr string) (dir base.Tree,
 	}
 }
 
+// Batch stages all changes made by fn, writing them with a single Commit once
+// fn returns. Nodes written multiple times within fn get one history entry, and
+// the private HAMT is written once. If fn returns an error the filesystem is
+// rolled back to its state before Batch was called, and the error returned
+func (fsys *fileSystem) Batch(fn func(tx PosixFS) error) error {
+	log.Debugw("fileSystem.Batch")
+	if err := fsys.Stage(fn); err != nil {
+		return err
+	}
+	_, err := fsys.Commit()
+	return err
+}
+
+// Stage applies all changes made by fn in a batch without committing them.
+// Staged changes are written by the next Commit, and discarded by Rollback. If
+// fn returns an error only the changes made by fn are rolled back, and the
+// error returned
+func (fsys *fileSystem) Stage(fn func(tx PosixFS) error) error {
+	log.Debugw("fileSystem.Stage")
+	if err := fsys.root.openBatch(); err != nil {
+		return err
+	}
+
+	if err := fn(fsys); err != nil {
+		log.Debugw("fileSystem.Stage rolling back", "err", err)
+		if rbErr := fsys.root.rollbackBatch(fsys.ctx); rbErr != nil {
+			return fmt.Errorf("%w\nrolling back batch: %s", err, rbErr)
+		}
+		return err
+	}
+
+	return fsys.root.flushBatch()
+}
+
+// Rollback discards all changes made since the last commit. Calling Rollback
+// while a batch is open discards changes made since the batch began
+func (fsys *fileSystem) Rollback() error {
+	log.Debugw("fileSystem.Rollback", "tx", fsys.root.tx)
+	if fsys.root.batch != nil {
+		return fsys.root.rollbackBatch(fsys.ctx)
+	}
+	if !fsys.root.tx.Defined() {
+		return fmt.Errorf("no commit to roll back to")
+	}
+
+	root, err := loadRoot(fsys.ctx, fsys.store, fsys.root.pstore.RatchetStore(), fsys.root.tx, fsys.root.rootKey, fsys.root.txName)
+	if err != nil {
+		return fmt.Errorf("loading root %s: %w", fsys.root.tx, err)
+	}
+	root.pstore.SetCipher(fsys.root.pstore.Cipher())
+	fsys.root = root
+	return nil
+}
+
 type CommitResult struct {
 	Root        cid.Cid
 	PrivateName *PrivateName
@@ -309,6 +562,13 @@ func (fsys *fileSystem) Commit() (res CommitResult, err error) {
 	if err = fsys.root.Commit(); err != nil {
 		return res, err
 	}
+	return fsys.commitResult()
+}
+
+func (fsys *fileSystem) commitResult() (res CommitResult, err error) {
+	if fsys.root.Private == nil {
+		return CommitResult{Root: fsys.root.id}, nil
+	}
 
 	pn, err := fsys.PrivateName()
 	if err != nil {
@@ -327,6 +587,7 @@ func (fsys *fileSystem) Commit() (res CommitResult, err error) {
 type rootHeader struct {
 	Info     *public.Info
 	Previous *cid.Cid
+	Merge    *cid.Cid // merged root, populated on merge commits
 	Metadata *cid.Cid
 	Pretty   *cid.Cid
 	Public   *cid.Cid
@@ -338,6 +599,7 @@ func (h *rootHeader) encodeBlock() (blocks.Block, error) {
 		"info":               h.Info.Map(),
 		"metadata":           h.Metadata,
 		"previous":           h.Previous,
+		"merge":              h.Merge,
 		base.PublicLinkName:  h.Public,
 		base.PrivateLinkName: h.Private,
 	}
@@ -367,6 +629,8 @@ func decodeRootHeader(blk blocks.Block) (*rootHeader, error) {
 		switch l.Name {
 		case base.PreviousLinkName:
 			h.Previous = &l.Cid
+		case base.MergeLinkName:
+			h.Merge = &l.Cid
 		case base.PublicLinkName:
 			h.Public = &l.Cid
 		case base.PrivateLinkName:
@@ -385,8 +649,10 @@ type rootTree struct {
 	store   public.Store
 	pstore  private.Store
 	id      cid.Cid
-	tx      cid.Cid // transaction start CID
-	rootKey Key
+	tx      cid.Cid     // transaction start CID
+	txName  PrivateName // private root name at transaction start
+	batch   *batch      // open batch, nil when no batch is in progress
+	rootKey Key         // private root key at transaction start
 
 	h *rootHeader
 
@@ -424,7 +690,7 @@ func newEmptyRootTree(store public.Store, rs ratchet.Store, rootKey Key) (root *
 }
 
 func loadRoot(ctx context.Context, store public.Store, rs ratchet.Store, id cid.Cid, rootKey Key, rootName PrivateName) (r *rootTree, err error) {
-	r = &rootTree{store: store, id: id, tx: id, rootKey: rootKey}
+	r = &rootTree{store: store, id: id, tx: id, txName: rootName, rootKey: rootKey}
 
 	blk, err := store.Blockservice().GetBlock(ctx, id)
 	if err != nil {
@@ -443,20 +709,19 @@ func loadRoot(ctx context.Context, store public.Store, rs ratchet.Store, id cid.
 		r.Public = public.NewEmptyTree(store, FileHierarchyNamePublic)
 	}
 
+	hamt := cid.Undef
+	if r.h.Private != nil {
+		hamt = *r.h.Private
+	}
+	if r.pstore, err = private.LoadStore(ctx, store.Blockservice(), rs, hamt); err != nil {
+		return nil, err
+	}
+	// roots without a private tree, or opened without a key have no private root
 	if r.h.Private != nil && !rootKey.IsEmpty() {
-		if r.pstore, err = private.LoadStore(ctx, store.Blockservice(), rs, *r.h.Private); err != nil {
-			return nil, err
-		}
-		if r.Private, err = private.LoadRoot(store.Context(), r.pstore, FileHierarchyNamePrivate, rootKey, rootName); err != nil {
-			return nil, fmt.Errorf("opening private root:\n%w", err)
-		}
-	} else {
-		if r.pstore, err = private.LoadStore(ctx, store.Blockservice(), rs, cid.Undef); err != nil {
-			return nil, err
-		}
 		if r.Private, err = private.LoadRoot(store.Context(), r.pstore, FileHierarchyNamePrivate, rootKey, rootName); err != nil {
 			return nil, fmt.Errorf("opening private root:\n%w", err)
 		}
+		r.pstore.SetCipher(r.Private.Cipher())
 	}
 
 	return r, nil
@@ -498,13 +763,123 @@ func (r *rootTree) Put() (result base.PutResult, err error) {
 }
 
 func (r *rootTree) Commit() error {
+	if r.batch != nil {
+		if err := r.flushBatch(); err != nil {
+			return err
+		}
+	}
+
+	return r.commit(nil)
+}
+
+// commit writes the root as the next version of the last commit. merge is the
+// CID of a root merged into this one, nil for regular commits
+func (r *rootTree) commit(merge *cid.Cid) error {
 	if r.tx.Defined() {
 		r.h.Previous = &r.tx
 	}
+	r.h.Merge = merge
+	if merge == nil {
+		// conflict records only describe the merge commit they're written with
+		if err := r.setMergeConflicts(nil); err != nil {
+			return err
+		}
+	}
 	if _, err := r.Put(); err != nil {
 		return err
 	}
 	r.tx = r.id
+	r.txName = ""
+	if r.Private != nil {
+		pn, err := r.Private.PrivateName()
+		if err != nil {
+			return err
+		}
+		r.txName = pn
+		r.rootKey = r.Private.Key()
+	}
+	return nil
+}
+
+// batch records the state of the root tree when a batch was opened
+type batch struct {
+	tx          *base.Tx
+	public      cid.Cid
+	hamt        cid.Cid
+	privateName PrivateName
+	privateKey  Key
+}
+
+func (r *rootTree) openBatch() (err error) {
+	if r.batch != nil {
+		return ErrBatchInProgress
+	}
+
+	b := &batch{
+		tx:     base.NewTx(),
+		public: r.Public.Cid(),
+	}
+	if r.Private != nil {
+		b.hamt = r.Private.Cid()
+		b.privateKey = r.Private.Key()
+		if b.privateName, err = r.Private.PrivateName(); err != nil {
+			return err
+		}
+	} else if r.h.Private != nil {
+		b.hamt = *r.h.Private
+	}
+
+	r.batch = b
+	r.store.SetTx(b.tx)
+	r.pstore.SetTx(b.tx)
+	return nil
+}
+
+func (r *rootTree) closeBatch() {
+	r.batch = nil
+	r.store.SetTx(nil)
+	r.pstore.SetTx(nil)
+}
+
+// flushBatch closes the open batch, writing the private HAMT changes staged
+// within it
+func (r *rootTree) flushBatch() error {
+	r.closeBatch()
+	if r.Private != nil {
+		return r.Private.Flush()
+	}
+	return nil
+}
+
+// rollbackBatch closes the open batch, reloading public & private trees from
+// their state when the batch was opened. The root itself isn't written within a
+// batch, so its CID is unaffected
+func (r *rootTree) rollbackBatch(ctx context.Context) (err error) {
+	b := r.batch
+	r.closeBatch()
+
+	// public trees that were never written have no CID to reload from
+	pub := public.NewEmptyTree(r.store, FileHierarchyNamePublic)
+	if b.public.Defined() {
+		if pub, err = public.LoadTree(ctx, r.store, FileHierarchyNamePublic, b.public); err != nil {
+			return fmt.Errorf("opening /%s tree %s:\n%w", FileHierarchyNamePublic, b.public, err)
+		}
+	}
+	pstore, err := private.LoadStore(ctx, r.store.Blockservice(), r.pstore.RatchetStore(), b.hamt)
+	if err != nil {
+		return err
+	}
+	pstore.SetCipher(r.pstore.Cipher())
+	var priv *private.Root
+	if b.privateName != "" {
+		if priv, err = private.LoadRoot(ctx, pstore, FileHierarchyNamePrivate, b.privateKey, b.privateName); err != nil {
+			return fmt.Errorf("opening private root:\n%w", err)
+		}
+	}
+
+	r.Public = pub
+	r.pstore = pstore
+	r.Private = priv
 	return nil
 }
 
@@ -533,8 +908,10 @@ func (r *rootTree) Links() base.Links {
 	links := base.NewLinks(
 		// base.Link{Cid: r.Pretty, Size: r.Pretty.Size(), Name: FileHierarchyNamePretty},
 		base.Link{Cid: r.Public.Cid(), Size: r.Public.Size(), Name: FileHierarchyNamePublic},
-		base.Link{Cid: r.Private.Cid(), Size: r.Private.Size(), Name: FileHierarchyNamePrivate},
 	)
+	if r.Private != nil {
+		links.Add(base.Link{Cid: r.Private.Cid(), Size: r.Private.Size(), Name: FileHierarchyNamePrivate})
+	}
 
 	if r.h.Previous != nil && !r.id.Equals(cid.Undef) {
 		links.Add(base.Link{Cid: *r.h.Previous, Name: PreviousLinkName})
@@ -584,6 +961,22 @@ func (r *rootTree) Rm(path base.Path) (res base.PutResult, err error) {
 	return nil, fmt.Errorf("cannot remove directory from root")
 }
 
+func (r *rootTree) Symlink(target string, path base.Path) (res base.PutResult, err error) {
+	return nil, fmt.Errorf("cannot create symlink within root directory, only /public or /private")
+}
+
+func (r *rootTree) Readlink(path base.Path) (string, error) {
+	return "", fmt.Errorf("%q: %w", path, base.ErrNotSymlink)
+}
+
+func (r *rootTree) Mv(from, to base.Path) (res base.PutResult, err error) {
+	return nil, fmt.Errorf("cannot move within root directory, only /public or /private")
+}
+
+func (r *rootTree) OpenFile(path base.Path, flag int) (base.WritableFile, error) {
+	return nil, fmt.Errorf("cannot open files within root directory, only /public or /private")
+}
+
 func (r *rootTree) Stat() (fi fs.FileInfo, err error) {
 	return base.NewFSFileInfo(
 		"",
@@ -700,52 +1093,466 @@ func (r *rootTree) history(max int) (hist []base.HistoryEntry, err error) {
 	return hist, nil
 }
 
-func (r *rootTree) MergeDiverged(n base.Node) (result base.MergeResult, err error) {
-	return base.MergeResult{}, fmt.Errorf("unfinished: root tree MergeDiverged")
+// MergeDiverged merges n, a root with history that has diverged from r, into
+// r. Both file hierarchies are merged & committed as a merge commit: a root
+// with r's last commit as its previous version & n as its merge parent
+func (r *rootTree) MergeDiverged(n base.Node, opts ...base.MergeOption) (result base.MergeResult, err error) {
+	b, ok := n.(*rootTree)
+	if !ok {
+		return result, fmt.Errorf("cannot merge root with %T", n)
+	}
+	ctx := r.store.Context()
+	pub, priv, err := r.mergeHierarchies(ctx, b, opts...)
+	if err != nil {
+		return result, err
+	}
+	conflicts := hierarchyConflicts(pub, priv)
+	if err = r.setMergeConflicts(conflicts); err != nil {
+		return result, err
+	}
+	bid := b.tx
+	if err = r.commit(&bid); err != nil {
+		return result, err
+	}
+
+	result = base.MergeResult{
+		Name: r.Name(),
+		Type: base.MTMergeCommit,
+		Cid:  r.id,
+		Size: r.Size(),
+
+		Conflicts: conflicts,
+		Decisions: hierarchyDecisions(pub, priv),
+	}
+	if r.Private != nil {
+		result.Key = r.Private.Key().Encode()
+		result.PrivateName = string(r.txName)
+	}
+	return result, nil
+}
+
+// mergeHierarchies merges the public & private trees of b into r. If only one
+// side has a private tree, r ends up with that tree
+func (r *rootTree) mergeHierarchies(ctx context.Context, b *rootTree, opts ...base.MergeOption) (pub, priv *base.MergeResult, err error) {
+	res, err := public.Merge(ctx, r.Public, b.Public, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("merging /%s: %w", FileHierarchyNamePublic, err)
+	}
+	if res.Type == base.MTFastForward {
+		if err := base.CopyBlocks(ctx, res.Cid, b.store.Blockservice(), r.store.Blockservice()); err != nil {
+			return nil, nil, err
+		}
+	}
+	log.Debugw("merged public", "type", res.Type, "result", res.Cid)
+	if r.Public, err = public.LoadTree(ctx, r.store, FileHierarchyNamePublic, res.Cid); err != nil {
+		return nil, nil, err
+	}
+	pub = &res
+
+	switch {
+	case r.Private == nil && b.Private == nil:
+		return pub, nil, nil
+	case b.Private == nil:
+		res = base.MergeResult{Type: base.MTLocalAhead, Cid: r.Private.Cid()}
+	case r.Private == nil:
+		// adopt b's private tree
+		if err := private.MergeHAMTBlocks(ctx, b.pstore, r.pstore); err != nil {
+			return nil, nil, err
+		}
+		pn, err := b.Private.PrivateName()
+		if err != nil {
+			return nil, nil, err
+		}
+		res = base.MergeResult{
+			Type:        base.MTFastForward,
+			Key:         b.Private.Key().Encode(),
+			PrivateName: string(pn),
+		}
+	default:
+		if res, err = private.Merge(ctx, r.Private, b.Private, opts...); err != nil {
+			return nil, nil, fmt.Errorf("merging /%s: %w", FileHierarchyNamePrivate, err)
+		}
+	}
+
+	if res.Type == base.MTFastForward || res.Type == base.MTMergeCommit {
+		pk := &private.Key{}
+		if err := pk.Decode(res.Key); err != nil {
+			return nil, nil, err
+		}
+		if r.Private, err = private.LoadRoot(ctx, r.pstore, FileHierarchyNamePrivate, *pk, private.Name(res.PrivateName)); err != nil {
+			return nil, nil, err
+		}
+		r.rootKey = *pk
+		if !res.Cid.Defined() {
+			res.Cid = r.Private.Cid()
+		}
+	}
+	log.Debugw("merged private", "type", res.Type, "result", res.Cid)
+	return pub, &res, nil
+}
+
+// dirty reports whether r has changes that aren't committed
+func (r *rootTree) dirty() bool {
+	if r.h.Public == nil || !r.Public.Cid().Equals(*r.h.Public) {
+		return true
+	}
+	if r.Private != nil && (r.h.Private == nil || !r.Private.Cid().Equals(*r.h.Private)) {
+		return true
+	}
+	return false
+}
+
+// mergeMetadataKey is the field of root metadata recording how the conflicts
+// of a merge commit were resolved
+const mergeMetadataKey = "merge"
+
+// hierarchyConflicts combines the conflicts of merging each file hierarchy,
+// with paths relative to the root
+func hierarchyConflicts(pub, priv *base.MergeResult) (conflicts []base.ConflictResolution) {
+	for _, h := range []struct {
+		name string
+		res  *base.MergeResult
+	}{
+		{FileHierarchyNamePublic, pub},
+		{FileHierarchyNamePrivate, priv},
+	} {
+		if h.res == nil {
+			continue
+		}
+		for _, c := range h.res.Conflicts {
+			c.Path = gopath.Join(h.name, c.Path)
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// hierarchyDecisions combines the decisions of merging each file hierarchy,
+// with paths relative to the root. Hierarchies adopted whole are reported as a
+// single fast-forward
+func hierarchyDecisions(pub, priv *base.MergeResult) (decisions []base.MergeDecision) {
+	for _, h := range []struct {
+		name string
+		res  *base.MergeResult
+	}{
+		{FileHierarchyNamePublic, pub},
+		{FileHierarchyNamePrivate, priv},
+	} {
+		if h.res == nil {
+			continue
+		}
+		ds := h.res.Decisions
+		if len(ds) == 0 && h.res.Type == base.MTFastForward {
+			id := h.res.Cid
+			ds = []base.MergeDecision{{Action: base.MAFastForward, Remote: &id}}
+		}
+		for _, d := range ds {
+			d.Path = gopath.Join(h.name, d.Path)
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions
+}
+
+// setMergeConflicts records conflicts in root metadata, replacing the record
+// of any prior merge. a nil slice removes the record
+func (r *rootTree) setMergeConflicts(conflicts []base.ConflictResolution) error {
+	md, err := r.metadataMap()
+	if err != nil {
+		return err
+	}
+	if md == nil {
+		md = map[string]interface{}{}
+	}
+
+	if conflicts == nil {
+		if _, ok := md[mergeMetadataKey]; !ok {
+			return nil
+		}
+		delete(md, mergeMetadataKey)
+	} else {
+		recs := make([]interface{}, len(conflicts))
+		for i, c := range conflicts {
+			recs[i] = c.Map()
+		}
+		md[mergeMetadataKey] = map[string]interface{}{"conflicts": recs}
+	}
+	return r.SetMetadata(md)
+}
+
+// metadataMap returns root metadata as a map, nil if root metadata isn't set
+// or isn't a map
+func (r *rootTree) metadataMap() (map[string]interface{}, error) {
+	if _, err := r.Metadata(); err != nil || r.metadata == nil {
+		return nil, err
+	}
+	data, err := r.metadata.Data()
+	if err != nil {
+		return nil, err
+	}
+	md, _ := data.(map[string]interface{})
+	return md, nil
+}
+
+// mergeConflicts reads the conflict resolutions recorded with the last commit,
+// nil if the last commit isn't a merge commit or had no conflicts
+func (r *rootTree) mergeConflicts() ([]base.ConflictResolution, error) {
+	md, err := r.metadataMap()
+	if err != nil {
+		return nil, err
+	}
+	rec, _ := md[mergeMetadataKey].(map[string]interface{})
+	recs, _ := rec["conflicts"].([]interface{})
+
+	conflicts := make([]base.ConflictResolution, 0, len(recs))
+	for _, v := range recs {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid merge conflict record: %v", v)
+		}
+		c := base.ConflictResolution{}
+		c.Path, _ = m["path"].(string)
+		res, _ := m["resolution"].(string)
+		c.Resolution = base.Resolution(res)
+		c.Copy, _ = m["copy"].(string)
+		fields, _ := m["fields"].([]interface{})
+		for _, f := range fields {
+			if s, ok := f.(string); ok {
+				c.Fields = append(c.Fields, s)
+			}
+		}
+		for key, dst := range map[string]**cid.Cid{"local": &c.Local, "remote": &c.Remote} {
+			if s, ok := m[key].(string); ok {
+				id, err := cid.Decode(s)
+				if err != nil {
+					return nil, err
+				}
+				*dst = &id
+			}
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+// isRootAncestor reports whether ancestor is in the history of the root at
+// head, following both previous & merge links
+func isRootAncestor(ctx context.Context, bserv blockservice.BlockService, head, ancestor cid.Cid) (bool, error) {
+	queue := []cid.Cid{head}
+	seen := map[cid.Cid]struct{}{}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id.Equals(ancestor) {
+			return true, nil
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		blk, err := bserv.GetBlock(ctx, id)
+		if err != nil {
+			return false, fmt.Errorf("loading root %s: %w", id, err)
+		}
+		h, err := decodeRootHeader(blk)
+		if err != nil {
+			return false, err
+		}
+		if h.Previous != nil {
+			queue = append(queue, *h.Previous)
+		}
+		if h.Merge != nil {
+			queue = append(queue, *h.Merge)
+		}
+	}
+	return false, nil
+}
+
+// MergeResult describes merging one filesystem into another
+type MergeResult struct {
+	// Type is the kind of merge applied to the root
+	Type base.MergeType
+	// Root is the CID of the merged root
+	Root        cid.Cid
+	PrivateName *PrivateName
+	PrivateKey  *Key
+	// Public & Private describe merging each file hierarchy. Both are nil when
+	// roots are in sync or local is ahead, Private is nil when neither side has
+	// a private tree
+	Public  *base.MergeResult
+	Private *base.MergeResult
+	// Conflicts lists how each conflicting entry was resolved, with paths from
+	// the root. Conflicts are also recorded in the metadata of the merge commit
+	Conflicts []base.ConflictResolution
+	// Decisions lists what the merge did with every path it touched, with
+	// paths from the root
+	Decisions []base.MergeDecision
 }
 
-func Merge(ctx context.Context, aFs, bFs WNFS) (err error) {
+// MergeReport is a JSON-serializable account of a merge, for logging &
+// auditing. Unlike MergeResult it carries no key material
+type MergeReport struct {
+	Type    base.MergeType       `json:"type"`
+	Root    cid.Cid              `json:"root"`
+	Public  base.MergeType       `json:"public,omitempty"`
+	Private base.MergeType       `json:"private,omitempty"`
+	Paths   []base.MergeDecision `json:"paths"`
+}
+
+// Report summarizes the merge result
+func (mr MergeResult) Report() MergeReport {
+	rep := MergeReport{
+		Type:  mr.Type,
+		Root:  mr.Root,
+		Paths: mr.Decisions,
+	}
+	if mr.Public != nil {
+		rep.Public = mr.Public.Type
+	}
+	if mr.Private != nil {
+		rep.Private = mr.Private.Type
+	}
+	if rep.Paths == nil {
+		rep.Paths = []base.MergeDecision{}
+	}
+	return rep
+}
+
+// Merge merges bFs into aFs. Fast-forwards & merge commits are committed to
+// aFs, with merge commits linking to the last commits of both filesystems.
+// Uncommitted changes on either side are included in a merge commit.
+// Conflicting changes are resolved by the resolver set in opts
+func Merge(ctx context.Context, aFs, bFs WNFS, opts ...base.MergeOption) (result MergeResult, err error) {
 	a, ok := aFs.(*fileSystem)
 	if !ok {
-		return fmt.Errorf("'a' is not a wnfs filesystem")
+		return result, fmt.Errorf("'a' is not a wnfs filesystem")
 	}
 	b, ok := bFs.(*fileSystem)
 	if !ok {
-		return fmt.Errorf("'b' is not a wnfs filesystem")
+		return result, fmt.Errorf("'b' is not a wnfs filesystem")
 	}
-	log.Debugw("Merge", "acid", a.Cid(), "bcid", b.Cid())
+	if !b.root.tx.Defined() {
+		return result, fmt.Errorf("'b' has no commits to merge")
+	}
+	log.Debugw("Merge", "acid", a.root.tx, "bcid", b.root.tx)
 
-	if a.root.Public != nil && b.root.Public != nil {
-		res, err := public.Merge(ctx, a.root.Public, b.root.Public)
-		if err != nil {
-			return err
+	result.Type = base.MTMergeCommit
+	if aDirty := a.root.dirty(); a.root.tx.Defined() && !b.root.dirty() {
+		if a.root.tx.Equals(b.root.tx) && !aDirty {
+			result.Type = base.MTInSync
+		} else if ahead, err := isRootAncestor(ctx, a.store.Blockservice(), a.root.tx, b.root.tx); err != nil {
+			return result, err
+		} else if ahead {
+			result.Type = base.MTLocalAhead
+		} else if behind, err := isRootAncestor(ctx, b.store.Blockservice(), b.root.tx, a.root.tx); err != nil {
+			return result, err
+		} else if behind && !aDirty {
+			result.Type = base.MTFastForward
 		}
-		log.Debugw("merged public", "result", res.Cid)
-		fmt.Printf("/public:\t%s\n", res.Type)
-		a.root.Public, err = public.LoadTree(ctx, a.root.store, FileHierarchyNamePublic, res.Cid)
+	}
+
+	switch result.Type {
+	case base.MTInSync, base.MTLocalAhead:
+		res, err := a.commitResult()
 		if err != nil {
-			return err
+			return result, err
+		}
+		result.Root, result.PrivateName, result.PrivateKey = a.root.tx, res.PrivateName, res.PrivateKey
+		return result, nil
+	case base.MTFastForward:
+		if result.Public, result.Private, err = a.root.fastForward(ctx, b.root); err != nil {
+			return result, err
+		}
+		result.Decisions = hierarchyDecisions(result.Public, result.Private)
+	default:
+		if result.Public, result.Private, err = a.root.mergeHierarchies(ctx, b.root, opts...); err != nil {
+			return result, err
+		}
+		result.Conflicts = hierarchyConflicts(result.Public, result.Private)
+		result.Decisions = hierarchyDecisions(result.Public, result.Private)
+		if err = a.root.setMergeConflicts(result.Conflicts); err != nil {
+			return result, err
+		}
+		bid := b.root.tx
+		if err = a.root.commit(&bid); err != nil {
+			return result, err
 		}
 	}
 
-	if a.root.Private != nil && b.root.Private != nil {
-		res, err := private.Merge(ctx, a.root.Private, b.root.Private)
-		if err != nil {
-			return err
+	res, err := a.commitResult()
+	if err != nil {
+		return result, err
+	}
+	result.Root, result.PrivateName, result.PrivateKey = res.Root, res.PrivateName, res.PrivateKey
+	return result, nil
+}
+
+// fastForward makes b's last commit the last commit of r, adopting both of b's
+// file hierarchies. b must have no uncommitted changes
+func (r *rootTree) fastForward(ctx context.Context, b *rootTree) (pub, priv *base.MergeResult, err error) {
+	blk, err := b.store.Blockservice().GetBlock(ctx, b.tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.store.Blockservice().AddBlock(ctx, blk); err != nil {
+		return nil, nil, err
+	}
+	h, err := decodeRootHeader(blk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if h.Private != nil {
+		// private HAMTs only grow, merging b's entries into r's yields b's HAMT.
+		// b's HAMT is merged even if b can't read it, so r's store keeps up with
+		// the header r adopts
+		if err := private.MergeHAMTBlocks(ctx, b.pstore, r.pstore); err != nil {
+			return nil, nil, err
 		}
-		log.Debugw("merged private", "result", res.Cid)
-		fmt.Printf("/private:\t%s\n", res.Type)
-		pk := &private.Key{}
-		if err := pk.Decode(res.Key); err != nil {
-			return err
+		if id := r.pstore.HAMT().CID(); !id.Equals(*h.Private) {
+			return nil, nil, fmt.Errorf("fast-forward: merged private HAMT %s doesn't match root HAMT %s", id, *h.Private)
 		}
-		a.root.Private, err = private.LoadRoot(a.root.store.Context(), a.root.pstore, FileHierarchyNamePrivate, *pk, private.Name(res.PrivateName))
+	}
+
+	if err := base.CopyBlocks(ctx, b.Public.Cid(), b.store.Blockservice(), r.store.Blockservice()); err != nil {
+		return nil, nil, err
+	}
+	if r.Public, err = public.LoadTree(ctx, r.store, FileHierarchyNamePublic, b.Public.Cid()); err != nil {
+		return nil, nil, err
+	}
+	pub = &base.MergeResult{Type: base.MTFastForward, Cid: r.Public.Cid(), Size: r.Public.Size()}
+
+	if b.Private != nil {
+		pn, err := b.Private.PrivateName()
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		key := b.Private.Key()
+		if r.Private, err = private.LoadRoot(ctx, r.pstore, FileHierarchyNamePrivate, key, pn); err != nil {
+			return nil, nil, err
 		}
+		r.rootKey = key
+		priv = &base.MergeResult{
+			Type:        base.MTFastForward,
+			Cid:         r.Private.Cid(),
+			Key:         key.Encode(),
+			PrivateName: string(pn),
+		}
+	} else if r.Private != nil {
+		priv = &base.MergeResult{Type: base.MTLocalAhead, Cid: r.Private.Cid()}
 	}
 
-	return nil
+	r.h = h
+	r.id = b.tx
+	r.tx = b.tx
+	r.txName = ""
+	if r.Private != nil {
+		if r.txName, err = r.Private.PrivateName(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return pub, priv, nil
 }
 
 func HAMTContents(ctx context.Context, bs blockservice.BlockService, id cid.Cid) (map[string]string, error) {
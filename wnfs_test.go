@@ -1,9 +1,13 @@
 package wnfs
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -11,8 +15,11 @@ import (
 	"testing"
 
 	cmp "github.com/google/go-cmp/cmp"
+	cid "github.com/ipfs/go-cid"
 	golog "github.com/ipfs/go-log"
 	base "github.com/qri-io/wnfs-go/base"
+	dagmod "github.com/qri-io/wnfs-go/dagmod"
+	dagsync "github.com/qri-io/wnfs-go/dagsync"
 	mockblocks "github.com/qri-io/wnfs-go/mockblocks"
 	private "github.com/qri-io/wnfs-go/private"
 	ratchet "github.com/qri-io/wnfs-go/private/ratchet"
@@ -67,6 +74,210 @@ func TestTransactions(t *testing.T) {
 	require.Nil(err)
 }
 
+func TestBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("commits_once", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(err)
+		_, err = fsys.Commit()
+		require.Nil(err)
+
+		err = fsys.Batch(func(tx PosixFS) error {
+			for _, pathStr := range []string{"public/foo/hello.txt", "private/foo/hello.txt"} {
+				if err := tx.Write(pathStr, base.NewMemfileBytes("hello.txt", []byte("hello"))); err != nil {
+					return err
+				}
+				if err := tx.Write(pathStr, base.NewMemfileBytes("hello.txt", []byte("hello again"))); err != nil {
+					return err
+				}
+			}
+			return tx.Mkdir("public/bar")
+		})
+		require.Nil(err)
+
+		for _, pathStr := range []string{"public/foo/hello.txt", "private/foo/hello.txt"} {
+			got, err := fsys.Cat(pathStr)
+			require.Nil(err)
+			assert.Equal("hello again", string(got))
+
+			hist, err := fsys.History(ctx, pathStr, -1)
+			require.Nil(err)
+			assert.Equal(1, len(hist), "%s: expected one history entry per batch", pathStr)
+		}
+
+		hist, err := fsys.History(ctx, "public", -1)
+		require.Nil(err)
+		assert.Equal(2, len(hist))
+
+		hist, err = fsys.History(ctx, "", -1)
+		require.Nil(err)
+		assert.Equal(2, len(hist))
+
+		err = fsys.Batch(func(tx PosixFS) error {
+			return fsys.Batch(func(PosixFS) error { return nil })
+		})
+		assert.ErrorIs(err, ErrBatchInProgress)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(err)
+		err = fsys.Write("private/keep.txt", base.NewMemfileBytes("keep.txt", []byte("keep")))
+		require.Nil(err)
+		_, err = fsys.Commit()
+		require.Nil(err)
+
+		start := fsys.Cid()
+		errBatch := fmt.Errorf("oh noes")
+		err = fsys.Batch(func(tx PosixFS) error {
+			if err := tx.Write("public/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello"))); err != nil {
+				return err
+			}
+			if err := tx.Write("private/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello"))); err != nil {
+				return err
+			}
+			if err := tx.Rm("private/keep.txt"); err != nil {
+				return err
+			}
+			return errBatch
+		})
+		require.ErrorIs(err, errBatch)
+		assert.Equal(start, fsys.Cid())
+
+		_, err = fsys.Cat("public/hello.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+		_, err = fsys.Cat("private/hello.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+		got, err := fsys.Cat("private/keep.txt")
+		require.Nil(err)
+		assert.Equal("keep", string(got))
+
+		// changes outside of a batch roll back to the last commit
+		err = fsys.Write("public/goodbye.txt", base.NewMemfileBytes("goodbye.txt", []byte("goodbye")))
+		require.Nil(err)
+		err = fsys.Write("private/goodbye.txt", base.NewMemfileBytes("goodbye.txt", []byte("goodbye")))
+		require.Nil(err)
+		err = fsys.Rollback()
+		require.Nil(err)
+		_, err = fsys.Cat("public/goodbye.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+		_, err = fsys.Cat("private/goodbye.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+		assert.Equal(start, fsys.Cid())
+
+		// private roots roll back to the revision of the latest commit
+		err = fsys.Write("private/second.txt", base.NewMemfileBytes("second.txt", []byte("second")))
+		require.Nil(err)
+		_, err = fsys.Commit()
+		require.Nil(err)
+		err = fsys.Batch(func(tx PosixFS) error {
+			if err := tx.Rm("private/second.txt"); err != nil {
+				return err
+			}
+			return errBatch
+		})
+		require.ErrorIs(err, errBatch)
+		got, err = fsys.Cat("private/second.txt")
+		require.Nil(err)
+		assert.Equal("second", string(got))
+		err = fsys.Write("private/goodbye.txt", base.NewMemfileBytes("goodbye.txt", []byte("goodbye")))
+		require.Nil(err)
+		require.Nil(fsys.Rollback())
+		got, err = fsys.Cat("private/second.txt")
+		require.Nil(err)
+		assert.Equal("second", string(got))
+	})
+
+	t.Run("stage", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(err)
+		_, err = fsys.Commit()
+		require.Nil(err)
+		start := fsys.Cid()
+
+		err = fsys.Stage(func(tx PosixFS) error {
+			if err := tx.Write("public/staged.txt", base.NewMemfileBytes("staged.txt", []byte("staged"))); err != nil {
+				return err
+			}
+			return tx.Write("private/staged.txt", base.NewMemfileBytes("staged.txt", []byte("staged")))
+		})
+		require.Nil(err)
+		assert.Equal(start, fsys.Cid(), "staged changes must not be committed")
+
+		// a failed stage only rolls back its own changes
+		errStage := fmt.Errorf("oh noes")
+		err = fsys.Stage(func(tx PosixFS) error {
+			if err := tx.Rm("private/staged.txt"); err != nil {
+				return err
+			}
+			if err := tx.Write("public/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello"))); err != nil {
+				return err
+			}
+			return errStage
+		})
+		require.ErrorIs(err, errStage)
+		_, err = fsys.Cat("public/hello.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+
+		res, err := fsys.Commit()
+		require.Nil(err)
+		assert.NotEqual(start, res.Root)
+		opened, err := FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+		require.Nil(err)
+		for _, pathStr := range []string{"public/staged.txt", "private/staged.txt"} {
+			got, err := opened.Cat(pathStr)
+			require.Nil(err)
+			assert.Equal("staged", string(got))
+		}
+	})
+
+	t.Run("rollback_unwritten_public", func(t *testing.T) {
+		require := require.New(t)
+		assert := assert.New(t)
+
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(err)
+
+		errBatch := fmt.Errorf("oh noes")
+		err = fsys.Batch(func(tx PosixFS) error {
+			if err := tx.Write("public/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello"))); err != nil {
+				return err
+			}
+			return errBatch
+		})
+		require.ErrorIs(err, errBatch)
+		_, err = fsys.Cat("public/hello.txt")
+		assert.ErrorIs(err, base.ErrNotFound)
+
+		err = fsys.Write("public/goodbye.txt", base.NewMemfileBytes("goodbye.txt", []byte("goodbye")))
+		require.Nil(err)
+		_, err = fsys.Commit()
+		require.Nil(err)
+		got, err := fsys.Cat("public/goodbye.txt")
+		require.Nil(err)
+		assert.Equal("goodbye", string(got))
+	})
+}
+
 func TestPublicWNFS(t *testing.T) {
 	require := require.New(t)
 	assert := assert.New(t)
@@ -128,6 +339,366 @@ func TestPublicWNFS(t *testing.T) {
 	})
 }
 
+func TestMv(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, hierarchy := range []string{FileHierarchyNamePublic, FileHierarchyNamePrivate} {
+		t.Run(hierarchy, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			store := newMemTestStore(ctx, t)
+			rs := ratchet.NewMemStore(ctx)
+			fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+			require.Nil(err)
+
+			from := hierarchy + "/foo/hello.txt"
+			err = fsys.Write(from, base.NewMemfileBytes("hello.txt", []byte("hello")))
+			require.Nil(err)
+			err = fsys.Write(from, base.NewMemfileBytes("hello.txt", []byte("hello again")))
+			require.Nil(err)
+			_, err = fsys.Commit()
+			require.Nil(err)
+
+			to := hierarchy + "/bar/baz/hello.txt"
+			err = fsys.Mv(from, to)
+			require.Nil(err)
+			_, err = fsys.Commit()
+			require.Nil(err)
+
+			_, err = fsys.Cat(from)
+			require.ErrorIs(err, base.ErrNotFound)
+			got, err := fsys.Cat(to)
+			require.Nil(err)
+			assert.Equal("hello again", string(got))
+
+			ents, err := fsys.Ls(hierarchy + "/foo")
+			require.Nil(err)
+			assert.Equal(0, len(ents))
+
+			if hierarchy == FileHierarchyNamePublic {
+				hist, err := fsys.History(ctx, to, -1)
+				require.Nil(err)
+				assert.Equal(2, len(hist), "moved file should keep its history")
+			}
+
+			// move a directory within the same parent
+			err = fsys.Mv(hierarchy+"/bar", hierarchy+"/qux")
+			require.Nil(err)
+			got, err = fsys.Cat(hierarchy + "/qux/baz/hello.txt")
+			require.Nil(err)
+			assert.Equal("hello again", string(got))
+
+			err = fsys.Write(hierarchy+"/other.txt", base.NewMemfileBytes("other.txt", []byte("other")))
+			require.Nil(err)
+			err = fsys.Mv(hierarchy+"/other.txt", hierarchy+"/qux/baz/hello.txt")
+			assert.ErrorIs(err, fs.ErrExist)
+			err = fsys.Mv(hierarchy+"/qux", hierarchy+"/qux/baz/qux")
+			assert.NotNil(err)
+		})
+	}
+
+	t.Run("cross_hierarchy", func(t *testing.T) {
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(t, err)
+
+		err = fsys.Write("public/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello")))
+		require.Nil(t, err)
+		err = fsys.Mv("public/hello.txt", "private/hello.txt")
+		assert.ErrorIs(t, err, ErrCrossHierarchyMove)
+	})
+}
+
+func TestSymlink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, hierarchy := range []string{FileHierarchyNamePublic, FileHierarchyNamePrivate} {
+		t.Run(hierarchy, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			store := newMemTestStore(ctx, t)
+			rs := ratchet.NewMemStore(ctx)
+			fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+			require.Nil(err)
+
+			err = fsys.Write(hierarchy+"/foo/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello")))
+			require.Nil(err)
+
+			err = fsys.Symlink("foo/hello.txt", hierarchy+"/link.txt")
+			require.Nil(err)
+			err = fsys.Symlink("../foo", hierarchy+"/bar/foo")
+			require.Nil(err)
+			err = fsys.Symlink("/"+hierarchy+"/foo", hierarchy+"/abs")
+			require.Nil(err)
+
+			for _, pathStr := range []string{"link.txt", "bar/foo/hello.txt", "abs/hello.txt"} {
+				got, err := fsys.Cat(hierarchy + "/" + pathStr)
+				require.Nil(err, pathStr)
+				assert.Equal("hello", string(got), pathStr)
+			}
+
+			err = fsys.Symlink("foo", hierarchy+"/link.txt")
+			assert.ErrorIs(err, fs.ErrExist)
+			_, err = fsys.Readlink(hierarchy + "/foo/hello.txt")
+			assert.ErrorIs(err, base.ErrNotSymlink)
+
+			err = fsys.Symlink("loop_b", hierarchy+"/loop_a")
+			require.Nil(err)
+			err = fsys.Symlink("loop_a", hierarchy+"/loop_b")
+			require.Nil(err)
+			_, err = fsys.Cat(hierarchy + "/loop_a")
+			assert.ErrorIs(err, base.ErrSymlinkLoop)
+
+			for i := 0; i <= base.MaxSymlinkDepth; i++ {
+				err = fsys.Symlink(fmt.Sprintf("chain_%d", i+1), fmt.Sprintf("%s/chain_%d", hierarchy, i))
+				require.Nil(err)
+			}
+			err = fsys.Symlink("foo/hello.txt", fmt.Sprintf("%s/chain_%d", hierarchy, base.MaxSymlinkDepth+1))
+			require.Nil(err)
+			_, err = fsys.Cat(hierarchy + "/chain_0")
+			assert.ErrorIs(err, base.ErrSymlinkDepth)
+			_, err = fsys.Cat(hierarchy + "/chain_2")
+			assert.Nil(err)
+
+			res, err := fsys.Commit()
+			require.Nil(err)
+			fsys, err = FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+			require.Nil(err)
+
+			target, err := fsys.Readlink(hierarchy + "/link.txt")
+			require.Nil(err)
+			assert.Equal("foo/hello.txt", target)
+			target, err = fsys.Readlink(hierarchy + "/abs")
+			require.Nil(err)
+			assert.Equal("/"+hierarchy+"/foo", target)
+		})
+	}
+
+	t.Run("cross_hierarchy", func(t *testing.T) {
+		store := newMemTestStore(ctx, t)
+		rs := ratchet.NewMemStore(ctx)
+		fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+		require.Nil(t, err)
+
+		err = fsys.Symlink("/private/foo", "public/foo")
+		assert.ErrorIs(t, err, ErrCrossHierarchySymlink)
+	})
+}
+
+func TestUnixFS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+	require.Nil(t, err)
+
+	err = fsys.Write("public/src/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello")))
+	require.Nil(t, err)
+	id, err := fsys.ExportUnixFS("public/src")
+	require.Nil(t, err)
+
+	err = fsys.MountUnixFS("public/mnt", id)
+	require.Nil(t, err)
+	got, err := fsys.Cat("public/mnt/hello.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+	fsys, err = FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+
+	got, err = fsys.Cat("public/mnt/hello.txt")
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	err = fsys.MountUnixFS("private/mnt", id)
+	assert.ErrorIs(t, err, ErrUnixFSNotPublic)
+	_, err = fsys.ExportUnixFS("private")
+	assert.ErrorIs(t, err, ErrUnixFSNotPublic)
+}
+
+func TestOpenFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, hierarchy := range []string{FileHierarchyNamePublic, FileHierarchyNamePrivate} {
+		t.Run(hierarchy, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			store := newMemTestStore(ctx, t)
+			rs := ratchet.NewMemStore(ctx)
+			fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+			require.Nil(err)
+
+			// span multiple chunks so edits land in the middle of the DAG
+			content := make([]byte, 600*1024)
+			rand.Read(content)
+			pathStr := hierarchy + "/foo/data.bin"
+			err = fsys.Write(pathStr, base.NewMemfileBytes("data.bin", content))
+			require.Nil(err)
+
+			_, err = fsys.OpenFile(pathStr, os.O_RDONLY)
+			assert.ErrorIs(err, dagmod.ErrNotWritable)
+			_, err = fsys.OpenFile(pathStr, os.O_RDWR|os.O_CREATE|os.O_EXCL)
+			assert.ErrorIs(err, fs.ErrExist)
+
+			f, err := fsys.OpenFile(pathStr, os.O_RDWR)
+			require.Nil(err)
+			_, err = f.WriteAt([]byte("edited"), 300*1024)
+			require.Nil(err)
+			require.Nil(f.Close())
+			copy(content[300*1024:], "edited")
+
+			f, err = fsys.OpenFile(pathStr, os.O_WRONLY|os.O_APPEND)
+			require.Nil(err)
+			_, err = f.Write([]byte("appended"))
+			require.Nil(err)
+			_, err = f.WriteAt([]byte("nope"), 0)
+			assert.ErrorIs(err, dagmod.ErrWriteAtInAppendMode)
+			require.Nil(f.Close())
+			content = append(content, "appended"...)
+
+			got, err := fsys.Cat(pathStr)
+			require.Nil(err)
+			assert.Equal(content, got)
+
+			f, err = fsys.OpenFile(hierarchy+"/new.txt", os.O_RDWR|os.O_CREATE)
+			require.Nil(err)
+			_, err = f.Write([]byte("hello world"))
+			require.Nil(err)
+			require.Nil(f.Truncate(5))
+			require.Nil(f.Close())
+
+			res, err := fsys.Commit()
+			require.Nil(err)
+			fsys, err = FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+			require.Nil(err)
+
+			got, err = fsys.Cat(pathStr)
+			require.Nil(err)
+			assert.Equal(content, got)
+			got, err = fsys.Cat(hierarchy + "/new.txt")
+			require.Nil(err)
+			assert.Equal("hello", string(got))
+
+			if hierarchy == FileHierarchyNamePrivate {
+				created, err := fsys.Open(hierarchy + "/new.txt")
+				require.Nil(err)
+				hist, err := created.(base.Node).History(ctx, -1)
+				require.Nil(err)
+				assert.Len(hist, 1, "creating a file with OpenFile must write a single revision")
+			}
+		})
+	}
+}
+
+func TestOpenFileReopenFreshStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+
+	content := make([]byte, 600*1024)
+	rand.Read(content)
+	pathStr := "private/data.bin"
+	require.Nil(t, fsys.Write(pathStr, base.NewMemfileBytes("data.bin", content)))
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	f, err := fsys.OpenFile(pathStr, os.O_RDWR)
+	require.Nil(t, err)
+	_, err = f.WriteAt([]byte("edited"), 300*1024)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	copy(content[300*1024:], "edited")
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	// nothing cached by the first filesystem may be needed to decrypt the edit
+	fsys, err = FromCID(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	got, err := fsys.Cat(pathStr)
+	require.Nil(t, err)
+	assert.Equal(t, content, got)
+}
+
+func TestSeekableFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, hierarchy := range []string{FileHierarchyNamePublic, FileHierarchyNamePrivate} {
+		t.Run(hierarchy, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			store := newMemTestStore(ctx, t)
+			rs := ratchet.NewMemStore(ctx)
+			fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+			require.Nil(err)
+
+			content := make([]byte, 700*1024)
+			rand.Read(content)
+			pathStr := hierarchy + "/data.bin"
+			err = fsys.Write(pathStr, base.NewMemfileBytes("data.bin", content))
+			require.Nil(err)
+			err = fsys.Write(hierarchy+"/empty.txt", base.NewMemfileBytes("empty.txt", nil))
+			require.Nil(err)
+
+			f, err := fsys.Open(pathStr)
+			require.Nil(err)
+			defer f.Close()
+
+			// read a footer without moving the read offset
+			ra, ok := f.(io.ReaderAt)
+			require.True(ok, "expected %T to implement io.ReaderAt", f)
+			footer := make([]byte, 16)
+			n, err := ra.ReadAt(footer, int64(len(content)-16))
+			require.Nil(err)
+			assert.Equal(16, n)
+			assert.Equal(content[len(content)-16:], footer)
+
+			// reads that run past the end of the file return io.EOF
+			n, err = ra.ReadAt(make([]byte, 32), int64(len(content)-16))
+			assert.Equal(io.EOF, err)
+			assert.Equal(16, n)
+
+			rs2, ok := f.(io.ReadSeeker)
+			require.True(ok, "expected %T to implement io.Seeker", f)
+			for _, off := range []int64{300 * 1024, 10, 600 * 1024} {
+				pos, err := rs2.Seek(off, io.SeekStart)
+				require.Nil(err)
+				assert.Equal(off, pos)
+				buf := make([]byte, 100)
+				_, err = io.ReadFull(rs2, buf)
+				require.Nil(err)
+				assert.Equal(content[off:off+100], buf)
+			}
+
+			end, err := rs2.Seek(-10, io.SeekEnd)
+			require.Nil(err)
+			assert.Equal(int64(len(content)-10), end)
+			rest, err := ioutil.ReadAll(rs2)
+			require.Nil(err)
+			assert.Equal(content[len(content)-10:], rest)
+
+			got, err := fsys.Cat(hierarchy + "/empty.txt")
+			require.Nil(err)
+			assert.Equal(0, len(got))
+		})
+	}
+}
+
 func TestMerge(t *testing.T) {
 	require := require.New(t)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -171,14 +742,755 @@ func TestMerge(t *testing.T) {
 	_, err = a.Commit()
 	require.Nil(err)
 
-	err = Merge(ctx, a, b)
+	mres, err := Merge(ctx, a, b)
 	require.Nil(err)
+	assert.Equal(t, base.MTMergeCommit, mres.Type)
 	res, err := a.Commit()
 	require.Nil(err)
 
 	t.Logf("%#v", res)
 }
 
+func TestMergeRoots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	bserv := store.Blockservice()
+	rs := ratchet.NewMemStore(ctx)
+	write := func(fsys WNFS, paths ...string) CommitResult {
+		t.Helper()
+		for _, p := range paths {
+			require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+		}
+		res, err := fsys.Commit()
+		require.Nil(t, err)
+		return res
+	}
+
+	a, err := NewEmptyFS(ctx, bserv, rs, testRootKey)
+	require.Nil(t, err)
+	initial := write(a, "public/shared.txt", "private/shared.txt")
+	b, err := FromCID(ctx, bserv, rs, initial.Root, *initial.PrivateKey, *initial.PrivateName)
+	require.Nil(t, err)
+
+	res, err := Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTInSync, res.Type)
+	assert.Equal(t, initial.Root, res.Root)
+
+	ahead := write(a, "public/ahead.txt")
+	res, err = Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTLocalAhead, res.Type)
+	assert.Equal(t, ahead.Root, res.Root)
+	assert.Equal(t, ahead.Root, a.Cid())
+
+	res, err = Merge(ctx, b, a)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTFastForward, res.Type)
+	assert.Equal(t, ahead.Root, res.Root, "fast-forward adopts the remote root")
+	assert.Equal(t, ahead.Root, b.Cid())
+	mustFileContents(t, b, "public/ahead.txt", "public/ahead.txt")
+
+	aRes := write(a, "public/a.txt", "private/a.txt")
+	bRes := write(b, "public/b.txt", "private/b.txt")
+	res, err = Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+	require.NotNil(t, res.Public)
+	assert.Equal(t, base.MTMergeCommit, res.Public.Type)
+	require.NotNil(t, res.Private)
+	assert.Equal(t, base.MTMergeCommit, res.Private.Type)
+	assert.Equal(t, res.Root, a.Cid())
+
+	blk, err := bserv.GetBlock(ctx, res.Root)
+	require.Nil(t, err)
+	h, err := decodeRootHeader(blk)
+	require.Nil(t, err)
+	require.NotNil(t, h.Previous)
+	require.NotNil(t, h.Merge)
+	assert.Equal(t, aRes.Root, *h.Previous)
+	assert.Equal(t, bRes.Root, *h.Merge)
+
+	merged, err := FromCID(ctx, bserv, rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	for _, p := range []string{"public/a.txt", "public/b.txt", "private/a.txt", "private/b.txt", "private/shared.txt"} {
+		mustFileContents(t, merged, p, p)
+	}
+
+	// the merged root is a descendant of both parents
+	res, err = Merge(ctx, merged, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTLocalAhead, res.Type)
+	res, err = Merge(ctx, b, merged)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTFastForward, res.Type)
+
+	// a commit after a merge isn't a merge commit
+	next := write(merged, "public/next.txt")
+	blk, err = bserv.GetBlock(ctx, next.Root)
+	require.Nil(t, err)
+	h, err = decodeRootHeader(blk)
+	require.Nil(t, err)
+	assert.Nil(t, h.Merge)
+}
+
+func TestMergeConflictMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	bserv := store.Blockservice()
+	rs := ratchet.NewMemStore(ctx)
+	write := func(fsys WNFS, content string, paths ...string) CommitResult {
+		t.Helper()
+		for _, p := range paths {
+			require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(content))))
+		}
+		res, err := fsys.Commit()
+		require.Nil(t, err)
+		return res
+	}
+
+	a, err := NewEmptyFS(ctx, bserv, rs, testRootKey)
+	require.Nil(t, err)
+	initial := write(a, "initial", "public/shared.txt", "private/shared.txt")
+	b, err := FromCID(ctx, bserv, rs, initial.Root, *initial.PrivateKey, *initial.PrivateName)
+	require.Nil(t, err)
+
+	write(a, "local", "public/shared.txt", "private/shared.txt")
+	write(b, "remote", "public/shared.txt", "private/shared.txt")
+	res, err := Merge(ctx, a, b, base.WithConflictResolver(base.PreferRemote))
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+	mustFileContents(t, a, "public/shared.txt", "remote")
+	mustFileContents(t, a, "private/shared.txt", "remote")
+
+	paths := []string{}
+	for _, c := range res.Conflicts {
+		assert.Equal(t, base.RRemote, c.Resolution)
+		paths = append(paths, c.Path)
+	}
+	assert.Equal(t, []string{"public/shared.txt", "private/shared.txt"}, paths)
+
+	merged, err := FromCID(ctx, bserv, rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	recorded, err := merged.(*fileSystem).root.mergeConflicts()
+	require.Nil(t, err)
+	assert.Equal(t, res.Conflicts, recorded)
+
+	// conflict records don't carry over to the next commit
+	write(merged, "next", "public/next.txt")
+	recorded, err = merged.(*fileSystem).root.mergeConflicts()
+	require.Nil(t, err)
+	assert.Empty(t, recorded)
+}
+
+func TestMergeReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	bserv := store.Blockservice()
+	rs := ratchet.NewMemStore(ctx)
+	write := func(fsys WNFS, paths ...string) CommitResult {
+		t.Helper()
+		for _, p := range paths {
+			require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+		}
+		res, err := fsys.Commit()
+		require.Nil(t, err)
+		return res
+	}
+
+	a, err := NewEmptyFS(ctx, bserv, rs, testRootKey)
+	require.Nil(t, err)
+	initial := write(a, "public/shared.txt", "private/shared.txt")
+	b, err := FromCID(ctx, bserv, rs, initial.Root, *initial.PrivateKey, *initial.PrivateName)
+	require.Nil(t, err)
+
+	write(b, "public/b.txt")
+	res, err := Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTFastForward, res.Type)
+	rep := res.Report()
+	require.Equal(t, 2, len(rep.Paths))
+	assert.Equal(t, []base.MergeDecision{
+		{Path: "public", Action: base.MAFastForward, Remote: rep.Paths[0].Remote},
+		{Path: "private", Action: base.MAFastForward, Remote: rep.Paths[1].Remote},
+	}, rep.Paths)
+
+	write(a, "public/a.txt", "private/a.txt")
+	write(b, "public/b2.txt")
+	res, err = Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+
+	actions := map[string]base.MergeAction{}
+	for _, d := range res.Report().Paths {
+		actions[d.Path] = d.Action
+	}
+	assert.Equal(t, map[string]base.MergeAction{
+		"public":        base.MAMerge,
+		"public/a.txt":  base.MAKeepLocal,
+		"public/b2.txt": base.MAAddRemote,
+	}, actions)
+
+	data, err := json.Marshal(res.Report())
+	require.Nil(t, err)
+	got := MergeReport{}
+	require.Nil(t, json.Unmarshal(data, &got))
+	assert.Equal(t, res.Report(), got)
+
+	// nothing to report when local is ahead
+	res, err = Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTLocalAhead, res.Type)
+	assert.Equal(t, []base.MergeDecision{}, res.Report().Paths)
+}
+
+func TestMergePreview(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	bserv := store.Blockservice()
+	rs := ratchet.NewMemStore(ctx)
+	write := func(fsys WNFS, paths ...string) CommitResult {
+		t.Helper()
+		for _, p := range paths {
+			require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+		}
+		res, err := fsys.Commit()
+		require.Nil(t, err)
+		return res
+	}
+	ratchetNames := func() (names []string) {
+		err := rs.ForEach(ctx, func(name string, _ *ratchet.Spiral) error {
+			names = append(names, name)
+			return nil
+		})
+		require.Nil(t, err)
+		return names
+	}
+
+	a, err := NewEmptyFS(ctx, bserv, rs, testRootKey)
+	require.Nil(t, err)
+	initial := write(a, "public/shared.txt", "private/shared.txt")
+	b, err := FromCID(ctx, bserv, rs, initial.Root, *initial.PrivateKey, *initial.PrivateName)
+	require.Nil(t, err)
+
+	aRes := write(a, "public/a.txt", "private/a.txt")
+	write(b, "public/b.txt", "private/dir/b.txt")
+	// uncommitted changes are previewed
+	require.Nil(t, a.Write("public/uncommitted.txt", base.NewMemfileBytes("uncommitted.txt", []byte("uncommitted"))))
+	aCid := a.Cid()
+
+	keys, err := base.AllKeys(ctx, bserv.Blockstore())
+	require.Nil(t, err)
+	ratchets := ratchetNames()
+
+	preview, err := MergePreview(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, preview.Type)
+
+	after, err := base.AllKeys(ctx, bserv.Blockstore())
+	require.Nil(t, err)
+	assert.Equal(t, len(keys), len(after), "preview must not write blocks")
+	assert.ElementsMatch(t, ratchets, ratchetNames(), "preview must not write ratchets")
+	assert.Equal(t, aCid, a.Cid())
+	assert.Equal(t, aRes.Root, a.(*fileSystem).root.tx)
+	_, err = a.Open("public/b.txt")
+	assert.NotNil(t, err, "preview must not change the local filesystem")
+
+	res, err := Merge(ctx, a, b)
+	require.Nil(t, err)
+	assert.Equal(t, preview.Type, res.Type)
+	assert.Equal(t, preview.Decisions, res.Decisions)
+
+	err = a.Batch(func(tx PosixFS) error {
+		_, err := MergePreview(ctx, a, b)
+		return err
+	})
+	assert.True(t, errors.Is(err, ErrBatchInProgress))
+}
+
+func TestMergeOneSidedPrivate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	rs := ratchet.NewMemStore(ctx)
+	withPrivate, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+	require.Nil(t, err)
+	require.Nil(t, withPrivate.Write("private/secret.txt", base.NewMemfileBytes("secret.txt", []byte("secret"))))
+	res, err := withPrivate.Commit()
+	require.Nil(t, err)
+
+	// opening without a key has no private root
+	publicOnly, err := FromCID(ctx, store.Blockservice(), rs, res.Root, Key{}, "")
+	require.Nil(t, err)
+	_, err = publicOnly.PrivateName()
+	assert.ErrorIs(t, err, ErrNoPrivateRoot)
+	require.Nil(t, publicOnly.Write("public/hello.txt", base.NewMemfileBytes("hello.txt", []byte("hello"))))
+	require.Nil(t, withPrivate.Write("private/other.txt", base.NewMemfileBytes("other.txt", []byte("other"))))
+	_, err = withPrivate.Commit()
+	require.Nil(t, err)
+	pubRes, err := publicOnly.Commit()
+	require.Nil(t, err)
+	assert.Nil(t, pubRes.PrivateKey)
+
+	// the side without a private tree adopts the remote one
+	mres, err := Merge(ctx, publicOnly, withPrivate)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, mres.Type)
+	require.NotNil(t, mres.Private)
+	assert.Equal(t, base.MTFastForward, mres.Private.Type)
+	require.NotNil(t, mres.PrivateKey)
+	mustFileContents(t, publicOnly, "private/other.txt", "other")
+	mustFileContents(t, publicOnly, "public/hello.txt", "hello")
+}
+
+func TestMergeFastForwardWithoutKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	rs := ratchet.NewMemStore(ctx)
+	a, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+	require.Nil(t, err)
+	require.Nil(t, a.Write("private/secret.txt", base.NewMemfileBytes("secret.txt", []byte("secret"))))
+	res, err := a.Commit()
+	require.Nil(t, err)
+
+	writer, err := FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	require.Nil(t, writer.Write("private/next.txt", base.NewMemfileBytes("next.txt", []byte("next"))))
+	next, err := writer.Commit()
+	require.Nil(t, err)
+
+	// a remote opened without a key still carries its private HAMT
+	keyless, err := FromCID(ctx, store.Blockservice(), rs, next.Root, Key{}, "")
+	require.Nil(t, err)
+	mres, err := Merge(ctx, a, keyless)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTFastForward, mres.Type)
+	assert.Equal(t, next.Root, a.Cid())
+
+	// the adopted header must describe the HAMT a's private store holds
+	root := a.(*fileSystem).root
+	require.NotNil(t, root.h.Private)
+	assert.Equal(t, root.pstore.HAMT().CID(), *root.h.Private)
+	assert.False(t, root.dirty())
+}
+
+func TestPushPull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	write := func(fsys WNFS, paths ...string) CommitResult {
+		t.Helper()
+		for _, p := range paths {
+			require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+		}
+		res, err := fsys.Commit()
+		require.Nil(t, err)
+		return res
+	}
+
+	aStore := newMemTestStore(ctx, t)
+	a, err := NewEmptyFS(ctx, aStore.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+	initial := write(a, "public/shared.txt", "private/shared.txt")
+
+	// push the initial commit to an empty store & open it there
+	bStore := newMemTestStore(ctx, t)
+	bRemote := dagsync.NewBlockstoreRemote(bStore.Blockservice().Blockstore())
+	stats, err := Push(ctx, a, bRemote)
+	require.Nil(t, err)
+	assert.Greater(t, stats.Blocks, 0)
+	b, err := FromCID(ctx, bStore.Blockservice(), ratchet.NewMemStore(ctx), initial.Root, *initial.PrivateKey, *initial.PrivateName)
+	require.Nil(t, err)
+	mustFileContents(t, b, "private/shared.txt", "private/shared.txt")
+
+	stats, err = Push(ctx, a, bRemote)
+	require.Nil(t, err)
+	assert.Equal(t, dagsync.Stats{}, stats, "pushing again should send nothing")
+
+	aRes := write(a, "public/a.txt", "private/a.txt")
+	write(b, "public/b.txt", "private/b.txt")
+	before, err := base.AllKeys(ctx, bStore.Blockservice().Blockstore())
+	require.Nil(t, err)
+
+	aRemote := dagsync.NewBlockstoreRemote(aStore.Blockservice().Blockstore())
+	res, stats, err := Pull(ctx, b, aRemote, aRes.Root, *aRes.PrivateKey, *aRes.PrivateName)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTMergeCommit, res.Type)
+	assert.Greater(t, stats.Blocks, 0)
+	aKeys, err := base.AllKeys(ctx, aStore.Blockservice().Blockstore())
+	require.Nil(t, err)
+	assert.Less(t, stats.Blocks, len(aKeys), "blocks b already has shouldn't be fetched")
+	after, err := base.AllKeys(ctx, bStore.Blockservice().Blockstore())
+	require.Nil(t, err)
+	assert.GreaterOrEqual(t, len(after)-len(before), stats.Blocks)
+
+	mustFileContents(t, b, "public/a.txt", "public/a.txt")
+	mustFileContents(t, b, "private/a.txt", "private/a.txt")
+	mustFileContents(t, b, "private/b.txt", "private/b.txt")
+
+	res, stats, err = Pull(ctx, b, aRemote, aRes.Root, *aRes.PrivateKey, *aRes.PrivateName)
+	require.Nil(t, err)
+	assert.Equal(t, base.MTLocalAhead, res.Type)
+	assert.Equal(t, dagsync.Stats{}, stats, "pulling again should fetch nothing")
+}
+
+func TestExportImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+	for _, p := range []string{"public/hello.txt", "private/dir/secret.txt"} {
+		require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+	}
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	archive := &bytes.Buffer{}
+	require.Nil(t, Export(ctx, store.Blockservice(), res.Root, archive))
+
+	imported := newMemTestStore(ctx, t)
+	roots, err := Import(ctx, imported.Blockservice(), archive)
+	require.Nil(t, err)
+	assert.Equal(t, []cid.Cid{res.Root}, roots)
+	opened, err := FromCID(ctx, imported.Blockservice(), ratchet.NewMemStore(ctx), res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+	mustFileContents(t, opened, "public/hello.txt", "public/hello.txt")
+	mustFileContents(t, opened, "private/dir/secret.txt", "private/dir/secret.txt")
+
+	archive.Reset()
+	require.Nil(t, Export(ctx, store.Blockservice(), res.Root, archive, ExportPublicOnly()))
+	publicOnly := newMemTestStore(ctx, t)
+	_, err = Import(ctx, publicOnly.Blockservice(), archive)
+	require.Nil(t, err)
+	opened, err = FromCID(ctx, publicOnly.Blockservice(), ratchet.NewMemStore(ctx), res.Root, Key{}, "")
+	require.Nil(t, err)
+	mustFileContents(t, opened, "public/hello.txt", "public/hello.txt")
+
+	headers, err := privateHeaders(ctx, store.Blockservice().Blockstore(), res.Root)
+	require.Nil(t, err)
+	require.NotEmpty(t, headers)
+	for _, id := range headers {
+		has, err := publicOnly.Blockservice().Blockstore().Has(ctx, id)
+		require.Nil(t, err)
+		assert.False(t, has, "public-only exports shouldn't include private node %s", id)
+	}
+}
+
+func TestShare(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+	require.Nil(t, err)
+	for _, p := range []string{"private/photos/a.jpg", "private/diary.txt"} {
+		require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+	}
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	recipient, err := NewExchangeKey()
+	require.Nil(t, err)
+	require.Nil(t, Share(fsys, "private/photos", recipient.Public, "photos"))
+	assert.NotNil(t, Share(fsys, "public", recipient.Public, "public"), "only private paths can be shared")
+	// revisions written after sharing are visible to the recipient
+	require.Nil(t, fsys.Write("private/photos/b.jpg", base.NewMemfileBytes("b.jpg", []byte("private/photos/b.jpg"))))
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	// the recipient opens the sharer's filesystem without its private key
+	opened, err := FromCID(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), res.Root, Key{}, "")
+	require.Nil(t, err)
+	shared, err := LoadSharedTree(ctx, opened, recipient, "photos")
+	require.Nil(t, err)
+
+	data, err := fs.ReadFile(shared, "a.jpg")
+	require.Nil(t, err)
+	assert.Equal(t, "private/photos/a.jpg", string(data))
+	data, err = fs.ReadFile(shared, "b.jpg")
+	require.Nil(t, err)
+	assert.Equal(t, "private/photos/b.jpg", string(data))
+	_, err = fs.ReadFile(shared, "../diary.txt")
+	assert.NotNil(t, err, "files outside the share must not be readable")
+
+	other, err := NewExchangeKey()
+	require.Nil(t, err)
+	_, err = LoadSharedTree(ctx, opened, other, "photos")
+	assert.NotNil(t, err, "shares are only readable by their recipient")
+	impostor := ExchangeKey{Public: recipient.Public, Private: other.Private}
+	_, err = LoadSharedTree(ctx, opened, impostor, "photos")
+	assert.ErrorIs(t, err, private.ErrNotShared)
+}
+
+func TestRevoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+	for _, p := range []string{"private/photos/a.jpg", "private/photos/album/c.jpg"} {
+		require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+	}
+	require.Nil(t, fsys.Mkdir("public/docs"))
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	recipient, err := NewExchangeKey()
+	require.Nil(t, err)
+	require.Nil(t, Share(fsys, "private/photos", recipient.Public, "photos"))
+	f, err := fsys.Open("private/photos")
+	require.Nil(t, err)
+	old, err := private.NewSharePointer(f)
+	require.Nil(t, err)
+
+	require.Nil(t, fsys.Revoke("private/photos"))
+	assert.NotNil(t, fsys.Revoke("public/docs"), "public paths can't be revoked")
+	assert.NotNil(t, fsys.Revoke("private/missing"))
+	require.Nil(t, fsys.Write("private/photos/b.jpg", base.NewMemfileBytes("b.jpg", []byte("private/photos/b.jpg"))))
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	// the owner reads everything, old & new
+	mustFileContents(t, fsys, "private/photos/a.jpg", "private/photos/a.jpg")
+	mustFileContents(t, fsys, "private/photos/album/c.jpg", "private/photos/album/c.jpg")
+	mustFileContents(t, fsys, "private/photos/b.jpg", "private/photos/b.jpg")
+	f, err = fsys.Open("private/photos")
+	require.Nil(t, err)
+	rotated, err := private.NewSharePointer(f)
+	require.Nil(t, err)
+	assert.NotEqual(t, old.Key, rotated.Key)
+	assert.NotEqual(t, old.BareNamefilter, rotated.BareNamefilter)
+
+	// old key holders only see revisions from before the rotation
+	opened, err := FromCID(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), res.Root, Key{}, "")
+	require.Nil(t, err)
+	shared, err := LoadSharedTree(ctx, opened, recipient, "photos")
+	require.Nil(t, err)
+	data, err := fs.ReadFile(shared, "a.jpg")
+	require.Nil(t, err)
+	assert.Equal(t, "private/photos/a.jpg", string(data))
+	_, err = fs.ReadFile(shared, "b.jpg")
+	assert.NotNil(t, err, "writes after revocation must not be readable with old keys")
+
+	oldFS, err := private.LoadSharedFS(ctx, opened.(*fileSystem).root.pstore, old)
+	require.Nil(t, err)
+	_, err = fs.ReadFile(oldFS, "b.jpg")
+	assert.NotNil(t, err, "old ratchets must not derive keys for revisions after revocation")
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+	for _, p := range []string{"private/photos/a.jpg", "private/photos/album/c.jpg", "private/diary.txt"} {
+		require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+	}
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+
+	ptr, err := Snapshot(fsys, "private/photos")
+	require.Nil(t, err)
+	_, err = Snapshot(fsys, "public")
+	assert.NotNil(t, err, "only private paths can be snapshotted")
+	exported, err := ptr.Encode()
+	require.Nil(t, err)
+
+	require.Nil(t, fsys.Write("private/photos/a.jpg", base.NewMemfileBytes("a.jpg", []byte("edited"))))
+	require.Nil(t, fsys.Write("private/photos/b.jpg", base.NewMemfileBytes("b.jpg", []byte("private/photos/b.jpg"))))
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+
+	// the snapshot holder opens the filesystem without its private key
+	opened, err := FromCID(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), res.Root, Key{}, "")
+	require.Nil(t, err)
+	imported, err := DecodeSnapshotPointer(exported)
+	require.Nil(t, err)
+	assert.Equal(t, ptr, imported)
+	snap, err := LoadSnapshot(ctx, opened, imported)
+	require.Nil(t, err)
+
+	data, err := fs.ReadFile(snap, "a.jpg")
+	require.Nil(t, err)
+	assert.Equal(t, "private/photos/a.jpg", string(data), "snapshots must read the revision they were taken at")
+	data, err = fs.ReadFile(snap, "album/c.jpg")
+	require.Nil(t, err)
+	assert.Equal(t, "private/photos/album/c.jpg", string(data))
+	_, err = fs.ReadFile(snap, "b.jpg")
+	assert.NotNil(t, err, "revisions written after a snapshot must not be visible")
+	_, err = fs.ReadFile(snap, "../diary.txt")
+	assert.NotNil(t, err, "files outside the snapshot must not be readable")
+
+	f, err := snap.Open(".")
+	require.Nil(t, err)
+	_, err = f.(private.Info).PrivateName()
+	assert.ErrorIs(t, err, private.ErrSnapshot, "snapshot keys must not name revisions")
+	_, err = f.(base.Node).History(ctx, -1)
+	assert.ErrorIs(t, err, private.ErrSnapshot)
+
+	wrong := imported
+	wrong.Key = private.NewKey()
+	_, err = LoadSnapshot(ctx, opened, wrong)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotEditedFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+
+	content := make([]byte, 600*1024)
+	rand.Read(content)
+	pathStr := "private/data.bin"
+	require.Nil(t, fsys.Write(pathStr, base.NewMemfileBytes("data.bin", content)))
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+	before, err := Snapshot(fsys, pathStr)
+	require.Nil(t, err)
+
+	f, err := fsys.OpenFile(pathStr, os.O_RDWR)
+	require.Nil(t, err)
+	_, err = f.WriteAt([]byte("edited"), 300*1024)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	copy(content[300*1024:], "edited")
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+	after, err := Snapshot(fsys, pathStr)
+	require.Nil(t, err)
+
+	snap, err := LoadSnapshot(ctx, fsys, after)
+	require.Nil(t, err)
+	data, err := fs.ReadFile(snap, ".")
+	require.Nil(t, err)
+	assert.Equal(t, content, data)
+
+	// content of the edited revision isn't sealed with the earlier revision's key
+	ef, err := fsys.Open(pathStr)
+	require.Nil(t, err)
+	earlier := before.Key.ContentKey()
+	r, err := fsys.(*fileSystem).root.pstore.GetEncryptedFile(ef.(*private.File).Content(), private.DefaultCipher, earlier[:])
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+	}
+	assert.NotNil(t, err, "edits must be re-encrypted for the revision they're written in")
+}
+
+func TestMixedCiphers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	rs := ratchet.NewMemStore(ctx)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), rs, testRootKey)
+	require.Nil(t, err)
+
+	paths := map[Cipher]string{
+		CipherAESGCM:            "private/aes.txt",
+		CipherChaCha20Poly1305:  "private/chacha/file.txt",
+		CipherXChaCha20Poly1305: "private/chacha/xchacha/file.txt",
+	}
+	for _, c := range []Cipher{CipherAESGCM, CipherChaCha20Poly1305, CipherXChaCha20Poly1305} {
+		fsys.SetCipher(c)
+		p := paths[c]
+		require.Nil(t, fsys.Write(p, base.NewMemfileBytes(filepath.Base(p), []byte(p))))
+	}
+
+	// in-place edits keep the cipher file content was written with
+	f, err := fsys.OpenFile(paths[CipherChaCha20Poly1305], os.O_WRONLY|os.O_APPEND)
+	require.Nil(t, err)
+	_, err = f.Write([]byte(" edited"))
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	res, err := fsys.Commit()
+	require.Nil(t, err)
+	opened, err := FromCID(ctx, store.Blockservice(), rs, res.Root, *res.PrivateKey, *res.PrivateName)
+	require.Nil(t, err)
+
+	mustFileContents(t, opened, paths[CipherAESGCM], paths[CipherAESGCM])
+	mustFileContents(t, opened, paths[CipherChaCha20Poly1305], paths[CipherChaCha20Poly1305]+" edited")
+	mustFileContents(t, opened, paths[CipherXChaCha20Poly1305], paths[CipherXChaCha20Poly1305])
+
+	for c, p := range paths {
+		f, err := opened.Open(p)
+		require.Nil(t, err)
+		fi, err := private.Stat(f)
+		require.Nil(t, err)
+		assert.Equal(t, c, fi.Cipher(), "cipher of %q", p)
+	}
+	f2, err := opened.Open("private")
+	require.Nil(t, err)
+	fi, err := private.Stat(f2)
+	require.Nil(t, err)
+	assert.Equal(t, CipherXChaCha20Poly1305, fi.Cipher(), "trees are rewritten with the latest cipher")
+
+	// reopened filesystems keep writing with the cipher of the private root
+	require.Nil(t, opened.Write("private/new.txt", base.NewMemfileBytes("new.txt", []byte("new"))))
+	f2, err = opened.Open("private/new.txt")
+	require.Nil(t, err)
+	fi, err = private.Stat(f2)
+	require.Nil(t, err)
+	assert.Equal(t, CipherXChaCha20Poly1305, fi.Cipher())
+}
+
+func TestRollbackKeepsCipher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemTestStore(ctx, t)
+	fsys, err := NewEmptyFS(ctx, store.Blockservice(), ratchet.NewMemStore(ctx), testRootKey)
+	require.Nil(t, err)
+	_, err = fsys.Commit()
+	require.Nil(t, err)
+	fsys.SetCipher(CipherXChaCha20Poly1305)
+
+	errBatch := fmt.Errorf("oh noes")
+	err = fsys.Batch(func(tx PosixFS) error {
+		if err := tx.Write("private/rolled_back.txt", base.NewMemfileBytes("rolled_back.txt", []byte("nope"))); err != nil {
+			return err
+		}
+		return errBatch
+	})
+	require.ErrorIs(t, err, errBatch)
+
+	require.Nil(t, fsys.Write("private/after.txt", base.NewMemfileBytes("after.txt", []byte("after"))))
+	f, err := fsys.Open("private/after.txt")
+	require.Nil(t, err)
+	fi, err := private.Stat(f)
+	require.Nil(t, err)
+	assert.Equal(t, CipherXChaCha20Poly1305, fi.Cipher(), "rolling back a batch must keep the cipher")
+
+	fsys.SetCipher(CipherChaCha20Poly1305)
+	require.Nil(t, fsys.Rollback())
+	require.Nil(t, fsys.Write("private/after.txt", base.NewMemfileBytes("after.txt", []byte("after"))))
+	f, err = fsys.Open("private/after.txt")
+	require.Nil(t, err)
+	fi, err = private.Stat(f)
+	require.Nil(t, err)
+	assert.Equal(t, CipherChaCha20Poly1305, fi.Cipher(), "rolling back to the last commit must keep the cipher")
+}
+
 func BenchmarkPublicCat10MbFile(t *testing.B) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -605,3 +1917,12 @@ func newFileTestStore(ctx context.Context, f fataler) (st public.Store, cleanup
 	store := public.NewStore(ctx, bserv)
 	return store, cleanup
 }
+
+func mustFileContents(t *testing.T, fsys WNFS, path, content string) {
+	t.Helper()
+	data, err := fsys.Cat(path)
+	if err != nil {
+		t.Fatalf("reading %q: %s", path, err)
+	}
+	assert.Equal(t, content, string(data), "content of %q", path)
+}